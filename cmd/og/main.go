@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/alexflint/go-arg"
 	"github.com/teejays/gokutil/errutil"
 	"github.com/teejays/gokutil/gopi/json"
 	"github.com/teejays/gokutil/log"
@@ -14,8 +19,19 @@ import (
 	"github.com/teejays/gokutil/ogconfig"
 	"github.com/teejays/gokutil/panics"
 
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
 	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/create"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/db"
 	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/dev"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/exec"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/logs"
+	pluginsubcmd "github.com/build-ongoku/ongoku-cli/pkg/subcmd/plugin"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/registry"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/secrets"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/tunnel"
 )
 
 const _version = "0.1.1" // increment this for every release
@@ -25,32 +41,76 @@ var _buildTimeCompiledAtStr string
 var _compiledAt time.Time
 
 func main() {
-	// Build context (and cancel it at the end). This lets us gracefully cancel any long running operations.
-	ctx, cancel := context.WithCancel(context.Background())
+	// Build context (and cancel it at the end). It's cancelled on Ctrl+C or SIGTERM, which gracefully cancels any long
+	// running operations.
+	ctx, cancel := interrupt.NotifyContext(context.Background())
 	defer cancel()
 
 	err := mainHelper(ctx)
-	if err != nil {
-		log.Error(ctx, "Could not complete the request.", "error", err)
+	if errors.Is(err, deploy.ErrDiffHasChanges) {
+		// Not a failure, but CI pipelines gate on the exit code. The changes have already been printed.
 		os.Exit(1)
 	}
+	var exitErr ogerr.ExitCodeError
+	if errors.As(err, &exitErr) {
+		// The command run by exec/shell failed, and has printed its own errors
+		os.Exit(exitErr.Code)
+	}
+	if err != nil {
+		category := ogerr.CategoryOf(err)
+		if interrupt.Interrupted() {
+			// Whatever failed, it's because it was stopped
+			category = ogerr.CategoryCancelled
+		}
+		log.Error(ctx, "Could not complete the request.", "category", category.String(), "error", err)
+		log.Info(ctx, "Hint: "+category.Hint())
+		os.Exit(category.ExitCode())
+	}
 }
 
 type Args struct {
 	mainutil.ParentArgs
 
 	// Auth          *auth.Args   `arg:"subcommand:auth" help:"Authentication related commands"`
-	Create *create.Args `arg:"subcommand:create" help:"Create a new Ongoku app."`
-	Deploy *deploy.Args `arg:"subcommand:deploy" help:"Deployment related commands"`
+	Create   *create.Args       `arg:"subcommand:create" help:"Create a new Ongoku app."`
+	DB       *db.Args           `arg:"subcommand:db" help:"Migrate, back up and restore the database of a deployment"`
+	Deploy   *deploy.Args       `arg:"subcommand:deploy" help:"Deployment related commands"`
+	Dev      *dev.Args          `arg:"subcommand:dev" help:"Run the app locally"`
+	Exec     *exec.Args         `arg:"subcommand:exec" help:"Run a command in a workload of a deployment"`
+	Logs     *logs.Args         `arg:"subcommand:logs" help:"Stream the logs of a deployment"`
+	Plugin   *pluginsubcmd.Args `arg:"subcommand:plugin" help:"Manage the plugins: og-<name> executables that run as og <name>"`
+	Registry *registry.Args     `arg:"subcommand:registry" help:"Container registry related commands"`
+	Secrets  *secrets.Args      `arg:"subcommand:secrets" help:"Manage the env variables (secrets) of deployments"`
+	Shell    *exec.ShellArgs    `arg:"subcommand:shell" help:"Open a shell in a workload of a deployment"`
+	Tunnel   *tunnel.Args       `arg:"subcommand:tunnel" help:"Reach the services (e.g. the database) of a deployment from the local machine"`
 
 	// Flags
 	AppRootFromCurrDirPath string `arg:"-d,--app-dir" help:"The root directory of the Ongoku app. Defaults to current dircetory." default:"."`
+	Output                 string `arg:"-o,--output,env:GOKU_OUTPUT" help:"The format of the results printed on stdout: table, json, yaml or quiet (only identifiers e.g. image references). Logs always go to stderr." default:"table"`
 }
 
 func (v *Args) Version() string {
 	return fmt.Sprintf("Ongoku CLI: Version %s\nBuildtime: %s\n", _version, _compiledAt)
 }
 
+func (v *Args) Epilogue() string {
+	return `Exit codes:
+  0    success
+  1    failure of an unknown category, or changes found by og deploy diff
+  2    usage: invalid command, arguments or flags
+  3    auth: missing or rejected credentials (registry, cluster, cloud)
+  4    license: missing or invalid Ongoku license
+  5    engine: the core engine (goku) failed
+  6    network: a server could not be reached
+  7    deploy: building, applying or rolling out the app failed
+  130  cancelled: interrupted e.g. with Ctrl+C
+og exec and og shell exit with the code of the remote command.
+
+Plugins:
+  og <name> runs the og-<name> executable in ~/.ongoku/plugins or on the PATH, with the
+  context of og in OG_* env variables, and exits with its code. See og plugin list.`
+}
+
 func (v *Args) Parse(ctx context.Context) error {
 
 	err := mainutil.ParseArgs(ctx, "Ongoku CLI", v)
@@ -61,6 +121,11 @@ func (v *Args) Parse(ctx context.Context) error {
 	return nil
 }
 
+// initLogger sets the logger up with the level, writing to stderr so that stdout only has the results.
+func initLogger(level slog.Level) {
+	log.InitWithWriters(level, os.Stderr, os.Stderr)
+}
+
 func mainHelper(ctx context.Context) error {
 	var err error
 
@@ -72,6 +137,11 @@ func mainHelper(ctx context.Context) error {
 		return errutil.Wrap(err, "Parsing the build time. This binary is not built correctly or may be corrupted.")
 	}
 
+	// Plugins are commands that og does not know, so they are looked for before parsing the args
+	if args, i, ok := pluginCall(os.Args[1:]); ok {
+		return runPlugin(ctx, &args, os.Args[1+i], os.Args[2+i:])
+	}
+
 	// Parse the command line args
 	var args Args
 	err = args.Parse(ctx)
@@ -79,7 +149,7 @@ func mainHelper(ctx context.Context) error {
 		if errors.Is(err, mainutil.ErrCleanExit) {
 			return nil
 		}
-		return errutil.Wrap(err, "Parsing command line args")
+		return ogerr.Mark(errutil.Wrap(err, "Parsing command line args"), ogerr.CategoryUsage)
 	}
 
 	// Run the command
@@ -99,9 +169,28 @@ func run(ctx context.Context, args *Args) error {
 	somethingDone := false
 
 	// Set the log level specifically for this run
-	log.Init(args.LogLevel)
+	initLogger(args.LogLevel)
+
+	format, err := output.ParseFormat(args.Output)
+	if err != nil {
+		return ogerr.Mark(errutil.Wrap(err, "Parsing --output"), ogerr.CategoryUsage)
+	}
+	output.SetFormat(format)
+
+	if args.Plugin != nil {
+
+		// Plugins are not tied to an app
+		somethingDone = true
+		args.Plugin.GokuVersion = _version
+		args.Plugin.Reserved = subcommandNames(reflect.TypeOf(Args{}))
+
+		log.Debug(ctx, "Running sub-command [plugin]", "args", json.MustPrettyPrint(args.Plugin))
+		err = pluginsubcmd.Run(ctx, args.Plugin)
+		if err != nil {
+			return errutil.Wrap(err, "Running sub-command [plugin]")
+		}
 
-	if args.Create != nil {
+	} else if args.Create != nil {
 
 		// Create is a unique branch because 1) no config to start with, 2) app root dir path doesn't apply yet
 		somethingDone = true
@@ -134,8 +223,19 @@ func run(ctx context.Context, args *Args) error {
 		// 	}
 		// }
 
+		if args.DB != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [db]", "args", json.MustPrettyPrint(args.DB))
+			err = db.Run(ctx, cfg, args.DB)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [db]")
+			}
+		}
+
 		if args.Deploy != nil {
 			somethingDone = true
+			args.Deploy.GokuVersion = _version
 
 			log.Debug(ctx, "Running sub-command [deploy]", "args", json.MustPrettyPrint(args.Deploy))
 			err = deploy.Run(ctx, cfg, args.Deploy)
@@ -143,12 +243,150 @@ func run(ctx context.Context, args *Args) error {
 				return errutil.Wrap(err, "Running sub-command [deploy]")
 			}
 		}
+
+		if args.Dev != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [dev]", "args", json.MustPrettyPrint(args.Dev))
+			err = dev.Run(ctx, cfg, args.Dev)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [dev]")
+			}
+		}
+
+		if args.Exec != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [exec]", "args", json.MustPrettyPrint(args.Exec))
+			err = exec.Run(ctx, cfg, args.Exec)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [exec]")
+			}
+		}
+
+		if args.Logs != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [logs]", "args", json.MustPrettyPrint(args.Logs))
+			err = logs.Run(ctx, cfg, args.Logs)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [logs]")
+			}
+		}
+
+		if args.Registry != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [registry]", "args", json.MustPrettyPrint(args.Registry))
+			err = registry.Run(ctx, cfg, args.Registry)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [registry]")
+			}
+		}
+
+		if args.Secrets != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [secrets]", "args", json.MustPrettyPrint(args.Secrets))
+			err = secrets.Run(ctx, cfg, args.Secrets)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [secrets]")
+			}
+		}
+
+		if args.Shell != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [shell]", "args", json.MustPrettyPrint(args.Shell))
+			err = exec.RunShell(ctx, cfg, args.Shell)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [shell]")
+			}
+		}
+
+		if args.Tunnel != nil {
+			somethingDone = true
+
+			log.Debug(ctx, "Running sub-command [tunnel]", "args", json.MustPrettyPrint(args.Tunnel))
+			err = tunnel.Run(ctx, cfg, args.Tunnel)
+			if err != nil {
+				return errutil.Wrap(err, "Running sub-command [tunnel]")
+			}
+		}
 	}
 
 	if !somethingDone {
-		return fmt.Errorf("Please provide a subcommand.")
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
 	}
 
 	return nil
 
 }
+
+// pluginCall tells whether the command line args call a plugin i.e. their first positional arg is not a subcommand of
+// og. If so, it returns the flags of og before it, and its index.
+func pluginCall(argv []string) (Args, int, bool) {
+	subcommands := subcommandNames(reflect.TypeOf(Args{}))
+	for i, a := range argv {
+		if strings.HasPrefix(a, "-") {
+			continue
+		}
+		var args Args
+		p, err := arg.NewParser(arg.Config{Program: "Ongoku CLI"}, &args)
+		if err != nil {
+			return Args{}, 0, false
+		}
+		if p.Parse(argv[:i]) != nil {
+			// Either a is the value of a flag, or the flags are invalid, which parsing all the args reports
+			continue
+		}
+		if slices.Contains(subcommands, a) {
+			return Args{}, 0, false
+		}
+		return args, i, true
+	}
+	return Args{}, 0, false
+}
+
+// subcommandNames returns the names of the subcommands in the args struct type, including those of embedded structs.
+func subcommandNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			names = append(names, subcommandNames(f.Type)...)
+			continue
+		}
+		for _, opt := range strings.Split(f.Tag.Get("arg"), ",") {
+			if name, ok := strings.CutPrefix(opt, "subcommand:"); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func runPlugin(ctx context.Context, args *Args, name string, pluginArgs []string) error {
+	err := args.ValidateAndProcess(ctx)
+	if err != nil {
+		return ogerr.Mark(errutil.Wrap(err, "Parsing command line args"), ogerr.CategoryUsage)
+	}
+	initLogger(args.LogLevel)
+
+	format, err := output.ParseFormat(args.Output)
+	if err != nil {
+		return ogerr.Mark(errutil.Wrap(err, "Parsing --output"), ogerr.CategoryUsage)
+	}
+
+	err = pluginsubcmd.RunPlugin(ctx, name, pluginArgs, pluginsubcmd.RunOptions{
+		GokuVersion:            _version,
+		AppRootFromCurrDirPath: args.AppRootFromCurrDirPath,
+		LogLevel:               args.LogLevelStr,
+		Output:                 string(format),
+	})
+	if err != nil {
+		return errutil.Wrap(err, "Running plugin [%s]", name)
+	}
+
+	return nil
+}
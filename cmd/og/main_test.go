@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSubcommandNames(t *testing.T) {
+	type embedded struct {
+		Inner *struct{} `arg:"subcommand:inner" help:"Inner"`
+	}
+	type args struct {
+		embedded
+		First  *struct{} `arg:"subcommand:first" help:"First"`
+		Second *struct{} `arg:"subcommand:second"`
+		Flag   string    `arg:"--flag"`
+	}
+	got := subcommandNames(reflect.TypeOf(args{}))
+	if want := []string{"inner", "first", "second"}; !slices.Equal(got, want) {
+		t.Errorf("subcommandNames() = %v, want %v", got, want)
+	}
+
+	// og's, including those of mainutil.ParentArgs
+	got = subcommandNames(reflect.TypeOf(Args{}))
+	for _, name := range []string{"version", "help", "deploy", "plugin", "tunnel"} {
+		if !slices.Contains(got, name) {
+			t.Errorf("subcommandNames(Args) = %v, want it to contain %s", got, name)
+		}
+	}
+}
+
+func TestPluginCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		argv      []string
+		want      bool
+		wantIndex int
+		wantArgs  func(Args) bool
+	}{
+		{name: "no args", argv: nil},
+		{name: "subcommand", argv: []string{"deploy", "all"}},
+		{name: "subcommand of mainutil", argv: []string{"version"}},
+		{name: "flags only", argv: []string{"--help"}},
+		{name: "subcommand after flags", argv: []string{"-o", "json", "deploy", "status"}},
+		{name: "plugin", argv: []string{"lint", "deploy"}, want: true, wantIndex: 0},
+		{name: "plugin with its flags", argv: []string{"lint", "--fix"}, want: true, wantIndex: 0},
+		{
+			name:      "plugin after flags of og",
+			argv:      []string{"--log-level", "debug", "-d", "app", "lint", "-o", "json"},
+			want:      true,
+			wantIndex: 4,
+			wantArgs: func(a Args) bool {
+				return a.LogLevelStr == "debug" && a.AppRootFromCurrDirPath == "app"
+			},
+		},
+		{name: "plugin after a flag with =", argv: []string{"--output=yaml", "lint"}, want: true, wantIndex: 1, wantArgs: func(a Args) bool { return a.Output == "yaml" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, i, ok := pluginCall(tt.argv)
+			if ok != tt.want {
+				t.Fatalf("pluginCall(%v) = %v, want %v", tt.argv, ok, tt.want)
+			}
+			if !ok {
+				return
+			}
+			if i != tt.wantIndex {
+				t.Errorf("pluginCall(%v) index = %d, want %d", tt.argv, i, tt.wantIndex)
+			}
+			if tt.wantArgs != nil && !tt.wantArgs(args) {
+				t.Errorf("pluginCall(%v) args = %+v", tt.argv, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package backup stores the database backups of an app: compressed dumps named after the deployment they were taken
+// of and when, kept either in a local directory or in an S3-compatible bucket (AWS S3, MinIO, R2...).
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/local"
+)
+
+// Extension of the backup files: gzip compressed dumps
+const Extension = ".dump.gz"
+
+// _timeFormat has milliseconds, so that two backups taken in the same second have different keys. Keys of backups
+// taken before had seconds only (_oldTimeFormat).
+const (
+	_timeFormat    = "20060102T150405.000Z"
+	_oldTimeFormat = "20060102T150405Z"
+)
+
+// StorageConfig defines where the backups are stored.
+type StorageConfig struct {
+	// Location is a directory (relative to the app root) or an S3 bucket with an optional prefix e.g. s3://my-backups/apps.
+	// Defaults to ~/.ongoku/backups/<app>, outside of the app's repository.
+	Location string `yaml:"location"`
+	// S3 are the settings of the bucket, if the location is one
+	S3 S3Config `yaml:"s3"`
+}
+
+// S3Config are the connection settings of an S3-compatible bucket.
+type S3Config struct {
+	// Endpoint is the host[:port] of the API. Defaults to s3.amazonaws.com. e.g. localhost:9000 for a local MinIO.
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	// AccessKeyEnv and SecretKeyEnv are the env variables with the credentials. If not set, the credentials come from
+	// the standard AWS (or MinIO) env variables, the AWS credentials file or the instance role.
+	AccessKeyEnv string `yaml:"access_key_env"`
+	SecretKeyEnv string `yaml:"secret_key_env"`
+	// Insecure talks to the endpoint over plain HTTP e.g. for a local MinIO
+	Insecure bool `yaml:"insecure"`
+}
+
+// Store holds the backups.
+type Store interface {
+	// Name describes the store e.g. for logs
+	Name() string
+	// Put stores the backup read from r under the key. Nothing is stored if r fails. It fails with an error wrapping
+	// os.ErrExist if there is a backup with the key already. It returns the size of the backup.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the backup with the key. It returns an error wrapping os.ErrNotExist if there is no such backup.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the backups of the deployment, or all of them if the deploy identifier is empty, oldest first.
+	List(ctx context.Context, deployIdentifier string) ([]Backup, error)
+}
+
+// Backup is a stored backup.
+type Backup struct {
+	// Key identifies the backup in its store e.g. myapp-prod/myapp-prod-20261018T120000.123Z.dump.gz
+	Key string `json:"key"`
+	// DeployIdentifier of the deployment the backup was taken of
+	DeployIdentifier string `json:"deploy_identifier"`
+	// Time the backup was taken at
+	Time time.Time `json:"time"`
+	// Size in bytes
+	Size int64 `json:"size"`
+}
+
+// Identifiers returns the key of the backup, to restore it with.
+func (b Backup) Identifiers() []string {
+	return []string{b.Key}
+}
+
+// DefaultDir is the directory the backups of the app are stored in if no location is configured. It's outside of the
+// app's repository, so that dumps of the databases are not committed by mistake.
+func DefaultDir(ctx context.Context, appName string) (string, error) {
+	dir, err := local.GetDefaultConfigDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backups", appName), nil
+}
+
+// Open returns the store of the location. Relative directories are relative to the app root.
+func Open(ctx context.Context, cfg StorageConfig, appRootPath string, appName string) (Store, error) {
+	location := cfg.Location
+	if strings.HasPrefix(location, "s3://") {
+		return newS3Store(ctx, location, cfg.S3)
+	}
+	if strings.Contains(location, "://") {
+		return nil, fmt.Errorf("Unsupported backup location [%s]. Use a directory or an s3://bucket/prefix URL", location)
+	}
+	if location == "" {
+		dir, err := DefaultDir(ctx, appName)
+		if err != nil {
+			return nil, err
+		}
+		return newLocalStore(dir, appRootPath), nil
+	}
+	s := newLocalStore(location, appRootPath)
+	if rel, err := filepath.Rel(appRootPath, s.dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Warn(ctx, "The backups are stored in the app's directory. Make sure it's in .gitignore, so that database dumps are not committed.", "location", s.dir)
+	}
+	return s, nil
+}
+
+// NewKey returns the key of a backup of the deployment taken at the time.
+func NewKey(deployIdentifier string, t time.Time) string {
+	return path.Join(deployIdentifier, deployIdentifier+"-"+t.UTC().Format(_timeFormat)+Extension)
+}
+
+var _keyRegexp = regexp.MustCompile(`^([^/]+)/([^/]+)-(\d{8}T\d{6}(?:\.\d{3})?Z)` + regexp.QuoteMeta(Extension) + `$`)
+
+// ParseKey returns the backup of the key, and false if the key is not one of a backup (see NewKey).
+func ParseKey(key string) (Backup, bool) {
+	m := _keyRegexp.FindStringSubmatch(key)
+	if m == nil || m[1] != m[2] {
+		return Backup{}, false
+	}
+	format := _timeFormat
+	if !strings.Contains(m[3], ".") {
+		format = _oldTimeFormat
+	}
+	t, err := time.Parse(format, m[3])
+	if err != nil {
+		return Backup{}, false
+	}
+	return Backup{Key: key, DeployIdentifier: m[1], Time: t}, true
+}
+
+// FormatSize returns the size in a human readable form e.g. 12.3 MiB.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
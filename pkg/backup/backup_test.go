@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// The S3 store is tested against a local MinIO if OG_TEST_S3_ENDPOINT is set e.g.
+//
+//	docker run -d -p 9000:9000 -e MINIO_ROOT_USER=minio -e MINIO_ROOT_PASSWORD=minio123 minio/minio server /data
+//	OG_TEST_S3_ENDPOINT=localhost:9000 OG_TEST_S3_ACCESS_KEY=minio OG_TEST_S3_SECRET_KEY=minio123 go test ./pkg/backup
+const (
+	_testS3EndpointEnv  = "OG_TEST_S3_ENDPOINT"
+	_testS3AccessKeyEnv = "OG_TEST_S3_ACCESS_KEY"
+	_testS3SecretKeyEnv = "OG_TEST_S3_SECRET_KEY"
+	_testS3BucketEnv    = "OG_TEST_S3_BUCKET"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(context.Background(), StorageConfig{Location: "backups"}, dir, "myapp")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	testStore(t, store)
+}
+
+func TestOpenDefault(t *testing.T) {
+	home, appRoot := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	store, err := Open(context.Background(), StorageConfig{}, appRoot, "myapp")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	// Outside of the app, so that dumps are not committed
+	want := filepath.Join(home, ".ongoku", "backups", "myapp")
+	if store.Name() != want {
+		t.Errorf("Open() store = %s, want %s", store.Name(), want)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv(_testS3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", _testS3EndpointEnv)
+	}
+	ctx := context.Background()
+	bucket := os.Getenv(_testS3BucketEnv)
+	if bucket == "" {
+		bucket = "og-test"
+	}
+	// A new prefix per run, so that runs do not see the backups of each other
+	location := fmt.Sprintf("s3://%s/run-%d", bucket, time.Now().UnixNano())
+
+	store, err := Open(ctx, StorageConfig{
+		Location: location,
+		S3: S3Config{
+			Endpoint:     endpoint,
+			AccessKeyEnv: _testS3AccessKeyEnv,
+			SecretKeyEnv: _testS3SecretKeyEnv,
+			Insecure:     true,
+		},
+	}, t.TempDir(), "myapp")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	client := store.(*s3Store).client
+	ok, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		t.Fatalf("BucketExists() error = %v", err)
+	}
+	if !ok {
+		err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})
+		if err != nil {
+			t.Fatalf("MakeBucket() error = %v", err)
+		}
+	}
+
+	testStore(t, store)
+}
+
+// testStore checks that the backups put in the store are listed, and read back as they were.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	backups, err := store.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() of empty store error = %v", err)
+	}
+	if len(backups) != 0 {
+		t.Fatalf("List() of empty store = %v, want none", backups)
+	}
+
+	// Two backups in the same second, and one of another deployment
+	contents := map[string][]byte{
+		NewKey("myapp-prod", now):                           []byte("first dump"),
+		NewKey("myapp-prod", now.Add(time.Millisecond)):     []byte("second dump, taken right after"),
+		NewKey("myapp-staging", now.Add(-time.Millisecond)): []byte("staging dump"),
+	}
+	for key, content := range contents {
+		size, err := store.Put(ctx, key, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+		if size != int64(len(content)) {
+			t.Errorf("Put(%s) size = %d, want %d", key, size, len(content))
+		}
+	}
+
+	_, err = store.Put(ctx, NewKey("myapp-prod", now), bytes.NewReader([]byte("overwrite")))
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("Put() of an existing key error = %v, want os.ErrExist", err)
+	}
+
+	failedKey := NewKey("myapp-prod", now.Add(time.Hour))
+	_, err = store.Put(ctx, failedKey, io.MultiReader(bytes.NewReader([]byte("partial")), errReader{}))
+	if err == nil {
+		t.Errorf("Put() with a failing reader error = nil, want an error")
+	}
+
+	backups, err = store.List(ctx, "myapp-prod")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	wantKeys := []string{NewKey("myapp-prod", now), NewKey("myapp-prod", now.Add(time.Millisecond))}
+	if len(backups) != len(wantKeys) {
+		t.Fatalf("List() = %v, want keys %v", backups, wantKeys)
+	}
+	for i, b := range backups {
+		if b.Key != wantKeys[i] {
+			t.Errorf("List()[%d].Key = %s, want %s", i, b.Key, wantKeys[i])
+		}
+		if b.DeployIdentifier != "myapp-prod" {
+			t.Errorf("List()[%d].DeployIdentifier = %s, want myapp-prod", i, b.DeployIdentifier)
+		}
+		if b.Size != int64(len(contents[b.Key])) {
+			t.Errorf("List()[%d].Size = %d, want %d", i, b.Size, len(contents[b.Key]))
+		}
+	}
+	if !backups[0].Time.Equal(now) {
+		t.Errorf("List()[0].Time = %s, want %s", backups[0].Time, now)
+	}
+
+	backups, err = store.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() of all deployments error = %v", err)
+	}
+	if len(backups) != len(contents) {
+		t.Errorf("List() of all deployments = %v, want %d backups", backups, len(contents))
+	}
+
+	for key, content := range contents {
+		r, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", key, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Get(%s) read error = %v", key, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("Get(%s) = %q, want %q", key, got, content)
+		}
+	}
+
+	_, err = store.Get(ctx, NewKey("myapp-prod", now.Add(time.Minute)))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get() of a missing key error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		ok   bool
+		want time.Time
+	}{
+		{key: "myapp-prod/myapp-prod-20261018T120000.123Z.dump.gz", ok: true, want: time.Date(2026, 10, 18, 12, 0, 0, 123e6, time.UTC)},
+		// Backups taken before keys had milliseconds
+		{key: "myapp-prod/myapp-prod-20261018T120000Z.dump.gz", ok: true, want: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{key: "myapp-prod/other-20261018T120000Z.dump.gz"},
+		{key: "myapp-prod/myapp-prod-20261018T120000Z.sql"},
+	}
+	for _, tt := range tests {
+		b, ok := ParseKey(tt.key)
+		if ok != tt.ok {
+			t.Errorf("ParseKey(%s) ok = %v, want %v", tt.key, ok, tt.ok)
+			continue
+		}
+		if ok && !b.Time.Equal(tt.want) {
+			t.Errorf("ParseKey(%s).Time = %s, want %s", tt.key, b.Time, tt.want)
+		}
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("dump failed")
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+)
+
+// localStore keeps the backups in a directory, one sub-directory per deployment.
+type localStore struct {
+	dir string
+}
+
+func newLocalStore(dir string, appRootPath string) *localStore {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(appRootPath, dir)
+	}
+	return &localStore{dir: dir}
+}
+
+func (s *localStore) Name() string {
+	return s.dir
+}
+
+// Put writes the backup to a temporary file first, so that a failed backup never looks like a complete one.
+func (s *localStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if _, err := os.Stat(path); err == nil {
+		return 0, fmt.Errorf("Backup file [%s] exists already: %w", path, os.ErrExist)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return 0, errutil.Wrap(err, "Creating backup directory [%s]", filepath.Dir(path))
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.partial")
+	if err != nil {
+		return 0, errutil.Wrap(err, "Creating backup file in [%s]", filepath.Dir(path))
+	}
+	defer os.Remove(f.Name())
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return 0, errutil.Wrap(err, "Writing backup file [%s]", path)
+	}
+	err = f.Close()
+	if err != nil {
+		return 0, errutil.Wrap(err, "Writing backup file [%s]", path)
+	}
+	// Unlike a rename, a link fails if the file exists (e.g. another backup was taken meanwhile)
+	err = os.Link(f.Name(), path)
+	if errors.Is(err, os.ErrExist) {
+		return 0, fmt.Errorf("Backup file [%s] exists already: %w", path, os.ErrExist)
+	}
+	if err != nil {
+		return 0, errutil.Wrap(err, "Moving backup file to [%s]", path)
+	}
+	return size, nil
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Opening backup file [%s]", path)
+	}
+	return f, nil
+}
+
+func (s *localStore) List(ctx context.Context, deployIdentifier string) ([]Backup, error) {
+	root := s.dir
+	if deployIdentifier != "" {
+		root = filepath.Join(s.dir, deployIdentifier)
+	}
+
+	var backups []Backup
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		b, ok := ParseKey(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		b.Size = info.Size()
+		backups = append(backups, b)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing backups in [%s]", root)
+	}
+
+	slices.SortFunc(backups, compareBackups)
+	return backups, nil
+}
+
+func compareBackups(a, b Backup) int {
+	if c := a.Time.Compare(b.Time); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Key, b.Key)
+}
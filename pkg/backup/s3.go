@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/teejays/gokutil/errutil"
+)
+
+const _defaultS3Endpoint = "s3.amazonaws.com"
+
+// s3Store keeps the backups in an S3-compatible bucket, under a prefix.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Store(ctx context.Context, location string, cfg S3Config) (*s3Store, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("Invalid backup location [%s]: no bucket. Use s3://bucket/prefix", location)
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var creds *credentials.Credentials
+	switch {
+	case cfg.AccessKeyEnv != "" || cfg.SecretKeyEnv != "":
+		accessKey, secretKey := os.Getenv(cfg.AccessKeyEnv), os.Getenv(cfg.SecretKeyEnv)
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("The credentials of the backup bucket are not set. Set the env variables [%s] and [%s]", cfg.AccessKeyEnv, cfg.SecretKeyEnv)
+		}
+		creds = credentials.NewStaticV4(accessKey, secretKey, "")
+	default:
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = _defaultS3Endpoint
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating client for S3 endpoint [%s]", endpoint)
+	}
+
+	return &s3Store{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *s3Store) Name() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	ok, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return 0, errutil.Wrap(err, "Checking backup bucket [%s]", s.bucket)
+	}
+	if !ok {
+		return 0, fmt.Errorf("Backup bucket [%s] does not exist", s.bucket)
+	}
+
+	_, err = s.client.StatObject(ctx, s.bucket, s.prefix+key, minio.StatObjectOptions{})
+	if err == nil {
+		return 0, fmt.Errorf("Backup [%s] exists already in bucket [%s]: %w", key, s.bucket, os.ErrExist)
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return 0, errutil.Wrap(err, "Checking backup [%s] in bucket [%s]", key, s.bucket)
+	}
+
+	// An unknown size uploads in parts, and the upload is aborted if r fails
+	info, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, r, -1, minio.PutObjectOptions{ContentType: "application/gzip"})
+	if err != nil {
+		return 0, errutil.Wrap(err, "Uploading backup [%s] to bucket [%s]", key, s.bucket)
+	}
+	return info.Size, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Downloading backup [%s] from bucket [%s]", key, s.bucket)
+	}
+	// GetObject is lazy, stat it to fail early if the backup does not exist
+	_, err = obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("Backup [%s] does not exist in bucket [%s]: %w", key, s.bucket, os.ErrNotExist)
+		}
+		return nil, errutil.Wrap(err, "Downloading backup [%s] from bucket [%s]", key, s.bucket)
+	}
+	return obj, nil
+}
+
+func (s *s3Store) List(ctx context.Context, deployIdentifier string) ([]Backup, error) {
+	prefix := s.prefix
+	if deployIdentifier != "" {
+		prefix += deployIdentifier + "/"
+	}
+
+	var backups []Backup
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, errutil.Wrap(obj.Err, "Listing backups in bucket [%s]", s.bucket)
+		}
+		b, ok := ParseKey(strings.TrimPrefix(obj.Key, s.prefix))
+		if !ok {
+			continue
+		}
+		b.Size = obj.Size
+		backups = append(backups, b)
+	}
+
+	slices.SortFunc(backups, compareBackups)
+	return backups, nil
+}
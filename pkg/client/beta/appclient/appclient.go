@@ -34,6 +34,18 @@ type TokenResponse struct {
 
 const _defaultBaseURL = "http://localhost:8080"
 
+// ServerBaseURLEnv is the env variable with the URL of the Ongoku server
+const ServerBaseURLEnv = "ONGOKU_CLI_SERVER_BASE_URL"
+
+// BaseURL returns the URL of the Ongoku server: the value of ServerBaseURLEnv, or else the default.
+func BaseURL() string {
+	baseURL := envutil.GetEnvVarStr(ServerBaseURLEnv)
+	if baseURL == "" {
+		return _defaultBaseURL
+	}
+	return baseURL
+}
+
 func NewClient(ctx context.Context, creds Creds) (Client, error) {
 	var ret Client
 
@@ -44,11 +56,10 @@ func NewClient(ctx context.Context, creds Creds) (Client, error) {
 		return ret, fmt.Errorf("Password is empty")
 	}
 
-	baseURL := envutil.GetEnvVarStr("ONGOKU_CLI_SERVER_BASE_URL")
-	if baseURL == "" {
-		log.Warn(ctx, "Env variable ONGOKU_CLI_SERVER_BASE_URL is not set. Using default value", "default", _defaultBaseURL)
-		baseURL = _defaultBaseURL
+	if envutil.GetEnvVarStr(ServerBaseURLEnv) == "" {
+		log.Warn(ctx, "Env variable "+ServerBaseURLEnv+" is not set. Using default value", "default", _defaultBaseURL)
 	}
+	baseURL := BaseURL()
 	httpClient := &http.Client{}
 
 	// Make a login request
@@ -98,7 +109,7 @@ func (c Client) makeRequest(ctx context.Context, method string, path string, req
 			return err
 		}
 	}
-	httpReq, err := http.NewRequest(method, url, httpReqBody)
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, httpReqBody)
 	if err != nil {
 		return err
 	}
@@ -151,7 +162,7 @@ func MakeRequest(ctx context.Context, method string, url string, httpClient *htt
 			return err
 		}
 	}
-	httpReq, err := http.NewRequest(method, url, httpReqBody)
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, httpReqBody)
 	if err != nil {
 		return err
 	}
@@ -1,15 +1,20 @@
 package coreengine
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/teejays/gokutil/cmdutil"
 	"github.com/teejays/gokutil/env/envutil"
 	"github.com/teejays/gokutil/errutil"
 	"github.com/teejays/gokutil/panics"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
 )
 
 // Default license key file path is $HOME/.ongoku/license
@@ -35,7 +40,7 @@ func NewClientFromDefaultLicenseFile(ctx context.Context) (Client, error) {
 func NewClientFromLicenseFile(ctx context.Context, licenseFilePath string) (Client, error) {
 	license, err := os.ReadFile(licenseFilePath)
 	if err != nil {
-		return Client{}, errutil.Wrap(err, "Reading license file")
+		return Client{}, ogerr.Mark(errutil.Wrap(err, "Reading license file"), ogerr.CategoryLicense)
 	}
 	c := Client{
 		license:         string(license),
@@ -64,7 +69,7 @@ func NewClientFromLicense(ctx context.Context, license []byte) (Client, error) {
 
 func (c Client) Validate(ctx context.Context) error {
 	// Ensure that this works
-	cmd := exec.Command("goku", "version")
+	cmd := interrupt.Command(ctx, "goku", "version")
 	err := c.ExecuteCoreEngineCommand(ctx, cmd, cmdutil.ExecOptions{}, true)
 	if err != nil {
 		return err
@@ -72,6 +77,17 @@ func (c Client) Validate(ctx context.Context) error {
 	return nil
 }
 
+// Version returns the version of the core engine, as reported by `goku version`.
+func (c Client) Version(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	cmd := interrupt.Command(ctx, "goku", "version")
+	err := c.ExecuteCoreEngineCommand(ctx, cmd, cmdutil.ExecOptions{OutWriter: &out}, true)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
 func (c Client) ExecuteCoreEngineCommand(ctx context.Context, cmd *exec.Cmd, opts cmdutil.ExecOptions, withLicense bool) error {
 
 	// Todo: Decide whether to run it directly or through docker
@@ -84,13 +100,13 @@ func (c Client) ExecuteCoreEngineCommand(ctx context.Context, cmd *exec.Cmd, opt
 		} else if c.license != "" {
 			cmd.Args = append(cmd.Args, "--license", c.license)
 		} else {
-			return errutil.New("No license set in the client")
+			return ogerr.New(ogerr.CategoryLicense, "No license set in the client")
 		}
 	}
 
 	err := cmdutil.ExecOSCmdWithOpts(ctx, cmd, opts)
 	if err != nil {
-		return errutil.Wrap(err, "Running command")
+		return ogerr.Mark(errutil.Wrap(err, "Running command"), ogerr.CategoryEngine)
 	}
 
 	return nil
@@ -0,0 +1,143 @@
+// Package gitinfo provides information about the git state of the app, which is used to derive image tags and to record
+// what was built.
+package gitinfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+)
+
+type Info struct {
+	Commit      string `json:"commit"`
+	ShortCommit string `json:"short_commit"`
+	Branch      string `json:"branch,omitempty"`
+	Dirty       bool   `json:"dirty"`
+	// DirtyHash is a hash of the uncommitted changes. It is empty if the tree is clean.
+	DirtyHash string `json:"dirty_hash,omitempty"`
+}
+
+// Get returns the git state of the repository that contains dir. Changes to the excluded paths (relative to dir) are
+// left out of the dirty state e.g. files that og writes itself.
+func Get(ctx context.Context, dir string, exclude ...string) (Info, error) {
+	var ret Info
+	var err error
+	pathspec := []string{"."}
+	for _, p := range exclude {
+		pathspec = append(pathspec, ":(exclude)"+filepath.ToSlash(p))
+	}
+
+	ret.Commit, err = git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return ret, errutil.Wrap(err, "Getting current commit (is [%s] a git repository with at least one commit?)", dir)
+	}
+	ret.ShortCommit, err = git(ctx, dir, "rev-parse", "--short=12", "HEAD")
+	if err != nil {
+		return ret, errutil.Wrap(err, "Getting current short commit")
+	}
+
+	// Branch is empty in detached HEAD state
+	branch, err := git(ctx, dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return ret, errutil.Wrap(err, "Getting current branch")
+	}
+	if branch != "HEAD" {
+		ret.Branch = branch
+	}
+
+	// Dirty state: hash the status, the diff and the untracked files so that the same uncommitted changes result in the
+	// same hash
+	status, err := git(ctx, dir, append([]string{"status", "--porcelain", "--untracked-files=all", "--"}, pathspec...)...)
+	if err != nil {
+		return ret, errutil.Wrap(err, "Getting git status")
+	}
+	if status != "" {
+		h := sha256.New()
+		h.Write([]byte(status + "\n"))
+		diff, err := git(ctx, dir, append([]string{"diff", "HEAD", "--"}, pathspec...)...)
+		if err != nil {
+			return ret, errutil.Wrap(err, "Getting git diff")
+		}
+		h.Write([]byte(diff + "\n"))
+		err = hashUntracked(ctx, h, dir, pathspec)
+		if err != nil {
+			return ret, err
+		}
+		ret.Dirty = true
+		ret.DirtyHash = hex.EncodeToString(h.Sum(nil))[:12]
+	}
+
+	return ret, nil
+}
+
+// ImageTag returns a tag derived from the commit, and the uncommitted changes (if any) e.g. git-1a2b3c4d5e6f or
+// git-1a2b3c4d5e6f-dirty-9f8e7d6c5b4a.
+func (i Info) ImageTag() string {
+	tag := fmt.Sprintf("git-%s", i.ShortCommit)
+	if i.Dirty {
+		tag = fmt.Sprintf("%s-dirty-%s", tag, i.DirtyHash)
+	}
+	return tag
+}
+
+// hashUntracked writes the paths and contents of the untracked (and not ignored) files to h, since the diff does not
+// have them.
+func hashUntracked(ctx context.Context, h io.Writer, dir string, pathspec []string) error {
+	out, err := git(ctx, dir, append([]string{"ls-files", "-z", "--others", "--exclude-standard", "--"}, pathspec...)...)
+	if err != nil {
+		return errutil.Wrap(err, "Listing untracked files")
+	}
+	for _, p := range strings.Split(out, "\x00") {
+		if p == "" {
+			continue
+		}
+		full := filepath.Join(dir, p)
+		fi, err := os.Lstat(full)
+		if err != nil {
+			return errutil.Wrap(err, "Reading untracked file [%s]", p)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", p, fi.Mode().Type())
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(full)
+			if err != nil {
+				return errutil.Wrap(err, "Reading untracked symlink [%s]", p)
+			}
+			io.WriteString(h, target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(full)
+			if err != nil {
+				return errutil.Wrap(err, "Reading untracked file [%s]", p)
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return errutil.Wrap(err, "Reading untracked file [%s]", p)
+			}
+		}
+		io.WriteString(h, "\x00")
+	}
+	return nil
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := interrupt.Command(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Running [%s]: %w: %s", cmd.String(), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
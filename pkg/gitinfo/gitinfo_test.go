@@ -0,0 +1,120 @@
+package gitinfo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// testRepo returns a git repository with one commit of file.txt.
+func testRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	writeFile(t, dir, "file.txt", "committed\n")
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "first"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, dir string) Info {
+	t.Helper()
+	info, err := Get(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	return info
+}
+
+var (
+	_cleanTag = regexp.MustCompile(`^git-[0-9a-f]{12}$`)
+	_dirtyTag = regexp.MustCompile(`^git-[0-9a-f]{12}-dirty-[0-9a-f]{12}$`)
+)
+
+func TestGetClean(t *testing.T) {
+	dir := testRepo(t)
+	info := get(t, dir)
+	if info.Dirty || info.DirtyHash != "" {
+		t.Errorf("Get() = dirty %v (%q), want clean", info.Dirty, info.DirtyHash)
+	}
+	if info.Branch != "main" || len(info.Commit) != 40 || info.ShortCommit != info.Commit[:12] {
+		t.Errorf("Get() = %+v, want branch main and the commit", info)
+	}
+	if tag := info.ImageTag(); !_cleanTag.MatchString(tag) || tag != "git-"+info.ShortCommit {
+		t.Errorf("ImageTag() = %q, want git-%s", tag, info.ShortCommit)
+	}
+}
+
+func TestGetDirty(t *testing.T) {
+	dir := testRepo(t)
+
+	writeFile(t, dir, "file.txt", "changed\n")
+	changed := get(t, dir)
+	if !changed.Dirty || !_dirtyTag.MatchString(changed.ImageTag()) {
+		t.Fatalf("Get() with a changed file = %+v, tag %q, want dirty", changed, changed.ImageTag())
+	}
+	if again := get(t, dir); again.DirtyHash != changed.DirtyHash {
+		t.Errorf("Get() dirty hash = %q then %q for the same changes, want the same", changed.DirtyHash, again.DirtyHash)
+	}
+
+	// The contents of untracked files count, not just their names
+	writeFile(t, dir, "new.txt", "one\n")
+	untracked := get(t, dir)
+	writeFile(t, dir, "new.txt", "two\n")
+	untrackedChanged := get(t, dir)
+	if untracked.DirtyHash == changed.DirtyHash || untrackedChanged.DirtyHash == untracked.DirtyHash {
+		t.Errorf("Get() dirty hashes = %q, %q, %q, want them all different", changed.DirtyHash, untracked.DirtyHash, untrackedChanged.DirtyHash)
+	}
+
+	// Ignored files don't
+	writeFile(t, dir, ".gitignore", "ignored.txt\n")
+	withIgnore := get(t, dir)
+	writeFile(t, dir, "ignored.txt", "anything\n")
+	if ignored := get(t, dir); ignored.DirtyHash != withIgnore.DirtyHash {
+		t.Errorf("Get() dirty hash changed with an ignored file: %q, %q", withIgnore.DirtyHash, ignored.DirtyHash)
+	}
+}
+
+func TestGetExclude(t *testing.T) {
+	dir := testRepo(t)
+	err := os.Mkdir(filepath.Join(dir, "out"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "out/written.json", "{}\n")
+	writeFile(t, dir, "file.txt", "changed\n")
+
+	info, err := Get(context.Background(), dir, "out/written.json", "file.txt")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if info.Dirty {
+		t.Errorf("Get() with only excluded changes = dirty (%q), want clean", info.DirtyHash)
+	}
+	if info := get(t, dir); !info.Dirty {
+		t.Errorf("Get() without exclusions = clean, want dirty")
+	}
+}
@@ -0,0 +1,93 @@
+// Package interrupt stops og gracefully on Ctrl+C (SIGINT) and SIGTERM. The first signal cancels the context of the
+// command, which stops what it's doing, and asks the processes it runs (see Command) to stop. A second signal kills them
+// and exits right away.
+package interrupt
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+// GracePeriod is how long the processes run by og have to stop once asked to, before they are killed.
+const GracePeriod = 10 * time.Second
+
+var _interrupted atomic.Bool
+
+// _stopping are the processes that were asked to stop, to kill on a second signal.
+var _stopping struct {
+	sync.Mutex
+	procs []*os.Process
+}
+
+// NotifyContext returns a copy of ctx that is cancelled on the first SIGINT or SIGTERM. On a second one, the processes
+// being stopped are killed and og exits with the exit code of cancelled errors.
+func NotifyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			_interrupted.Store(true)
+			log.Warn(ctx, "Interrupted, stopping. Press Ctrl+C again to exit right away.", "signal", sig.String(), "gracePeriod", GracePeriod)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigs:
+			log.Error(ctx, "Interrupted again, exiting without waiting for the running processes to stop")
+			killStopping()
+			os.Exit(ogerr.CategoryCancelled.ExitCode())
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+			cancel()
+		})
+	}
+}
+
+// Interrupted tells whether og got a signal to stop.
+func Interrupted() bool {
+	return _interrupted.Load()
+}
+
+// Command is like exec.CommandContext, except that when ctx is cancelled the process gets a SIGTERM to stop gracefully,
+// and is only killed if it hasn't stopped after GracePeriod.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		_stopping.Lock()
+		_stopping.procs = append(_stopping.procs, cmd.Process)
+		_stopping.Unlock()
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = GracePeriod
+	return cmd
+}
+
+func killStopping() {
+	_stopping.Lock()
+	defer _stopping.Unlock()
+	for _, p := range _stopping.procs {
+		_ = p.Kill()
+	}
+}
@@ -0,0 +1,188 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type Action string
+
+const (
+	ActionCreated    Action = "created"
+	ActionConfigured Action = "configured"
+	ActionUnchanged  Action = "unchanged"
+	ActionDeleted    Action = "deleted"
+	ActionNotFound   Action = "not found"
+)
+
+// Result is the outcome of applying (or deleting) one object.
+type Result struct {
+	ObjectRef
+	Action Action `json:"action"`
+	// Object is the object as returned by the API server (nil for deletes).
+	Object *unstructured.Unstructured `json:"-"`
+	// Live is the object as it was in the cluster before the apply (nil if it did not exist).
+	Live *unstructured.Unstructured `json:"-"`
+}
+
+type ApplyOptions struct {
+	// DryRun makes the API server process the request (validation, defaulting, admission) without persisting it.
+	DryRun bool
+}
+
+// Apply applies the objects using server-side apply, in dependency order (see SortForApply). Conflicts with other field
+// managers are resolved in favour of og, like `kubectl apply --server-side --force-conflicts`.
+func (c *Client) Apply(ctx context.Context, objs []*unstructured.Unstructured, opts ApplyOptions) ([]Result, error) {
+	objs = slices.Clone(objs)
+	SortForApply(objs)
+
+	var results []Result
+	for _, obj := range objs {
+		res, err := c.applyOne(ctx, obj, opts)
+		if err != nil {
+			return results, errutil.Wrap(err, "Applying [%s]", RefOf(obj))
+		}
+		log.Debug(ctx, "Applied object", "object", res.ObjectRef.String(), "action", res.Action, "dryRun", opts.DryRun)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// applyOne applies a copy of obj, so that the caller's object is left as is (e.g. without the default namespace).
+func (c *Client) applyOne(ctx context.Context, obj *unstructured.Unstructured, opts ApplyOptions) (Result, error) {
+	obj = obj.DeepCopy()
+	ri, _, err := c.resourceFor(obj)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{ObjectRef: RefOf(obj)}
+
+	// What's there right now, to tell created/configured/unchanged apart
+	live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return res, errutil.Wrap(err, "Getting live object")
+		}
+		live = nil
+	}
+	res.Live = live
+
+	// Server-side apply does not allow these fields in the request
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return res, errutil.Wrap(err, "Marshalling object")
+	}
+	force := true
+	patchOpts := metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+	}
+	if opts.DryRun {
+		patchOpts.DryRun = []string{metav1.DryRunAll}
+	}
+	applied, err := ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, patchOpts)
+	if err != nil && opts.DryRun && apierrors.IsNotFound(err) && live == nil && obj.GetNamespace() != "" {
+		// The server cannot dry run objects in a namespace that does not exist yet (e.g. one created by the same
+		// apply). They would be created, so the local object is used.
+		missing, nsErr := c.namespaceMissing(ctx, obj.GetNamespace())
+		if nsErr != nil {
+			return res, nsErr
+		}
+		if missing {
+			res.Object, res.Action = obj, ActionCreated
+			return res, nil
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+	res.Object = applied
+
+	switch {
+	case live == nil:
+		res.Action = ActionCreated
+	case opts.DryRun:
+		// Dry runs don't bump the resource version, so compare the content
+		res.Action = ActionConfigured
+		if equalIgnoringMetadataNoise(live, applied) {
+			res.Action = ActionUnchanged
+		}
+	case live.GetResourceVersion() == applied.GetResourceVersion():
+		res.Action = ActionUnchanged
+	default:
+		res.Action = ActionConfigured
+	}
+
+	return res, nil
+}
+
+// namespaceMissing tells whether the namespace does not exist.
+func (c *Client) namespaceMissing(ctx context.Context, namespace string) (bool, error) {
+	_, err := c.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errutil.Wrap(err, "Getting namespace [%s]", namespace)
+	}
+	return false, nil
+}
+
+// Delete deletes the objects, in the reverse of the apply order. Objects that do not exist are reported as not found.
+func (c *Client) Delete(ctx context.Context, objs []*unstructured.Unstructured, dryRun bool) ([]Result, error) {
+	objs = slices.Clone(objs)
+	SortForApply(objs)
+	slices.Reverse(objs)
+
+	propagation := metav1.DeletePropagationForeground
+	var results []Result
+	for _, obj := range objs {
+		obj = obj.DeepCopy()
+		ri, _, err := c.resourceFor(obj)
+		if err != nil {
+			return results, errutil.Wrap(err, "Deleting [%s]", RefOf(obj))
+		}
+		delOpts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+		if dryRun {
+			delOpts.DryRun = []string{metav1.DryRunAll}
+		}
+		res := Result{ObjectRef: RefOf(obj), Action: ActionDeleted}
+		err = ri.Delete(ctx, obj.GetName(), delOpts)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return results, errutil.Wrap(err, "Deleting [%s]", RefOf(obj))
+			}
+			res.Action = ActionNotFound
+		}
+		log.Debug(ctx, "Deleted object", "object", res.ObjectRef.String(), "action", res.Action, "dryRun", dryRun)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// equalIgnoringMetadataNoise compares two versions of an object, ignoring the metadata fields that change on every write.
+func equalIgnoringMetadataNoise(a, b *unstructured.Unstructured) bool {
+	clean := func(u *unstructured.Unstructured) map[string]interface{} {
+		u = u.DeepCopy()
+		u.SetResourceVersion("")
+		u.SetManagedFields(nil)
+		u.SetGeneration(0)
+		unstructured.RemoveNestedField(u.Object, "status")
+		return u.Object
+	}
+	ja, _ := json.Marshal(clean(a))
+	jb, _ := json.Marshal(clean(b))
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,347 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// The tests run against a local API server (etcd and kube-apiserver, without controllers) started by envtest. They are
+// skipped unless KUBEBUILDER_ASSETS points to the binaries, which `make test-envtest` sets up, or e.g.
+//
+//	export KUBEBUILDER_ASSETS=$(go run sigs.k8s.io/controller-runtime/tools/setup-envtest@latest use 1.32 -p path)
+//	go test ./pkg/kube
+var _testRestConfig *rest.Config
+
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		os.Exit(m.Run())
+	}
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Starting the test API server:", err)
+		os.Exit(1)
+	}
+	_testRestConfig = cfg
+	code := m.Run()
+	err = env.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Stopping the test API server:", err)
+	}
+	os.Exit(code)
+}
+
+var _nonNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// testClient returns a client of the test API server, with a new namespace for the test as its default namespace.
+func testClient(t *testing.T) *Client {
+	t.Helper()
+	if _testRestConfig == nil {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	ns := "og-" + strings.Trim(_nonNameChars.ReplaceAllString(strings.ToLower(t.Name()), "-"), "-")
+	c, err := NewClientFromConfig(_testRestConfig, ns)
+	if err != nil {
+		t.Fatalf("NewClientFromConfig() error = %v", err)
+	}
+	_, err = c.Clientset.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Creating namespace [%s] error = %v", ns, err)
+	}
+	return c
+}
+
+func decode(t *testing.T, manifest string) []*unstructured.Unstructured {
+	t.Helper()
+	objs, err := DecodeManifest([]byte(manifest))
+	if err != nil {
+		t.Fatalf("DecodeManifest() error = %v", err)
+	}
+	return objs
+}
+
+func actions(results []Result) map[string]Action {
+	ret := map[string]Action{}
+	for _, res := range results {
+		ret[res.Kind+"/"+res.Name] = res.Action
+	}
+	return ret
+}
+
+func configMap(name string, value string) string {
+	return fmt.Sprintf(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: %s
+data:
+  key: %s
+`, name, value)
+}
+
+const _testDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+spec:
+  selector:
+    matchLabels:
+      app: backend
+  template:
+    metadata:
+      labels:
+        app: backend
+    spec:
+      containers:
+      - name: backend
+        image: example.com/backend:v1
+`
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	c := testClient(t)
+	objs := decode(t, configMap("config", "v1")+"---"+_testDeployment)
+
+	results, err := c.Apply(ctx, objs, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := map[string]Action{"ConfigMap/config": ActionCreated, "Deployment/backend": ActionCreated}
+	if got := actions(results); !mapsEqual(got, want) {
+		t.Errorf("Apply() actions = %v, want %v", got, want)
+	}
+	for _, obj := range objs {
+		if obj.GetNamespace() != "" {
+			t.Errorf("Apply() set the namespace of the object [%s] to [%s], want it left as is", obj.GetName(), obj.GetNamespace())
+		}
+	}
+
+	cm, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, "config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Getting applied ConfigMap error = %v", err)
+	}
+	if !slices.ContainsFunc(cm.ManagedFields, func(f metav1.ManagedFieldsEntry) bool {
+		return f.Manager == FieldManager && f.Operation == metav1.ManagedFieldsOperationApply
+	}) {
+		t.Errorf("Applied ConfigMap managed fields = %v, want an apply by [%s]", cm.ManagedFields, FieldManager)
+	}
+
+	// Applying the same objects again changes nothing
+	results, err = c.Apply(ctx, objs, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Apply() again error = %v", err)
+	}
+	want = map[string]Action{"ConfigMap/config": ActionUnchanged, "Deployment/backend": ActionUnchanged}
+	if got := actions(results); !mapsEqual(got, want) {
+		t.Errorf("Apply() again actions = %v, want %v", got, want)
+	}
+
+	// Fields set by someone else are taken over
+	cm.Data["key"] = "edited"
+	_, err = c.Clientset.CoreV1().ConfigMaps(c.Namespace).Update(ctx, cm, metav1.UpdateOptions{FieldManager: "someone-else"})
+	if err != nil {
+		t.Fatalf("Updating ConfigMap error = %v", err)
+	}
+	results, err = c.Apply(ctx, decode(t, configMap("config", "v2")), ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Apply() of a conflicting change error = %v", err)
+	}
+	if got := actions(results)["ConfigMap/config"]; got != ActionConfigured {
+		t.Errorf("Apply() of a change action = %s, want %s", got, ActionConfigured)
+	}
+	cm, err = c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, "config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Getting applied ConfigMap error = %v", err)
+	}
+	if cm.Data["key"] != "v2" {
+		t.Errorf("Applied ConfigMap data = %v, want key: v2", cm.Data)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	ctx := context.Background()
+	c := testClient(t)
+
+	results, err := c.Apply(ctx, decode(t, configMap("config", "v1")), ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Apply() dry run error = %v", err)
+	}
+	if got := actions(results)["ConfigMap/config"]; got != ActionCreated {
+		t.Errorf("Apply() dry run of a new object action = %s, want %s", got, ActionCreated)
+	}
+	_, err = c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, "config", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Getting ConfigMap after a dry run error = %v, want not found", err)
+	}
+
+	_, err = c.Apply(ctx, decode(t, configMap("config", "v1")), ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	tests := []struct {
+		value string
+		want  Action
+	}{
+		{value: "v1", want: ActionUnchanged},
+		{value: "v2", want: ActionConfigured},
+	}
+	for _, tt := range tests {
+		results, err := c.Apply(ctx, decode(t, configMap("config", tt.value)), ApplyOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("Apply() dry run with value [%s] error = %v", tt.value, err)
+		}
+		if got := actions(results)["ConfigMap/config"]; got != tt.want {
+			t.Errorf("Apply() dry run with value [%s] action = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+	cm, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, "config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Getting ConfigMap error = %v", err)
+	}
+	if cm.Data["key"] != "v1" {
+		t.Errorf("ConfigMap data after a dry run = %v, want key: v1", cm.Data)
+	}
+}
+
+func TestApplyDryRunNewNamespace(t *testing.T) {
+	ctx := context.Background()
+	c := testClient(t)
+	ns := c.Namespace + "-new"
+
+	// The namespace is created by the same apply, and another object is in a namespace that is not in the manifests
+	objs := decode(t, fmt.Sprintf(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: %s
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: %s
+data:
+  key: v1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+  namespace: %s-other
+data:
+  key: v1
+`, ns, ns, ns))
+	results, err := c.Apply(ctx, objs, ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Apply() dry run into new namespaces error = %v", err)
+	}
+	want := map[string]Action{"Namespace/" + ns: ActionCreated, "ConfigMap/config": ActionCreated, "ConfigMap/other": ActionCreated}
+	if got := actions(results); !mapsEqual(got, want) {
+		t.Errorf("Apply() dry run into new namespaces actions = %v, want %v", got, want)
+	}
+	_, err = c.Clientset.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Getting namespace after a dry run error = %v, want not found", err)
+	}
+
+	diffs, err := c.Diff(ctx, objs, "myapp", DiffOptions{})
+	if err != nil {
+		t.Fatalf("Diff() into new namespaces error = %v", err)
+	}
+	for _, dr := range diffs {
+		if dr.Action != ActionCreated || dr.Diff == "" {
+			t.Errorf("Diff() of [%s] = %s with diff %q, want created with a diff", dr.ObjectRef, dr.Action, dr.Diff)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	c := testClient(t)
+
+	ours := decode(t, configMap("kept", "v1")+"---"+configMap("removed", "v1"))
+	SetDeployLabels(ours, "myapp")
+	theirs := decode(t, configMap("other-deployment", "v1"))
+	SetDeployLabels(theirs, "otherapp")
+	unlabelled := decode(t, configMap("unlabelled", "v1"))
+	for _, objs := range [][]*unstructured.Unstructured{ours, theirs, unlabelled} {
+		_, err := c.Apply(ctx, objs, ApplyOptions{})
+		if err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+
+	// "removed" is no longer in the manifests
+	next := decode(t, configMap("kept", "v2"))
+	SetDeployLabels(next, "myapp")
+	prunable, err := c.FindPrunable(ctx, next, "myapp")
+	if err != nil {
+		t.Fatalf("FindPrunable() error = %v", err)
+	}
+	var names []string
+	for _, obj := range prunable {
+		names = append(names, obj.GetName())
+	}
+	if !slices.Equal(names, []string{"removed"}) {
+		t.Fatalf("FindPrunable() = %v, want [removed]", names)
+	}
+
+	results, err := c.Delete(ctx, prunable, true)
+	if err != nil {
+		t.Fatalf("Delete() dry run error = %v", err)
+	}
+	if got := actions(results)["ConfigMap/removed"]; got != ActionDeleted {
+		t.Errorf("Delete() dry run action = %s, want %s", got, ActionDeleted)
+	}
+	cm, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, "removed", metav1.GetOptions{})
+	if err != nil || cm.DeletionTimestamp != nil {
+		t.Fatalf("Getting ConfigMap after a dry run delete = %v, %v, want it untouched", cm, err)
+	}
+
+	results, err = c.Delete(ctx, prunable, false)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := actions(results)["ConfigMap/removed"]; got != ActionDeleted {
+		t.Errorf("Delete() action = %s, want %s", got, ActionDeleted)
+	}
+	// Without the garbage collector of a real cluster, foreground deletes stay pending
+	cm, err = c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, "removed", metav1.GetOptions{})
+	if err == nil && cm.DeletionTimestamp == nil {
+		t.Errorf("Pruned ConfigMap is not being deleted")
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatalf("Getting pruned ConfigMap error = %v", err)
+	}
+	for _, name := range []string{"kept", "other-deployment", "unlabelled"} {
+		_, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("Getting ConfigMap [%s] after prune error = %v, want it kept", name, err)
+		}
+	}
+}
+
+func mapsEqual(a, b map[string]Action) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
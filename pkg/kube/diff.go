@@ -0,0 +1,266 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const _diffContextLines = 3
+
+const _lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// DiffResult is the change that applying one object would make to the cluster.
+type DiffResult struct {
+	ObjectRef
+	// Action is created, configured, unchanged or deleted.
+	Action Action `json:"action"`
+	// Diff is the unified diff between the live and the merged object (empty if unchanged).
+	Diff string `json:"diff,omitempty"`
+}
+
+// DiffOptions change what Diff compares.
+type DiffOptions struct {
+	// Prune reports the objects of the deployment that are in the cluster but not in objs (see FindPrunable) as deleted,
+	// as they would be by an apply that prunes.
+	Prune bool
+}
+
+// Diff compares the objects with the live state of the cluster, using a server-side dry run so that defaulting and
+// admission are taken into account.
+func (c *Client) Diff(ctx context.Context, objs []*unstructured.Unstructured, identifier string, opts DiffOptions) ([]DiffResult, error) {
+	objs = slices.Clone(objs)
+	SortForApply(objs)
+
+	var results []DiffResult
+	for _, obj := range objs {
+		res, err := c.applyOne(ctx, obj, ApplyOptions{DryRun: true})
+		if err != nil {
+			return results, errutil.Wrap(err, "Dry running apply of [%s]", RefOf(obj))
+		}
+
+		dr, err := diffResult(res.ObjectRef, res.Action, res.Live, res.Object)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, dr)
+	}
+	if !opts.Prune {
+		return results, nil
+	}
+
+	prunable, err := c.FindPrunable(ctx, objs, identifier)
+	if err != nil {
+		return results, errutil.Wrap(err, "Finding objects removed from the manifests")
+	}
+	for _, obj := range prunable {
+		dr, err := diffResult(RefOf(obj), ActionDeleted, obj, nil)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, dr)
+	}
+
+	return results, nil
+}
+
+func diffResult(ref ObjectRef, action Action, live, merged *unstructured.Unstructured) (DiffResult, error) {
+	dr := DiffResult{ObjectRef: ref, Action: action}
+	if action == ActionUnchanged {
+		return dr, nil
+	}
+	from, err := diffableYAML(live)
+	if err != nil {
+		return dr, errutil.Wrap(err, "Converting live [%s] to yaml", ref)
+	}
+	to, err := diffableYAML(merged)
+	if err != nil {
+		return dr, errutil.Wrap(err, "Converting merged [%s] to yaml", ref)
+	}
+	name := strings.ToLower(ref.Kind) + "/" + ref.Name
+	if ref.Namespace != "" {
+		name = ref.Namespace + "/" + name
+	}
+	dr.Diff = UnifiedDiff("live/"+name, "merged/"+name, from, to)
+	// Only the fields that are left out of the diff changed
+	if dr.Diff == "" {
+		dr.Action = ActionUnchanged
+	}
+	return dr, nil
+}
+
+// diffableYAML returns the object as yaml, without the fields that the server manages and that change on every write.
+func diffableYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	obj = obj.DeepCopy()
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	obj.SetUID("")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(obj.Object, "status")
+	if obj.GetKind() == "Secret" && obj.GroupVersionKind().Group == "" {
+		redactSecret(obj)
+	}
+	b, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// redactSecret replaces the values of the secret with their hash, so that diffs show which values change without
+// showing them.
+func redactSecret(obj *unstructured.Unstructured) {
+	for _, field := range []string{"data", "stringData"} {
+		values, _, _ := unstructured.NestedMap(obj.Object, field)
+		for k, v := range values {
+			sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+			values[k] = "redacted:sha256:" + hex.EncodeToString(sum[:])[:12]
+		}
+		if values != nil {
+			_ = unstructured.SetNestedMap(obj.Object, values, field)
+		}
+	}
+	// It has the values too, when the secret was applied with kubectl
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[_lastAppliedAnnotation]; ok {
+		delete(annotations, _lastAppliedAnnotation)
+		obj.SetAnnotations(annotations)
+	}
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns the unified diff (as in `diff -u`) from one text to another, or an empty string if they are the
+// same.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	a, b := splitLines(from), splitLines(to)
+	ops := lineDiff(a, b)
+	if !slices.ContainsFunc(ops, func(op diffOp) bool { return op.kind != ' ' }) {
+		return ""
+	}
+
+	// Line numbers (in a and b) at the start of each op
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		aPos[k+1], bPos[k+1] = aPos[k], bPos[k]
+		if op.kind != '+' {
+			aPos[k+1]++
+		}
+		if op.kind != '-' {
+			bPos[k+1]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	i := 0
+	for i < len(ops) {
+		// Find the next change
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Extend the hunk until the changes are more than two contexts apart
+		start := max(0, i-_diffContextLines)
+		end := i
+		for k := i; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			} else if k-end > 2*_diffContextLines {
+				break
+			}
+		}
+		stop := min(len(ops), end+_diffContextLines+1)
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[stop]-aPos[start]),
+			hunkRange(bPos[start], bPos[stop]-bPos[start]),
+		)
+		for _, op := range ops[start:stop] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i = stop
+	}
+
+	return sb.String()
+}
+
+func hunkRange(pos, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", pos)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", pos+1)
+	}
+	return fmt.Sprintf("%d,%d", pos+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineDiff returns the edit script from a to b, based on their longest common subsequence. Manifests are small, so
+// the quadratic table is fine.
+func lineDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "same",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "created",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- live\n+++ merged\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "deleted",
+			from: "a\n",
+			to:   "",
+			want: "--- live\n+++ merged\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "changed line with context",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- live\n+++ merged\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "changes far apart are separate hunks",
+			from: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			to:   "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			want: "--- live\n+++ merged\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+		{
+			name: "changes close together are one hunk",
+			from: "a\n1\n2\n3\nb\n",
+			to:   "A\n1\n2\n3\nB\n",
+			want: "--- live\n+++ merged\n@@ -1,5 +1,5 @@\n-a\n+A\n 1\n 2\n 3\n-b\n+B\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := UnifiedDiff("live", "merged", tt.from, tt.to)
+			if got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffableYAMLRedactsSecrets(t *testing.T) {
+	secret := func(value string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":            "creds",
+				"resourceVersion": "42",
+				"uid":             "1234",
+				"annotations": map[string]interface{}{
+					_lastAppliedAnnotation: `{"data":{"password":"` + value + `"}}`,
+					"note":                 "kept",
+				},
+			},
+			"data":       map[string]interface{}{"password": value},
+			"stringData": map[string]interface{}{"token": value},
+		}}
+	}
+
+	got, err := diffableYAML(secret("aHVudGVyMg=="))
+	if err != nil {
+		t.Fatalf("diffableYAML() error = %v", err)
+	}
+	for _, leak := range []string{"aHVudGVyMg==", _lastAppliedAnnotation, "resourceVersion", "uid"} {
+		if strings.Contains(got, leak) {
+			t.Errorf("diffableYAML() contains %q:\n%s", leak, got)
+		}
+	}
+	for _, want := range []string{"password: redacted:sha256:", "token: redacted:sha256:", "note: kept"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diffableYAML() does not contain %q:\n%s", want, got)
+		}
+	}
+
+	// A changed value changes the redacted value, so that the diff shows which key changed
+	other, err := diffableYAML(secret("c3dvcmRmaXNo"))
+	if err != nil {
+		t.Fatalf("diffableYAML() error = %v", err)
+	}
+	diff := UnifiedDiff("live", "merged", got, other)
+	if !strings.Contains(diff, "-  password: redacted:sha256:") || !strings.Contains(diff, "+  password: redacted:sha256:") {
+		t.Errorf("UnifiedDiff() of the redacted secrets does not show the changed password:\n%s", diff)
+	}
+
+	// Only core Secrets are redacted
+	cm := secret("plain")
+	cm.SetKind("ConfigMap")
+	got, err = diffableYAML(cm)
+	if err != nil {
+		t.Fatalf("diffableYAML() error = %v", err)
+	}
+	if !strings.Contains(got, "password: plain") {
+		t.Errorf("diffableYAML() of a ConfigMap is redacted:\n%s", got)
+	}
+}
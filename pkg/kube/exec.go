@@ -0,0 +1,245 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+// LabelOneOffOf is set on the one-off jobs (see CreateOneOffJob) to the name of the workload they were created from.
+const LabelOneOffOf = "ongoku.build/one-off-of"
+
+// _oneOffJobTTL is how long finished one-off jobs are kept, if they are not deleted by og.
+const _oneOffJobTTL int32 = 3600
+
+// ExecOptions are the streams of a command run in a container.
+type ExecOptions struct {
+	Container string
+	// Stdin is nil if the command does not read stdin
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Stderr is not used with a TTY, since it's merged into stdout
+	Stderr io.Writer
+	TTY    bool
+	// TerminalSize returns the size of the local terminal, when TTY is set
+	TerminalSize func() (width uint16, height uint16, ok bool)
+}
+
+// Exec runs the command in a container of the pod.
+func (c *Client) Exec(ctx context.Context, pod corev1.Pod, command []string, opts ExecOptions) error {
+	req := c.Clientset.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    true,
+			Stderr:    !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+	return c.stream(ctx, req.URL(), opts)
+}
+
+// Attach attaches to the main process of a container of the pod. The container must have been created with the same
+// stdin and TTY settings.
+func (c *Client) Attach(ctx context.Context, pod corev1.Pod, opts ExecOptions) error {
+	req := c.Clientset.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("attach").
+		VersionedParams(&corev1.PodAttachOptions{
+			Container: opts.Container,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    true,
+			Stderr:    !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+	return c.stream(ctx, req.URL(), opts)
+}
+
+func (c *Client) stream(ctx context.Context, u *url.URL, opts ExecOptions) error {
+	executor, err := remotecommand.NewSPDYExecutor(c.RestConfig, "POST", u)
+	if err != nil {
+		return errutil.Wrap(err, "Creating executor")
+	}
+	streamOpts := remotecommand.StreamOptions{Stdin: opts.Stdin, Stdout: opts.Stdout, Tty: opts.TTY}
+	if !opts.TTY {
+		streamOpts.Stderr = opts.Stderr
+	}
+	if opts.TTY && opts.TerminalSize != nil {
+		streamOpts.TerminalSizeQueue = &terminalSizeQueue{size: opts.TerminalSize}
+	}
+
+	err = executor.StreamWithContext(ctx, streamOpts)
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return ogerr.ExitCodeError{Code: exitErr.ExitStatus()}
+	}
+	return err
+}
+
+// terminalSizeQueue sends the size of the local terminal once, when the stream starts.
+type terminalSizeQueue struct {
+	size func() (uint16, uint16, bool)
+	sent bool
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	if q.sent {
+		return nil
+	}
+	q.sent = true
+	w, h, ok := q.size()
+	if !ok {
+		return nil
+	}
+	return &remotecommand.TerminalSize{Width: w, Height: h}
+}
+
+// PodTemplateOf returns the pod template of the workload.
+func (c *Client) PodTemplateOf(ctx context.Context, ref ObjectRef) (corev1.PodTemplateSpec, error) {
+	switch ref.Kind {
+	case GVKDeployment.Kind:
+		o, err := c.Clientset.AppsV1().Deployments(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return corev1.PodTemplateSpec{}, err
+		}
+		return o.Spec.Template, nil
+	case GVKStatefulSet.Kind:
+		o, err := c.Clientset.AppsV1().StatefulSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return corev1.PodTemplateSpec{}, err
+		}
+		return o.Spec.Template, nil
+	case GVKDaemonSet.Kind:
+		o, err := c.Clientset.AppsV1().DaemonSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return corev1.PodTemplateSpec{}, err
+		}
+		return o.Spec.Template, nil
+	case GVKJob.Kind:
+		o, err := c.Clientset.BatchV1().Jobs(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return corev1.PodTemplateSpec{}, err
+		}
+		return o.Spec.Template, nil
+	}
+	return corev1.PodTemplateSpec{}, fmt.Errorf("Kind [%s] is not a tracked workload", ref.Kind)
+}
+
+// CreateOneOffJob creates a Job that runs the command once, in a pod created from the pod template of the workload
+// (same image, env and volumes). The pod does not get the labels of the workload, so that it does not receive traffic
+// from its services. With interactive, the container is created with stdin and a TTY, to be attached to.
+func (c *Client) CreateOneOffJob(ctx context.Context, ref ObjectRef, tmpl corev1.PodTemplateSpec, container string, command []string, interactive bool) (*batchv1.Job, error) {
+	tmpl = *tmpl.DeepCopy()
+	var main *corev1.Container
+	for i := range tmpl.Spec.Containers {
+		if tmpl.Spec.Containers[i].Name == container {
+			main = &tmpl.Spec.Containers[i]
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("Workload [%s] has no container [%s]", ref, container)
+	}
+	main.Command, main.Args = command, nil
+	// Probes are for serving pods, and would restart a long command
+	main.LivenessProbe, main.ReadinessProbe, main.StartupProbe = nil, nil, nil
+	main.Stdin, main.StdinOnce, main.TTY = interactive, interactive, interactive
+	// Sidecars would keep the job running after the command is done
+	tmpl.Spec.Containers = []corev1.Container{*main}
+	tmpl.Spec.RestartPolicy = corev1.RestartPolicyNever
+
+	labels := map[string]string{LabelManagedBy: FieldManager, LabelOneOffOf: ref.Name}
+	tmpl.Labels = labels
+	backoffLimit, ttl := int32(0), _oneOffJobTTL
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: ref.Name + "-run-", Namespace: ref.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template:                tmpl,
+		},
+	}
+	job, err := c.Clientset.BatchV1().Jobs(ref.Namespace).Create(ctx, job, metav1.CreateOptions{FieldManager: FieldManager})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating job")
+	}
+	return job, nil
+}
+
+// WaitForJobPod waits for the pod of the job to be running (or already done), and returns it.
+func (c *Client) WaitForJobPod(ctx context.Context, job *batchv1.Job, timeout time.Duration) (corev1.Pod, error) {
+	var pod corev1.Pod
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		list, err := c.Clientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job.Name})
+		if err != nil {
+			return false, err
+		}
+		for _, p := range list.Items {
+			switch p.Status.Phase {
+			case corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed:
+				pod = p
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return pod, errutil.Wrap(err, "Waiting for the pod of job [%s] to start", job.Name)
+	}
+	return pod, nil
+}
+
+// WaitForContainerExit waits for the container of the pod to terminate, and returns its exit code.
+func (c *Client) WaitForContainerExit(ctx context.Context, pod corev1.Pod, container string) (int, error) {
+	var code int
+	err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		p, err := c.Clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, cs := range p.Status.ContainerStatuses {
+			if cs.Name == container && cs.State.Terminated != nil {
+				code = int(cs.State.Terminated.ExitCode)
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return 0, errutil.Wrap(err, "Waiting for container [%s] of pod [%s] to exit", container, pod.Name)
+	}
+	return code, nil
+}
+
+// DeleteJob deletes the job and its pods.
+func (c *Client) DeleteJob(ctx context.Context, job *batchv1.Job) error {
+	propagation := metav1.DeletePropagationBackground
+	err := c.Clientset.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errutil.Wrap(err, "Deleting job [%s]", job.Name)
+	}
+	return nil
+}
+
+// PodLogs copies the logs of a container of the pod to w.
+func (c *Client) PodLogs(ctx context.Context, pod corev1.Pod, container string, follow bool, w io.Writer) error {
+	rc, err := c.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: container, Follow: follow}).Stream(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Streaming logs of [%s/%s]", pod.Name, container)
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
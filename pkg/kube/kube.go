@@ -0,0 +1,152 @@
+// Package kube is a small Kubernetes client for deploying Ongoku apps. It applies manifests using server-side apply and
+// tracks the readiness of the workloads, without depending on kubectl being installed.
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teejays/gokutil/errutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+// FieldManager is the field manager used for server-side apply, so that the fields owned by og can be told apart from
+// those set by other tools.
+const FieldManager = "og"
+
+// Options select the cluster and the default namespace.
+type Options struct {
+	// Kubeconfig is the path to the kubeconfig file. Defaults to KUBECONFIG or ~/.kube/config.
+	Kubeconfig string
+	// Context is the kubeconfig context. Defaults to the current context.
+	Context string
+	// Namespace is the default namespace for namespaced objects that do not have one. Defaults to the context's namespace.
+	Namespace string
+}
+
+type Client struct {
+	RestConfig *rest.Config
+	Clientset  kubernetes.Interface
+	Dynamic    dynamic.Interface
+	Mapper     meta.ResettableRESTMapper
+	// Namespace is the default namespace
+	Namespace string
+}
+
+// NewClient creates a client from the kubeconfig.
+func NewClient(ctx context.Context, opts Options) (*Client, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if opts.Kubeconfig != "" {
+		loadingRules.ExplicitPath = opts.Kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: opts.Context,
+	}
+	clientCfg := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+
+	restCfg, err := clientCfg.ClientConfig()
+	if err != nil {
+		return nil, ogerr.Mark(errutil.Wrap(err, "Loading kubeconfig"), ogerr.CategoryAuth)
+	}
+
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace, _, err = clientCfg.Namespace()
+		if err != nil {
+			return nil, errutil.Wrap(err, "Getting namespace from kubeconfig")
+		}
+	}
+
+	return NewClientFromConfig(restCfg, namespace)
+}
+
+// NewClientFromConfig creates a client from a rest config e.g. one pointing to a local test API server.
+func NewClientFromConfig(restCfg *rest.Config, namespace string) (*Client, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating kubernetes clientset")
+	}
+	dyn, err := dynamic.NewForConfig(restCfg)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating kubernetes dynamic client")
+	}
+	disc, err := discovery.NewDiscoveryClientForConfig(restCfg)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating kubernetes discovery client")
+	}
+
+	return &Client{
+		RestConfig: restCfg,
+		Clientset:  clientset,
+		Dynamic:    dyn,
+		Mapper:     restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disc)),
+		Namespace:  namespace,
+	}, nil
+}
+
+// resourceFor returns the dynamic resource interface for the object, and sets the default namespace on namespaced
+// objects that do not have one.
+func (c *Client) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, *meta.RESTMapping, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		// The kind may have just been created (e.g. a CRD), so refresh the discovery info and try again
+		c.Mapper.Reset()
+		mapping, err = c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, nil, errutil.Wrap(err, "Finding resource for kind [%s]", gvk.String())
+		}
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.Dynamic.Resource(mapping.Resource), mapping, nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(c.Namespace)
+	}
+	return c.Dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), mapping, nil
+}
+
+// ObjectRef identifies an object in the cluster.
+type ObjectRef struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func RefOf(obj *unstructured.Unstructured) ObjectRef {
+	return ObjectRef{
+		Kind:      obj.GetKind(),
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}
+}
+
+func (r ObjectRef) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s/%s", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s/%s (namespace: %s)", r.Kind, r.Name, r.Namespace)
+}
+
+var (
+	GVKDeployment  = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	GVKStatefulSet = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}
+	GVKDaemonSet   = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}
+	GVKJob         = schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}
+)
@@ -0,0 +1,264 @@
+// Package kubefake provides a kube.Client backed by the fake clients of client-go, for unit tests that don't need a real
+// API server (see the envtest tests of package kube for those).
+//
+// Server-side apply is approximated: the applied object replaces the live one, and its resource version is bumped only if
+// it changed. Dry runs (of apply and delete) are not persisted, and namespaced objects can't be applied in a namespace
+// that does not exist, like on a real cluster. The typed clientset reads and writes the objects of the dynamic client.
+package kubefake
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+)
+
+// _kinds are the kinds the client knows, and whether they are namespaced.
+var _kinds = map[schema.GroupVersionKind]bool{
+	{Version: "v1", Kind: "Namespace"}:                                false,
+	{Version: "v1", Kind: "PersistentVolume"}:                         false,
+	{Version: "v1", Kind: "ConfigMap"}:                                true,
+	{Version: "v1", Kind: "Secret"}:                                   true,
+	{Version: "v1", Kind: "Service"}:                                  true,
+	{Version: "v1", Kind: "ServiceAccount"}:                           true,
+	{Version: "v1", Kind: "PersistentVolumeClaim"}:                    true,
+	{Version: "v1", Kind: "Pod"}:                                      true,
+	{Group: "apps", Version: "v1", Kind: "Deployment"}:                true,
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"}:               true,
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"}:                 true,
+	{Group: "apps", Version: "v1", Kind: "ReplicaSet"}:                true,
+	{Group: "batch", Version: "v1", Kind: "Job"}:                      true,
+	{Group: "batch", Version: "v1", Kind: "CronJob"}:                  true,
+	{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}:      true,
+	{Group: "coordination.k8s.io", Version: "v1", Kind: "Lease"}:      true,
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"}: true,
+}
+
+var _namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+type resettableMapper struct {
+	*meta.DefaultRESTMapper
+}
+
+func (resettableMapper) Reset() {}
+
+// NewClient returns a client with namespace as its default namespace (which exists), and with the objects in the
+// cluster.
+func NewClient(namespace string, objs ...*unstructured.Unstructured) *kube.Client {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	listKinds := map[schema.GroupVersionResource]string{}
+	for gvk, namespaced := range _kinds {
+		scope := meta.RESTScopeRoot
+		if namespaced {
+			scope = meta.RESTScopeNamespace
+		}
+		mapper.Add(gvk, scope)
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		listKinds[plural] = gvk.Kind + "List"
+	}
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	tracker := dyn.Tracker()
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName(namespace)
+	for _, obj := range append([]*unstructured.Unstructured{ns}, objs...) {
+		obj = obj.DeepCopy()
+		if obj.GetResourceVersion() == "" {
+			obj.SetResourceVersion("1")
+		}
+		if obj.GetUID() == "" {
+			obj.SetUID(uidOf(obj.GetNamespace(), obj.GetName()))
+		}
+		err := tracker.Add(obj)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("*", "*", typedReactor(tracker, mapper))
+
+	return &kube.Client{
+		Clientset: clientset,
+		Dynamic:   dynamicClient{FakeDynamicClient: dyn},
+		Mapper:    resettableMapper{mapper},
+		Namespace: namespace,
+	}
+}
+
+func uidOf(namespace string, name string) types.UID {
+	return types.UID("uid-" + namespace + "-" + name)
+}
+
+func namespaceExists(tracker k8stesting.ObjectTracker, name string) bool {
+	_, err := tracker.Get(_namespacesGVR, "", name)
+	return err == nil
+}
+
+// typedReactor serves the gets, creates, updates and deletes of the typed clientset from the objects of the dynamic
+// client, so that both clients see the same cluster. Other verbs use the (empty) tracker of the typed clientset.
+func typedReactor(tracker k8stesting.ObjectTracker, mapper meta.RESTMapper) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gvr, ns := action.GetResource(), action.GetNamespace()
+		switch action := action.(type) {
+		case k8stesting.GetAction:
+			obj, err := tracker.Get(gvr, ns, action.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			typed, err := toTyped(obj.(*unstructured.Unstructured))
+			return true, typed, err
+		case k8stesting.CreateAction, k8stesting.UpdateAction:
+			gvk, err := mapper.KindFor(gvr)
+			if err != nil {
+				return true, nil, err
+			}
+			typed := action.(interface{ GetObject() runtime.Object }).GetObject()
+			u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+			if err != nil {
+				return true, nil, err
+			}
+			obj := &unstructured.Unstructured{Object: u}
+			obj.SetGroupVersionKind(gvk)
+			if action.GetVerb() == "create" {
+				obj.SetUID(uidOf(ns, obj.GetName()))
+				obj.SetResourceVersion("1")
+				err = tracker.Create(gvr, obj, ns)
+			} else {
+				rv, _ := strconv.Atoi(obj.GetResourceVersion())
+				obj.SetResourceVersion(strconv.Itoa(rv + 1))
+				err = tracker.Update(gvr, obj, ns)
+			}
+			if err != nil {
+				return true, nil, err
+			}
+			typed, err = toTyped(obj)
+			return true, typed, err
+		case k8stesting.DeleteAction:
+			return true, nil, tracker.Delete(gvr, ns, action.GetName())
+		}
+		return false, nil, nil
+	}
+}
+
+func toTyped(obj *unstructured.Unstructured) (runtime.Object, error) {
+	typed, err := scheme.Scheme.New(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed)
+	return typed, err
+}
+
+// dynamicClient is the fake dynamic client, with server-side apply and dry runs. The fake itself does not pass the
+// options of the requests to its reactors, so they are handled here.
+type dynamicClient struct {
+	*dynamicfake.FakeDynamicClient
+}
+
+func (c dynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	res := c.FakeDynamicClient.Resource(gvr)
+	return resourceClient{ResourceInterface: res, namespaceable: res, tracker: c.Tracker(), gvr: gvr}
+}
+
+type resourceClient struct {
+	dynamic.ResourceInterface
+	// namespaceable is nil for the clients of a namespace
+	namespaceable dynamic.NamespaceableResourceInterface
+	tracker       k8stesting.ObjectTracker
+	gvr           schema.GroupVersionResource
+	namespace     string
+}
+
+func (c resourceClient) Namespace(ns string) dynamic.ResourceInterface {
+	return resourceClient{ResourceInterface: c.namespaceable.Namespace(ns), tracker: c.tracker, gvr: c.gvr, namespace: ns}
+}
+
+func (c resourceClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if pt != types.ApplyPatchType || len(subresources) > 0 {
+		return c.ResourceInterface.Patch(ctx, name, pt, data, opts, subresources...)
+	}
+	obj := &unstructured.Unstructured{}
+	err := json.Unmarshal(data, &obj.Object)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return c.apply(name, obj, opts.FieldManager, len(opts.DryRun) > 0)
+}
+
+func (c resourceClient) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, opts metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) > 0 {
+		return c.ResourceInterface.Apply(ctx, name, obj, opts, subresources...)
+	}
+	return c.apply(name, obj.DeepCopy(), opts.FieldManager, len(opts.DryRun) > 0)
+}
+
+// apply approximates server-side apply: the applied object replaces the live one.
+func (c resourceClient) apply(name string, obj *unstructured.Unstructured, fieldManager string, dryRun bool) (*unstructured.Unstructured, error) {
+	if c.namespace != "" && !namespaceExists(c.tracker, c.namespace) {
+		return nil, apierrors.NewNotFound(corev1.Resource("namespaces"), c.namespace)
+	}
+	obj.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply}})
+
+	current, err := c.tracker.Get(c.gvr, c.namespace, name)
+	switch {
+	case apierrors.IsNotFound(err):
+		obj.SetUID(uidOf(c.namespace, name))
+		obj.SetResourceVersion("1")
+		err = nil
+		if !dryRun {
+			err = c.tracker.Create(c.gvr, obj, c.namespace)
+		}
+	case err == nil:
+		live := current.(*unstructured.Unstructured)
+		obj.SetUID(live.GetUID())
+		obj.SetResourceVersion(live.GetResourceVersion())
+		if !dryRun && !sameContent(live, obj) {
+			rv, _ := strconv.Atoi(live.GetResourceVersion())
+			obj.SetResourceVersion(strconv.Itoa(rv + 1))
+			err = c.tracker.Update(c.gvr, obj, c.namespace)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (c resourceClient) Delete(ctx context.Context, name string, opts metav1.DeleteOptions, subresources ...string) error {
+	if len(opts.DryRun) == 0 {
+		return c.ResourceInterface.Delete(ctx, name, opts, subresources...)
+	}
+	_, err := c.tracker.Get(c.gvr, c.namespace, name)
+	return err
+}
+
+// sameContent compares the objects without the metadata that the server manages.
+func sameContent(a, b *unstructured.Unstructured) bool {
+	clean := func(u *unstructured.Unstructured) string {
+		u = u.DeepCopy()
+		u.SetResourceVersion("")
+		u.SetManagedFields(nil)
+		u.SetUID("")
+		bytes, _ := json.Marshal(u.Object)
+		return string(bytes)
+	}
+	return clean(a) == clean(b)
+}
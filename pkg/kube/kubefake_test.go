@@ -0,0 +1,195 @@
+package kube_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube/kubefake"
+)
+
+// The tests in this file use the fake client of package kubefake, and run without an API server.
+
+func configMap(name string, value string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "apps"},
+		"data":       map[string]interface{}{"key": value},
+	}}
+	obj.SetLabels(labels)
+	return obj
+}
+
+func deployLabels(identifier string) map[string]string {
+	objs := []*unstructured.Unstructured{{Object: map[string]interface{}{}}}
+	kube.SetDeployLabels(objs, identifier)
+	return objs[0].GetLabels()
+}
+
+func TestDiffPrune(t *testing.T) {
+	ctx := context.Background()
+	c := kubefake.NewClient("apps",
+		configMap("unchanged", "v1", deployLabels("myapp")),
+		configMap("changed", "v1", deployLabels("myapp")),
+		configMap("removed", "v1", deployLabels("myapp")),
+		configMap("og-history-myapp", "", kube.BookkeepingLabels("myapp", kube.RoleHistory)),
+		configMap("other-deployment", "v1", deployLabels("otherapp")),
+	)
+
+	objs := []*unstructured.Unstructured{
+		configMap("unchanged", "v1", deployLabels("myapp")),
+		configMap("changed", "v2", deployLabels("myapp")),
+		configMap("added", "v1", deployLabels("myapp")),
+	}
+	tests := []struct {
+		name  string
+		prune bool
+		want  map[string]kube.Action
+	}{
+		{
+			name: "without prune",
+			want: map[string]kube.Action{"unchanged": kube.ActionUnchanged, "changed": kube.ActionConfigured, "added": kube.ActionCreated},
+		},
+		{
+			name:  "with prune",
+			prune: true,
+			want:  map[string]kube.Action{"unchanged": kube.ActionUnchanged, "changed": kube.ActionConfigured, "added": kube.ActionCreated, "removed": kube.ActionDeleted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := c.Diff(ctx, objs, "myapp", kube.DiffOptions{Prune: tt.prune})
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			got := map[string]kube.Action{}
+			for _, res := range results {
+				got[res.Name] = res.Action
+				if (res.Action == kube.ActionUnchanged) != (res.Diff == "") {
+					t.Errorf("Diff() of [%s] = %s with diff %q", res.ObjectRef, res.Action, res.Diff)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("Diff() of [%s] = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+
+	// The dry runs change nothing
+	results, err := c.Diff(ctx, objs, "myapp", kube.DiffOptions{Prune: true})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(results) != 4 {
+		t.Errorf("Diff() after dry runs = %d results, want 4", len(results))
+	}
+}
+
+func TestFindPrunable(t *testing.T) {
+	ctx := context.Background()
+	inOther := configMap("removed-other", "v1", deployLabels("myapp"))
+	inOther.SetNamespace("other")
+	c := kubefake.NewClient("apps",
+		configMap("kept", "v1", deployLabels("myapp")),
+		configMap("removed", "v1", deployLabels("myapp")),
+		inOther,
+		configMap("og-history-myapp", "", kube.BookkeepingLabels("myapp", kube.RoleHistory)),
+		configMap("og-lock-myapp", "", kube.BookkeepingLabels("myapp", kube.RoleLock)),
+		configMap("other-deployment", "v1", deployLabels("otherapp")),
+		configMap("unlabelled", "v1", nil),
+	)
+	otherNamespace := configMap("kept-other", "v1", deployLabels("myapp"))
+	otherNamespace.SetNamespace("other")
+
+	tests := []struct {
+		name string
+		objs []*unstructured.Unstructured
+		want []kube.ObjectRef
+	}{
+		{
+			name: "objects of the deployment no longer in the manifests",
+			objs: []*unstructured.Unstructured{configMap("kept", "v1", nil)},
+			want: []kube.ObjectRef{{Kind: "ConfigMap", Name: "removed", Namespace: "apps"}},
+		},
+		{
+			name: "in each namespace of the manifests",
+			objs: []*unstructured.Unstructured{configMap("kept", "v1", nil), otherNamespace},
+			want: []kube.ObjectRef{{Kind: "ConfigMap", Name: "removed", Namespace: "apps"}, {Kind: "ConfigMap", Name: "removed-other", Namespace: "other"}},
+		},
+		{
+			name: "only of the kinds in the manifests",
+			objs: []*unstructured.Unstructured{{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "kept", "namespace": "apps"},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prunable, err := c.FindPrunable(ctx, tt.objs, "myapp")
+			if err != nil {
+				t.Fatalf("FindPrunable() error = %v", err)
+			}
+			var got []kube.ObjectRef
+			for _, obj := range prunable {
+				got = append(got, kube.RefOf(obj))
+			}
+			slices.SortFunc(got, func(a, b kube.ObjectRef) int { return strings.Compare(a.String(), b.String()) })
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindPrunable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	ns := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "apps"},
+	}}
+	objs := []*unstructured.Unstructured{
+		ns,
+		configMap("config", "v1", deployLabels("myapp")),
+		configMap("missing", "v1", deployLabels("myapp")),
+	}
+	c := kubefake.NewClient("apps", objs[1])
+
+	tests := []struct {
+		name   string
+		dryRun bool
+		want   []kube.Action
+	}{
+		// In the reverse order of apply: the namespace is deleted last, after its objects
+		{name: "dry run", dryRun: true, want: []kube.Action{kube.ActionNotFound, kube.ActionDeleted, kube.ActionDeleted}},
+		{name: "delete", want: []kube.Action{kube.ActionNotFound, kube.ActionDeleted, kube.ActionDeleted}},
+		{name: "again", want: []kube.Action{kube.ActionNotFound, kube.ActionNotFound, kube.ActionNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := c.Delete(ctx, objs, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			var got []kube.Action
+			for _, res := range results {
+				got = append(got, res.Action)
+			}
+			if len(results) != 3 || results[2].Kind != "Namespace" || !slices.Equal(got, tt.want) {
+				t.Errorf("Delete() = %v, want the actions %v with the namespace last", results, tt.want)
+			}
+		})
+	}
+}
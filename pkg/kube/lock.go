@@ -0,0 +1,209 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LockHeldError is returned when a lock is held by someone else.
+type LockHeldError struct {
+	Name   string
+	Holder string
+	Since  time.Time
+}
+
+func (e LockHeldError) Error() string {
+	return fmt.Sprintf("Lock [%s] is held by [%s] since %s", e.Name, e.Holder, e.Since.Format(time.RFC3339))
+}
+
+// ErrLockLost is the cause of the cancellation of the context of a Lease, when the lease could not be renewed in time
+// or was taken over by someone else.
+var ErrLockLost = errors.New("Lock lost")
+
+// Lease is a lock held with a coordination Lease. It's renewed in the background until it's released, and can be
+// taken over by someone else once it expires (e.g. if its holder crashed).
+type Lease struct {
+	c        *Client
+	lease    *coordinationv1.Lease
+	holder   string
+	duration time.Duration
+	// ctx is cancelled when the lock is lost
+	ctx    context.Context
+	lose   context.CancelCauseFunc
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// AcquireLease takes the lock. It returns a LockHeldError if the lock is held by someone else. The context of the
+// returned lease (see Lease.Context) is ctx, cancelled if the lock is lost.
+func (c *Client) AcquireLease(ctx context.Context, namespace string, name string, holder string, labels map[string]string, duration time.Duration) (*Lease, error) {
+	leases := c.Clientset.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(duration.Seconds())
+	spec := coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &seconds, AcquireTime: &now, RenewTime: &now}
+
+	lease, err := leases.Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       spec,
+	}, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errutil.Wrap(err, "Getting lease [%s]", name)
+		}
+		if !leaseExpired(existing) {
+			held := LockHeldError{Name: name, Holder: ptrString(existing.Spec.HolderIdentity)}
+			if existing.Spec.AcquireTime != nil {
+				held.Since = existing.Spec.AcquireTime.Time
+			}
+			return nil, held
+		}
+		log.Warn(ctx, "Taking over an expired lock", "lock", name, "previousHolder", ptrString(existing.Spec.HolderIdentity))
+		// The resource version makes the update fail if someone else took it over first
+		existing.Spec = spec
+		lease, err = leases.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		return nil, errutil.Wrap(err, "Acquiring lease [%s]", name)
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	l := &Lease{c: c, lease: lease, holder: holder, duration: duration, cancel: cancel, done: make(chan struct{})}
+	l.ctx, l.lose = context.WithCancelCause(ctx)
+	go l.renew(renewCtx, duration/3)
+	return l, nil
+}
+
+// Context is done when the lock is lost (context.Cause is then ErrLockLost), so that the work it protects stops.
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+func (l *Lease) renew(ctx context.Context, interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewed := l.lease.Spec.RenewTime.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := l.renewOnce(ctx)
+			if ctx.Err() != nil {
+				// Released
+				return
+			}
+			var lost lockLostError
+			if errors.As(err, &lost) {
+				log.Error(ctx, "Lost lock", "lock", l.lease.Name, "error", err)
+				l.lose(err)
+				return
+			}
+			if err == nil {
+				renewed = time.Now()
+				continue
+			}
+			// Until the lease expires, nobody else can take it, so the renewal is retried
+			if time.Since(renewed)+interval >= l.duration {
+				err = lockLostError{fmt.Errorf("%w: [%s] could not be renewed before it expired: %s", ErrLockLost, l.lease.Name, err)}
+				log.Error(ctx, "Lost lock", "lock", l.lease.Name, "error", err)
+				l.lose(err)
+				return
+			}
+			log.Warn(ctx, "Could not renew lock, retrying", "lock", l.lease.Name, "error", err)
+		}
+	}
+}
+
+type lockLostError struct {
+	error
+}
+
+func (e lockLostError) Unwrap() error {
+	return e.error
+}
+
+// renewOnce renews the lease. It returns a lockLostError if someone else holds it now.
+func (l *Lease) renewOnce(ctx context.Context) error {
+	leases := l.c.Clientset.CoordinationV1().Leases(l.lease.Namespace)
+	lease := l.lease.DeepCopy()
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	updated, err := leases.Update(ctx, lease, metav1.UpdateOptions{FieldManager: FieldManager})
+	if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+		// Changed since the last renewal: it's still ours only if the holder is the same
+		live, getErr := leases.Get(ctx, l.lease.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(getErr) {
+			return lockLostError{fmt.Errorf("%w: [%s] was deleted", ErrLockLost, l.lease.Name)}
+		}
+		if getErr != nil {
+			return errutil.Wrap(getErr, "Getting lease [%s]", l.lease.Name)
+		}
+		if ptrString(live.Spec.HolderIdentity) != l.holder {
+			return lockLostError{fmt.Errorf("%w: [%s] was taken over by [%s]", ErrLockLost, l.lease.Name, ptrString(live.Spec.HolderIdentity))}
+		}
+		live.Spec.RenewTime = &now
+		updated, err = leases.Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		return errutil.Wrap(err, "Renewing lease [%s]", l.lease.Name)
+	}
+	l.lease = updated
+	return nil
+}
+
+// Release stops renewing the lock and deletes it, unless it was lost: the delete only succeeds if the lease has not
+// changed since it was last renewed, so that the lock of someone else is never deleted.
+func (l *Lease) Release(ctx context.Context) error {
+	l.cancel()
+	<-l.done
+	defer l.lose(context.Canceled)
+	if l.ctx.Err() != nil && errors.Is(context.Cause(l.ctx), ErrLockLost) {
+		log.Warn(ctx, "Not releasing the lock, since it was lost", "lock", l.lease.Name)
+		return nil
+	}
+
+	err := l.c.Clientset.CoordinationV1().Leases(l.lease.Namespace).Delete(ctx, l.lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &l.lease.UID, ResourceVersion: &l.lease.ResourceVersion},
+	})
+	if apierrors.IsConflict(err) {
+		log.Warn(ctx, "Not releasing the lock, since it was changed by someone else", "lock", l.lease.Name)
+		return nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errutil.Wrap(err, "Deleting lease [%s]", l.lease.Name)
+	}
+	return nil
+}
+
+// DeleteLease deletes the lock, whoever holds it.
+func (c *Client) DeleteLease(ctx context.Context, namespace string, name string) error {
+	err := c.Clientset.CoordinationV1().Leases(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errutil.Wrap(err, "Deleting lease [%s]", name)
+	}
+	return nil
+}
+
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(time.Now())
+}
+
+func ptrString(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
@@ -0,0 +1,193 @@
+package kube
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// _logsPollInterval is how often the pods are listed when following logs, to pick up new pods and restarted containers.
+const _logsPollInterval = 2 * time.Second
+
+// LogOptions select the logs to stream.
+type LogOptions struct {
+	Follow bool
+	// Since only returns the logs newer than this duration. Zero returns all the logs.
+	Since time.Duration
+	// Tail is the number of lines from the end of the logs of each container. Zero returns all the lines.
+	Tail int64
+}
+
+// LogLine is a line of the logs of a container.
+type LogLine struct {
+	Time      time.Time
+	Workload  string
+	Namespace string
+	Pod       string
+	Container string
+	Message   string
+}
+
+type logStreamKey struct {
+	namespace, pod, container string
+}
+
+type logStreamState struct {
+	active bool
+	// last is the time of the last line received, so that a resumed stream does not repeat lines
+	last time.Time
+}
+
+// StreamLogs streams the logs of all the containers of the pods of the workloads to handle. Calls to handle are not
+// concurrent. With opts.Follow, the pods are listed again periodically so that new pods (e.g. of a rollout) are picked
+// up and the streams of restarted containers are resumed, until the context is done.
+func (c *Client) StreamLogs(ctx context.Context, refs []ObjectRef, opts LogOptions, handle func(LogLine)) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	states := map[logStreamKey]*logStreamState{}
+	var errs []error
+
+	stream := func(ref ObjectRef, pod corev1.Pod, container string, podOpts *corev1.PodLogOptions, st *logStreamState) {
+		defer wg.Done()
+		defer func() {
+			mu.Lock()
+			st.active = false
+			mu.Unlock()
+		}()
+
+		rc, err := c.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, podOpts).Stream(ctx)
+		if err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			if opts.Follow {
+				// Retried on the next poll
+				log.Debug(ctx, "Could not stream logs", "pod", pod.Name, "container", container, "error", err)
+				return
+			}
+			errs = append(errs, errutil.Wrap(err, "Streaming logs of [%s/%s]", pod.Name, container))
+			return
+		}
+		defer rc.Close()
+
+		scanner := bufio.NewScanner(rc)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			// Lines are prefixed with their timestamp (see Timestamps in the options)
+			ts, msg, _ := strings.Cut(scanner.Text(), " ")
+			t, err := time.Parse(time.RFC3339Nano, ts)
+			if err != nil {
+				msg = scanner.Text()
+			}
+
+			mu.Lock()
+			if !t.IsZero() && !t.After(st.last) {
+				// Already seen before the stream was resumed
+				mu.Unlock()
+				continue
+			}
+			if !t.IsZero() {
+				st.last = t
+			}
+			handle(LogLine{Time: t, Workload: ref.Name, Namespace: pod.Namespace, Pod: pod.Name, Container: container, Message: msg})
+			mu.Unlock()
+		}
+	}
+
+	firstScan := true
+	scan := func() error {
+		for _, ref := range refs {
+			pods, err := c.PodsOf(ctx, ref)
+			if err != nil {
+				return errutil.Wrap(err, "Finding pods of [%s]", ref)
+			}
+			for _, pod := range pods {
+				for _, container := range pod.Spec.Containers {
+					if !containerStarted(pod, container.Name) {
+						continue
+					}
+					key := logStreamKey{pod.Namespace, pod.Name, container.Name}
+
+					mu.Lock()
+					st, seen := states[key]
+					if seen && st.active {
+						mu.Unlock()
+						continue
+					}
+					if !opts.Follow && seen {
+						mu.Unlock()
+						continue
+					}
+					podOpts := &corev1.PodLogOptions{Container: container.Name, Follow: opts.Follow, Timestamps: true}
+					switch {
+					case seen:
+						// Resume a stream that ended e.g. because the container restarted
+						since := metav1.NewTime(st.last)
+						podOpts.SinceTime = &since
+					case firstScan:
+						if opts.Since > 0 {
+							secs := int64(opts.Since.Seconds())
+							podOpts.SinceSeconds = &secs
+						}
+						if opts.Tail > 0 {
+							podOpts.TailLines = &opts.Tail
+						}
+					}
+					// Pods that show up later are streamed from their start
+					if !seen {
+						st = &logStreamState{}
+						states[key] = st
+					}
+					st.active = true
+					mu.Unlock()
+
+					wg.Add(1)
+					go stream(ref, pod, container.Name, podOpts, st)
+				}
+			}
+		}
+		firstScan = false
+		return nil
+	}
+
+	err := scan()
+	if err != nil {
+		return err
+	}
+
+	if opts.Follow {
+		ticker := time.NewTicker(_logsPollInterval)
+		defer ticker.Stop()
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+				err := scan()
+				if err != nil {
+					log.Debug(ctx, "Could not list pods to follow", "error", err)
+				}
+			}
+		}
+	}
+
+	wg.Wait()
+	return errutil.Combine(errs...)
+}
+
+// containerStarted returns true if the container is running or has run, so that it has logs.
+func containerStarted(pod corev1.Pod, container string) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == container {
+			return cs.State.Running != nil || cs.State.Terminated != nil || cs.LastTerminationState.Terminated != nil
+		}
+	}
+	return false
+}
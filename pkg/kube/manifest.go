@@ -0,0 +1,95 @@
+package kube
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/teejays/gokutil/errutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ReadManifests reads the objects from the k8s manifest files (multi-document yaml or json).
+func ReadManifests(paths []string) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Reading k8s manifest [%s]", p)
+		}
+		fileObjs, err := DecodeManifest(b)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Decoding k8s manifest [%s]", p)
+		}
+		objs = append(objs, fileObjs...)
+	}
+	return objs, nil
+}
+
+// DecodeManifest decodes the objects in a (multi-document) yaml or json manifest. Lists are flattened.
+func DecodeManifest(b []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 4096)
+	for {
+		var raw map[string]interface{}
+		err := decoder.Decode(&raw)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		// Empty documents e.g. a trailing ---
+		if len(raw) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: raw}
+		if obj.IsList() {
+			err = obj.EachListItem(func(item runtime.Object) error {
+				objs = append(objs, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// _applyOrder is the order in which kinds are applied, so that objects exist before the ones that depend on them.
+// Kinds not listed here are applied after these. Deletion happens in the reverse order.
+var _applyOrder = []string{
+	"Namespace",
+	"CustomResourceDefinition",
+	"ServiceAccount",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"Service",
+}
+
+// SortForApply sorts the objects (in place) in the order they should be applied. The order within a kind is kept.
+func SortForApply(objs []*unstructured.Unstructured) {
+	rank := func(kind string) int {
+		if i := slices.Index(_applyOrder, kind); i >= 0 {
+			return i
+		}
+		return len(_applyOrder)
+	}
+	slices.SortStableFunc(objs, func(a, b *unstructured.Unstructured) int {
+		return rank(a.GetKind()) - rank(b.GetKind())
+	})
+}
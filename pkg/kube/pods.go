@@ -0,0 +1,53 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teejays/gokutil/errutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodsOf returns the pods of the workload, found with the workload's label selector.
+func (c *Client) PodsOf(ctx context.Context, ref ObjectRef) ([]corev1.Pod, error) {
+	var selector *metav1.LabelSelector
+	switch ref.Kind {
+	case GVKDeployment.Kind:
+		d, err := c.Clientset.AppsV1().Deployments(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = d.Spec.Selector
+	case GVKStatefulSet.Kind:
+		s, err := c.Clientset.AppsV1().StatefulSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = s.Spec.Selector
+	case GVKDaemonSet.Kind:
+		d, err := c.Clientset.AppsV1().DaemonSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = d.Spec.Selector
+	case GVKJob.Kind:
+		j, err := c.Clientset.BatchV1().Jobs(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = j.Spec.Selector
+	default:
+		return nil, fmt.Errorf("Kind [%s] is not a tracked workload", ref.Kind)
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Parsing label selector of [%s]", ref)
+	}
+	pods, err := c.Clientset.CoreV1().Pods(ref.Namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing pods of [%s]", ref)
+	}
+	return pods.Items, nil
+}
@@ -0,0 +1,140 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/teejays/gokutil/errutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// ForwardedPort is a local port forwarded to a port of a pod. A Local port of 0 is picked among the free ones.
+type ForwardedPort struct {
+	Local  uint16
+	Remote uint16
+}
+
+// ServiceBackend returns a running and ready pod behind the service, and the ports of the pod that the ports of the
+// service target (by service port).
+func (c *Client) ServiceBackend(ctx context.Context, namespace string, name string) (corev1.Pod, map[int32]int32, error) {
+	svc, err := c.Clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return corev1.Pod{}, nil, errutil.Wrap(err, "Getting service [%s]", name)
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return corev1.Pod{}, nil, fmt.Errorf("Service [%s] has no selector, so its pods can't be found", name)
+	}
+
+	list, err := c.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String()})
+	if err != nil {
+		return corev1.Pod{}, nil, errutil.Wrap(err, "Listing pods of service [%s]", name)
+	}
+	var pod *corev1.Pod
+	for i := range list.Items {
+		if PodReady(list.Items[i]) {
+			pod = &list.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return corev1.Pod{}, nil, fmt.Errorf("Service [%s] has no ready pods", name)
+	}
+
+	ports := map[int32]int32{}
+	for _, sp := range svc.Spec.Ports {
+		target := sp.TargetPort.IntVal
+		if sp.TargetPort.StrVal != "" {
+			// Named ports are looked up in the containers of the pod
+			for _, container := range pod.Spec.Containers {
+				for _, cp := range container.Ports {
+					if cp.Name == sp.TargetPort.StrVal {
+						target = cp.ContainerPort
+					}
+				}
+			}
+		}
+		if target == 0 {
+			target = sp.Port
+		}
+		ports[sp.Port] = target
+	}
+
+	return *pod, ports, nil
+}
+
+// ForwardPorts forwards local ports (on 127.0.0.1) to the ports of the pod, until the context is done or the connection
+// to the pod is lost. ready is called with the forwarded ports (and the picked local ports) once they are listening.
+func (c *Client) ForwardPorts(ctx context.Context, pod corev1.Pod, ports []ForwardedPort, ready func([]ForwardedPort)) error {
+	transport, upgrader, err := spdy.RoundTripperFor(c.RestConfig)
+	if err != nil {
+		return errutil.Wrap(err, "Creating round tripper")
+	}
+	url := c.Clientset.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	var specs []string
+	for _, p := range ports {
+		specs = append(specs, fmt.Sprintf("%d:%d", p.Local, p.Remote))
+	}
+	stopCh, readyCh := make(chan struct{}), make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, specs, stopCh, readyCh, io.Discard, os.Stderr)
+	if err != nil {
+		return errutil.Wrap(err, "Creating port forwarder")
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return errutil.Wrap(err, "Forwarding ports to pod [%s]", pod.Name)
+	case <-ctx.Done():
+		close(stopCh)
+		return <-errCh
+	}
+
+	forwarded, err := fw.GetPorts()
+	if err != nil {
+		close(stopCh)
+		return errutil.Wrap(err, "Getting forwarded ports")
+	}
+	var got []ForwardedPort
+	for _, p := range forwarded {
+		got = append(got, ForwardedPort{Local: p.Local, Remote: p.Remote})
+	}
+	ready(got)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return errutil.Wrap(err, "Forwarding ports to pod [%s]", pod.Name)
+		}
+		return fmt.Errorf("Lost connection to pod [%s]", pod.Name)
+	case <-ctx.Done():
+		close(stopCh)
+		return <-errCh
+	}
+}
+
+// PodReady returns true if the pod is running and all its containers are ready.
+func PodReady(pod corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
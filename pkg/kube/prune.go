@@ -0,0 +1,150 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teejays/gokutil/errutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// LabelManagedBy is the standard label for the tool managing an object.
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	// LabelDeployIdentifier ties an object to the deployment (see deploy --deploy-identifier) that created it, so that
+	// the objects of a deployment can be found even after they are removed from the manifests.
+	LabelDeployIdentifier = "ongoku.build/deploy-identifier"
+	// LabelRole marks the objects that og keeps for its own bookkeeping, e.g. the env secret. They carry the labels of
+	// the deployment but are not in the manifests, so they are never pruned.
+	LabelRole = "ongoku.build/role"
+)
+
+// The values of LabelRole.
+const (
+	RoleEnv     = "env"
+	RoleHistory = "history"
+	RoleLock    = "lock"
+)
+
+// SetDeployLabels labels the objects (in place) as managed by og for the given deployment.
+func SetDeployLabels(objs []*unstructured.Unstructured, identifier string) {
+	for _, obj := range objs {
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[LabelManagedBy] = FieldManager
+		labels[LabelDeployIdentifier] = identifier
+		obj.SetLabels(labels)
+	}
+}
+
+// BookkeepingLabels are the labels of an object that og keeps for the deployment, with the given role.
+func BookkeepingLabels(identifier string, role string) map[string]string {
+	return map[string]string{
+		LabelManagedBy:        FieldManager,
+		LabelDeployIdentifier: identifier,
+		LabelRole:             role,
+	}
+}
+
+// DeploySelector is the label selector for the objects of a deployment.
+func DeploySelector(identifier string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", LabelManagedBy, FieldManager, LabelDeployIdentifier, identifier)
+}
+
+// FindPrunable returns the objects of the deployment that are in the cluster but not in objs. Only the kinds (and
+// namespaces) that appear in objs are searched. The bookkeeping objects of og (see LabelRole) are never prunable.
+func (c *Client) FindPrunable(ctx context.Context, objs []*unstructured.Unstructured, identifier string) ([]*unstructured.Unstructured, error) {
+	type scope struct {
+		gvk       schema.GroupVersionKind
+		namespace string
+	}
+
+	wanted := map[ObjectRef]bool{}
+	var scopes []scope
+	seen := map[scope]bool{}
+	for _, obj := range objs {
+		obj = obj.DeepCopy()
+		_, mapping, err := c.resourceFor(obj)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Resolving [%s]", RefOf(obj))
+		}
+		wanted[RefOf(obj)] = true
+
+		s := scope{gvk: obj.GroupVersionKind()}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			s.namespace = obj.GetNamespace()
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	var prunable []*unstructured.Unstructured
+	for _, s := range scopes {
+		mapping, err := c.Mapper.RESTMapping(s.gvk.GroupKind(), s.gvk.Version)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Finding resource for kind [%s]", s.gvk.String())
+		}
+		var list *unstructured.UnstructuredList
+		listOpts := metav1.ListOptions{LabelSelector: DeploySelector(identifier) + ",!" + LabelRole}
+		if s.namespace != "" {
+			list, err = c.Dynamic.Resource(mapping.Resource).Namespace(s.namespace).List(ctx, listOpts)
+		} else {
+			list, err = c.Dynamic.Resource(mapping.Resource).List(ctx, listOpts)
+		}
+		if err != nil {
+			return nil, errutil.Wrap(err, "Listing [%s] objects", s.gvk.Kind)
+		}
+		for i := range list.Items {
+			item := &list.Items[i]
+			item.SetGroupVersionKind(s.gvk)
+			if !wanted[RefOf(item)] {
+				prunable = append(prunable, item)
+			}
+		}
+	}
+
+	return prunable, nil
+}
+
+// FindDeployed returns the live objects of the deployment: the objects in objs that exist in the cluster and carry the
+// deployment's labels, and the labelled objects of the same kinds that are no longer in objs. Objects that exist but
+// are not labelled for the deployment are returned as skipped, since they may belong to someone else.
+func (c *Client) FindDeployed(ctx context.Context, objs []*unstructured.Unstructured, identifier string) ([]*unstructured.Unstructured, []ObjectRef, error) {
+	var deployed []*unstructured.Unstructured
+	var skipped []ObjectRef
+	for _, obj := range objs {
+		obj = obj.DeepCopy()
+		ri, _, err := c.resourceFor(obj)
+		if err != nil {
+			return nil, nil, errutil.Wrap(err, "Resolving [%s]", RefOf(obj))
+		}
+		live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, nil, errutil.Wrap(err, "Getting [%s]", RefOf(obj))
+		}
+		if live.GetLabels()[LabelDeployIdentifier] != identifier {
+			skipped = append(skipped, RefOf(obj))
+			continue
+		}
+		deployed = append(deployed, live)
+	}
+
+	prunable, err := c.FindPrunable(ctx, objs, identifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	deployed = append(deployed, prunable...)
+
+	return deployed, skipped, nil
+}
@@ -0,0 +1,223 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const _readyPollInterval = 2 * time.Second
+
+// WorkloadStatus is the readiness of one workload.
+type WorkloadStatus struct {
+	ObjectRef
+	Ready bool `json:"ready"`
+	// Failed is true if the workload cannot become ready without intervention (e.g. a failed Job)
+	Failed  bool   `json:"failed"`
+	Message string `json:"message"`
+}
+
+// IsWorkload returns true for the kinds whose readiness is tracked.
+func IsWorkload(kind string) bool {
+	switch kind {
+	case GVKDeployment.Kind, GVKStatefulSet.Kind, GVKDaemonSet.Kind, GVKJob.Kind:
+		return true
+	}
+	return false
+}
+
+// WaitReady waits for the workloads among refs (Deployments, StatefulSets, DaemonSets and Jobs) to be ready, and logs
+// their progress. Other kinds are ignored.
+func (c *Client) WaitReady(ctx context.Context, refs []ObjectRef, timeout time.Duration) ([]WorkloadStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var statuses []WorkloadStatus
+	for _, ref := range refs {
+		if !IsWorkload(ref.Kind) {
+			continue
+		}
+
+		var lastMsg string
+		for {
+			st, err := c.GetWorkloadStatus(ctx, ref)
+			if err != nil {
+				return statuses, errutil.Wrap(err, "Getting status of [%s]", ref)
+			}
+			if st.Message != lastMsg {
+				log.Info(ctx, "Rollout progress", "workload", ref.String(), "status", st.Message)
+				lastMsg = st.Message
+			}
+			if st.Failed {
+				statuses = append(statuses, st)
+				return statuses, fmt.Errorf("Workload [%s] failed: %s", ref, st.Message)
+			}
+			if st.Ready {
+				statuses = append(statuses, st)
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				statuses = append(statuses, st)
+				return statuses, fmt.Errorf("Timed out after %s waiting for [%s] to be ready (last status: %s)", timeout, ref, st.Message)
+			case <-time.After(_readyPollInterval):
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+// GetWorkloadStatus returns the readiness of the workload, using the same rules as `kubectl rollout status`.
+func (c *Client) GetWorkloadStatus(ctx context.Context, ref ObjectRef) (WorkloadStatus, error) {
+	st := WorkloadStatus{ObjectRef: ref}
+
+	switch ref.Kind {
+	case GVKDeployment.Kind:
+		d, err := c.Clientset.AppsV1().Deployments(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return st, err
+		}
+		st.Ready, st.Failed, st.Message = deploymentStatus(d)
+
+	case GVKStatefulSet.Kind:
+		s, err := c.Clientset.AppsV1().StatefulSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return st, err
+		}
+		st.Ready, st.Message = statefulSetStatus(s)
+
+	case GVKDaemonSet.Kind:
+		d, err := c.Clientset.AppsV1().DaemonSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return st, err
+		}
+		st.Ready, st.Message = daemonSetStatus(d)
+
+	case GVKJob.Kind:
+		j, err := c.Clientset.BatchV1().Jobs(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return st, err
+		}
+		st.Ready, st.Failed, st.Message = jobStatus(j)
+
+	default:
+		return st, fmt.Errorf("Kind [%s] is not a tracked workload", ref.Kind)
+	}
+
+	return st, nil
+}
+
+func deploymentStatus(d *appsv1.Deployment) (bool, bool, string) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, false, "waiting for the deployment spec update to be observed"
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return false, true, fmt.Sprintf("exceeded its progress deadline: %s", c.Message)
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas < replicas {
+		return false, false, fmt.Sprintf("%d of %d updated replicas are available", d.Status.UpdatedReplicas, replicas)
+	}
+	if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return false, false, fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	}
+	if d.Status.AvailableReplicas < d.Status.UpdatedReplicas {
+		return false, false, fmt.Sprintf("%d of %d updated replicas are available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return true, false, fmt.Sprintf("%d of %d replicas are available", d.Status.AvailableReplicas, replicas)
+}
+
+func statefulSetStatus(s *appsv1.StatefulSet) (bool, string) {
+	if s.Status.ObservedGeneration == 0 || s.Generation > s.Status.ObservedGeneration {
+		return false, "waiting for the statefulset spec update to be observed"
+	}
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	if s.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d replicas are ready", s.Status.ReadyReplicas, replicas)
+	}
+	if s.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		if s.Status.UpdateRevision != s.Status.CurrentRevision {
+			return false, fmt.Sprintf("%d of %d replicas are updated", s.Status.UpdatedReplicas, replicas)
+		}
+	}
+	return true, fmt.Sprintf("%d of %d replicas are ready", s.Status.ReadyReplicas, replicas)
+}
+
+func daemonSetStatus(d *appsv1.DaemonSet) (bool, string) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, "waiting for the daemonset spec update to be observed"
+	}
+	if d.Status.UpdatedNumberScheduled < d.Status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("%d of %d updated pods are scheduled", d.Status.UpdatedNumberScheduled, d.Status.DesiredNumberScheduled)
+	}
+	if d.Status.NumberAvailable < d.Status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("%d of %d updated pods are available", d.Status.NumberAvailable, d.Status.DesiredNumberScheduled)
+	}
+	return true, fmt.Sprintf("%d of %d pods are available", d.Status.NumberAvailable, d.Status.DesiredNumberScheduled)
+}
+
+func jobStatus(j *batchv1.Job) (bool, bool, string) {
+	for _, c := range j.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, false, "completed"
+		case batchv1.JobFailed:
+			return false, true, fmt.Sprintf("failed: %s", c.Message)
+		}
+	}
+	return false, false, fmt.Sprintf("%d active, %d succeeded, %d failed", j.Status.Active, j.Status.Succeeded, j.Status.Failed)
+}
+
+// WaitDeleted waits for the objects to be gone from the cluster e.g. after a foreground delete, which returns before the
+// dependents (like the pods of a Deployment) are deleted.
+func (c *Client) WaitDeleted(ctx context.Context, objs []*unstructured.Unstructured, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, obj := range objs {
+		obj = obj.DeepCopy()
+		ri, _, err := c.resourceFor(obj)
+		if err != nil {
+			return errutil.Wrap(err, "Resolving [%s]", RefOf(obj))
+		}
+		for {
+			_, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				log.Debug(ctx, "Object deleted", "object", RefOf(obj).String())
+				break
+			}
+			if err != nil && ctx.Err() == nil {
+				return errutil.Wrap(err, "Getting [%s]", RefOf(obj))
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("Timed out after %s waiting for [%s] to be deleted", timeout, RefOf(obj))
+			case <-time.After(_readyPollInterval):
+			}
+		}
+	}
+	return nil
+}
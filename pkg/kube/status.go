@@ -0,0 +1,291 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// WorkloadDetail is the live state of a workload: its replicas, the containers of its pods and their restarts.
+type WorkloadDetail struct {
+	WorkloadStatus
+	Desired int32 `json:"desired"`
+	// ReadyReplicas are the replicas (or for Jobs, the succeeded pods) that are ready
+	ReadyReplicas int32 `json:"ready_replicas"`
+	// Restarts is the sum of the restarts of the containers of the pods
+	Restarts   int32             `json:"restarts"`
+	Containers []ContainerDetail `json:"containers"`
+	// UIDs are the UIDs of the workload and of the replica sets and pods it owns, which its events are about
+	UIDs []types.UID `json:"-"`
+}
+
+// ContainerDetail is a container of a workload.
+type ContainerDetail struct {
+	Name string `json:"name"`
+	// Image is the image in the spec of the workload
+	Image string `json:"image"`
+	// ImageIDs are the distinct images (with their digests) that the pods of the workload are running
+	ImageIDs []string `json:"image_ids"`
+}
+
+// Event is a kubernetes event about an object.
+type Event struct {
+	Object string `json:"object"`
+	// UID is the UID of the object
+	UID      types.UID `json:"-"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Endpoint is how a service of the deployment can be reached.
+type Endpoint struct {
+	ObjectRef
+	// Type is the service type e.g. ClusterIP, LoadBalancer, or Ingress for ingresses
+	Type string `json:"type"`
+	// Addresses are the cluster IP, the external IPs/hostnames, or the hosts of an ingress
+	Addresses []string `json:"addresses"`
+	Ports     []string `json:"ports"`
+}
+
+// ListWorkloads returns the workloads in the namespace that match the label selector.
+func (c *Client) ListWorkloads(ctx context.Context, namespace string, selector string) ([]ObjectRef, error) {
+	opts := metav1.ListOptions{LabelSelector: selector}
+	var refs []ObjectRef
+
+	deployments, err := c.Clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing deployments")
+	}
+	for _, d := range deployments.Items {
+		refs = append(refs, ObjectRef{Kind: GVKDeployment.Kind, Name: d.Name, Namespace: d.Namespace})
+	}
+	statefulSets, err := c.Clientset.AppsV1().StatefulSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing stateful sets")
+	}
+	for _, s := range statefulSets.Items {
+		refs = append(refs, ObjectRef{Kind: GVKStatefulSet.Kind, Name: s.Name, Namespace: s.Namespace})
+	}
+	daemonSets, err := c.Clientset.AppsV1().DaemonSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing daemon sets")
+	}
+	for _, d := range daemonSets.Items {
+		refs = append(refs, ObjectRef{Kind: GVKDaemonSet.Kind, Name: d.Name, Namespace: d.Namespace})
+	}
+	jobs, err := c.Clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing jobs")
+	}
+	for _, j := range jobs.Items {
+		refs = append(refs, ObjectRef{Kind: GVKJob.Kind, Name: j.Name, Namespace: j.Namespace})
+	}
+
+	return refs, nil
+}
+
+// DescribeWorkload returns the live state of the workload.
+func (c *Client) DescribeWorkload(ctx context.Context, ref ObjectRef) (WorkloadDetail, error) {
+	var d WorkloadDetail
+
+	st, err := c.GetWorkloadStatus(ctx, ref)
+	if err != nil {
+		return d, err
+	}
+	d.WorkloadStatus = st
+
+	var spec corev1.PodSpec
+	switch ref.Kind {
+	case GVKDeployment.Kind:
+		o, err := c.Clientset.AppsV1().Deployments(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return d, err
+		}
+		d.Desired, d.ReadyReplicas, spec = ptrValue(o.Spec.Replicas, 1), o.Status.ReadyReplicas, o.Spec.Template.Spec
+		d.UIDs = append(d.UIDs, o.UID)
+		// Events about creating pods (e.g. quota exceeded) are on the replica sets
+		rsUIDs, err := c.ownedReplicaSets(ctx, o)
+		if err != nil {
+			return d, err
+		}
+		d.UIDs = append(d.UIDs, rsUIDs...)
+	case GVKStatefulSet.Kind:
+		o, err := c.Clientset.AppsV1().StatefulSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return d, err
+		}
+		d.Desired, d.ReadyReplicas, spec = ptrValue(o.Spec.Replicas, 1), o.Status.ReadyReplicas, o.Spec.Template.Spec
+		d.UIDs = append(d.UIDs, o.UID)
+	case GVKDaemonSet.Kind:
+		o, err := c.Clientset.AppsV1().DaemonSets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return d, err
+		}
+		d.Desired, d.ReadyReplicas, spec = o.Status.DesiredNumberScheduled, o.Status.NumberReady, o.Spec.Template.Spec
+		d.UIDs = append(d.UIDs, o.UID)
+	case GVKJob.Kind:
+		o, err := c.Clientset.BatchV1().Jobs(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return d, err
+		}
+		d.Desired, d.ReadyReplicas, spec = ptrValue(o.Spec.Completions, 1), o.Status.Succeeded, o.Spec.Template.Spec
+		d.UIDs = append(d.UIDs, o.UID)
+	}
+
+	pods, err := c.PodsOf(ctx, ref)
+	if err != nil {
+		return d, err
+	}
+	for _, pod := range pods {
+		d.UIDs = append(d.UIDs, pod.UID)
+	}
+	for _, container := range spec.Containers {
+		cd := ContainerDetail{Name: container.Name, Image: container.Image}
+		for _, pod := range pods {
+			for _, cs := range pod.Status.ContainerStatuses {
+				if cs.Name != container.Name {
+					continue
+				}
+				d.Restarts += cs.RestartCount
+				if cs.ImageID != "" && !slices.Contains(cd.ImageIDs, cs.ImageID) {
+					cd.ImageIDs = append(cd.ImageIDs, cs.ImageID)
+				}
+			}
+		}
+		d.Containers = append(d.Containers, cd)
+	}
+
+	return d, nil
+}
+
+// ownedReplicaSets returns the UIDs of the replica sets of the deployment.
+func (c *Client) ownedReplicaSets(ctx context.Context, d *appsv1.Deployment) ([]types.UID, error) {
+	sel, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Parsing label selector of deployment [%s]", d.Name)
+	}
+	list, err := c.Clientset.AppsV1().ReplicaSets(d.Namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing replica sets of deployment [%s]", d.Name)
+	}
+	var uids []types.UID
+	for _, rs := range list.Items {
+		if metav1.IsControlledBy(&rs, d) {
+			uids = append(uids, rs.UID)
+		}
+	}
+	return uids, nil
+}
+
+// WarningEvents returns the warning events in the namespace newer than since, most recent first.
+func (c *Client) WarningEvents(ctx context.Context, namespace string, since time.Duration) ([]Event, error) {
+	list, err := c.Clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: "type=" + corev1.EventTypeWarning})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing events")
+	}
+	cutoff := time.Now().Add(-since)
+	var events []Event
+	for _, e := range list.Items {
+		last := e.LastTimestamp.Time
+		if last.IsZero() {
+			last = e.EventTime.Time
+		}
+		if last.Before(cutoff) {
+			continue
+		}
+		events = append(events, Event{
+			Object:   fmt.Sprintf("%s/%s", e.InvolvedObject.Kind, e.InvolvedObject.Name),
+			UID:      e.InvolvedObject.UID,
+			Reason:   e.Reason,
+			Message:  strings.TrimSpace(e.Message),
+			Count:    e.Count,
+			LastSeen: last,
+		})
+	}
+	slices.SortFunc(events, func(a, b Event) int { return b.LastSeen.Compare(a.LastSeen) })
+	return events, nil
+}
+
+// ListEndpoints returns the services and ingresses in the namespace that match the label selector.
+func (c *Client) ListEndpoints(ctx context.Context, namespace string, selector string) ([]Endpoint, error) {
+	opts := metav1.ListOptions{LabelSelector: selector}
+	var endpoints []Endpoint
+
+	services, err := c.Clientset.CoreV1().Services(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing services")
+	}
+	for _, svc := range services.Items {
+		ep := Endpoint{
+			ObjectRef: ObjectRef{Kind: "Service", Name: svc.Name, Namespace: svc.Namespace},
+			Type:      string(svc.Spec.Type),
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			ep.Addresses = append(ep.Addresses, firstNonEmptyString(ing.Hostname, ing.IP))
+		}
+		ep.Addresses = append(ep.Addresses, svc.Spec.ExternalIPs...)
+		if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != corev1.ClusterIPNone {
+			ep.Addresses = append(ep.Addresses, svc.Spec.ClusterIP)
+		}
+		for _, p := range svc.Spec.Ports {
+			port := fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+			if p.NodePort != 0 {
+				port = fmt.Sprintf("%d:%d/%s", p.Port, p.NodePort, p.Protocol)
+			}
+			ep.Ports = append(ep.Ports, port)
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	ingresses, err := c.Clientset.NetworkingV1().Ingresses(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing ingresses")
+	}
+	for _, ing := range ingresses.Items {
+		ep := Endpoint{
+			ObjectRef: ObjectRef{Kind: "Ingress", Name: ing.Name, Namespace: ing.Namespace},
+			Type:      "Ingress",
+		}
+		scheme := "http"
+		if len(ing.Spec.TLS) > 0 {
+			scheme = "https"
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.Host != "" {
+				ep.Addresses = append(ep.Addresses, scheme+"://"+rule.Host)
+			}
+		}
+		for _, lb := range ing.Status.LoadBalancer.Ingress {
+			ep.Addresses = append(ep.Addresses, firstNonEmptyString(lb.Hostname, lb.IP))
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	return endpoints, nil
+}
+
+func ptrValue(p *int32, def int32) int32 {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+func firstNonEmptyString(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
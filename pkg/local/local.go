@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 
 	"github.com/teejays/gokutil/errutil"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
 )
 
 type Config struct {
@@ -16,7 +18,8 @@ type Config struct {
 }
 
 type PermanentConfig struct {
-	Credentials Credentials `json:"credentials"`
+	Credentials Credentials     `json:"credentials"`
+	Registry    registry.Config `json:"registry"`
 }
 
 type Credentials struct {
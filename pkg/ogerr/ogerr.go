@@ -0,0 +1,149 @@
+// Package ogerr categorises the errors of the CLI, so that og exits with a code that tells scripts what kind of failure
+// it was, and ends with a hint on how to fix it.
+//
+// Exit codes:
+//
+//	0    success
+//	1    failure of an unknown category, or changes found by og deploy diff
+//	2    usage: invalid command, arguments or flags
+//	3    auth: missing or rejected credentials (registry, cluster, cloud)
+//	4    license: missing or invalid Ongoku license
+//	5    engine: the core engine (goku) failed
+//	6    network: a server could not be reached
+//	7    deploy: building, applying or rolling out the app failed
+//	130  cancelled: interrupted e.g. with Ctrl+C
+//
+// og exec, og shell and plugins exit with the code of the command they run instead (see ExitCodeError). The codes are only ever added to, never
+// changed.
+package ogerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+type Category string
+
+const (
+	CategoryUnknown   Category = ""
+	CategoryUsage     Category = "usage"
+	CategoryAuth      Category = "auth"
+	CategoryLicense   Category = "license"
+	CategoryEngine    Category = "engine"
+	CategoryNetwork   Category = "network"
+	CategoryDeploy    Category = "deploy"
+	CategoryCancelled Category = "cancelled"
+)
+
+var _exitCodes = map[Category]int{
+	CategoryUnknown:   1,
+	CategoryUsage:     2,
+	CategoryAuth:      3,
+	CategoryLicense:   4,
+	CategoryEngine:    5,
+	CategoryNetwork:   6,
+	CategoryDeploy:    7,
+	CategoryCancelled: 130,
+}
+
+var _hints = map[Category]string{
+	CategoryUnknown:   "Run again with --log-level debug for more details.",
+	CategoryUsage:     "Check the command and its flags with --help e.g. og deploy --help.",
+	CategoryAuth:      "Check the credentials: log in to the registry with og registry login, and check the kubeconfig context and the cloud credentials (e.g. AWS_PROFILE).",
+	CategoryLicense:   "Check the Ongoku license file at ~/.ongoku/license.txt.",
+	CategoryEngine:    "Check that the core engine (goku) is installed and on the PATH, and run again with --log-level debug to see its output.",
+	CategoryNetwork:   "Check the network connection (and VPN or proxy), and that the server is reachable. Then run again.",
+	CategoryDeploy:    "Check the deployment with og deploy status and og logs. og deploy rollback restores the previous release.",
+	CategoryCancelled: "The command was cancelled before it completed. Run it again to complete it.",
+}
+
+// ExitCode is the exit code of og for the category.
+func (c Category) ExitCode() int {
+	return _exitCodes[c]
+}
+
+// Hint tells the user how to fix an error of the category.
+func (c Category) Hint() string {
+	return _hints[c]
+}
+
+func (c Category) String() string {
+	if c == CategoryUnknown {
+		return "unknown"
+	}
+	return string(c)
+}
+
+// Error is an error with a category. It's found through errutil.Wrap chains with errors.As.
+type Error struct {
+	Category Category
+	Err      error
+}
+
+func (e Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitCodeError is returned when a command that og runs (e.g. in a container, or a plugin) exits with a non-zero code.
+// og exits with the same code, since the command has printed its own errors.
+type ExitCodeError struct {
+	Code int
+}
+
+func (e ExitCodeError) Error() string {
+	return fmt.Sprintf("Command exited with code %d", e.Code)
+}
+
+// New creates an error of the category.
+func New(c Category, msg string, args ...interface{}) error {
+	return Error{Category: c, Err: fmt.Errorf(msg, args...)}
+}
+
+// Mark gives err the category, unless it already has one (e.g. a network error while deploying stays a network
+// error).
+func Mark(err error, c Category) error {
+	if err == nil || CategoryOf(err) != CategoryUnknown {
+		return err
+	}
+	return Error{Category: c, Err: err}
+}
+
+// CategoryOf returns the category of err: cancelled if the context was cancelled, else the category it was given, else
+// the category of the well known errors in it (e.g. network errors, errors of the kubernetes API).
+func CategoryOf(err error) Category {
+	if err == nil {
+		return CategoryUnknown
+	}
+	if errors.Is(err, context.Canceled) {
+		return CategoryCancelled
+	}
+	var e Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+		return CategoryAuth
+	}
+	// Not net.Error: syscall errors (e.g. a missing file) and context.DeadlineExceeded (e.g. waiting for a rollout) are
+	// net.Errors too
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var urlErr *url.Error
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || (errors.As(err, &urlErr) && !errors.Is(err, context.DeadlineExceeded)) {
+		return CategoryNetwork
+	}
+	var statusErr apierrors.APIStatus
+	if errors.As(err, &statusErr) {
+		return CategoryDeploy
+	}
+	return CategoryUnknown
+}
@@ -0,0 +1,184 @@
+// Package output writes the results of the commands to stdout, in the format selected with --output:
+//   - table (default): for people e.g. aligned columns
+//   - json: the result as JSON, for scripts
+//   - yaml: the result as YAML, with the same fields as the JSON
+//   - quiet: only the identifiers of the result (e.g. image references), one per line
+//
+// The results are the exported types of the commands, and their fields are documented there. Fields are only ever added
+// to them, never renamed or removed, so that scripts keep working. The commands pass stdout as the writer, and logs go to
+// stderr, so that stdout only has results.
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"gopkg.in/yaml.v3"
+)
+
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+	FormatQuiet Format = "quiet"
+)
+
+var _formats = []Format{FormatTable, FormatJSON, FormatYAML, FormatQuiet}
+
+// _format is the format of this run, set once from the --output flag.
+var _format = FormatTable
+
+// ParseFormat parses the value of --output. Empty is the table format.
+func ParseFormat(s string) (Format, error) {
+	if s == "" {
+		return FormatTable, nil
+	}
+	f := Format(strings.ToLower(s))
+	if !slices.Contains(_formats, f) {
+		var names []string
+		for _, f := range _formats {
+			names = append(names, string(f))
+		}
+		return "", fmt.Errorf("Invalid output format [%s]. Options: %s", s, strings.Join(names, ", "))
+	}
+	return f, nil
+}
+
+func SetFormat(f Format) {
+	_format = f
+}
+
+func GetFormat() Format {
+	return _format
+}
+
+// IsStructured tells whether the results are written for scripts (json or yaml).
+func IsStructured() bool {
+	return _format == FormatJSON || _format == FormatYAML
+}
+
+// Identifier is implemented by the results that have something to print in the quiet format. Results that don't
+// implement it print nothing.
+type Identifier interface {
+	// Identifiers returns the lines to print e.g. the references of the built images
+	Identifiers() []string
+}
+
+// Print writes the result to w in the output format. printTable writes it in the table format. If it's nil, nothing
+// is written in the table format (e.g. when the logs already say what was done).
+func Print(w io.Writer, result any, printTable func(w io.Writer)) error {
+	switch _format {
+	case FormatJSON:
+		b, err := encodeJSON(result, "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case FormatYAML:
+		b, err := encodeYAML(result)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case FormatQuiet:
+		if r, ok := result.(Identifier); ok {
+			for _, id := range r.Identifiers() {
+				fmt.Fprintln(w, id)
+			}
+		}
+		return nil
+	default:
+		if printTable != nil {
+			printTable(w)
+		}
+		return nil
+	}
+}
+
+// PrintItem writes one item of a stream of results (e.g. a line of logs) as soon as it's known: one JSON object per line
+// in json (JSON Lines), and one document per item in yaml. In the table and quiet formats, printText writes it.
+func PrintItem(w io.Writer, item any, printText func(w io.Writer)) error {
+	switch _format {
+	case FormatJSON:
+		b, err := encodeJSON(item, "")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case FormatYAML:
+		b, err := encodeYAML(item)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append([]byte("---\n"), b...))
+		return err
+	default:
+		printText(w)
+		return nil
+	}
+}
+
+// NonNil returns the list, or an empty list if it's nil, so that lists are [] and not null in the results.
+func NonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+func encodeJSON(v any, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Encoding result as JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeYAML encodes the value through its JSON encoding, so that the YAML has the same field names (and order) as the
+// JSON.
+func encodeYAML(v any) ([]byte, error) {
+	b, err := encodeJSON(v, "")
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	err = yaml.Unmarshal(b, &node)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Converting result to YAML")
+	}
+	// JSON is flow style YAML with quoted strings. Block style reads better.
+	resetStyle(&node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&node)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		return nil, errutil.Wrap(err, "Encoding result as YAML")
+	}
+	return buf.Bytes(), nil
+}
+
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
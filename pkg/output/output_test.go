@@ -0,0 +1,185 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+type testResult struct {
+	Name   string            `json:"name"`
+	Images []string          `json:"images"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Count  int               `json:"count"`
+	Skip   string            `json:"-"`
+}
+
+func (r testResult) Identifiers() []string {
+	return r.Images
+}
+
+// testResultNoIDs has nothing to print in the quiet format.
+type testResultNoIDs struct {
+	Name string `json:"name"`
+}
+
+func printWith(t *testing.T, f Format, result any) string {
+	t.Helper()
+	SetFormat(f)
+	t.Cleanup(func() { SetFormat(FormatTable) })
+	var buf bytes.Buffer
+	err := Print(&buf, result, func(w io.Writer) { fmt.Fprintln(w, "NAME  IMAGES") })
+	if err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	return buf.String()
+}
+
+func TestPrint(t *testing.T) {
+	result := testResult{
+		Name:   "<app> & co",
+		Images: []string{"myrepo/app:v1", "myrepo/worker:v1"},
+		Labels: map[string]string{"enabled": "true", "version": "1"},
+		Count:  2,
+		Skip:   "not printed",
+	}
+
+	tests := []struct {
+		name   string
+		format Format
+		result any
+		want   string
+	}{
+		{
+			name:   "table",
+			format: FormatTable,
+			result: result,
+			want:   "NAME  IMAGES\n",
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			result: result,
+			want: `{
+  "name": "<app> & co",
+  "images": [
+    "myrepo/app:v1",
+    "myrepo/worker:v1"
+  ],
+  "labels": {
+    "enabled": "true",
+    "version": "1"
+  },
+  "count": 2
+}
+`,
+		},
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			result: result,
+			want: `name: <app> & co
+images:
+  - myrepo/app:v1
+  - myrepo/worker:v1
+labels:
+  enabled: "true"
+  version: "1"
+count: 2
+`,
+		},
+		{
+			name:   "json with an empty list",
+			format: FormatJSON,
+			result: testResult{Images: NonNil[string](nil)},
+			want:   "{\n  \"name\": \"\",\n  \"images\": [],\n  \"count\": 0\n}\n",
+		},
+		{
+			name:   "quiet",
+			format: FormatQuiet,
+			result: result,
+			want:   "myrepo/app:v1\nmyrepo/worker:v1\n",
+		},
+		{
+			name:   "quiet without identifiers",
+			format: FormatQuiet,
+			result: testResultNoIDs{Name: "app"},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := printWith(t, tt.format, tt.result)
+			if got != tt.want {
+				t.Errorf("Print() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The YAML decodes to the same values, with the strings that look like other types kept as strings
+	var decoded testResult
+	err := yaml.Unmarshal([]byte(printWith(t, FormatYAML, result)), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Labels["enabled"] != "true" || decoded.Labels["version"] != "1" || decoded.Count != 2 {
+		t.Errorf("Print() YAML decodes to %+v", decoded)
+	}
+}
+
+func TestPrintItem(t *testing.T) {
+	type line struct {
+		Pod  string `json:"pod"`
+		Line string `json:"line"`
+	}
+	items := []line{{Pod: "app-1", Line: "started"}, {Pod: "app-2", Line: "ready"}}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{format: FormatJSON, want: "{\"pod\":\"app-1\",\"line\":\"started\"}\n{\"pod\":\"app-2\",\"line\":\"ready\"}\n"},
+		{format: FormatYAML, want: "---\npod: app-1\nline: started\n---\npod: app-2\nline: ready\n"},
+		{format: FormatTable, want: "app-1 started\napp-2 ready\n"},
+		{format: FormatQuiet, want: "app-1 started\napp-2 ready\n"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			SetFormat(tt.format)
+			t.Cleanup(func() { SetFormat(FormatTable) })
+			var buf bytes.Buffer
+			for _, item := range items {
+				err := PrintItem(&buf, item, func(w io.Writer) { fmt.Fprintln(w, item.Pod, item.Line) })
+				if err != nil {
+					t.Fatalf("PrintItem() error = %v", err)
+				}
+			}
+			if buf.String() != tt.want {
+				t.Errorf("PrintItem() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{in: "", want: FormatTable},
+		{in: "json", want: FormatJSON},
+		{in: "YAML", want: FormatYAML},
+		{in: "quiet", want: FormatQuiet},
+		{in: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
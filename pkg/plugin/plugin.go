@@ -0,0 +1,493 @@
+// Package plugin finds, installs and runs third-party og subcommands, like git and kubectl plugins: `og <name> args...`
+// runs the executable og-<name> with the args, when <name> is not one of og's own subcommands. Plugins are looked up in
+// the plugins dir (~/.ongoku/plugins, where og plugin install puts them) and then on the PATH.
+//
+// A plugin may have a manifest next to its executable, og-<name>.yaml:
+//
+//	name: <name>
+//	version: 1.2.0
+//	description: What the plugin does
+//	og_version: ">= 0.1.0, < 0.2.0" # the versions of og the plugin works with
+//
+// Plugins get the context og resolved in env variables (see Env), in addition to the env of og.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/local"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+const (
+	// Prefix is the prefix of the executables of plugins
+	Prefix = "og-"
+	// ManifestExt is the extension of the manifest, added to the path of the executable
+	ManifestExt = ".yaml"
+)
+
+var _nameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Manifest describes a plugin.
+type Manifest struct {
+	Name        string `yaml:"name" json:"name"`
+	Version     string `yaml:"version" json:"version,omitempty"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	// OgVersion is the constraint on the versions of og the plugin works with e.g. ">= 0.1.0, < 0.2.0"
+	OgVersion string `yaml:"og_version" json:"og_version,omitempty"`
+}
+
+// Plugin is a plugin that was found.
+type Plugin struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Installed tells whether the plugin is in the plugins dir, rather than on the PATH
+	Installed bool `json:"installed"`
+	// Manifest is nil if the plugin does not have one
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+// Dir is the dir og plugin install puts the plugins in.
+func Dir(ctx context.Context) (string, error) {
+	dir, err := local.GetDefaultConfigDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// ValidateName ensures that the name can be a subcommand.
+func ValidateName(name string) error {
+	if !_nameRegexp.MatchString(name) {
+		return ogerr.New(ogerr.CategoryUsage, "Invalid plugin name [%s]. Names have lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// List returns the plugins, in the order they are looked up. When more than one executable has the same name, the
+// first one is used and the others are left out. Plugins named after one of og's own subcommands (reserved) are left out
+// too, since they cannot be run.
+func List(ctx context.Context, reserved []string) ([]Plugin, error) {
+	var plugins []Plugin
+	seen := map[string]bool{}
+	for i, dir := range searchPath(ctx) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			// PATH often has dirs that don't exist
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), Prefix)
+			if !ok || strings.HasSuffix(name, ManifestExt) || ValidateName(name) != nil || seen[name] {
+				continue
+			}
+			p := filepath.Join(dir, e.Name())
+			if !isExecutable(p) {
+				continue
+			}
+			if slices.Contains(reserved, name) {
+				log.Debug(ctx, "Ignoring plugin named after a subcommand of og", "plugin", p)
+				continue
+			}
+			seen[name] = true
+			plugin, err := load(name, p, i == 0)
+			if err != nil {
+				return nil, err
+			}
+			plugins = append(plugins, plugin)
+		}
+	}
+	return plugins, nil
+}
+
+// Find returns the plugin with the name, if there is one.
+func Find(ctx context.Context, name string) (Plugin, bool, error) {
+	if ValidateName(name) != nil {
+		return Plugin{}, false, nil
+	}
+	for i, dir := range searchPath(ctx) {
+		p := filepath.Join(dir, Prefix+name)
+		if isExecutable(p) {
+			plugin, err := load(name, p, i == 0)
+			return plugin, true, err
+		}
+	}
+	return Plugin{}, false, nil
+}
+
+// searchPath returns the dirs plugins are looked up in: the plugins dir, then the PATH.
+func searchPath(ctx context.Context) []string {
+	var dirs []string
+	dir, err := Dir(ctx)
+	if err != nil {
+		log.Debug(ctx, "Could not get the plugins dir, only looking up plugins on the PATH", "error", err)
+	} else {
+		dirs = append(dirs, dir)
+	}
+	for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+		if d != "" && !slices.Contains(dirs, d) {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+func isExecutable(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+func load(name string, p string, installed bool) (Plugin, error) {
+	plugin := Plugin{Name: name, Path: p, Installed: installed}
+	b, err := os.ReadFile(p + ManifestExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return plugin, nil
+		}
+		return plugin, errutil.Wrap(err, "Reading manifest of plugin [%s]", name)
+	}
+	m, err := parseManifest(name, b)
+	if err != nil {
+		return plugin, errutil.Wrap(err, "Parsing manifest [%s]", p+ManifestExt)
+	}
+	plugin.Manifest = &m
+	return plugin, nil
+}
+
+func parseManifest(name string, b []byte) (Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err := dec.Decode(&m)
+	if err != nil {
+		return m, err
+	}
+	if m.Name != "" && m.Name != name {
+		return m, fmt.Errorf("The manifest is for plugin [%s], not [%s]", m.Name, name)
+	}
+	if m.Version != "" {
+		_, err = semver.NewVersion(m.Version)
+		if err != nil {
+			return m, errutil.Wrap(err, "Parsing version [%s]", m.Version)
+		}
+	}
+	if m.OgVersion != "" {
+		_, err = semver.NewConstraint(m.OgVersion)
+		if err != nil {
+			return m, errutil.Wrap(err, "Parsing og_version [%s]", m.OgVersion)
+		}
+	}
+	return m, nil
+}
+
+// CheckOgVersion returns an error if the manifest of the plugin says it does not work with this version of og.
+func (p Plugin) CheckOgVersion(ogVersion string) error {
+	if p.Manifest == nil || p.Manifest.OgVersion == "" {
+		return nil
+	}
+	c, err := semver.NewConstraint(p.Manifest.OgVersion)
+	if err != nil {
+		return errutil.Wrap(err, "Parsing og_version [%s] of plugin [%s]", p.Manifest.OgVersion, p.Name)
+	}
+	v, err := semver.NewVersion(ogVersion)
+	if err != nil {
+		return errutil.Wrap(err, "Parsing og version [%s]", ogVersion)
+	}
+	if !c.Check(v) {
+		return ogerr.New(ogerr.CategoryUsage, "Plugin [%s] works with og %s, and this is og %s. Upgrade og, or install a version of the plugin that works with it", p.Name, p.Manifest.OgVersion, ogVersion)
+	}
+	return nil
+}
+
+// Env is the context og passes to plugins, as env variables. Empty values are not set.
+type Env struct {
+	// OG_VERSION
+	OgVersion string
+	// OG_BIN is the path of the og executable, to run og from the plugin
+	OgBin string
+	// OG_APP_ROOT is the root dir of the app, if og runs in one (see --app-dir)
+	AppRoot string
+	// OG_APP_NAME
+	AppName string
+	// OG_PROFILE is the path of the profile config of the user
+	Profile string
+	// OG_SERVER_URL is the URL of the Ongoku server
+	ServerURL string
+	// OG_TOKEN is the token of the user for the Ongoku server, if logged in
+	Token string
+	// OG_LOG_LEVEL e.g. info
+	LogLevel string
+	// OG_OUTPUT is the format of the results on stdout e.g. json (see --output)
+	Output string
+}
+
+// Environ returns the env variables, in the form of os.Environ.
+func (e Env) Environ() []string {
+	var env []string
+	for _, kv := range [][2]string{
+		{"OG_VERSION", e.OgVersion},
+		{"OG_BIN", e.OgBin},
+		{"OG_APP_ROOT", e.AppRoot},
+		{"OG_APP_NAME", e.AppName},
+		{"OG_PROFILE", e.Profile},
+		{"OG_SERVER_URL", e.ServerURL},
+		{"OG_TOKEN", e.Token},
+		{"OG_LOG_LEVEL", e.LogLevel},
+		{"OG_OUTPUT", e.Output},
+	} {
+		if kv[1] != "" {
+			env = append(env, kv[0]+"="+kv[1])
+		}
+	}
+	return env
+}
+
+// Run runs the plugin with the args, attached to the terminal. Its results go to stdout.
+func (p Plugin) Run(ctx context.Context, args []string, env Env) error {
+	cmd := interrupt.Command(ctx, p.Path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env.Environ()...)
+	return cmd.Run()
+}
+
+// InstallOptions are the options of Install.
+type InstallOptions struct {
+	// Name of the plugin. Defaults to the name of the executable without the prefix.
+	Name string
+	// OgVersion is the version of og, that the plugin must work with unless Force
+	OgVersion string
+	Force     bool
+	// SHA256 is the expected hex SHA-256 checksum of the executable. It's required for downloads, since the plugin runs
+	// with the credentials of og (see Env).
+	SHA256 string
+	// ManifestSHA256 is the expected hex SHA-256 checksum of the manifest. The manifest of a download is only fetched if
+	// it's set, since the manifest decides whether the plugin is compatible.
+	ManifestSHA256 string
+}
+
+// Install copies the plugin executable at source (a path or an https URL) into the plugins dir, along with its manifest
+// if there is one next to it (for downloads, only with opts.ManifestSHA256). An installed plugin with the same name is
+// replaced. Unless opts.Force, a plugin that does
+// not work with this version of og is not installed.
+func Install(ctx context.Context, source string, opts InstallOptions) (Plugin, error) {
+	if strings.HasPrefix(source, "http://") {
+		return Plugin{}, ogerr.New(ogerr.CategoryUsage, "Plugins are not downloaded over plain http, since they run with the credentials of og. Use an https URL")
+	}
+	remote := strings.HasPrefix(source, "https://")
+	if remote && opts.SHA256 == "" {
+		return Plugin{}, ogerr.New(ogerr.CategoryUsage, "The checksum of a downloaded plugin is required, since it runs with the credentials of og. Pass it with --sha256")
+	}
+	name := opts.Name
+	if name == "" {
+		base := filepath.Base(source)
+		if remote {
+			u, err := url.Parse(source)
+			if err != nil {
+				return Plugin{}, errutil.Wrap(err, "Parsing URL [%s]", source)
+			}
+			base = path.Base(u.Path)
+		}
+		var ok bool
+		name, ok = strings.CutPrefix(base, Prefix)
+		if !ok {
+			return Plugin{}, ogerr.New(ogerr.CategoryUsage, "The executable [%s] is not named %s<name>. Use --name to name the plugin", base, Prefix)
+		}
+	}
+	err := ValidateName(name)
+	if err != nil {
+		return Plugin{}, err
+	}
+
+	fetch := readFile
+	if remote {
+		fetch = download
+	}
+	bin, err := fetch(ctx, source)
+	if err != nil {
+		return Plugin{}, errutil.Wrap(err, "Getting plugin [%s]", source)
+	}
+	if bin == nil {
+		return Plugin{}, fmt.Errorf("Plugin [%s] does not exist", source)
+	}
+	// Before anything is written, so that a tampered plugin is never executable
+	if opts.SHA256 != "" {
+		err = checkSHA256(bin, opts.SHA256)
+		if err != nil {
+			return Plugin{}, errutil.Wrap(err, "Checking plugin [%s]. It was not installed", source)
+		}
+	}
+	var manifest []byte
+	if !remote || opts.ManifestSHA256 != "" {
+		manifest, err = fetch(ctx, source+ManifestExt)
+		if err != nil {
+			return Plugin{}, errutil.Wrap(err, "Getting plugin manifest [%s]", source+ManifestExt)
+		}
+	} else {
+		log.Info(ctx, "Not installing the manifest of the downloaded plugin, since its checksum was not given. Pass it with --manifest-sha256 to install it", "manifest", source+ManifestExt)
+	}
+	if opts.ManifestSHA256 != "" {
+		if manifest == nil {
+			return Plugin{}, fmt.Errorf("Plugin manifest [%s] does not exist, but its checksum was given", source+ManifestExt)
+		}
+		err = checkSHA256(manifest, opts.ManifestSHA256)
+		if err != nil {
+			return Plugin{}, errutil.Wrap(err, "Checking plugin manifest [%s]. The plugin was not installed", source+ManifestExt)
+		}
+	}
+
+	plugin := Plugin{Name: name, Installed: true}
+	if manifest != nil {
+		m, err := parseManifest(name, manifest)
+		if err != nil {
+			return Plugin{}, errutil.Wrap(err, "Parsing manifest [%s]", source+ManifestExt)
+		}
+		plugin.Manifest = &m
+	}
+	err = plugin.CheckOgVersion(opts.OgVersion)
+	if err != nil && !opts.Force {
+		return Plugin{}, errutil.Wrap(err, "Use --force to install it anyway")
+	}
+
+	dir, err := Dir(ctx)
+	if err != nil {
+		return Plugin{}, errutil.Wrap(err, "Getting plugins dir")
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return Plugin{}, errutil.Wrap(err, "Creating plugins dir")
+	}
+	plugin.Path = filepath.Join(dir, Prefix+name)
+	err = writeFileAtomic(plugin.Path, bin, 0755)
+	if err != nil {
+		return Plugin{}, errutil.Wrap(err, "Writing plugin [%s]", plugin.Path)
+	}
+	if manifest != nil {
+		err = writeFileAtomic(plugin.Path+ManifestExt, manifest, 0644)
+	} else {
+		// The manifest of the replaced plugin
+		err = os.Remove(plugin.Path + ManifestExt)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return Plugin{}, errutil.Wrap(err, "Writing plugin manifest")
+	}
+	return plugin, nil
+}
+
+// Remove removes a plugin installed with Install.
+func Remove(ctx context.Context, name string) (Plugin, error) {
+	plugin, ok, err := Find(ctx, name)
+	if err != nil {
+		return plugin, err
+	}
+	if !ok {
+		return plugin, ogerr.New(ogerr.CategoryUsage, "Plugin [%s] is not installed. See og plugin list", name)
+	}
+	if !plugin.Installed {
+		return plugin, ogerr.New(ogerr.CategoryUsage, "Plugin [%s] was not installed with og plugin install, it's on the PATH at [%s]. Remove it from there", name, plugin.Path)
+	}
+	for _, p := range []string{plugin.Path, plugin.Path + ManifestExt} {
+		err = os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return plugin, errutil.Wrap(err, "Removing [%s]", p)
+		}
+	}
+	return plugin, nil
+}
+
+// checkSHA256 returns an error if the hex SHA-256 checksum of b is not want, which may be prefixed with sha256:.
+func checkSHA256(b []byte, want string) error {
+	sum := sha256.Sum256(b)
+	got := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(got, strings.TrimPrefix(want, "sha256:")) {
+		return fmt.Errorf("The SHA-256 checksum is [%s], not [%s] as expected", got, want)
+	}
+	return nil
+}
+
+// readFile returns the content of the file, or nil if it does not exist.
+func readFile(_ context.Context, p string) ([]byte, error) {
+	b, err := os.ReadFile(p)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// _httpClient only follows redirects to https URLs.
+var _httpClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("Not following redirect to [%s], which is not https", req.URL)
+		}
+		if len(via) >= 10 {
+			return errors.New("Stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// download returns the content at the URL, or nil if it's not found.
+func download(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating request")
+	}
+	resp, err := _httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got status [%s] from [%s]", resp.Status, u)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// writeFileAtomic writes the file through a temp file, so that a plugin that is running is not changed under it.
+func writeFileAtomic(p string, b []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
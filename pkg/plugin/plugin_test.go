@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestInstallManifestChecksum(t *testing.T) {
+	bin := []byte("#!/bin/sh\necho lint\n")
+	manifest := []byte("name: lint\nversion: 1.0.0\nog_version: \">= 1.0.0\"\n")
+
+	tests := []struct {
+		name           string
+		manifestSHA256 string
+		force          bool
+		wantErr        string
+	}{
+		{name: "incompatible manifest", wantErr: "Use --force"},
+		{name: "incompatible manifest with force", force: true},
+		{name: "manifest with its checksum", manifestSHA256: sha256Hex(manifest), force: true},
+		{name: "manifest with another checksum", manifestSHA256: sha256Hex(bin), force: true, wantErr: "Checking plugin manifest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			src := filepath.Join(t.TempDir(), Prefix+"lint")
+			for p, b := range map[string][]byte{src: bin, src + ManifestExt: manifest} {
+				err := os.WriteFile(p, b, 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p, err := Install(context.Background(), src, InstallOptions{OgVersion: "0.1.0", Force: tt.force, SHA256: sha256Hex(bin), ManifestSHA256: tt.manifestSHA256})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Install() error = %v, want %q", err, tt.wantErr)
+				}
+				if _, ok, _ := Find(context.Background(), "lint"); ok {
+					t.Errorf("Install() failed but installed the plugin")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Install() error = %v", err)
+			}
+			if p.Manifest == nil || p.Manifest.Version != "1.0.0" {
+				t.Errorf("Install() manifest = %+v, want version 1.0.0", p.Manifest)
+			}
+		})
+	}
+}
+
+func TestInstallRemoteRequiresChecksum(t *testing.T) {
+	_, err := Install(context.Background(), "https://example.com/og-lint", InstallOptions{})
+	if err == nil || !strings.Contains(err.Error(), "--sha256") {
+		t.Errorf("Install() error = %v, want the checksum to be required", err)
+	}
+}
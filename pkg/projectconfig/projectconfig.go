@@ -0,0 +1,463 @@
+// Package projectconfig loads the CLI specific settings of an Ongoku app.
+//
+// These settings live in a separate file (ongoku.cli.yaml) next to the ongoku.yaml file, since ongoku.yaml is owned by
+// the core engine and is decoded strictly (unknown fields are rejected).
+package projectconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"gopkg.in/yaml.v3"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/backup"
+	"github.com/build-ongoku/ongoku-cli/pkg/local"
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+const FileName = "ongoku.cli.yaml"
+
+type Config struct {
+	Deploy   DeployConfig    `yaml:"deploy"`
+	Registry registry.Config `yaml:"registry"`
+	Secrets  SecretsConfig   `yaml:"secrets"`
+	// Tunnels describe the components reached with `og tunnel` e.g. the database
+	Tunnels []TunnelConfig `yaml:"tunnels"`
+	// Database holds the settings of `og db`
+	Database DatabaseConfig `yaml:"database"`
+	// Dev holds the settings of `og dev`
+	Dev DevConfig `yaml:"dev"`
+	// Environments are the named targets (e.g. dev, staging, prod) selected with --env. Each one overlays the settings above.
+	Environments map[string]EnvironmentConfig `yaml:"environments"`
+
+	// EnvName is the selected environment (see ForEnv), if any
+	EnvName string `yaml:"-"`
+}
+
+type DeployConfig struct {
+	// Target is where the app is deployed: kubernetes (default) or compose (Docker Compose on a single host)
+	Target string `yaml:"target"`
+	// Builder is the tool used to build the images: auto (default), docker, podman, buildah or kaniko
+	Builder string `yaml:"builder"`
+	// Images are the docker images that make up the app. If empty, a single image is built using the default app.Dockerfile.
+	Images []ImageConfig `yaml:"images"`
+	// Platforms are the default platforms for all images e.g. linux/amd64, linux/arm64
+	Platforms []string `yaml:"platforms"`
+	// CacheFrom and CacheTo are passed to the builder e.g. type=registry,ref=myrepo/app:buildcache. Each image gets its
+	// own cache: {image} is replaced by the image name, or else images other than the default one get their name as a
+	// suffix e.g. ref=myrepo/app:buildcache-worker.
+	CacheFrom []string `yaml:"cache_from"`
+	CacheTo   []string `yaml:"cache_to"`
+	// Kubernetes holds the settings for apply (and other commands that talk to the cluster)
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+	// Compose holds the settings of the compose target
+	Compose ComposeConfig `yaml:"compose"`
+	// HealthCheck is the smoke check run by `deploy all` after the release is ready
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	// RollbackOnFailure rolls back to the previous release when the workloads do not become ready, for the targets that
+	// support it (not compose)
+	RollbackOnFailure bool `yaml:"rollback_on_failure"`
+}
+
+type HealthCheckConfig struct {
+	// URL is the health endpoint of the deployed app e.g. https://api.example.com/health
+	URL string `yaml:"url"`
+	// ExpectedStatus is the expected HTTP status code. Defaults to any 2xx.
+	ExpectedStatus int `yaml:"expected_status"`
+	// Timeout is how long to keep retrying until the endpoint is healthy e.g. 1m
+	Timeout string `yaml:"timeout"`
+}
+
+type KubernetesConfig struct {
+	Namespace  string `yaml:"namespace"`
+	Context    string `yaml:"context"`
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Manifests are the paths of the k8s manifest files to apply, relative to the app root
+	Manifests []string `yaml:"manifests"`
+	// WaitTimeout is how long to wait for the applied workloads to be ready e.g. 5m
+	WaitTimeout string `yaml:"wait_timeout"`
+	// HistoryLimit is the number of releases kept in the release history. Defaults to 10.
+	HistoryLimit int `yaml:"history_limit"`
+	// Images change the image references in the manifests before they are applied (like kustomize's images)
+	Images []ImageOverride `yaml:"images"`
+	// Overrides change the replicas and resources of the workloads in the manifests before they are applied
+	Overrides []WorkloadOverride `yaml:"overrides"`
+	// Patches are paths (relative to the app root) to partial k8s manifests that are merged into the objects with the
+	// same kind and name before they are applied. Lists of named items (e.g. containers, env) are merged by name.
+	Patches []string `yaml:"patches"`
+}
+
+// ComposeConfig are the settings of the compose target, which runs the app with Docker Compose on a single host.
+type ComposeConfig struct {
+	// Files are the paths of the compose files, relative to the app root. Defaults to infra/.goku/generated/compose/docker-compose.yaml.
+	Files []string `yaml:"files"`
+	// ProjectName is the compose project. Defaults to the deploy identifier.
+	ProjectName string `yaml:"project_name"`
+	// Host is the docker daemon to deploy to e.g. ssh://deploy@myserver. Defaults to the local one (or DOCKER_HOST).
+	Host string `yaml:"host"`
+	// WaitTimeout is how long to wait for the services to be running (and healthy) e.g. 5m
+	WaitTimeout string `yaml:"wait_timeout"`
+}
+
+// SecretsConfig is where the runtime configuration (env variables) of the deployed app is kept. See `og secrets`.
+type SecretsConfig struct {
+	// Store is cluster (default: the values only live in a Kubernetes Secret) or sealed (encrypted in the repo, and
+	// applied as a Kubernetes Secret when deploying)
+	Store string `yaml:"store"`
+	// Name of the Kubernetes Secret. Defaults to the deploy identifier followed by -env.
+	Name string `yaml:"name"`
+	// SealedFile is the path of the sealed secrets, relative to the app root. Defaults to infra/secrets/<env>.sealed.yaml.
+	SealedFile string `yaml:"sealed_file"`
+	// Recipients are the age public keys that sealed secrets are encrypted for
+	Recipients []string `yaml:"recipients"`
+	// ExampleFile lists the variables the app needs, and is compared with the secrets before deploying. Defaults to .env.example.
+	ExampleFile string `yaml:"example_file"`
+	// NoInject stops the secrets from being added (with envFrom) to the containers of the workloads
+	NoInject bool `yaml:"no_inject"`
+}
+
+// TunnelConfig describes how to reach a component of the deployment from the local machine, with `og tunnel`.
+type TunnelConfig struct {
+	// Component is the name given to `og tunnel` e.g. database
+	Component string `yaml:"component"`
+	// Service is the k8s Service (or compose service) of the component. Defaults to the component.
+	Service string `yaml:"service"`
+	// Ports are the ports of the service to forward. Defaults to all of them.
+	Ports []int `yaml:"ports"`
+	// Connection is the connection string printed once the tunnel is up. It can contain the placeholders {host} and
+	// {port} (the local end of the tunnel), and env variables e.g. postgres://app:${DB_PASSWORD}@{host}:{port}/app
+	Connection string `yaml:"connection"`
+}
+
+// DatabaseConfig are the settings of the database of the deployed app.
+type DatabaseConfig struct {
+	Migrate MigrateConfig `yaml:"migrate"`
+	Backup  BackupConfig  `yaml:"backup"`
+}
+
+// BackupConfig are the settings of `og db backup` and `og db restore`. The commands run in the running database
+// workload: the dump command prints the dump to stdout, and the restore command reads it from stdin.
+type BackupConfig struct {
+	// Workload the commands run in. Defaults to database.
+	Workload string `yaml:"workload"`
+	// Container of the workload. Defaults to its first container.
+	Container string `yaml:"container"`
+	// Dump prints the dump of the database to stdout. Defaults to a plain SQL pg_dump of $POSTGRES_DB.
+	Dump []string `yaml:"dump"`
+	// Restore reads a dump from stdin and loads it. Defaults to psql on $POSTGRES_DB.
+	Restore []string `yaml:"restore"`
+	// Storage is where the backups are kept. Defaults to ~/.ongoku/backups/<app>.
+	Storage backup.StorageConfig `yaml:"storage"`
+	// BeforeDestroy backs up the database before `og deploy destroy` deletes it
+	BeforeDestroy bool `yaml:"before_destroy"`
+}
+
+// MigrateConfig are the commands that migrate the database of a deployment. They run in a one-off container (a k8s Job,
+// or docker compose run) of a workload, so they get its image, env and secrets.
+type MigrateConfig struct {
+	// Workload the migrations run in. Defaults to backend.
+	Workload string `yaml:"workload"`
+	// Container of the workload. Defaults to its first container.
+	Container string `yaml:"container"`
+	// Up applies the pending migrations e.g. ["./backend", "migrate", "up"]
+	Up []string `yaml:"up"`
+	// Status lists the applied and pending migrations
+	Status []string `yaml:"status"`
+	// Down rolls back the last migration
+	Down []string `yaml:"down"`
+	// Local are the commands run on the local machine with --local, against a tunnel to the database
+	Local LocalMigrateConfig `yaml:"local"`
+	// BeforeDeploy applies the migrations of a release before it's deployed, and stops the deploy if they fail
+	BeforeDeploy bool `yaml:"before_deploy"`
+}
+
+// LocalMigrateConfig are the migration commands run on the local machine. They run in the app root, with the
+// connection string of the tunnel to the database in an env variable.
+type LocalMigrateConfig struct {
+	Up     []string `yaml:"up"`
+	Status []string `yaml:"status"`
+	Down   []string `yaml:"down"`
+	// Tunnel is the component (see tunnels) of the database. Defaults to database.
+	Tunnel string `yaml:"tunnel"`
+	// URLEnv is the env variable the connection string is passed in. Defaults to DATABASE_URL.
+	URLEnv string `yaml:"url_env"`
+}
+
+// DevConfig are the settings of `og dev`, which runs the app on the local machine.
+type DevConfig struct {
+	// Components by name e.g. database, backend, frontend. The components enabled in ongoku.yaml have defaults, and a
+	// component defined here replaces its default.
+	Components map[string]DevComponentConfig `yaml:"components"`
+	// HealthTimeout is how long to wait for a component to be healthy before giving up e.g. 2m. Defaults to 1m.
+	HealthTimeout string `yaml:"health_timeout"`
+}
+
+// DevComponentConfig defines how a component runs locally: either as a docker container (Image), or as a process
+// (Command).
+type DevComponentConfig struct {
+	// Image runs the component in a docker container e.g. postgres:16-alpine
+	Image string `yaml:"image"`
+	// Command runs the component as a process. With an image, it's the command of the container (optional).
+	Command []string `yaml:"command"`
+	// Dir is the directory the process runs in, relative to the app root. Defaults to the app root.
+	Dir string `yaml:"dir"`
+	// Env are added to the env of the component. Values are expanded using env variables.
+	Env map[string]string `yaml:"env"`
+	// EnvFile is loaded into the env of the component, relative to the app root e.g. .env.development
+	EnvFile string `yaml:"env_file"`
+	// Ports the component listens on, checked for conflicts before starting. Containers publish them on the same port.
+	Ports []int `yaml:"ports"`
+	// Volumes of the container e.g. pgdata:/var/lib/postgresql/data. Named volumes are prefixed with the app name.
+	Volumes []string `yaml:"volumes"`
+	// DependsOn are the components that are started (and healthy) before this one
+	DependsOn []string `yaml:"depends_on"`
+	// HealthCheck tells when the component is ready. Defaults to its first port accepting connections.
+	HealthCheck DevHealthCheckConfig `yaml:"health_check"`
+	// Watch restarts the process when its files change e.g. a Go backend
+	Watch DevWatchConfig `yaml:"watch"`
+	// Disabled components are not run
+	Disabled bool `yaml:"disabled"`
+}
+
+// DevHealthCheckConfig tells when a component is ready. The first check that is set is used.
+type DevHealthCheckConfig struct {
+	// Command is run (in the container, for containers) until it succeeds e.g. ["pg_isready"]
+	Command []string `yaml:"command"`
+	// URL is requested until it returns a status below 500 e.g. http://localhost:8080/health
+	URL string `yaml:"url"`
+	// Port is dialled until it accepts connections. Defaults to the first port of the component.
+	Port int `yaml:"port"`
+}
+
+// DevWatchConfig are the files whose changes restart a process.
+type DevWatchConfig struct {
+	// Paths to watch, relative to the directory of the component e.g. ["."]
+	Paths []string `yaml:"paths"`
+	// Extensions of the files to watch e.g. [".go"]. Defaults to all files.
+	Extensions []string `yaml:"extensions"`
+	// Ignore are directory names that are not watched. node_modules and hidden directories are never watched.
+	Ignore []string `yaml:"ignore"`
+}
+
+// EnvironmentConfig is a deployment target. Unset fields fall back to the top level settings.
+type EnvironmentConfig struct {
+	// Target overrides deploy.target e.g. compose for a single host staging environment
+	Target string `yaml:"target"`
+	// DeployIdentifier of the environment. Defaults to the app name followed by the environment name.
+	DeployIdentifier string `yaml:"deploy_identifier"`
+	// ImageRepo overrides the registry repository e.g. ghcr.io/myorg/{app}-staging
+	ImageRepo string `yaml:"image_repo"`
+	// Kubernetes overlays deploy.kubernetes. Images, overrides and patches are added to the top level ones.
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+	// Compose overlays deploy.compose
+	Compose ComposeConfig `yaml:"compose"`
+	// Replicas sets the replicas of workloads by name e.g. {backend: 3}
+	Replicas map[string]int `yaml:"replicas"`
+	// EnvFile is loaded into the environment (without overriding already set variables) e.g. .env.prod
+	EnvFile string `yaml:"env_file"`
+	// HealthCheck overrides deploy.health_check
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	// Secrets overlays the top level secrets settings
+	Secrets SecretsConfig `yaml:"secrets"`
+	// RollbackOnFailure turns deploy.rollback_on_failure on for the environment
+	RollbackOnFailure bool `yaml:"rollback_on_failure"`
+	// Protected environments cannot be deployed to from a working tree with uncommitted changes
+	Protected bool `yaml:"protected"`
+	// RequireApproval asks for confirmation (or --approve) before changing the environment
+	RequireApproval bool `yaml:"require_approval"`
+}
+
+// ImageOverride changes the references to an image in the k8s manifests.
+type ImageOverride struct {
+	// Name is the image as referenced in the manifests, without the tag or digest e.g. myrepo/app
+	Name string `yaml:"name"`
+	// Image is the name of a built image (see deploy.images) whose reference (digest or tag) replaces the image
+	Image string `yaml:"image"`
+	// NewName, NewTag and Digest replace the parts of the reference, like kustomize's images
+	NewName string `yaml:"new_name"`
+	NewTag  string `yaml:"new_tag"`
+	Digest  string `yaml:"digest"`
+}
+
+// WorkloadOverride changes a workload in the k8s manifests.
+type WorkloadOverride struct {
+	// Kind of the workload e.g. Deployment. Optional, if the name is unique.
+	Kind string `yaml:"kind"`
+	// Name of the workload
+	Name     string `yaml:"name"`
+	Replicas *int   `yaml:"replicas"`
+	// Container is the container whose resources are set. Defaults to all the containers of the workload.
+	Container string           `yaml:"container"`
+	Resources *ResourcesConfig `yaml:"resources"`
+}
+
+// ResourcesConfig are the compute resources of a container e.g. requests: {cpu: 100m, memory: 128Mi}
+type ResourcesConfig struct {
+	Requests map[string]string `yaml:"requests"`
+	Limits   map[string]string `yaml:"limits"`
+}
+
+type ImageConfig struct {
+	// Name of the image e.g. backend, frontend, migration
+	Name string `yaml:"name"`
+	// Dockerfile is the path to the Dockerfile, relative to the app root
+	Dockerfile string `yaml:"dockerfile"`
+	// Context is the build context, relative to the app root. Defaults to the app root.
+	Context string `yaml:"context"`
+	// Target is the build stage to build (optional)
+	Target string `yaml:"target"`
+	// Repo overrides the image repo for this image (optional)
+	Repo string `yaml:"repo"`
+	// BuildArgs are passed as --build-arg. Values are expanded using env variables e.g. ${NPM_TOKEN}.
+	BuildArgs map[string]string `yaml:"build_args"`
+	// Secrets are passed as --secret e.g. id=npmrc,src=${HOME}/.npmrc. Values are expanded using env variables.
+	Secrets []string `yaml:"secrets"`
+	// Platforms overrides the default platforms for this image (optional)
+	Platforms []string `yaml:"platforms"`
+}
+
+// Load reads the CLI config file from the app root. A missing file is not an error, and results in an empty config.
+func Load(appRootPath string) (Config, error) {
+	var cfg Config
+
+	f, err := os.Open(filepath.Join(appRootPath, FileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("opening %s file: %w", FileName, err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	err = decoder.Decode(&cfg)
+	if err != nil {
+		// An empty file is fine
+		if errors.Is(err, io.EOF) {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("Decoding YAML (%s): %w", FileName, err)
+	}
+
+	return cfg, nil
+}
+
+// ForEnv returns the config with the settings of the named environment overlaid. An empty name returns the config as is.
+func (c Config) ForEnv(name string) (Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	env, ok := c.Environments[name]
+	if !ok {
+		var names []string
+		for n := range c.Environments {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return c, fmt.Errorf("Environment [%s] is not defined in %s. Defined environments: [%s]", name, FileName, strings.Join(names, ", "))
+	}
+
+	c.EnvName = name
+
+	if env.ImageRepo != "" {
+		c.Registry.Repository = env.ImageRepo
+	}
+
+	k, ek := c.Deploy.Kubernetes, env.Kubernetes
+	k.Namespace = firstNonEmpty(ek.Namespace, k.Namespace)
+	k.Context = firstNonEmpty(ek.Context, k.Context)
+	k.Kubeconfig = firstNonEmpty(ek.Kubeconfig, k.Kubeconfig)
+	k.WaitTimeout = firstNonEmpty(ek.WaitTimeout, k.WaitTimeout)
+	if len(ek.Manifests) > 0 {
+		k.Manifests = ek.Manifests
+	}
+	if ek.HistoryLimit > 0 {
+		k.HistoryLimit = ek.HistoryLimit
+	}
+	k.Images = append(slices.Clone(k.Images), ek.Images...)
+	k.Overrides = append(slices.Clone(k.Overrides), ek.Overrides...)
+	k.Patches = append(slices.Clone(k.Patches), ek.Patches...)
+	// Sorted, so that the order of the overrides doesn't depend on the map iteration
+	names := make([]string, 0, len(env.Replicas))
+	for n := range env.Replicas {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	for _, n := range names {
+		replicas := env.Replicas[n]
+		k.Overrides = append(k.Overrides, WorkloadOverride{Name: n, Replicas: &replicas})
+	}
+	c.Deploy.Kubernetes = k
+
+	c.Deploy.Target = firstNonEmpty(env.Target, c.Deploy.Target)
+	dc, ec := c.Deploy.Compose, env.Compose
+	if len(ec.Files) > 0 {
+		dc.Files = ec.Files
+	}
+	dc.ProjectName = firstNonEmpty(ec.ProjectName, dc.ProjectName)
+	dc.Host = firstNonEmpty(ec.Host, dc.Host)
+	dc.WaitTimeout = firstNonEmpty(ec.WaitTimeout, dc.WaitTimeout)
+	c.Deploy.Compose = dc
+
+	if env.HealthCheck.URL != "" {
+		c.Deploy.HealthCheck = env.HealthCheck
+	}
+	c.Deploy.RollbackOnFailure = c.Deploy.RollbackOnFailure || env.RollbackOnFailure
+
+	sc, es := c.Secrets, env.Secrets
+	sc.Store = firstNonEmpty(es.Store, sc.Store)
+	sc.Name = firstNonEmpty(es.Name, sc.Name)
+	sc.SealedFile = firstNonEmpty(es.SealedFile, sc.SealedFile)
+	sc.ExampleFile = firstNonEmpty(es.ExampleFile, sc.ExampleFile)
+	if len(es.Recipients) > 0 {
+		sc.Recipients = es.Recipients
+	}
+	sc.NoInject = sc.NoInject || es.NoInject
+	c.Secrets = sc
+
+	return c, nil
+}
+
+// Env returns the selected environment (see ForEnv), and false if none is selected.
+func (c Config) Env() (EnvironmentConfig, bool) {
+	if c.EnvName == "" {
+		return EnvironmentConfig{}, false
+	}
+	return c.Environments[c.EnvName], true
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// GetRegistryConfig returns the registry settings of the project, with any unset fields taken from the user's profile config.
+func (c Config) GetRegistryConfig(ctx context.Context) (registry.Config, error) {
+	profile, err := local.LoadConfig(ctx, "")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return registry.Config{}, errutil.Wrap(err, "Loading profile config")
+		}
+		profile = local.Config{}
+	}
+
+	regCfg := c.Registry.Merge(profile.Permanent.Registry)
+	err = regCfg.Validate(ctx)
+	if err != nil {
+		return registry.Config{}, errutil.Wrap(err, "Validating registry config")
+	}
+
+	return regCfg, nil
+}
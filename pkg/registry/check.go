@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+// ErrPushDenied is returned by CheckPush when the registry does not allow the credentials to push to the repository.
+var ErrPushDenied = ogerr.New(ogerr.CategoryAuth, "push to the repository is not allowed")
+
+// CheckPush verifies that the credentials can push to the image repo, without pushing anything. It does so by starting
+// a blob upload (the first step of a push) using the registry HTTP API, and cancelling it straight away.
+func CheckPush(ctx context.Context, repo string, creds Credentials) error {
+	host, path := ParseRepo(repo)
+
+	baseURL := registryBaseURL(host)
+	httpClient := &http.Client{}
+
+	// Step 1: Find out how the registry wants us to authenticate
+	challenge, err := getAuthChallenge(ctx, httpClient, baseURL)
+	if err != nil {
+		return errutil.Wrap(err, "Getting registry auth challenge")
+	}
+
+	var authHeader string
+	switch strings.ToLower(challenge.scheme) {
+	case "":
+		log.Debug(ctx, "Registry does not require authentication", "host", host)
+	case "basic":
+		if creds.IsEmpty() {
+			return fmt.Errorf("%w: registry [%s] requires credentials, but none were found. Run `og registry login` first", ErrPushDenied, host)
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		authHeader = req.Header.Get("Authorization")
+	case "bearer":
+		token, err := getBearerToken(ctx, httpClient, challenge, path, creds)
+		if err != nil {
+			return err
+		}
+		authHeader = "Bearer " + token
+	default:
+		return fmt.Errorf("Unsupported registry auth scheme [%s]", challenge.scheme)
+	}
+
+	// Step 2: Start a blob upload. A 202 means we're allowed to push.
+	uploadURL := fmt.Sprintf("%s/%s/blobs/uploads/", baseURL, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, nil)
+	if err != nil {
+		return errutil.Wrap(err, "Creating blob upload request")
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errutil.Wrap(err, "Making blob upload request")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		// Cancel the upload (best effort)
+		if loc := resp.Header.Get("Location"); loc != "" {
+			cancelUpload(ctx, httpClient, baseURL, loc, authHeader)
+		}
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if creds.IsEmpty() {
+			return fmt.Errorf("%w: no credentials found for registry [%s]. Run `og registry login` first (status %d: %s)", ErrPushDenied, host, resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		return fmt.Errorf("%w: user [%s] cannot push to [%s] (status %d: %s)", ErrPushDenied, creds.Username, repo, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return fmt.Errorf("Unexpected response from registry when starting a blob upload: %s", resp.Status)
+}
+
+func registryBaseURL(host string) string {
+	scheme := "https"
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		scheme = "http"
+	}
+	// Docker Hub's registry API is not served from docker.io itself
+	if host == DefaultHost {
+		host = "registry-1.docker.io"
+	}
+	return fmt.Sprintf("%s://%s/v2", scheme, host)
+}
+
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+func getAuthChallenge(ctx context.Context, httpClient *http.Client, baseURL string) (authChallenge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/", nil)
+	if err != nil {
+		return authChallenge{}, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return authChallenge{}, errutil.Wrap(err, "Making request to [%s]", baseURL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return authChallenge{}, nil
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return authChallenge{}, fmt.Errorf("Unexpected response from registry [%s]: %s", baseURL, resp.Status)
+	}
+
+	return parseAuthChallenge(resp.Header.Get("WWW-Authenticate")), nil
+}
+
+// parseAuthChallenge parses a WWW-Authenticate header e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseAuthChallenge(header string) authChallenge {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	c := authChallenge{
+		scheme: scheme,
+		params: map[string]string{},
+	}
+	for _, part := range strings.Split(rest, ",") {
+		k, v, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		c.params[strings.ToLower(k)] = strings.Trim(v, `"`)
+	}
+	return c
+}
+
+func getBearerToken(ctx context.Context, httpClient *http.Client, challenge authChallenge, path string, creds Credentials) (string, error) {
+	realm := challenge.params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("Registry auth challenge does not have a realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", errutil.Wrap(err, "Parsing registry auth realm")
+	}
+	q := u.Query()
+	if service := challenge.params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", fmt.Sprintf("repository:%s:pull,push", path))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if !creds.IsEmpty() {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", errutil.Wrap(err, "Requesting registry token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", fmt.Errorf("%w: registry rejected the credentials of user [%s] (%s)", ErrPushDenied, creds.Username, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected response from registry token endpoint: %s", resp.Status)
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return "", errutil.Wrap(err, "Decoding registry token response")
+	}
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	if tokenResp.AccessToken != "" {
+		return tokenResp.AccessToken, nil
+	}
+	return "", fmt.Errorf("Registry token response does not have a token")
+}
+
+func cancelUpload(ctx context.Context, httpClient *http.Client, baseURL string, location string, authHeader string) {
+	// Location can be relative to the registry host
+	u, err := url.Parse(location)
+	if err != nil {
+		log.Debug(ctx, "Could not parse blob upload location", "location", location, "error", err)
+		return
+	}
+	if !u.IsAbs() {
+		base, _ := url.Parse(baseURL)
+		u = base.ResolveReference(u)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Debug(ctx, "Could not cancel blob upload", "location", location, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,267 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/teejays/gokutil/cmdutil"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+// Credentials for a registry. Empty credentials mean anonymous access.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+func (c Credentials) IsEmpty() bool {
+	return c.Username == "" && c.Password == ""
+}
+
+const (
+	_defaultUsernameEnv = "OG_REGISTRY_USERNAME"
+	_defaultPasswordEnv = "OG_REGISTRY_PASSWORD"
+	// Docker stores the Docker Hub credentials under this key
+	_dockerHubConfigKey = "https://index.docker.io/v1/"
+)
+
+// GetCredentials resolves the credentials for the registry host, from the configured credentials source.
+func (c Config) GetCredentials(ctx context.Context, host string) (Credentials, error) {
+	switch c.Credentials.Source {
+	case "", CredentialSourceDocker:
+		return getDockerCredentials(ctx, host)
+
+	case CredentialSourceEnv:
+		userEnv := c.Credentials.UsernameEnv
+		if userEnv == "" {
+			userEnv = _defaultUsernameEnv
+		}
+		passEnv := c.Credentials.PasswordEnv
+		if passEnv == "" {
+			passEnv = _defaultPasswordEnv
+		}
+		creds := Credentials{
+			Username: os.Getenv(userEnv),
+			Password: os.Getenv(passEnv),
+		}
+		if creds.Username == "" && c.Credentials.UsernameEnv == "" {
+			creds.Username = c.Credentials.Username
+		}
+		if creds.Username == "" || creds.Password == "" {
+			return creds, ogerr.New(ogerr.CategoryAuth, "Registry credentials source is [%s] but env variables [%s] and/or [%s] are not set", CredentialSourceEnv, userEnv, passEnv)
+		}
+		return creds, nil
+
+	case CredentialSourceCommand:
+		var stderr bytes.Buffer
+		cmd := interrupt.Command(ctx, "sh", "-c", c.Credentials.PasswordCommand)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return Credentials{}, ogerr.Mark(fmt.Errorf("Running registry password command: %w: %s", err, strings.TrimSpace(stderr.String())), ogerr.CategoryAuth)
+		}
+		return Credentials{
+			Username: c.Credentials.Username,
+			Password: strings.TrimSpace(string(out)),
+		}, nil
+	}
+
+	return Credentials{}, fmt.Errorf("Unknown registry credentials source [%s]", c.Credentials.Source)
+}
+
+func dockerConfigDir() (string, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", errutil.Wrap(err, "Getting home dir to find docker config")
+		}
+		dir = filepath.Join(homeDir, ".docker")
+	}
+	return dir, nil
+}
+
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// getDockerCredentials reads the credentials stored by `docker login` for the host. It returns empty credentials if
+// there are none.
+func getDockerCredentials(ctx context.Context, host string) (Credentials, error) {
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Debug(ctx, "No docker config file found. Using anonymous registry access.", "dir", dir)
+			return Credentials{}, nil
+		}
+		return Credentials{}, errutil.Wrap(err, "Reading docker config file")
+	}
+	var dcfg dockerConfigFile
+	err = json.Unmarshal(b, &dcfg)
+	if err != nil {
+		return Credentials{}, errutil.Wrap(err, "Unmarshalling docker config file")
+	}
+
+	key := host
+	if host == DefaultHost {
+		key = _dockerHubConfigKey
+	}
+
+	// Credential helpers take precedence over the stored auths, like in docker
+	helper := dcfg.CredHelpers[host]
+	if helper == "" {
+		helper = dcfg.CredsStore
+	}
+	if helper != "" {
+		return getDockerHelperCredentials(ctx, helper, key)
+	}
+
+	for _, k := range []string{key, "https://" + key} {
+		auth, ok := dcfg.Auths[k]
+		if !ok || auth.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return Credentials{}, errutil.Wrap(err, "Decoding docker auth for [%s]", k)
+		}
+		user, pass, _ := strings.Cut(string(decoded), ":")
+		return Credentials{Username: user, Password: pass}, nil
+	}
+
+	log.Debug(ctx, "No docker credentials found for registry. Using anonymous registry access.", "host", host)
+	return Credentials{}, nil
+}
+
+func getDockerHelperCredentials(ctx context.Context, helper string, serverURL string) (Credentials, error) {
+	var stderr bytes.Buffer
+	cmd := interrupt.Command(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers exit with an error if there are no credentials for the server
+		log.Debug(ctx, "Docker credential helper did not return credentials", "helper", helper, "server", serverURL, "error", err, "stderr", strings.TrimSpace(stderr.String()))
+		return Credentials{}, nil
+	}
+	var resp struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	err = json.Unmarshal(out, &resp)
+	if err != nil {
+		return Credentials{}, errutil.Wrap(err, "Unmarshalling docker credential helper [%s] output", helper)
+	}
+	return Credentials{Username: resp.Username, Password: resp.Secret}, nil
+}
+
+// Login logs the container tool (docker, podman or buildah) into the registry host, so that it can push to it.
+func Login(ctx context.Context, bin string, host string, creds Credentials) error {
+	cmdParts := []string{"login"}
+	if host != DefaultHost {
+		cmdParts = append(cmdParts, host)
+	}
+
+	// No credentials: let the tool prompt the user
+	if creds.IsEmpty() {
+		cmd := interrupt.Command(ctx, bin, cmdParts...)
+		cmd.Stdin = os.Stdin
+		err := cmdutil.ExecOSCmd(ctx, cmd)
+		if err != nil {
+			return ogerr.Mark(errutil.Wrap(err, "Logging in to registry [%s]", host), ogerr.CategoryAuth)
+		}
+		return nil
+	}
+
+	cmdParts = append(cmdParts, "--username", creds.Username, "--password-stdin")
+	cmd := interrupt.Command(ctx, bin, cmdParts...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Getting stdin pipe: %w", err)
+	}
+	go func() {
+		defer stdin.Close()
+		_, inerr := io.WriteString(stdin, creds.Password)
+		if inerr != nil {
+			log.Error(ctx, "Error writing registry password to [login] stdin", "error", inerr, "command", bin)
+		}
+	}()
+
+	err = cmdutil.ExecOSCmd(ctx, cmd)
+	if err != nil {
+		return ogerr.Mark(errutil.Wrap(err, "Logging in to registry [%s]", host), ogerr.CategoryAuth)
+	}
+
+	return nil
+}
+
+// WriteDockerConfigAuth stores the credentials for the host in the docker config file, for tools that read it directly
+// instead of having a login command (e.g. kaniko).
+func WriteDockerConfigAuth(ctx context.Context, host string, creds Credentials) error {
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "config.json")
+
+	// Keep the rest of the file as is
+	cfg := map[string]interface{}{}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errutil.Wrap(err, "Reading docker config file")
+	}
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &cfg)
+		if err != nil {
+			return errutil.Wrap(err, "Unmarshalling docker config file")
+		}
+	}
+	auths, _ := cfg["auths"].(map[string]interface{})
+	if auths == nil {
+		auths = map[string]interface{}{}
+	}
+	key := host
+	if host == DefaultHost {
+		key = _dockerHubConfigKey
+	}
+	auths[key] = map[string]string{
+		"auth": base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password)),
+	}
+	cfg["auths"] = auths
+
+	b, err = json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return errutil.Wrap(err, "Marshalling docker config file")
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errutil.Wrap(err, "Creating docker config dir")
+	}
+	err = os.WriteFile(path, b, 0600)
+	if err != nil {
+		return errutil.Wrap(err, "Writing docker config file")
+	}
+	log.Debug(ctx, "Stored registry credentials in docker config file", "host", host, "path", path)
+	return nil
+}
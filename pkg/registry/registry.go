@@ -0,0 +1,134 @@
+// Package registry handles the container registry settings of an app: where images are pushed, how to get the credentials
+// for it, and whether those credentials are allowed to push.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultHost is the registry host used when a repository does not specify one (same as docker).
+const DefaultHost = "docker.io"
+
+// Config holds the registry settings. It can be set in the project config (ongoku.cli.yaml) and/or the user's profile
+// config (~/.ongoku/ogconfig.json). Project settings take precedence.
+type Config struct {
+	// Host of the registry e.g. ghcr.io, 123456789.dkr.ecr.us-east-1.amazonaws.com. Defaults to docker.io.
+	Host string `yaml:"host" json:"host,omitempty"`
+	// Repository is the repository naming scheme, without the host. It can contain the placeholders {app}, {identifier}
+	// and {image} e.g. myorg/{app} or myorg/{app}-{image}. If {image} is not used, all images of the app share the
+	// repository and are told apart by their tag.
+	Repository string `yaml:"repository" json:"repository,omitempty"`
+	// Credentials defines where the credentials for the registry come from.
+	Credentials CredentialsConfig `yaml:"credentials" json:"credentials,omitempty"`
+}
+
+type CredentialSource string
+
+const (
+	// CredentialSourceDocker uses the credentials stored by `docker login` (including credential helpers). This is the default.
+	CredentialSourceDocker CredentialSource = "docker"
+	// CredentialSourceEnv reads the username and password from env variables.
+	CredentialSourceEnv CredentialSource = "env"
+	// CredentialSourceCommand runs a command that prints the password e.g. `aws ecr get-login-password`.
+	CredentialSourceCommand CredentialSource = "command"
+)
+
+type CredentialsConfig struct {
+	Source CredentialSource `yaml:"source" json:"source,omitempty"`
+	// Username is used by the env (if UsernameEnv is not set) and command sources.
+	Username string `yaml:"username" json:"username,omitempty"`
+	// UsernameEnv and PasswordEnv are the env variables used by the env source. Default to OG_REGISTRY_USERNAME and OG_REGISTRY_PASSWORD.
+	UsernameEnv string `yaml:"username_env" json:"username_env,omitempty"`
+	PasswordEnv string `yaml:"password_env" json:"password_env,omitempty"`
+	// PasswordCommand is run through `sh -c` by the command source. Its output is used as the password.
+	PasswordCommand string `yaml:"password_command" json:"password_command,omitempty"`
+}
+
+// Merge returns the config c, with the empty fields filled from fallback.
+func (c Config) Merge(fallback Config) Config {
+	if c.Host == "" {
+		c.Host = fallback.Host
+	}
+	if c.Repository == "" {
+		c.Repository = fallback.Repository
+	}
+	if c.Credentials == (CredentialsConfig{}) {
+		c.Credentials = fallback.Credentials
+	}
+	return c
+}
+
+// RepoVars are the values for the placeholders in the repository naming scheme.
+type RepoVars struct {
+	App        string
+	Identifier string
+}
+
+// ImageRepo returns the full image repository (including the host), with the {app} and {identifier} placeholders
+// replaced. The {image} placeholder, if any, is left as is (see ExpandImage). It returns an empty string if no
+// repository is configured.
+func (c Config) ImageRepo(vars RepoVars) string {
+	if c.Repository == "" {
+		return ""
+	}
+	repo := strings.NewReplacer("{app}", vars.App, "{identifier}", vars.Identifier).Replace(c.Repository)
+	if c.Host != "" && c.Host != DefaultHost {
+		repo = c.Host + "/" + repo
+	}
+	return repo
+}
+
+// HasImagePlaceholder returns true if the repo has a separate repository per image.
+func HasImagePlaceholder(repo string) bool {
+	return strings.Contains(repo, "{image}")
+}
+
+// ExpandImage replaces the {image} placeholder in the repo.
+func ExpandImage(repo string, image string) string {
+	return strings.ReplaceAll(repo, "{image}", image)
+}
+
+// ParseRepo splits an image repo into the registry host, and the repository path e.g. ghcr.io/myorg/app => ghcr.io, myorg/app.
+// Like docker, the first component is only treated as the host if it looks like one.
+func ParseRepo(repo string) (string, string) {
+	first, rest, found := strings.Cut(repo, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first, rest
+	}
+	// Docker Hub: single component repos live under library/
+	if !found {
+		return DefaultHost, "library/" + repo
+	}
+	return DefaultHost, repo
+}
+
+// SplitImageRef splits an image reference into its repo, tag and digest e.g. myrepo/app:v1@sha256:... => myrepo/app, v1,
+// sha256:... The tag and the digest are empty if they are not in the reference.
+func SplitImageRef(ref string) (string, string, string) {
+	repo, digest, _ := strings.Cut(ref, "@")
+	var tag string
+	// A colon after the last slash separates the tag (a colon before it is a port)
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	return repo, tag, digest
+}
+
+// Validate ensures that the config is usable.
+func (c Config) Validate(ctx context.Context) error {
+	switch c.Credentials.Source {
+	case "", CredentialSourceDocker, CredentialSourceEnv:
+	case CredentialSourceCommand:
+		if c.Credentials.PasswordCommand == "" {
+			return fmt.Errorf("Registry credentials source is [%s] but no password_command is set", CredentialSourceCommand)
+		}
+		if c.Credentials.Username == "" {
+			return fmt.Errorf("Registry credentials source is [%s] but no username is set", CredentialSourceCommand)
+		}
+	default:
+		return fmt.Errorf("Unknown registry credentials source [%s]. Options: %s, %s, %s", c.Credentials.Source, CredentialSourceDocker, CredentialSourceEnv, CredentialSourceCommand)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teejays/gokutil/errutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+)
+
+// ClusterStore keeps the secrets only in the cluster, in a Kubernetes Secret.
+type ClusterStore struct {
+	Client    *kube.Client
+	Namespace string
+	// SecretName is the name of the Kubernetes Secret
+	SecretName string
+	// Labels are set on the Secret
+	Labels map[string]string
+}
+
+func (s ClusterStore) Name() string {
+	return fmt.Sprintf("secret %s/%s", s.Namespace, s.SecretName)
+}
+
+func (s ClusterStore) Load(ctx context.Context) (map[string]string, error) {
+	secret, err := s.Client.Clientset.CoreV1().Secrets(s.Namespace).Get(ctx, s.SecretName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return map[string]string{}, nil
+		}
+		return nil, errutil.Wrap(err, "Getting secret [%s/%s]", s.Namespace, s.SecretName)
+	}
+	values := map[string]string{}
+	for k, v := range secret.Data {
+		values[k] = string(v)
+	}
+	for k, v := range secret.StringData {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func (s ClusterStore) Save(ctx context.Context, values map[string]string) error {
+	secret := NewSecret(s.SecretName, s.Namespace, s.Labels, values)
+
+	client := s.Client.Clientset.CoreV1().Secrets(s.Namespace)
+	existing, err := client.Get(ctx, s.SecretName, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return errutil.Wrap(err, "Getting secret [%s/%s]", s.Namespace, s.SecretName)
+		}
+		_, err = client.Create(ctx, secret, metav1.CreateOptions{FieldManager: kube.FieldManager})
+		if err != nil {
+			return errutil.Wrap(err, "Creating secret [%s/%s]", s.Namespace, s.SecretName)
+		}
+		return nil
+	}
+
+	// Secrets saved before the labels changed get them too
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+	for k, v := range s.Labels {
+		existing.Labels[k] = v
+	}
+	existing.Data = secret.Data
+	existing.StringData = nil
+	_, err = client.Update(ctx, existing, metav1.UpdateOptions{FieldManager: kube.FieldManager})
+	if err != nil {
+		return errutil.Wrap(err, "Updating secret [%s/%s]", s.Namespace, s.SecretName)
+	}
+	return nil
+}
+
+// NewSecret returns the Kubernetes Secret that holds the values.
+func NewSecret(name string, namespace string, labels map[string]string, values map[string]string) *corev1.Secret {
+	data := map[string][]byte{}
+	for k, v := range values {
+		data[k] = []byte(v)
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/teejays/gokutil/errutil"
+	"gopkg.in/yaml.v3"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/local"
+)
+
+const (
+	// EnvAgeKey holds the age identity (private key) used to decrypt sealed secrets e.g. in CI
+	EnvAgeKey = "OG_SECRETS_AGE_KEY"
+	// EnvAgeKeyFile is the path to a file with the age identity. Defaults to ~/.ongoku/secrets.key.
+	EnvAgeKeyFile = "OG_SECRETS_AGE_KEY_FILE"
+
+	_defaultKeyFileName = "secrets.key"
+	_sealedVersion      = 1
+	_encPrefix          = "ENC[age,"
+	_encSuffix          = "]"
+)
+
+// SealedStore keeps the secrets in a file in the repo, with each value encrypted with age for the recipients.
+type SealedStore struct {
+	Path string
+	// Recipients are the age public keys (age1...) that can decrypt the values
+	Recipients []string
+}
+
+type sealedFile struct {
+	Meta sealedMeta        `yaml:"og_secrets"`
+	Data map[string]string `yaml:"data"`
+}
+
+type sealedMeta struct {
+	Version    int      `yaml:"version"`
+	Recipients []string `yaml:"recipients"`
+}
+
+func (s SealedStore) Name() string {
+	return fmt.Sprintf("sealed file %s", s.Path)
+}
+
+func (s SealedStore) Load(ctx context.Context) (map[string]string, error) {
+	f, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Data) == 0 {
+		return map[string]string{}, nil
+	}
+
+	identities, err := LoadIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for k, enc := range f.Data {
+		v, err := decryptValue(enc, identities)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Decrypting [%s]", k)
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+func (s SealedStore) Save(ctx context.Context, values map[string]string) error {
+	if len(s.Recipients) == 0 {
+		return fmt.Errorf("No recipients to encrypt the secrets for. Set secrets.recipients in the project config (see `og secrets keygen`)")
+	}
+	var recipients []age.Recipient
+	for _, r := range s.Recipients {
+		rec, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return errutil.Wrap(err, "Parsing recipient [%s]", r)
+		}
+		recipients = append(recipients, rec)
+	}
+
+	// Values that did not change keep their ciphertext (if the recipients did not change either), so that the diff of
+	// the file only shows what changed
+	old, err := s.read()
+	if err != nil {
+		return err
+	}
+	var oldValues map[string]string
+	if len(old.Data) > 0 && slices.Equal(old.Meta.Recipients, s.Recipients) {
+		oldValues, err = s.Load(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	f := sealedFile{
+		Meta: sealedMeta{Version: _sealedVersion, Recipients: s.Recipients},
+		Data: map[string]string{},
+	}
+	for k, v := range values {
+		if ov, ok := oldValues[k]; ok && ov == v {
+			f.Data[k] = old.Data[k]
+			continue
+		}
+		enc, err := encryptValue(v, recipients)
+		if err != nil {
+			return errutil.Wrap(err, "Encrypting [%s]", k)
+		}
+		f.Data[k] = enc
+	}
+
+	b, err := yaml.Marshal(f)
+	if err != nil {
+		return errutil.Wrap(err, "Marshalling sealed secrets")
+	}
+	header := "# Sealed secrets managed by `og secrets`. Values are encrypted with age, keys are not. Do not edit by hand.\n"
+	err = os.MkdirAll(filepath.Dir(s.Path), 0755)
+	if err != nil {
+		return errutil.Wrap(err, "Creating directory for sealed secrets")
+	}
+	err = os.WriteFile(s.Path, append([]byte(header), b...), 0644)
+	if err != nil {
+		return errutil.Wrap(err, "Writing sealed secrets [%s]", s.Path)
+	}
+	return nil
+}
+
+// read returns the sealed file as is. A missing file is empty.
+func (s SealedStore) read() (sealedFile, error) {
+	var f sealedFile
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return f, errutil.Wrap(err, "Reading sealed secrets [%s]", s.Path)
+	}
+	err = yaml.Unmarshal(b, &f)
+	if err != nil {
+		return f, errutil.Wrap(err, "Decoding sealed secrets [%s]", s.Path)
+	}
+	if f.Meta.Version > _sealedVersion {
+		return f, fmt.Errorf("Sealed secrets [%s] were written by a newer version of og (format version %d)", s.Path, f.Meta.Version)
+	}
+	return f, nil
+}
+
+func encryptValue(v string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.WriteString(w, v)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	return _encPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()) + _encSuffix, nil
+}
+
+func decryptValue(enc string, identities []age.Identity) (string, error) {
+	if !strings.HasPrefix(enc, _encPrefix) || !strings.HasSuffix(enc, _encSuffix) {
+		return "", fmt.Errorf("Value is not in the %s...%s format", _encPrefix, _encSuffix)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(enc, _encPrefix), _encSuffix))
+	if err != nil {
+		return "", errutil.Wrap(err, "Decoding base64")
+	}
+	r, err := age.Decrypt(bytes.NewReader(b), identities...)
+	if err != nil {
+		return "", err
+	}
+	v, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// LoadIdentities returns the age identities to decrypt sealed secrets with, from OG_SECRETS_AGE_KEY, or the key file.
+func LoadIdentities(ctx context.Context) ([]age.Identity, error) {
+	if key := os.Getenv(EnvAgeKey); key != "" {
+		ids, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, errutil.Wrap(err, "Parsing age identity from %s", EnvAgeKey)
+		}
+		return ids, nil
+	}
+
+	path, err := KeyFilePath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("No age identity to decrypt the secrets with. Set %s, or create %s with `og secrets keygen`", EnvAgeKey, path)
+		}
+		return nil, errutil.Wrap(err, "Opening age identity file")
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Parsing age identity file [%s]", path)
+	}
+	return ids, nil
+}
+
+// KeyFilePath is the path of the age identity file.
+func KeyFilePath(ctx context.Context) (string, error) {
+	if p := os.Getenv(EnvAgeKeyFile); p != "" {
+		return p, nil
+	}
+	dir, err := local.GetDefaultConfigDir(ctx)
+	if err != nil {
+		return "", errutil.Wrap(err, "Getting default config dir")
+	}
+	return filepath.Join(dir, _defaultKeyFileName), nil
+}
+
+// GenerateKey creates a new age identity in the key file, and returns its recipient (public key). An existing key file
+// is not overwritten.
+func GenerateKey(ctx context.Context) (string, string, error) {
+	path, err := KeyFilePath(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = os.Stat(path)
+	if err == nil {
+		return "", path, fmt.Errorf("Key file [%s] already exists", path)
+	}
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", path, errutil.Wrap(err, "Generating age identity")
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", path, errutil.Wrap(err, "Creating directory for the key file")
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n", id.Recipient(), id)
+	err = os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		return "", path, errutil.Wrap(err, "Writing key file [%s]", path)
+	}
+	return id.Recipient().String(), path, nil
+}
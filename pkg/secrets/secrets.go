@@ -0,0 +1,122 @@
+// Package secrets manages the runtime configuration (env variables) of a deployed app. The values live either only in
+// the cluster (as a Kubernetes Secret), or sealed in the repo: encrypted with age, one value at a time so that the keys
+// stay readable and diffable (like sops), and materialised as a Kubernetes Secret when deploying.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/teejays/gokutil/errutil"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+)
+
+const (
+	StoreCluster = "cluster"
+	StoreSealed  = "sealed"
+)
+
+// Store holds the secrets of one environment.
+type Store interface {
+	// Name describes the store e.g. for logs
+	Name() string
+	// Load returns the secrets. A store that does not exist yet has no secrets.
+	Load(ctx context.Context) (map[string]string, error)
+	// Save replaces the secrets.
+	Save(ctx context.Context, values map[string]string) error
+}
+
+var _keyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateKey ensures that the key can be used as an env variable name.
+func ValidateKey(key string) error {
+	if !_keyRegexp.MatchString(key) {
+		return ogerr.New(ogerr.CategoryUsage, "Invalid key [%s]. Keys must be valid env variable names (letters, digits and underscores, not starting with a digit)", key)
+	}
+	return nil
+}
+
+// ReadEnvFile reads the KEY=VALUE pairs of a .env file.
+func ReadEnvFile(path string) (map[string]string, error) {
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Reading env file [%s]", path)
+	}
+	return values, nil
+}
+
+// ReadExampleKeys returns the keys of the example env file (e.g. .env.example), which lists the variables that the app
+// needs. It returns os.ErrNotExist if there is no such file.
+func ReadExampleKeys(path string) ([]string, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := ReadEnvFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return SortedKeys(values), nil
+}
+
+// CompareKeys returns the keys that are in the example but not in the values (missing), and the other way round (extra).
+func CompareKeys(example []string, values map[string]string) ([]string, []string) {
+	var missing, extra []string
+	for _, k := range example {
+		if _, ok := values[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	for _, k := range SortedKeys(values) {
+		if !slices.Contains(example, k) {
+			extra = append(extra, k)
+		}
+	}
+	return missing, extra
+}
+
+// MissingKeysError is returned when secrets that the app needs are not set.
+type MissingKeysError struct {
+	Keys []string
+}
+
+func (e MissingKeysError) Error() string {
+	return fmt.Sprintf("Secrets [%s] are in the example env file but are not set. Set them with `og secrets set`.", strings.Join(e.Keys, ", "))
+}
+
+// CheckExample returns a MissingKeysError if any of the keys in the example env file are not in the store. It's a no-op
+// if the example file does not exist.
+func CheckExample(ctx context.Context, store Store, examplePath string) error {
+	example, err := ReadExampleKeys(examplePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	values, err := store.Load(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Loading secrets from [%s]", store.Name())
+	}
+	missing, _ := CompareKeys(example, values)
+	if len(missing) > 0 {
+		return MissingKeysError{Keys: missing}
+	}
+	return nil
+}
+
+func SortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
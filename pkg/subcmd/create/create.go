@@ -3,7 +3,6 @@ package create
 import (
 	"context"
 	"fmt"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -15,6 +14,8 @@ import (
 	"github.com/teejays/gokutil/ogconfig"
 
 	"github.com/build-ongoku/ongoku-cli/pkg/client/coreengine"
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
 )
 
 var llog = log.GetLogger().WithHeading("Goku Creator")
@@ -43,7 +44,7 @@ func (a *Args) Validate(ctx context.Context) error {
 
 	// Validate the app name
 	if a.AppName == "" {
-		return fmt.Errorf("Please provide an app name")
+		return ogerr.New(ogerr.CategoryUsage, "Please provide an app name")
 	}
 	// Regex to ensure no special characters other than or -
 	rgx, err := regexp.Compile(`^[a-zA-Z0-9-]*$`)
@@ -83,7 +84,7 @@ func Run(ctx context.Context, args *Args) error {
 
 	err = args.Validate(ctx)
 	if err != nil {
-		return errutil.Wrap(err, "Validating args")
+		return ogerr.Mark(errutil.Wrap(err, "Validating args"), ogerr.CategoryUsage)
 	}
 
 	// Get the default license
@@ -117,13 +118,19 @@ func Run(ctx context.Context, args *Args) error {
 	}
 	cmdParts = append(cmdParts, "--log-level", log.GetLogLevel().String())
 
-	cmd := exec.CommandContext(ctx, "goku", cmdParts...)
+	cmd := interrupt.Command(ctx, "goku", cmdParts...)
 
 	err = cl.ExecuteCoreEngineCommand(ctx,
 		cmd,
 		cmdutil.ExecOptions{},
 		true,
 	)
+	if err != nil && interrupt.Interrupted() {
+		if args.NoRollback {
+			return errutil.Wrap(err, "Interrupted while creating app [%s]. It may be partly created: remove [%s] and run og create again", args.AppName, args.appRootPath)
+		}
+		return errutil.Wrap(err, "Interrupted while creating app [%s]. The core engine rolls back what it created, unless it was killed: if [%s] is left behind, remove it and run og create again", args.AppName, args.appRootPath)
+	}
 	if err != nil {
 		return errutil.Wrap(err, "Running core engine command")
 	}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/backup"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+type Args struct {
+	Migrate  *struct{}     `arg:"subcommand:migrate" help:"Apply the pending migrations to the database of the deployment"`
+	Status   *struct{}     `arg:"subcommand:status" help:"List the applied and pending migrations"`
+	Rollback *RollbackArgs `arg:"subcommand:rollback" help:"Roll back the last migration(s)"`
+	Unlock   *struct{}     `arg:"subcommand:unlock" help:"Release the migration lock, if a migration run crashed while holding it"`
+	Backup   *struct{}     `arg:"subcommand:backup" help:"Back up the database of the deployment (a compressed, timestamped dump) to the backup storage"`
+	Restore  *RestoreArgs  `arg:"subcommand:restore" help:"Replace the database of the deployment with a backup"`
+	Backups  *BackupsArgs  `arg:"subcommand:backups" help:"Manage the backups in the backup storage"`
+
+	// Flags
+	Local            bool   `arg:"--local" help:"Run the local migration commands (database.migrate.local) on this machine, against a tunnel to the database"`
+	Env              string `arg:"-e,--env,env:GOKU_DEPLOY_ENV" help:"The environment of the database, as defined under environments in ongoku.cli.yaml e.g. staging, prod"`
+	DeployIdentifier string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The deploy identifier of the deployment the database belongs to"`
+	Approve          bool   `arg:"--approve,env:GOKU_DEPLOY_APPROVE" help:"Approve changes to environments that require approval, without being asked"`
+	deploy.KubeFlags
+}
+
+type RollbackArgs struct {
+	Steps int `arg:"--steps" default:"1" help:"Number of migrations to roll back"`
+}
+
+type RestoreArgs struct {
+	Backup              string `arg:"positional,required" help:"The backup to restore, as listed by og db backups list, or latest for the latest backup of the deployment"`
+	Yes                 bool   `arg:"-y,--yes" help:"Do not ask for confirmation"`
+	FromOtherDeployment bool   `arg:"--from-other-deployment" help:"Allow restoring a backup taken of another deployment e.g. prod into staging"`
+	SkipBackup          bool   `arg:"--skip-backup" help:"Do not back up the current database before restoring"`
+}
+
+type BackupsArgs struct {
+	List *ListBackupsArgs `arg:"subcommand:list" help:"List the backups of the deployment"`
+}
+
+type ListBackupsArgs struct {
+	All bool `arg:"--all" help:"List the backups of all the deployments"`
+}
+
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	if args.Migrate == nil && args.Status == nil && args.Rollback == nil && args.Unlock == nil && args.Backup == nil && args.Restore == nil && args.Backups == nil {
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
+	}
+
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+	commonFlags := deploy.CommonFlags{DeployIdentifier: args.DeployIdentifier, Env: args.Env, Approve: args.Approve}
+	pcfg, err = deploy.ResolveEnv(ctx, cfg, pcfg, &commonFlags)
+	if err != nil {
+		return err
+	}
+	opts := deploy.MigrateOptions{Local: args.Local, Settings: deploy.TargetSettings{KubeFlags: args.KubeFlags}}
+
+	switch {
+	case args.Migrate != nil:
+		err = deploy.ApproveEnvChange(ctx, cfg, pcfg, commonFlags, "migrate the database")
+		if err != nil {
+			return err
+		}
+		opts.Action = deploy.MigrateUp
+		err = deploy.RunMigrations(ctx, cfg, pcfg, commonFlags, opts)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [migrate]")
+		}
+		log.Info(ctx, "Migrations applied", "deployIdentifier", commonFlags.DeployIdentifier, "env", pcfg.EnvName)
+	case args.Status != nil:
+		// The status is the output of the migration tool, kept for the result
+		var buf bytes.Buffer
+		opts.Action, opts.Stdout = deploy.MigrateStatus, &buf
+		err = deploy.RunMigrations(ctx, cfg, pcfg, commonFlags, opts)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [status]")
+		}
+		status := MigrationStatus{DeployIdentifier: commonFlags.DeployIdentifier, Output: buf.String()}
+		return output.Print(os.Stdout, status, func(w io.Writer) { io.WriteString(w, status.Output) })
+	case args.Rollback != nil:
+		if args.Rollback.Steps < 1 {
+			return ogerr.New(ogerr.CategoryUsage, "Invalid --steps [%d]. It must be at least 1", args.Rollback.Steps)
+		}
+		err = deploy.ApproveEnvChange(ctx, cfg, pcfg, commonFlags, fmt.Sprintf("roll back %d migration(s)", args.Rollback.Steps))
+		if err != nil {
+			return err
+		}
+		opts.Action = deploy.MigrateDown
+		for i := 1; i <= args.Rollback.Steps; i++ {
+			log.Info(ctx, "Rolling back migration", "step", i, "of", args.Rollback.Steps)
+			err = deploy.RunMigrations(ctx, cfg, pcfg, commonFlags, opts)
+			if err != nil {
+				return errutil.Wrap(err, "Running subcommand [rollback]")
+			}
+		}
+	case args.Unlock != nil:
+		t, err := deploy.GetTarget(ctx, cfg, pcfg, commonFlags, opts.Settings)
+		if err != nil {
+			return err
+		}
+		err = t.Unlock(ctx, deploy.MigrateLock)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [unlock]")
+		}
+		log.Info(ctx, "Released the migration lock", "deployIdentifier", commonFlags.DeployIdentifier)
+	case args.Backup != nil:
+		b, err := deploy.RunBackup(ctx, cfg, pcfg, commonFlags, opts.Settings)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [backup]")
+		}
+		return output.Print(os.Stdout, b, func(w io.Writer) { printBackups(w, BackupList{Backups: []backup.Backup{b}}) })
+	case args.Restore != nil:
+		err = deploy.ApproveEnvChange(ctx, cfg, pcfg, commonFlags, "restore the database")
+		if err != nil {
+			return err
+		}
+		result, err := deploy.RunRestore(ctx, cfg, pcfg, commonFlags, deploy.RestoreOptions{
+			Key:                 args.Restore.Backup,
+			Yes:                 args.Restore.Yes,
+			FromOtherDeployment: args.Restore.FromOtherDeployment,
+			SkipBackup:          args.Restore.SkipBackup,
+			Settings:            opts.Settings,
+		})
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [restore]")
+		}
+		return output.Print(os.Stdout, result, nil)
+	case args.Backups != nil:
+		if args.Backups.List == nil {
+			return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
+		}
+		backups, err := deploy.ListBackups(ctx, cfg, pcfg, commonFlags, args.Backups.List.All)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [backups list]")
+		}
+		list := BackupList{Backups: output.NonNil(backups)}
+		return output.Print(os.Stdout, list, func(w io.Writer) { printBackups(w, list) })
+	}
+
+	return nil
+}
+
+// MigrationStatus is the result of db status.
+type MigrationStatus struct {
+	DeployIdentifier string `json:"deploy_identifier"`
+	// Output is what the status command of the migration tool printed
+	Output string `json:"output"`
+}
+
+// BackupList is the result of db backups list, oldest first. The result of db backup is the backup.Backup taken.
+type BackupList struct {
+	Backups []backup.Backup `json:"backups"`
+}
+
+// Identifiers returns the keys of the backups, to restore them with.
+func (l BackupList) Identifiers() []string {
+	var ret []string
+	for _, b := range l.Backups {
+		ret = append(ret, b.Key)
+	}
+	return ret
+}
+
+func printBackups(w io.Writer, list BackupList) {
+	if len(list.Backups) == 0 {
+		fmt.Fprintln(w, "No backups")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "BACKUP\tDEPLOYMENT\tTAKEN AT\tSIZE")
+	for _, b := range list.Backups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", b.Key, b.DeployIdentifier, b.Time.Local().Format(time.DateTime), backup.FormatSize(b.Size))
+	}
+}
@@ -0,0 +1,278 @@
+package deploy
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/backup"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// LatestBackup selects the latest backup of the deployment, in place of a backup key.
+const LatestBackup = "latest"
+
+const _defaultBackupWorkload = "database"
+
+var (
+	// Plain SQL (compressed by og) that recreates the objects it contains, so that it can be restored over an existing
+	// database with psql
+	_defaultDumpCommand    = []string{"sh", "-c", `pg_dump --clean --if-exists --no-owner --no-privileges -U "$POSTGRES_USER" "$POSTGRES_DB"`}
+	_defaultRestoreCommand = []string{"sh", "-c", `psql --quiet -v ON_ERROR_STOP=1 -U "$POSTGRES_USER" -d "$POSTGRES_DB"`}
+)
+
+// RestoreOptions select the backup to restore and the safety checks to skip.
+type RestoreOptions struct {
+	// Key of the backup, or LatestBackup
+	Key string
+	// Yes skips the confirmation
+	Yes bool
+	// FromOtherDeployment allows restoring a backup taken of another deployment e.g. prod into staging
+	FromOtherDeployment bool
+	// SkipBackup does not back up the current database before restoring
+	SkipBackup bool
+	Settings   TargetSettings
+}
+
+// RestoreResult is the result of db restore.
+type RestoreResult struct {
+	DeployIdentifier string `json:"deploy_identifier"`
+	// Restored is the backup that was restored
+	Restored backup.Backup `json:"restored"`
+	// Previous is the backup of the database taken before the restore, to undo it. Not set with --skip-backup.
+	Previous *backup.Backup `json:"previous,omitempty"`
+}
+
+// OpenBackupStore opens the store of the backups configured in the project config.
+func OpenBackupStore(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config) (backup.Store, error) {
+	return backup.Open(ctx, pcfg.Database.Backup.Storage, cfg.AppRootPath.Full, cfg.AppName.ToKebab())
+}
+
+// RunBackup dumps the database of the deployment into a compressed, timestamped backup in the backup store.
+func RunBackup(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, settings TargetSettings) (backup.Backup, error) {
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, settings)
+	if err != nil {
+		return backup.Backup{}, err
+	}
+	return backupDatabase(ctx, cfg, pcfg, commonFlags, t)
+}
+
+func backupDatabase(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, t DeployTarget) (backup.Backup, error) {
+	bcfg := pcfg.Database.Backup
+	store, err := OpenBackupStore(ctx, cfg, pcfg)
+	if err != nil {
+		return backup.Backup{}, err
+	}
+
+	b := backup.Backup{DeployIdentifier: commonFlags.DeployIdentifier, Time: time.Now().UTC().Truncate(time.Millisecond)}
+	b.Key = backup.NewKey(b.DeployIdentifier, b.Time)
+	workload := firstNonEmpty(bcfg.Workload, _defaultBackupWorkload)
+	command := bcfg.Dump
+	if len(command) == 0 {
+		command = _defaultDumpCommand
+	}
+	log.Info(ctx, "Backing up the database", "target", t.Name(), "workload", workload, "store", store.Name(), "backup", b.Key)
+
+	// The dump is compressed as it's streamed to the store, and the store discards it if the dump fails
+	pr, pw := io.Pipe()
+	dumpErr := make(chan error, 1)
+	go func() {
+		gz := gzip.NewWriter(pw)
+		err := t.Exec(ctx, ExecOptions{
+			Workload:  workload,
+			Container: bcfg.Container,
+			Command:   command,
+			Stdout:    gz,
+			Stderr:    os.Stderr,
+		})
+		if err != nil {
+			err = errutil.Wrap(err, "Running the dump command %q", command)
+		} else {
+			err = gz.Close()
+		}
+		pw.CloseWithError(err)
+		dumpErr <- err
+	}()
+
+	b.Size, err = store.Put(ctx, b.Key, pr)
+	// Unblocks the dump if the store gave up early
+	pr.CloseWithError(errors.New("Backup store stopped reading"))
+	dErr := <-dumpErr
+	// A failed dump fails the store too, with the error of the dump
+	if err != nil {
+		return backup.Backup{}, err
+	}
+	if dErr != nil {
+		return backup.Backup{}, dErr
+	}
+
+	log.Info(ctx, "Backed up the database", "backup", b.Key, "size", backup.FormatSize(b.Size))
+	return b, nil
+}
+
+// ListBackups returns the backups of the deployment, or of all the deployments, oldest first.
+func ListBackups(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, all bool) ([]backup.Backup, error) {
+	store, err := OpenBackupStore(ctx, cfg, pcfg)
+	if err != nil {
+		return nil, err
+	}
+	deployIdentifier := commonFlags.DeployIdentifier
+	if all {
+		deployIdentifier = ""
+	}
+	return store.List(ctx, deployIdentifier)
+}
+
+// RunRestore loads a backup into the database of the deployment, replacing its data. Before anything is changed, it
+// makes sure that the backup was taken of this deployment (unless told otherwise), asks for confirmation, and backs up
+// the current database. The restore holds the migration lock, so that migrations do not run at the same time. The
+// returned result has the backup restored, and the backup of the current database to undo it with.
+func RunRestore(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, opts RestoreOptions) (RestoreResult, error) {
+	bcfg := pcfg.Database.Backup
+	result := RestoreResult{DeployIdentifier: commonFlags.DeployIdentifier}
+	store, err := OpenBackupStore(ctx, cfg, pcfg)
+	if err != nil {
+		return result, err
+	}
+
+	b, err := findBackup(ctx, store, commonFlags.DeployIdentifier, opts.Key)
+	if err != nil {
+		return result, err
+	}
+	result.Restored = b
+	if b.DeployIdentifier != commonFlags.DeployIdentifier {
+		if !opts.FromOtherDeployment {
+			return result, fmt.Errorf("Backup [%s] was taken of deployment [%s], not [%s]. Use --from-other-deployment to restore it anyway", b.Key, b.DeployIdentifier, commonFlags.DeployIdentifier)
+		}
+		log.Warn(ctx, "Restoring a backup of another deployment (--from-other-deployment)", "backup", b.Key, "from", b.DeployIdentifier, "to", commonFlags.DeployIdentifier)
+	}
+
+	// Opened before anything is changed, so that a missing backup fails early
+	r, err := store.Get(ctx, b.Key)
+	if err != nil {
+		return result, err
+	}
+	defer r.Close()
+
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, opts.Settings)
+	if err != nil {
+		return result, err
+	}
+
+	fmt.Fprintf(os.Stderr, "The database of deployment [%s] (%s) will be replaced with backup [%s] taken at %s.\n", commonFlags.DeployIdentifier, t.Name(), b.Key, b.Time.Format(time.RFC3339))
+	if !opts.Yes {
+		prompt := fmt.Sprintf("Type the deploy identifier [%s] to confirm: ", commonFlags.DeployIdentifier)
+		err = confirmByTyping(prompt, commonFlags.DeployIdentifier, "--yes")
+		if err != nil {
+			return result, err
+		}
+	}
+
+	lockCtx, release, err := t.Lock(ctx, MigrateLock)
+	var held LockHeldError
+	if errors.As(err, &held) {
+		return result, errutil.Wrap(err, "A migration is running. Wait for it to finish, or if it crashed, release the lock with `og db unlock`")
+	}
+	if err != nil {
+		return result, errutil.Wrap(err, "Taking the migration lock")
+	}
+	defer func() {
+		err := release(context.Background())
+		if err != nil {
+			log.Warn(ctx, "Could not release the migration lock. Release it with `og db unlock`.", "error", err)
+		}
+	}()
+	// The restore stops if the lock is lost, since a migration could start then
+	ctx = lockCtx
+
+	if opts.SkipBackup {
+		log.Warn(ctx, "Not backing up the current database before restoring (--skip-backup)")
+	} else {
+		current, err := backupDatabase(ctx, cfg, pcfg, commonFlags, t)
+		if err != nil {
+			return result, errutil.Wrap(err, "Backing up the current database before restoring. Use --skip-backup to restore without a backup")
+		}
+		log.Info(ctx, "To undo the restore, restore the backup of the current database", "backup", current.Key)
+		result.Previous = &current
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return result, errutil.Wrap(err, "Reading backup [%s]", b.Key)
+	}
+	defer gz.Close()
+
+	workload := firstNonEmpty(bcfg.Workload, _defaultBackupWorkload)
+	command := bcfg.Restore
+	if len(command) == 0 {
+		command = _defaultRestoreCommand
+	}
+	log.Info(ctx, "Restoring the database", "target", t.Name(), "workload", workload, "backup", b.Key)
+	err = t.Exec(ctx, ExecOptions{
+		Workload:  workload,
+		Container: bcfg.Container,
+		Command:   command,
+		Stdin:     gz,
+		Stdout:    os.Stderr,
+		Stderr:    os.Stderr,
+	})
+	if err != nil && errors.Is(context.Cause(ctx), kube.ErrLockLost) {
+		// Not a cancelled error, since og was not asked to stop
+		return result, ogerr.New(ogerr.CategoryDeploy, "Stopped the restore, since the migration lock was lost (%s). The database may be partly restored: %s", context.Cause(ctx), err)
+	}
+	if err != nil {
+		return result, errutil.Wrap(err, "Running the restore command %q", command)
+	}
+
+	log.Info(ctx, "Restored the database", "deployIdentifier", commonFlags.DeployIdentifier, "backup", b.Key)
+	return result, nil
+}
+
+// findBackup returns the backup with the key, or the latest backup of the deployment.
+func findBackup(ctx context.Context, store backup.Store, deployIdentifier string, key string) (backup.Backup, error) {
+	if key == LatestBackup {
+		backups, err := store.List(ctx, deployIdentifier)
+		if err != nil {
+			return backup.Backup{}, err
+		}
+		if len(backups) == 0 {
+			return backup.Backup{}, fmt.Errorf("No backups of deployment [%s] in [%s]", deployIdentifier, store.Name())
+		}
+		return backups[len(backups)-1], nil
+	}
+
+	b, ok := backup.ParseKey(key)
+	if !ok {
+		return backup.Backup{}, ogerr.New(ogerr.CategoryUsage, "Invalid backup [%s]. Use a backup as listed by `og db backups list`, or %s", key, LatestBackup)
+	}
+	return b, nil
+}
+
+// backupBeforeDestroy backs up the database before the deployment is destroyed, if the project config asks for it.
+func backupBeforeDestroy(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, t DeployTarget, args *DestroyArgs) error {
+	switch {
+	case !pcfg.Database.Backup.BeforeDestroy, args.DryRun:
+		return nil
+	case args.KeepData:
+		log.Info(ctx, "Not backing up the database, since its data is kept (--keep-data)")
+		return nil
+	case args.SkipBackup:
+		log.Warn(ctx, "Not backing up the database before destroying (--skip-backup)")
+		return nil
+	}
+	_, err := backupDatabase(ctx, cfg, pcfg, commonFlags, t)
+	if err != nil {
+		return errutil.Wrap(err, "Backing up the database before destroying. Nothing was deleted. Use --skip-backup to destroy without a backup")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/teejays/gokutil/cmdutil"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+// Builder builds (and pushes) container images. Implementations wrap a build tool e.g. docker buildx, podman.
+type Builder interface {
+	// Name is the value used to select the builder using --builder
+	Name() string
+	// Available returns an error if the builder cannot be used on this machine
+	Available(ctx context.Context) error
+	// Build builds the image (and pushes it, unless b.NoPush), and returns the digest of the image. The digest may be
+	// empty if the image was not pushed.
+	Build(ctx context.Context, b ImageBuild) (string, error)
+	// Login stores the credentials for the registry host, so that the builder can push to it
+	Login(ctx context.Context, host string, creds registry.Credentials) error
+}
+
+// BuilderAuto selects the first available builder, in the order of _builders.
+const BuilderAuto = "auto"
+
+// _builders are the supported builders, in the order of preference for auto-detection.
+var _builders = []Builder{
+	dockerBuildxBuilder{},
+	podmanBuilder{bin: "podman"},
+	podmanBuilder{bin: "buildah"},
+	kanikoBuilder{},
+}
+
+// BuilderNames returns the names of the supported builders.
+func BuilderNames() []string {
+	var names []string
+	for _, b := range _builders {
+		names = append(names, b.Name())
+	}
+	return names
+}
+
+// GetBuilder returns the builder with the given name. If name is empty or auto, the first available builder is returned.
+func GetBuilder(ctx context.Context, name string) (Builder, error) {
+	if name == "" || name == BuilderAuto {
+		var errs []string
+		for _, b := range _builders {
+			err := b.Available(ctx)
+			if err != nil {
+				log.Debug(ctx, "Builder is not available", "builder", b.Name(), "error", err)
+				errs = append(errs, fmt.Sprintf("%s: %s", b.Name(), err))
+				continue
+			}
+			log.Info(ctx, "Auto-detected image builder", "builder", b.Name())
+			return b, nil
+		}
+		return nil, fmt.Errorf("No image builder is available on this machine. Install one of: %s\n%s", strings.Join(BuilderNames(), ", "), strings.Join(errs, "\n"))
+	}
+
+	for _, b := range _builders {
+		if b.Name() != name {
+			continue
+		}
+		err := b.Available(ctx)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Builder [%s] is not available", name)
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("Unknown builder [%s]. Options: %s, %s", name, BuilderAuto, strings.Join(BuilderNames(), ", "))
+}
+
+/* * * * * * * *
+ * Docker Buildx
+ * * * * * * * */
+
+type dockerBuildxBuilder struct{}
+
+func (dockerBuildxBuilder) Name() string {
+	return "docker"
+}
+
+func (dockerBuildxBuilder) Available(ctx context.Context) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return err
+	}
+	if err := interrupt.Command(ctx, "docker", "buildx", "version").Run(); err != nil {
+		return fmt.Errorf("docker buildx is not installed: %w", err)
+	}
+	if err := interrupt.Command(ctx, "docker", "info").Run(); err != nil {
+		return fmt.Errorf("docker daemon is not reachable: %w", err)
+	}
+	return nil
+}
+
+func (dockerBuildxBuilder) Login(ctx context.Context, host string, creds registry.Credentials) error {
+	return registry.Login(ctx, "docker", host, creds)
+}
+
+// Build builds the image using docker buildx. More than one platform results in a multi-platform image (manifest list),
+// in which case the digest is that of the manifest list.
+func (dockerBuildxBuilder) Build(ctx context.Context, b ImageBuild) (string, error) {
+
+	// Buildx writes the digest of the result to the metadata file
+	tmpDir, err := os.MkdirTemp("", "og-build-")
+	if err != nil {
+		return "", errutil.Wrap(err, "Creating temp dir for build metadata")
+	}
+	defer os.RemoveAll(tmpDir)
+	metadataPath := filepath.Join(tmpDir, "metadata.json")
+
+	cmdParts := []string{
+		"docker", "buildx", "build",
+		"--platform", strings.Join(b.Platforms, ","),
+		"-t", b.Ref(),
+		"-f", b.DockerfilePath,
+	}
+	cmdParts = append(cmdParts, commonBuildFlags(b)...)
+	for _, c := range b.CacheFrom {
+		cmdParts = append(cmdParts, "--cache-from", c)
+	}
+	for _, c := range b.CacheTo {
+		cmdParts = append(cmdParts, "--cache-to", c)
+	}
+	cmdParts = append(cmdParts, "--metadata-file", metadataPath)
+	cmdParts = append(cmdParts, "--pull")
+	if !b.NoPush {
+		cmdParts = append(cmdParts, "--push")
+	} else if len(b.Platforms) > 1 {
+		log.Warn(ctx, "Multi-platform images cannot be loaded into the local docker image store. The result will only be available in the build cache.", "image", b.Name)
+	}
+	cmdParts = append(cmdParts, b.ContextPath)
+
+	err = execBuildCmd(ctx, b.ContextPath, cmdParts)
+	if err != nil {
+		return "", err
+	}
+
+	if b.NoPush {
+		log.Debug(ctx, "Not pushing image, so no digest is recorded", "image", b.Name)
+		return "", nil
+	}
+
+	digest, err := readBuildMetadataDigest(metadataPath)
+	if err != nil {
+		return "", errutil.Wrap(err, "Reading image digest from build metadata")
+	}
+
+	return digest, nil
+}
+
+// readBuildMetadataDigest reads the image digest from the metadata file written by `docker buildx build --metadata-file`.
+func readBuildMetadataDigest(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var metadata struct {
+		Digest string `json:"containerimage.digest"`
+	}
+	err = json.Unmarshal(b, &metadata)
+	if err != nil {
+		return "", errutil.Wrap(err, "Unmarshalling build metadata")
+	}
+	if metadata.Digest == "" {
+		return "", fmt.Errorf("Build metadata does not contain an image digest")
+	}
+	return metadata.Digest, nil
+}
+
+/* * * * * * * *
+ * Helpers
+ * * * * * * * */
+
+// commonBuildFlags returns the flags that are the same across docker, podman and buildah.
+func commonBuildFlags(b ImageBuild) []string {
+	var flags []string
+	if b.Target != "" {
+		flags = append(flags, "--target", b.Target)
+	}
+	// Sort the build args so the command is deterministic
+	var buildArgKeys []string
+	for k := range b.BuildArgs {
+		buildArgKeys = append(buildArgKeys, k)
+	}
+	slices.Sort(buildArgKeys)
+	for _, k := range buildArgKeys {
+		flags = append(flags, "--build-arg", fmt.Sprintf("%s=%s", k, b.BuildArgs[k]))
+	}
+	for _, s := range b.Secrets {
+		flags = append(flags, "--secret", s)
+	}
+	return flags
+}
+
+// cacheRepo extracts the repo from a buildx style cache spec e.g. type=registry,ref=myrepo/app:buildcache => myrepo/app.
+// Builders other than buildx only support registry caches, identified by a repo.
+func cacheRepo(spec string) string {
+	if !strings.Contains(spec, "=") {
+		return spec
+	}
+	for _, part := range strings.Split(spec, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		if k == "ref" {
+			repo, _, _ := strings.Cut(v, "@")
+			// Strip the tag, but not a port
+			if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+				repo = repo[:i]
+			}
+			return repo
+		}
+	}
+	return ""
+}
+
+func execBuildCmd(ctx context.Context, dir string, cmdParts []string) error {
+	cmd := interrupt.Command(ctx, cmdParts[0], cmdParts[1:]...)
+	err := cmdutil.ExecOSCmdWithOpts(ctx, cmd, cmdutil.ExecOptions{
+		Dir:           dir,
+		IsLoudCommand: true,
+	})
+	if err != nil {
+		return errutil.Wrap(err, "Executing command: %v", cmd)
+	}
+	return nil
+}
+
+// readDigestFile reads a digest file written by podman, buildah or kaniko.
+func readDigestFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", errutil.Wrap(err, "Reading digest file")
+	}
+	digest := strings.TrimSpace(string(b))
+	if digest == "" {
+		return "", fmt.Errorf("Digest file is empty")
+	}
+	return digest, nil
+}
@@ -0,0 +1,113 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+
+	"github.com/teejays/gokutil/errutil"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+// _kanikoExecutorPaths are where the kaniko executor is looked for. The kaniko images ship it at /kaniko/executor.
+var _kanikoExecutorPaths = []string{"/kaniko/executor", "executor"}
+
+// kanikoBuilder builds images using the kaniko executor, which runs without a daemon or privileges (e.g. in a CI pod).
+// Kaniko builds for one platform at a time, and does not support build secrets.
+type kanikoBuilder struct{}
+
+func (kanikoBuilder) Name() string {
+	return "kaniko"
+}
+
+func (kanikoBuilder) executor() (string, error) {
+	for _, p := range _kanikoExecutorPaths {
+		if path, err := exec.LookPath(p); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("kaniko executor not found in %v", _kanikoExecutorPaths)
+}
+
+func (k kanikoBuilder) Available(ctx context.Context) error {
+	_, err := k.executor()
+	return err
+}
+
+// Login stores the credentials in the docker config file, which is where kaniko reads them from.
+func (kanikoBuilder) Login(ctx context.Context, host string, creds registry.Credentials) error {
+	if creds.IsEmpty() {
+		return fmt.Errorf("Builder [kaniko] cannot prompt for registry credentials. Configure a registry credentials source.")
+	}
+	return registry.WriteDockerConfigAuth(ctx, host, creds)
+}
+
+func (k kanikoBuilder) Build(ctx context.Context, b ImageBuild) (string, error) {
+	executor, err := k.executor()
+	if err != nil {
+		return "", err
+	}
+
+	if len(b.Platforms) > 1 {
+		return "", fmt.Errorf("Builder [%s] cannot build multi-platform images (requested %v). Build each platform separately, or use another builder.", k.Name(), b.Platforms)
+	}
+	if len(b.Secrets) > 0 {
+		return "", fmt.Errorf("Builder [%s] does not support build secrets", k.Name())
+	}
+
+	tmpDir, err := os.MkdirTemp("", "og-build-")
+	if err != nil {
+		return "", errutil.Wrap(err, "Creating temp dir for digest file")
+	}
+	defer os.RemoveAll(tmpDir)
+	digestPath := filepath.Join(tmpDir, "digest")
+
+	cmdParts := []string{
+		executor,
+		"--dockerfile", b.DockerfilePath,
+		"--context", "dir://" + b.ContextPath,
+		"--destination", b.Ref(),
+		"--digest-file", digestPath,
+	}
+	if len(b.Platforms) == 1 {
+		cmdParts = append(cmdParts, "--custom-platform", b.Platforms[0])
+	}
+	if b.Target != "" {
+		cmdParts = append(cmdParts, "--target", b.Target)
+	}
+	var buildArgKeys []string
+	for key := range b.BuildArgs {
+		buildArgKeys = append(buildArgKeys, key)
+	}
+	slices.Sort(buildArgKeys)
+	for _, key := range buildArgKeys {
+		cmdParts = append(cmdParts, "--build-arg", fmt.Sprintf("%s=%s", key, b.BuildArgs[key]))
+	}
+	// Kaniko has a single cache repo, used for both reading and writing
+	var cacheRepos []string
+	for _, c := range append(b.CacheTo, b.CacheFrom...) {
+		if r := cacheRepo(c); r != "" {
+			cacheRepos = append(cacheRepos, r)
+		}
+	}
+	if len(cacheRepos) > 0 {
+		cmdParts = append(cmdParts, "--cache=true", "--cache-repo", cacheRepos[0])
+	}
+	if b.NoPush {
+		cmdParts = append(cmdParts, "--no-push")
+	}
+
+	err = execBuildCmd(ctx, b.ContextPath, cmdParts)
+	if err != nil {
+		return "", err
+	}
+
+	if b.NoPush {
+		return "", nil
+	}
+	return readDigestFile(digestPath)
+}
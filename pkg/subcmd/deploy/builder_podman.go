@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+// podmanBuilder builds images using podman or buildah. Both are daemonless and share the same build and push flags.
+type podmanBuilder struct {
+	bin string // podman or buildah
+}
+
+func (p podmanBuilder) Name() string {
+	return p.bin
+}
+
+func (p podmanBuilder) Available(ctx context.Context) error {
+	if _, err := exec.LookPath(p.bin); err != nil {
+		return err
+	}
+	if err := interrupt.Command(ctx, p.bin, "version").Run(); err != nil {
+		return fmt.Errorf("%s is not working: %w", p.bin, err)
+	}
+	return nil
+}
+
+func (p podmanBuilder) Login(ctx context.Context, host string, creds registry.Credentials) error {
+	return registry.Login(ctx, p.bin, host, creds)
+}
+
+// Build builds the image. More than one platform results in a manifest list, which is pushed with all its images.
+func (p podmanBuilder) Build(ctx context.Context, b ImageBuild) (string, error) {
+	multiPlatform := len(b.Platforms) > 1
+
+	tmpDir, err := os.MkdirTemp("", "og-build-")
+	if err != nil {
+		return "", errutil.Wrap(err, "Creating temp dir for digest file")
+	}
+	defer os.RemoveAll(tmpDir)
+	digestPath := filepath.Join(tmpDir, "digest")
+
+	// Multi-platform builds are added to a manifest list, which cannot already exist as an image
+	if multiPlatform {
+		_ = interrupt.Command(ctx, p.bin, "manifest", "rm", b.Ref()).Run()
+	}
+
+	cmdParts := []string{
+		p.bin, "build",
+		"--platform", strings.Join(b.Platforms, ","),
+		"-f", b.DockerfilePath,
+	}
+	if multiPlatform {
+		cmdParts = append(cmdParts, "--manifest", b.Ref())
+	} else {
+		cmdParts = append(cmdParts, "-t", b.Ref())
+	}
+	cmdParts = append(cmdParts, commonBuildFlags(b)...)
+	if len(b.CacheFrom) > 0 || len(b.CacheTo) > 0 {
+		cmdParts = append(cmdParts, "--layers")
+	}
+	for _, c := range b.CacheFrom {
+		if r := cacheRepo(c); r != "" {
+			cmdParts = append(cmdParts, "--cache-from", r)
+		}
+	}
+	for _, c := range b.CacheTo {
+		if r := cacheRepo(c); r != "" {
+			cmdParts = append(cmdParts, "--cache-to", r)
+		}
+	}
+	cmdParts = append(cmdParts, "--pull", b.ContextPath)
+
+	err = execBuildCmd(ctx, b.ContextPath, cmdParts)
+	if err != nil {
+		return "", err
+	}
+
+	if b.NoPush {
+		log.Debug(ctx, "Not pushing image, so no digest is recorded", "image", b.Name)
+		return "", nil
+	}
+
+	// Push
+	if multiPlatform {
+		cmdParts = []string{p.bin, "manifest", "push", "--all", "--digestfile", digestPath, b.Ref(), "docker://" + b.Ref()}
+	} else {
+		cmdParts = []string{p.bin, "push", "--digestfile", digestPath, b.Ref(), "docker://" + b.Ref()}
+	}
+	err = execBuildCmd(ctx, b.ContextPath, cmdParts)
+	if err != nil {
+		return "", errutil.Wrap(err, "Pushing image")
+	}
+
+	return readDigestFile(digestPath)
+}
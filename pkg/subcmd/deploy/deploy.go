@@ -3,20 +3,28 @@ package deploy
 import (
 	"context"
 	"fmt"
-	"os/exec"
-	"path/filepath"
+	"time"
 
-	"github.com/teejays/gokutil/cmdutil"
 	"github.com/teejays/gokutil/errutil"
 	"github.com/teejays/gokutil/gopi/json"
 	"github.com/teejays/gokutil/log"
 	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
 )
 
 type Args struct {
+	All         *AllArgs         `arg:"subcommand:all" help:"Run the whole deploy pipeline: build and push the images, apply them to the deploy target, wait for the release to be ready and smoke check it."`
 	DockerImage *DockerImageArgs `arg:"subcommand:docker-image" help:"Build and push docker images for the app, that can be deployed to the cloud."`
-	K8sApply    *K8sApplyArgs    `arg:"subcommand:k8s-apply" help:"Apply the k8s deployment file(s) for the app."`
-	Destroy     *struct{}        `arg:"subcommand:destroy" help:"Destroy the deployment in the cloud. This will delete the app from the cloud."`
+	Apply       *ApplyArgs       `arg:"subcommand:apply" help:"Apply the app to the deploy target of the environment (deploy.target in the project config: kubernetes or compose)."`
+	K8sApply    *K8sApplyArgs    `arg:"subcommand:k8s-apply" help:"Deprecated: use apply. Apply the app to the kubernetes deploy target."`
+	Render      *RenderArgs      `arg:"subcommand:render" help:"Print the k8s manifests as they would be applied, with the built images, labels and overrides set."`
+	Diff        *DiffArgs        `arg:"subcommand:diff" help:"Show the changes that applying the app would make to the deploy target. Exits with a non-zero code if there are changes."`
+	Status      *StatusArgs      `arg:"subcommand:status" help:"Show the live state of the deployment: workloads, replicas, restarts, running vs. built images, warnings and endpoints."`
+	History     *HistoryArgs     `arg:"subcommand:history" help:"List the releases of the deployment that were applied to the cluster."`
+	Rollback    *RollbackArgs    `arg:"subcommand:rollback" help:"Roll back the deployment to a previous release."`
+	Destroy     *DestroyArgs     `arg:"subcommand:destroy" help:"Destroy the deployment in the cloud. This will delete the app from the cloud."`
 
 	CommonFlags
 
@@ -25,26 +33,66 @@ type Args struct {
 }
 
 type CommonFlags struct {
-	DeployIdentifier string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The identifier to use for the deployment. This is used to identify the deployment in the cloud."`
+	DeployIdentifier  string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The identifier to use for the deployment. This is used to identify the deployment in the cloud."`
+	BuildManifestPath string `arg:"--build-manifest,env:GOKU_DEPLOY_BUILD_MANIFEST" help:"Path to the build manifest written by docker-image and read by apply. Defaults to infra/.goku/deploy/build-manifest.json in the app root, in a directory that git ignores. The build manifest does not make the git tree dirty."`
+	Env               string `arg:"-e,--env,env:GOKU_DEPLOY_ENV" help:"The environment to deploy to, as defined under environments in ongoku.cli.yaml e.g. staging, prod"`
+	Approve           bool   `arg:"--approve,env:GOKU_DEPLOY_APPROVE" help:"Approve changes to environments that require approval, without being asked"`
 }
 
 type (
 	DockerImageArgs struct {
 		DockerImageFlags
+
+		// Set by og, for the build manifest
+		GokuVersion string `arg:"-"`
 	}
 
 	DockerImageFlags struct {
-		ImageRepo string `arg:"--image-repo,env:GOKU_DEPLOY_IMAGE_REPO" help:"The repo to use for the built images. If not provided, a default repo will be used."`
-		ImageTag  string `arg:"--image-tag,env:GOKU_DEPLOY_IMAGE_TAG" help:"The tag to use for the built images. If not provided, a default tag will be used."`
+		ImageRepo string `arg:"--image-repo,env:GOKU_DEPLOY_IMAGE_REPO" help:"The repo to use for the built images. If not provided, the repo from the registry config is used. Can contain the {image} placeholder for a separate repo per image."`
+		ImageTag  string `arg:"--image-tag,env:GOKU_DEPLOY_IMAGE_TAG" help:"The tag to use for the built images. If not provided, a tag derived from the git commit (and uncommitted changes) is used."`
 		NoPush    bool   `arg:"--no-push" help:"Do not push the built images to the registry"`
+
+		SkipRegistryCheck bool   `arg:"--skip-registry-check" help:"Do not verify push permissions before building"`
+		Builder           string `arg:"--builder,env:GOKU_DEPLOY_BUILDER" help:"The tool used to build the images. Options: auto, docker, podman, buildah, kaniko. Defaults to auto-detecting what's available."`
+
+		Images    []string `arg:"--image,separate" help:"Name of the image(s) to build, as declared in the project config. Defaults to all images."`
+		Platforms []string `arg:"--platform,separate,env:GOKU_DEPLOY_PLATFORMS" help:"Platform(s) to build for e.g. linux/amd64,linux/arm64. More than one platform produces a multi-platform image (manifest list)."`
+		BuildArgs []string `arg:"--build-arg,separate" help:"Build arg(s) passed to the builder, in the form KEY=VALUE"`
+		Secrets   []string `arg:"--secret,separate" help:"Build secret(s) passed to the builder e.g. id=npmrc,src=$HOME/.npmrc"`
+		CacheFrom []string `arg:"--cache-from,separate,env:GOKU_DEPLOY_CACHE_FROM" help:"External cache source(s) for the build e.g. type=registry,ref=myrepo/app:buildcache. {image} is replaced by the image name, or else images other than the default one get their name as a suffix e.g. myrepo/app:buildcache-worker."`
+		CacheTo   []string `arg:"--cache-to,separate,env:GOKU_DEPLOY_CACHE_TO" help:"Cache export destination(s) for the build e.g. type=registry,ref=myrepo/app:buildcache,mode=max. Each image gets its own, like --cache-from."`
 	}
 )
 
 type (
+	ApplyArgs struct {
+		ApplyFlags
+	}
+	// K8sApplyArgs are the args of k8s-apply, the deprecated name of apply from when kubernetes was the only target.
 	K8sApplyArgs struct {
-		K8sApplyFlags
+		ApplyFlags
+	}
+	ApplyFlags struct {
+		UseTags bool `arg:"--use-tags" help:"Deploy images by their (mutable) tags, instead of the digests recorded in the build manifest"`
+
+		RenderFlags
+		KubeFlags
+		Manifests   []string      `arg:"--manifest,separate" help:"Path(s) to the k8s manifest file(s) to apply, relative to the app root. Defaults to infra/.goku/generated/k3s/app.yaml."`
+		WaitTimeout time.Duration `arg:"--wait-timeout,env:GOKU_DEPLOY_WAIT_TIMEOUT" help:"How long to wait for the applied workloads to be ready e.g. 5m. Defaults to 5m."`
+		NoWait      bool          `arg:"--no-wait" help:"Do not wait for the applied workloads to be ready"`
+		Prune       bool          `arg:"--prune" help:"Delete the resources of this deployment that are no longer in the manifest(s)"`
+
+		RollbackOnFailure bool `arg:"--rollback-on-failure,env:GOKU_DEPLOY_ROLLBACK_ON_FAILURE" help:"Roll back to the previous release if the workloads do not become ready (not supported by the compose target). Also set with deploy.rollback_on_failure in the project config."`
+		SkipSecretsCheck  bool `arg:"--skip-secrets-check" help:"Do not check that the secrets cover the variables in the example env file (.env.example) before applying"`
+		SkipMigrations    bool `arg:"--skip-migrations" help:"Do not apply the database migrations before deploying, even if database.migrate.before_deploy is set"`
+	}
+
+	// KubeFlags select the cluster and namespace. They override the values in the project config.
+	KubeFlags struct {
+		Namespace   string `arg:"-n,--namespace,env:GOKU_DEPLOY_NAMESPACE" help:"The k8s namespace to deploy to. Defaults to deploy.kubernetes.namespace, then to the namespace of the objects in the manifest(s), then to the namespace of the kube context. Objects of the manifest(s) in another namespace are an error."`
+		KubeContext string `arg:"--kube-context,env:GOKU_DEPLOY_KUBE_CONTEXT" help:"The kubeconfig context to use. Defaults to the current context."`
+		Kubeconfig  string `arg:"--kubeconfig,env:GOKU_DEPLOY_KUBECONFIG" help:"Path to the kubeconfig file. Defaults to kubectl's default (KUBECONFIG or ~/.kube/config)."`
 	}
-	K8sApplyFlags struct{}
 )
 
 func RunWithInit(ctx context.Context, args *Args) error {
@@ -67,20 +115,62 @@ func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
 
 	var somethingDone bool
 
-	if args.CommonFlags.DeployIdentifier == "" {
-		args.CommonFlags.DeployIdentifier = cfg.AppName.ToCompact()
-		log.Warn(ctx, "DeployIdentifier not provided. Using default value.", "default", args.CommonFlags.DeployIdentifier)
+	// Load the CLI specific project config (ongoku.cli.yaml), if any
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+
+	pcfg, err = ResolveEnv(ctx, cfg, pcfg, &args.CommonFlags)
+	if err != nil {
+		return err
+	}
+
+	// All
+	if args.All != nil {
+		somethingDone = true
+
+		log.Info(ctx, "Running subcommand [all]", "args", json.MustPrettyPrint(args.All))
+		err := guardEnv(ctx, cfg, pcfg, args.CommonFlags, envGuards{CheckDirty: true, Approval: true, Action: "deploy"})
+		if err != nil {
+			return err
+		}
+		args.All.GokuVersion = args.GokuVersion
+		err = RunAll(ctx, cfg, pcfg, args.All, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [all]")
+		}
 	}
 
 	// DockerImage
 	if args.DockerImage != nil {
 		somethingDone = true
 
-		log.Info(ctx, "Running subcommand [docker-imahge]", "args", json.MustPrettyPrint(args.DockerImage))
-		err := RunDockerImage(ctx, cfg, args.DockerImage, args.CommonFlags)
+		log.Info(ctx, "Running subcommand [docker-image]", "args", json.MustPrettyPrint(args.DockerImage))
+		err := guardEnv(ctx, cfg, pcfg, args.CommonFlags, envGuards{CheckDirty: true, Approval: false, Action: "build images"})
 		if err != nil {
 			return err
 		}
+		args.DockerImage.GokuVersion = args.GokuVersion
+		err = RunDockerImage(ctx, cfg, pcfg, args.DockerImage, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [docker-image]")
+		}
+	}
+
+	// Apply
+	if args.Apply != nil {
+		somethingDone = true
+
+		log.Info(ctx, "Running subcommand [apply]", "args", json.MustPrettyPrint(args.Apply))
+		err := guardEnv(ctx, cfg, pcfg, args.CommonFlags, envGuards{CheckDirty: true, Approval: true, Action: "deploy"})
+		if err != nil {
+			return err
+		}
+		err = RunApply(ctx, cfg, pcfg, args.Apply, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [apply]")
+		}
 	}
 
 	// K8sApply
@@ -88,121 +178,138 @@ func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
 		somethingDone = true
 
 		log.Info(ctx, "Running subcommand [k8s-apply]", "args", json.MustPrettyPrint(args.K8sApply))
-		err := RunK8sApply(ctx, cfg, args.K8sApply, args.CommonFlags)
+		log.Warn(ctx, "og deploy k8s-apply is deprecated and will be removed. Use og deploy apply, which takes the same flags.")
+		err := requireTarget(pcfg, TargetKubernetes, "k8s-apply")
+		if err != nil {
+			return err
+		}
+		err = guardEnv(ctx, cfg, pcfg, args.CommonFlags, envGuards{CheckDirty: true, Approval: true, Action: "deploy"})
+		if err != nil {
+			return err
+		}
+		err = RunApply(ctx, cfg, pcfg, &ApplyArgs{ApplyFlags: args.K8sApply.ApplyFlags}, args.CommonFlags)
 		if err != nil {
 			return errutil.Wrap(err, "Running subcommand [k8s-apply]")
 		}
 
 	}
 
-	// Destroy is not a part of all
-	if args.Destroy != nil {
+	// Render
+	if args.Render != nil {
 		somethingDone = true
 
-		log.Info(ctx, "Running subcommand [destroy]")
-		err := RunDestroy(ctx, cfg, args.Destroy, args.CommonFlags)
+		log.Info(ctx, "Running subcommand [render]", "args", json.MustPrettyPrint(args.Render))
+		err := RunRender(ctx, cfg, pcfg, args.Render, args.CommonFlags)
 		if err != nil {
-			return errutil.Wrap(err, "Running subcommand [destroy]")
+			return errutil.Wrap(err, "Running subcommand [render]")
 		}
 	}
 
-	if !somethingDone {
-		return fmt.Errorf("Please provide a subcommand.")
-	}
-
-	return nil
-}
-
-// RunDockerImage is a builds and pushes the docker image for the app to the registry.
-func RunDockerImage(ctx context.Context, cfg ogconfig.Config, args *DockerImageArgs, commonFlags CommonFlags) error {
-	var err error
-
-	if commonFlags.DeployIdentifier == "" {
-		commonFlags.DeployIdentifier = cfg.AppName.ToCompact()
-		log.Warn(ctx, "DeployIdentifier not provided. Using default value.", "default", commonFlags.DeployIdentifier)
-	}
+	// Diff
+	if args.Diff != nil {
+		somethingDone = true
 
-	if args.ImageRepo == "" {
-		args.ImageRepo = "iamteejay/goku"
-		log.Warn(ctx, "ImageRepo not provided. Using default value.", "default", args.ImageRepo)
+		log.Info(ctx, "Running subcommand [diff]", "args", json.MustPrettyPrint(args.Diff))
+		err := RunDiff(ctx, cfg, pcfg, args.Diff, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [diff]")
+		}
 	}
 
-	if args.ImageTag == "" {
-		args.ImageTag = fmt.Sprintf("og-img-%s", commonFlags.DeployIdentifier)
-		log.Warn(ctx, "ImageTag not provided. Using default value.", "default", args.ImageTag)
-	}
+	// Status
+	if args.Status != nil {
+		somethingDone = true
 
-	// Build the base image (which should have all the necessary files to run anything)
-	log.Info(ctx, "DockerImage Step [1/	] Building & pushing deploy image...")
-	err = cmdutil.DockerImageBuild(ctx,
-		cmdutil.DockerBuildReq{
-			DockerfilePath:  filepath.Join(cfg.AppRootPath.Full, "infra", ".goku", "static", "app.Dockerfile"),
-			DockerImageRepo: args.ImageRepo,
-			DockerImageTag:  args.ImageTag,
-			NoPush:          args.NoPush,
-			Platforms:       []string{"linux/amd64"},
-		},
-		cmdutil.ExecOptions{
-			Dir: cfg.AppRootPath.Full,
-		},
-	)
-	if err != nil {
-		return errutil.Wrap(err, "Building docker image [%s] using file [%s]", args.ImageTag, "app.Dockerfile")
+		log.Info(ctx, "Running subcommand [status]")
+		err := RunStatus(ctx, cfg, pcfg, args.Status, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [status]")
+		}
 	}
 
-	return nil
-
-}
+	// History
+	if args.History != nil {
+		somethingDone = true
 
-func RunK8sApply(ctx context.Context, cfg ogconfig.Config, args *K8sApplyArgs, commonFlags CommonFlags) error {
+		log.Info(ctx, "Running subcommand [history]")
+		err := requireTarget(pcfg, TargetKubernetes, "history")
+		if err != nil {
+			return err
+		}
+		err = RunHistory(ctx, cfg, pcfg, args.History, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [history]")
+		}
+	}
 
-	var err error
+	// Rollback
+	if args.Rollback != nil {
+		somethingDone = true
 
-	// Digital Ocean App Platform
-	/*
-		// doctl app create --upsert --spec .goku/generated/do/app.yaml
-		err := cmdutil.ExecCmdFromDir(ctx, cfg.AppRootPath.Full,
-			"doctl", "app",
-			"create", "--upsert",
-			"--spec", filepath.Join(".goku", "generated", "do", "app.yaml"),
-		)
+		log.Info(ctx, "Running subcommand [rollback]", "args", json.MustPrettyPrint(args.Rollback))
+		err := requireTarget(pcfg, TargetKubernetes, "rollback")
 		if err != nil {
-			return errutil.Wrap(err, "Running command [doctl app create]")
+			return err
+		}
+		err = guardEnv(ctx, cfg, pcfg, args.CommonFlags, envGuards{CheckDirty: false, Approval: true, Action: "roll back"})
+		if err != nil {
+			return err
+		}
+		err = RunRollback(ctx, cfg, pcfg, args.Rollback, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [rollback]")
 		}
-	*/
-
-	// Kubernetes (kubectl should be setup where this command is run)
-	err = cmdutil.ExecCmdFromDir(ctx, cfg.AppRootPath.Full,
-		"kubectl", "apply", "-f", filepath.Join("infra", ".goku", "generated", "k3s", "app.yaml"),
-	)
-	if err != nil {
-		return errutil.Wrap(err, "Running command [kubectl apply]")
 	}
 
-	// Todo: Add a wait here to wait for the pods to be ready
-	// kubectl wait -n ongoku --for=condition=Ready pod -l identifier=ongoku --timeout=5m
-	{
-		cmd := exec.CommandContext(ctx, "kubectl", "wait", "-n", "ongoku", "--for=condition=Ready", "pod", "-l", fmt.Sprint("identifier=", commonFlags.DeployIdentifier), "--timeout=5m")
-		err = cmdutil.ExecOSCmdWithOpts(ctx, cmd, cmdutil.ExecOptions{
-			Dir: cfg.AppRootPath.Full,
-		})
+	// Destroy is not a part of all
+	if args.Destroy != nil {
+		somethingDone = true
+
+		log.Info(ctx, "Running subcommand [destroy]", "args", json.MustPrettyPrint(args.Destroy))
+		err := guardEnv(ctx, cfg, pcfg, args.CommonFlags, envGuards{CheckDirty: false, Approval: true, Action: "destroy"})
 		if err != nil {
-			return errutil.Wrap(err, "Waiting for pods to be ready")
+			return err
 		}
+		err = RunDestroy(ctx, cfg, pcfg, args.Destroy, args.CommonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [destroy]")
+		}
+	}
+
+	if !somethingDone {
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
 	}
 
 	return nil
 }
 
-func RunDestroy(ctx context.Context, cfg ogconfig.Config, args *struct{}, commondFlags CommonFlags) error {
-
-	// Kubernetes (kubectl should be setup where this command is run)
-	err := cmdutil.ExecCmdFromDir(ctx, cfg.AppRootPath.Full,
-		"kubectl", "delete", "-f", filepath.Join("infra", ".goku", "generated", "k3s", "app.yaml"),
-	)
+// ResolveEnv overlays the settings of the environment selected with --env (if any) on the project config, loads its
+// env file and fills in the default deploy identifier.
+func ResolveEnv(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags *CommonFlags) (projectconfig.Config, error) {
+	// Overlay the settings of the environment, if any
+	pcfg, err := pcfg.ForEnv(commonFlags.Env)
 	if err != nil {
-		return errutil.Wrap(err, "Running command [kubectl delete]")
+		return pcfg, errutil.Wrap(err, "Selecting environment")
+	}
+	env, hasEnv := pcfg.Env()
+	if hasEnv {
+		log.Info(ctx, "Using environment", "env", pcfg.EnvName, "protected", env.Protected)
+		err = loadEnvFile(ctx, cfg, env.EnvFile)
+		if err != nil {
+			return pcfg, errutil.Wrap(err, "Loading env file of environment [%s]", pcfg.EnvName)
+		}
+		if commonFlags.DeployIdentifier == "" {
+			commonFlags.DeployIdentifier = env.DeployIdentifier
+		}
 	}
 
-	return nil
+	if commonFlags.DeployIdentifier == "" {
+		commonFlags.DeployIdentifier = cfg.AppName.ToCompact()
+		if hasEnv {
+			commonFlags.DeployIdentifier += "-" + pcfg.EnvName
+		}
+		log.Warn(ctx, "DeployIdentifier not provided. Using default value.", "default", commonFlags.DeployIdentifier)
+	}
+
+	return pcfg, nil
 }
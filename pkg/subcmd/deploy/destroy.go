@@ -0,0 +1,107 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// _dataKinds are the kinds that hold data that cannot be recreated from the manifests, and are kept with --keep-data.
+// Namespaces are kept too, since deleting one deletes everything in it.
+var _dataKinds = []string{"PersistentVolumeClaim", "PersistentVolume", "Secret", "Namespace"}
+
+type (
+	DestroyArgs struct {
+		DestroyFlags
+	}
+	DestroyFlags struct {
+		Yes        bool `arg:"-y,--yes" help:"Do not ask for confirmation"`
+		DryRun     bool `arg:"--dry-run" help:"List what would be deleted, without deleting anything"`
+		KeepData   bool `arg:"--keep-data" help:"Do not delete persistent volumes (and their claims), secrets and namespaces, or the compose volumes"`
+		SkipBackup bool `arg:"--skip-backup" help:"Do not back up the database before destroying, even if database.backup.before_destroy is set"`
+
+		KubeFlags
+		Manifests   []string      `arg:"--manifest,separate" help:"Path(s) to the k8s manifest file(s) of the deployment, relative to the app root. Defaults to infra/.goku/generated/k3s/app.yaml."`
+		WaitTimeout time.Duration `arg:"--wait-timeout,env:GOKU_DEPLOY_WAIT_TIMEOUT" help:"How long to wait for the resources to be deleted e.g. 5m. Defaults to 5m."`
+		NoWait      bool          `arg:"--no-wait" help:"Do not wait for the resources to be deleted"`
+	}
+)
+
+// RunDestroy removes the deployment from its deploy target, after listing what will be removed and asking for
+// confirmation. If database.backup.before_destroy is set, the database is backed up first.
+func RunDestroy(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *DestroyArgs, commonFlags CommonFlags) error {
+
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, TargetSettings{
+		KubeFlags:   args.KubeFlags,
+		Manifests:   args.Manifests,
+		WaitTimeout: args.WaitTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	result := DestroyResult{DeployIdentifier: commonFlags.DeployIdentifier, Target: t.Name(), DryRun: args.DryRun, Resources: []string{}}
+	err = t.Destroy(ctx, DestroyOptions{
+		DryRun:   args.DryRun,
+		KeepData: args.KeepData,
+		Wait:     !args.NoWait,
+		Confirm: func(resources []string) error {
+			result.Resources = resources
+			if !args.DryRun && !args.Yes {
+				// Along with the prompt, on stderr
+				fmt.Fprintf(os.Stderr, "The following resources of deployment [%s] will be deleted:\n", commonFlags.DeployIdentifier)
+				for _, r := range resources {
+					fmt.Fprintf(os.Stderr, "  - %s\n", r)
+				}
+				prompt := fmt.Sprintf("This cannot be undone. Type the deploy identifier [%s] to confirm: ", commonFlags.DeployIdentifier)
+				err := confirmByTyping(prompt, commonFlags.DeployIdentifier, "--yes")
+				if err != nil {
+					return err
+				}
+			}
+			// After the confirmation, and before anything is deleted
+			return backupBeforeDestroy(ctx, cfg, pcfg, commonFlags, t, args)
+		},
+	})
+	if err != nil {
+		return ogerr.Mark(err, ogerr.CategoryDeploy)
+	}
+	return output.Print(os.Stdout, result, func(w io.Writer) { printDestroyed(w, result) })
+}
+
+// DestroyResult is the result of destroy: the resources of the deployment that were deleted, or would be on a dry run.
+type DestroyResult struct {
+	DeployIdentifier string `json:"deploy_identifier"`
+	Target           string `json:"target"`
+	DryRun           bool   `json:"dry_run"`
+	// Resources are e.g. Deployment/backend (namespace: prod) for kubernetes, or the containers and volumes for compose
+	Resources []string `json:"resources"`
+}
+
+// Identifiers returns the resources.
+func (r DestroyResult) Identifiers() []string {
+	return r.Resources
+}
+
+func printDestroyed(w io.Writer, r DestroyResult) {
+	if len(r.Resources) == 0 {
+		fmt.Fprintf(w, "Deployment [%s] has no resources to delete\n", r.DeployIdentifier)
+		return
+	}
+	verb := "were deleted"
+	if r.DryRun {
+		verb = "would be deleted"
+	}
+	fmt.Fprintf(w, "The following resources of deployment [%s] %s:\n", r.DeployIdentifier, verb)
+	for _, res := range r.Resources {
+		fmt.Fprintf(w, "  - %s\n", res)
+	}
+}
@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube/kubefake"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+const _testDestroyManifest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: data
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: db
+  namespace: data
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: data
+`
+
+// testKubernetesTarget returns a target for the manifest, with the app root in a temporary directory.
+func testKubernetesTarget(t *testing.T, kc *kube.Client, manifest string, pcfg projectconfig.Config) *kubernetesTarget {
+	t.Helper()
+	root := t.TempDir()
+	path := filepath.Join(root, "app.yaml")
+	err := os.WriteFile(path, []byte(manifest), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg ogconfig.Config
+	cfg.AppRootPath.Full = root
+	return &kubernetesTarget{
+		cfg:         cfg,
+		pcfg:        pcfg,
+		commonFlags: CommonFlags{DeployIdentifier: "myapp"},
+		ks:          KubeSettings{Namespace: kc.Namespace, Manifests: []string{path}},
+		kc:          kc,
+	}
+}
+
+func TestDestroyKeepData(t *testing.T) {
+	ctx := context.Background()
+	objs, err := kube.DecodeManifest([]byte(_testDestroyManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kube.SetDeployLabels(objs, "myapp")
+	kc := kubefake.NewClient("data")
+	_, err = kc.Apply(ctx, objs, kube.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		keepData bool
+		want     []string
+	}{
+		{
+			name:     "keep data",
+			keepData: true,
+			want:     []string{"ConfigMap/config (namespace: data)"},
+		},
+		{
+			name: "everything",
+			want: []string{"Namespace/data", "PersistentVolumeClaim/db (namespace: data)", "ConfigMap/config (namespace: data)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			target := testKubernetesTarget(t, kc, _testDestroyManifest, projectconfig.Config{})
+			err := target.Destroy(ctx, DestroyOptions{
+				DryRun:   true,
+				KeepData: tt.keepData,
+				Confirm: func(resources []string) error {
+					got = resources
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("Destroy() error = %v", err)
+			}
+			slices.Sort(got)
+			slices.Sort(tt.want)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Destroy() resources = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// ErrDiffHasChanges is returned by diff when applying the app would change the deploy target.
+var ErrDiffHasChanges = errors.New("Applying the app would change the deploy target")
+
+type (
+	DiffArgs struct {
+		DiffFlags
+	}
+	DiffFlags struct {
+		UseTags bool `arg:"--use-tags" help:"Compare with the images referenced by their tags, instead of the digests recorded in the build manifest"`
+
+		RenderFlags
+		KubeFlags
+		Manifests []string `arg:"--manifest,separate" help:"Path(s) to the k8s manifest file(s) to compare, relative to the app root. Defaults to infra/.goku/generated/k3s/app.yaml."`
+		NoColor   bool     `arg:"--no-color" help:"Do not colour the diff. Colours are only used when the output is a terminal and NO_COLOR is not set."`
+		Prune     bool     `arg:"--prune" help:"Also show the resources of this deployment that are no longer in the manifest(s), which apply --prune would delete"`
+	}
+)
+
+// RunDiff prints the changes that applying the app would make to the deploy target, and returns ErrDiffHasChanges if
+// there are any.
+func RunDiff(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *DiffArgs, commonFlags CommonFlags) error {
+
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, TargetSettings{
+		UseTags:     args.UseTags,
+		RenderFlags: args.RenderFlags,
+		KubeFlags:   args.KubeFlags,
+		Manifests:   args.Manifests,
+	})
+	if err != nil {
+		return err
+	}
+
+	results, err := t.Plan(ctx, PlanOptions{Prune: args.Prune})
+	if err != nil {
+		return errutil.Wrap(err, "Planning changes to the %s target", t.Name())
+	}
+
+	result := DiffResult{Changes: []PlannedChange{}}
+	for _, res := range results {
+		switch res.Action {
+		case kube.ActionCreated:
+			result.Added++
+		case kube.ActionConfigured:
+			result.Changed++
+		case kube.ActionDeleted:
+			result.Deleted++
+		case kube.ActionUnchanged:
+			result.Unchanged++
+		}
+		if res.Diff == "" {
+			log.Debug(ctx, "No changes", "resource", res.Resource)
+			continue
+		}
+		result.Changes = append(result.Changes, res)
+	}
+
+	color := ColorEnabled(args.NoColor)
+	err = output.Print(os.Stdout, result, func(w io.Writer) {
+		for _, res := range result.Changes {
+			printDiff(w, res, color)
+		}
+		fmt.Fprintf(w, "\n%d to add, %d to change, %d to delete (%d unchanged)\n", result.Added, result.Changed, result.Deleted, result.Unchanged)
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Added+result.Changed+result.Deleted > 0 {
+		return ErrDiffHasChanges
+	}
+	return nil
+}
+
+// DiffResult is the result of diff: the changes that applying the app would make.
+type DiffResult struct {
+	// Changes are the resources that would change, with their diff
+	Changes   []PlannedChange `json:"changes"`
+	Added     int             `json:"added"`
+	Changed   int             `json:"changed"`
+	Deleted   int             `json:"deleted"`
+	Unchanged int             `json:"unchanged"`
+}
+
+// Identifiers returns the resources that would change.
+func (r DiffResult) Identifiers() []string {
+	var ret []string
+	for _, c := range r.Changes {
+		ret = append(ret, c.Resource)
+	}
+	return ret
+}
+
+const (
+	_colorReset = "\033[0m"
+	_colorBold  = "\033[1m"
+	_colorRed   = "\033[31m"
+	_colorGreen = "\033[32m"
+	_colorCyan  = "\033[36m"
+)
+
+func printDiff(w io.Writer, res PlannedChange, color bool) {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + _colorReset
+	}
+
+	fmt.Fprintf(w, "%s\n", paint(_colorBold, fmt.Sprintf("# %s (%s)", res.Resource, res.Action)))
+	for _, line := range strings.Split(strings.TrimSuffix(res.Diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			line = paint(_colorBold, line)
+		case strings.HasPrefix(line, "@@"):
+			line = paint(_colorCyan, line)
+		case strings.HasPrefix(line, "-"):
+			line = paint(_colorRed, line)
+		case strings.HasPrefix(line, "+"):
+			line = paint(_colorGreen, line)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// ColorEnabled returns true if the output can be coloured: stdout is a terminal, and neither --no-color nor NO_COLOR are
+// set.
+func ColorEnabled(noColor bool) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
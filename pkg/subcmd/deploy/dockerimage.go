@@ -0,0 +1,446 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/client/coreengine"
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+// DefaultImageName is the name of the image that is built when no images are declared in the project config.
+const DefaultImageName = "app"
+
+var _defaultPlatforms = []string{"linux/amd64"}
+
+// ImageBuild holds everything needed to build (and push) one image.
+type ImageBuild struct {
+	Name           string
+	DockerfilePath string // Full path
+	ContextPath    string // Full path
+	Target         string
+	Repo           string
+	Tag            string
+	Platforms      []string
+	BuildArgs      map[string]string
+	Secrets        []string
+	CacheFrom      []string
+	CacheTo        []string
+	NoPush         bool
+}
+
+func (b ImageBuild) Ref() string {
+	return fmt.Sprintf("%s:%s", b.Repo, b.Tag)
+}
+
+// RunDockerImage builds and pushes the docker image(s) for the app to the registry, and prints what was built.
+func RunDockerImage(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *DockerImageArgs, commonFlags CommonFlags) error {
+	manifest, err := buildImages(ctx, cfg, pcfg, args, commonFlags)
+	if err != nil {
+		return err
+	}
+	return output.Print(os.Stdout, manifest, func(w io.Writer) { printBuildManifest(w, manifest) })
+}
+
+// buildImages builds and pushes the docker image(s) for the app, and writes the build manifest.
+func buildImages(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *DockerImageArgs, commonFlags CommonFlags) (BuildManifest, error) {
+	var err error
+	var manifest BuildManifest
+
+	if commonFlags.DeployIdentifier == "" {
+		commonFlags.DeployIdentifier = cfg.AppName.ToCompact()
+		log.Warn(ctx, "DeployIdentifier not provided. Using default value.", "default", commonFlags.DeployIdentifier)
+	}
+
+	// Repo: flag > project registry config > profile registry config
+	regCfg, err := pcfg.GetRegistryConfig(ctx)
+	if err != nil {
+		return manifest, errutil.Wrap(err, "Getting registry config")
+	}
+	if args.ImageRepo == "" {
+		args.ImageRepo = regCfg.ImageRepo(registry.RepoVars{
+			App:        cfg.AppName.ToKebab(),
+			Identifier: commonFlags.DeployIdentifier,
+		})
+	}
+	if args.ImageRepo == "" {
+		if !args.NoPush {
+			return manifest, fmt.Errorf("No image repository is configured. Set registry.repository in %s (or in your profile config), or pass --image-repo. Use --no-push to only build locally.", projectconfig.FileName)
+		}
+		args.ImageRepo = cfg.AppName.ToKebab()
+		log.Warn(ctx, "No image repository is configured. Since images are not being pushed, using a local repo name.", "repo", args.ImageRepo)
+	}
+
+	// Default tag is derived from the git state, so that the same code always results in the same tag
+	gitInfo, gitErr := appGitInfo(ctx, cfg, commonFlags)
+	if gitErr != nil {
+		log.Warn(ctx, "Could not get the git state of the app", "error", gitErr)
+	}
+	if args.ImageTag == "" {
+		if gitErr == nil {
+			args.ImageTag = gitInfo.ImageTag()
+			log.Info(ctx, "ImageTag not provided. Using tag derived from the git state.", "tag", args.ImageTag)
+		} else {
+			args.ImageTag = fmt.Sprintf("og-img-%s", commonFlags.DeployIdentifier)
+			log.Warn(ctx, "ImageTag not provided. Using default (mutable) tag.", "default", args.ImageTag)
+		}
+	}
+	if gitErr == nil && gitInfo.Dirty {
+		log.Warn(ctx, "The app has uncommitted changes. The built image(s) will be tagged as dirty.", "tag", args.ImageTag)
+	}
+
+	builds, err := GetImageBuilds(ctx, cfg, pcfg, args.DockerImageFlags)
+	if err != nil {
+		return manifest, errutil.Wrap(err, "Resolving images to build")
+	}
+
+	// Builder: flag > project config > auto-detect
+	builderName := args.Builder
+	if builderName == "" {
+		builderName = pcfg.Deploy.Builder
+	}
+	builder, err := GetBuilder(ctx, builderName)
+	if err != nil {
+		return manifest, errutil.Wrap(err, "Getting image builder")
+	}
+
+	// Ensure we can push before starting a (potentially long) build
+	if !args.NoPush {
+		err = prepareRegistry(ctx, builder, regCfg, builds, args.SkipRegistryCheck)
+		if err != nil {
+			return manifest, errutil.Wrap(err, "Preparing registry for push")
+		}
+	}
+
+	manifest = BuildManifest{
+		DeployIdentifier: commonFlags.DeployIdentifier,
+		CLIVersion:       args.GokuVersion,
+		EngineVersion:    getEngineVersion(ctx),
+		CreatedAt:        time.Now().UTC(),
+	}
+	if gitErr == nil {
+		manifest.Git = &gitInfo
+	}
+
+	for i, b := range builds {
+		log.Info(ctx, fmt.Sprintf("DockerImage Step [%d/%d] Building & pushing image [%s]...", i+1, len(builds), b.Name), "ref", b.Ref(), "platforms", b.Platforms, "builder", builder.Name())
+		digest, err := builder.Build(ctx, b)
+		if err != nil && interrupt.Interrupted() {
+			return manifest, errutil.Wrap(err, "Interrupted while building docker image [%s] (%d of %d). Run the command again to build it", b.Ref(), i+1, len(builds))
+		}
+		if err != nil {
+			return manifest, ogerr.Mark(errutil.Wrap(err, "Building docker image [%s] using file [%s]", b.Ref(), b.DockerfilePath), ogerr.CategoryDeploy)
+		}
+		log.Info(ctx, "Built image", "image", b.Name, "ref", b.Ref(), "digest", digest)
+		bi := BuiltImage{
+			Name:      b.Name,
+			Repo:      b.Repo,
+			Tags:      []string{b.Tag},
+			Digest:    digest,
+			Platforms: b.Platforms,
+			Pushed:    !b.NoPush,
+		}
+		bi.Ref = builtImageRef(bi, false)
+		manifest.Images = append(manifest.Images, bi)
+	}
+
+	// Write the build manifest, so apply can deploy the images by digest
+	manifestPath := getBuildManifestPath(cfg.AppRootPath.Full, commonFlags)
+	if commonFlags.BuildManifestPath == "" {
+		err = ignoreDir(filepath.Dir(manifestPath))
+		if err != nil {
+			return manifest, errutil.Wrap(err, "Ignoring the build manifest directory in git")
+		}
+	}
+	err = SaveBuildManifest(ctx, manifestPath, manifest)
+	if err != nil {
+		return manifest, errutil.Wrap(err, "Saving build manifest")
+	}
+	log.Info(ctx, "Build manifest written", "path", manifestPath)
+
+	return manifest, nil
+}
+
+func printBuildManifest(w io.Writer, m BuildManifest) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "IMAGE\tREFERENCE\tPLATFORMS\tPUSHED")
+	for _, img := range m.Images {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", img.Name, img.Ref, strings.Join(img.Platforms, ","), img.Pushed)
+	}
+}
+
+// getEngineVersion returns the version of the core engine, or an empty string if it cannot be determined. The engine
+// version is informational only, so we don't fail the build if it's not available.
+func getEngineVersion(ctx context.Context) string {
+	cl, err := coreengine.NewClientFromDefaultLicenseFile(ctx)
+	if err != nil {
+		log.Warn(ctx, "Could not create core engine client. Engine version will not be recorded.", "error", err)
+		return ""
+	}
+	v, err := cl.Version(ctx)
+	if err != nil {
+		log.Warn(ctx, "Could not get core engine version. Engine version will not be recorded.", "error", err)
+		return ""
+	}
+	return v
+}
+
+// prepareRegistry logs the builder into the registries of the builds (if the credentials are not managed by docker), and
+// verifies that the credentials can push to the repos.
+func prepareRegistry(ctx context.Context, builder Builder, regCfg registry.Config, builds []ImageBuild, skipCheck bool) error {
+	var repos []string
+	for _, b := range builds {
+		if !slices.Contains(repos, b.Repo) {
+			repos = append(repos, b.Repo)
+		}
+	}
+
+	var loggedInHosts []string
+	for _, repo := range repos {
+		host, _ := registry.ParseRepo(repo)
+		creds, err := regCfg.GetCredentials(ctx, host)
+		if err != nil {
+			return errutil.Wrap(err, "Getting credentials for registry [%s]", host)
+		}
+
+		if regCfg.Credentials.Source != "" && regCfg.Credentials.Source != registry.CredentialSourceDocker && !slices.Contains(loggedInHosts, host) {
+			log.Info(ctx, "Logging into registry...", "host", host, "username", creds.Username)
+			err = builder.Login(ctx, host, creds)
+			if err != nil {
+				return errutil.Wrap(err, "Logging into registry [%s]", host)
+			}
+			loggedInHosts = append(loggedInHosts, host)
+		}
+
+		if skipCheck {
+			continue
+		}
+		log.Info(ctx, "Checking push permissions...", "repo", repo)
+		err = registry.CheckPush(ctx, repo, creds)
+		if err != nil {
+			if errors.Is(err, registry.ErrPushDenied) {
+				return errutil.Wrap(err, "Checking push permissions for [%s]", repo)
+			}
+			// The registry may not support the check, so don't block the build
+			log.Warn(ctx, "Could not verify push permissions. Continuing with the build.", "repo", repo, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// GetImageBuilds resolves the images declared in the project config (or the default app image) and the command line flags
+// into a list of builds. Command line flags take precedence over the project config.
+func GetImageBuilds(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, flags DockerImageFlags) ([]ImageBuild, error) {
+
+	images := pcfg.Deploy.Images
+	if len(images) == 0 {
+		images = []projectconfig.ImageConfig{
+			{
+				Name:       DefaultImageName,
+				Dockerfile: filepath.Join("infra", ".goku", "static", "app.Dockerfile"),
+			},
+		}
+	}
+
+	// Validate the image names
+	var names []string
+	for _, img := range images {
+		if img.Name == "" {
+			return nil, fmt.Errorf("An image in %s does not have a name", projectconfig.FileName)
+		}
+		if img.Dockerfile == "" {
+			return nil, fmt.Errorf("Image [%s] in %s does not have a dockerfile", img.Name, projectconfig.FileName)
+		}
+		if slices.Contains(names, img.Name) {
+			return nil, fmt.Errorf("Image [%s] is declared more than once in %s", img.Name, projectconfig.FileName)
+		}
+		names = append(names, img.Name)
+	}
+	selected := splitCommaValues(flags.Images)
+	for _, name := range selected {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("Image [%s] is not declared. Available images: %s", name, strings.Join(names, ", "))
+		}
+	}
+
+	// Flag values
+	flagBuildArgs, err := parseKeyValues(flags.BuildArgs)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Parsing build args")
+	}
+	flagPlatforms := splitCommaValues(flags.Platforms)
+
+	cacheFrom := flags.CacheFrom
+	if len(cacheFrom) == 0 {
+		cacheFrom = expandEnvAll(pcfg.Deploy.CacheFrom)
+	}
+	cacheTo := flags.CacheTo
+	if len(cacheTo) == 0 {
+		cacheTo = expandEnvAll(pcfg.Deploy.CacheTo)
+	}
+
+	var builds []ImageBuild
+	for _, img := range images {
+		if len(selected) > 0 && !slices.Contains(selected, img.Name) {
+			log.Debug(ctx, "Skipping image since it was not selected", "image", img.Name)
+			continue
+		}
+
+		b := ImageBuild{
+			Name:           img.Name,
+			DockerfilePath: filepath.Join(cfg.AppRootPath.Full, img.Dockerfile),
+			ContextPath:    filepath.Join(cfg.AppRootPath.Full, img.Context),
+			Target:         img.Target,
+			Repo:           flags.ImageRepo,
+			Tag:            flags.ImageTag,
+			BuildArgs:      map[string]string{},
+			Secrets:        append(expandEnvAll(img.Secrets), flags.Secrets...),
+			CacheFrom:      imageCacheSpecs(cacheFrom, img.Name),
+			CacheTo:        imageCacheSpecs(cacheTo, img.Name),
+			NoPush:         flags.NoPush,
+		}
+
+		// Images share the repo (and are told apart by the tag suffix), unless they have their own repo
+		if img.Repo != "" {
+			b.Repo = img.Repo
+		} else if registry.HasImagePlaceholder(flags.ImageRepo) {
+			b.Repo = registry.ExpandImage(flags.ImageRepo, img.Name)
+		} else if img.Name != DefaultImageName {
+			b.Tag = fmt.Sprintf("%s-%s", flags.ImageTag, img.Name)
+		}
+
+		// Platforms: flag > image > project > default
+		switch {
+		case len(flagPlatforms) > 0:
+			b.Platforms = flagPlatforms
+		case len(img.Platforms) > 0:
+			b.Platforms = img.Platforms
+		case len(pcfg.Deploy.Platforms) > 0:
+			b.Platforms = pcfg.Deploy.Platforms
+		default:
+			b.Platforms = _defaultPlatforms
+		}
+
+		// Build args: flag > image
+		for k, v := range img.BuildArgs {
+			b.BuildArgs[k] = os.ExpandEnv(v)
+		}
+		for k, v := range flagBuildArgs {
+			b.BuildArgs[k] = v
+		}
+
+		builds = append(builds, b)
+	}
+
+	return builds, nil
+}
+
+// imageCacheSpecs returns the cache specs of one image, so that images do not overwrite each other's cache. The {image}
+// placeholder is replaced by the image name. Otherwise, like tags in a shared repo, the cache of an image other than the
+// default one is told apart by a suffix e.g. type=registry,ref=myrepo/app:buildcache => ref=myrepo/app:buildcache-worker.
+func imageCacheSpecs(specs []string, image string) []string {
+	var ret []string
+	for _, spec := range specs {
+		switch {
+		case strings.Contains(spec, "{image}"):
+			spec = registry.ExpandImage(spec, image)
+		case image != DefaultImageName:
+			spec = suffixCacheSpec(spec, "-"+image)
+		}
+		ret = append(ret, spec)
+	}
+	return ret
+}
+
+// suffixCacheSpec adds the suffix to what identifies a cache in a buildx style cache spec: the tag of a registry ref, the
+// scope of a gha cache, or the directory of a local cache. A plain value is a registry ref.
+func suffixCacheSpec(spec string, suffix string) string {
+	if !strings.Contains(spec, "=") {
+		return suffixRefTag(spec, suffix)
+	}
+	parts := strings.Split(spec, ",")
+	hasScope := false
+	for i, part := range parts {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "ref":
+			parts[i] = k + "=" + suffixRefTag(v, suffix)
+		case "scope", "src", "dest":
+			parts[i] = k + "=" + v + suffix
+			hasScope = hasScope || k == "scope"
+		}
+	}
+	// gha caches share the buildkit scope by default
+	if slices.Contains(parts, "type=gha") && !hasScope {
+		parts = append(parts, "scope=buildkit"+suffix)
+	}
+	return strings.Join(parts, ",")
+}
+
+// suffixRefTag adds the suffix to the tag of the image ref, which defaults to latest. Refs by digest are left alone.
+func suffixRefTag(ref string, suffix string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref + suffix
+	}
+	return ref + ":latest" + suffix
+}
+
+// splitCommaValues allows repeated flags to also be passed as comma separated values e.g. --platform linux/amd64,linux/arm64
+func splitCommaValues(vals []string) []string {
+	var ret []string
+	for _, v := range vals {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// parseKeyValues parses values of the form KEY=VALUE. A value of the form KEY takes the value from the env variable KEY.
+func parseKeyValues(vals []string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, v := range vals {
+		k, val, found := strings.Cut(v, "=")
+		if k == "" {
+			return nil, ogerr.New(ogerr.CategoryUsage, "Invalid value [%s]. Expected the form KEY=VALUE", v)
+		}
+		if !found {
+			val = os.Getenv(k)
+		}
+		ret[k] = val
+	}
+	return ret, nil
+}
+
+func expandEnvAll(vals []string) []string {
+	var ret []string
+	for _, v := range vals {
+		ret = append(ret, os.ExpandEnv(v))
+	}
+	return ret
+}
@@ -0,0 +1,69 @@
+package deploy
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+func TestImageCacheSpecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  string
+		image string
+		want  string
+	}{
+		{name: "default image", spec: "type=registry,ref=myrepo/app:buildcache", image: DefaultImageName, want: "type=registry,ref=myrepo/app:buildcache"},
+		{name: "registry ref", spec: "type=registry,ref=myrepo/app:buildcache,mode=max", image: "worker", want: "type=registry,ref=myrepo/app:buildcache-worker,mode=max"},
+		{name: "registry ref with port", spec: "type=registry,ref=localhost:5000/app", image: "worker", want: "type=registry,ref=localhost:5000/app:latest-worker"},
+		{name: "registry ref by digest", spec: "type=registry,ref=myrepo/app@sha256:abc", image: "worker", want: "type=registry,ref=myrepo/app@sha256:abc"},
+		{name: "plain ref", spec: "myrepo/app:buildcache", image: "worker", want: "myrepo/app:buildcache-worker"},
+		{name: "placeholder", spec: "type=registry,ref=myrepo/{image}:buildcache", image: "worker", want: "type=registry,ref=myrepo/worker:buildcache"},
+		{name: "placeholder for the default image", spec: "type=registry,ref=myrepo/{image}:buildcache", image: DefaultImageName, want: "type=registry,ref=myrepo/app:buildcache"},
+		{name: "gha", spec: "type=gha", image: "worker", want: "type=gha,scope=buildkit-worker"},
+		{name: "gha with scope", spec: "type=gha,scope=main", image: "worker", want: "type=gha,scope=main-worker"},
+		{name: "local", spec: "type=local,dest=/tmp/cache", image: "worker", want: "type=local,dest=/tmp/cache-worker"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := imageCacheSpecs([]string{tt.spec}, tt.image)
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("imageCacheSpecs(%q, %q) = %q, want %q", tt.spec, tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetImageBuildsCache(t *testing.T) {
+	var pcfg projectconfig.Config
+	pcfg.Deploy.Images = []projectconfig.ImageConfig{
+		{Name: DefaultImageName, Dockerfile: "app.Dockerfile"},
+		{Name: "worker", Dockerfile: "worker.Dockerfile"},
+	}
+	pcfg.Deploy.CacheTo = []string{"type=registry,ref=myrepo/app:buildcache,mode=max"}
+
+	builds, err := GetImageBuilds(context.Background(), ogconfig.Config{}, pcfg, DockerImageFlags{
+		ImageRepo: "myrepo/app",
+		ImageTag:  "v1",
+		CacheFrom: []string{"type=registry,ref=myrepo/app:buildcache"},
+	})
+	if err != nil {
+		t.Fatalf("GetImageBuilds() error = %v", err)
+	}
+	want := map[string][2]string{
+		DefaultImageName: {"type=registry,ref=myrepo/app:buildcache", "type=registry,ref=myrepo/app:buildcache,mode=max"},
+		"worker":         {"type=registry,ref=myrepo/app:buildcache-worker", "type=registry,ref=myrepo/app:buildcache-worker,mode=max"},
+	}
+	if len(builds) != len(want) {
+		t.Fatalf("GetImageBuilds() = %d builds, want %d", len(builds), len(want))
+	}
+	for _, b := range builds {
+		if !slices.Equal(b.CacheFrom, []string{want[b.Name][0]}) || !slices.Equal(b.CacheTo, []string{want[b.Name][1]}) {
+			t.Errorf("GetImageBuilds() image [%s] cache = %q, %q, want %q", b.Name, b.CacheFrom, b.CacheTo, want[b.Name])
+		}
+	}
+}
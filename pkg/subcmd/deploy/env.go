@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/teejays/gokutil/env/envutil"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// envGuards are the checks made before a subcommand changes an environment.
+type envGuards struct {
+	// CheckDirty refuses to run against protected environments when the working tree has uncommitted changes
+	CheckDirty bool
+	// Approval asks for approval if the environment requires it
+	Approval bool
+	// Action describes what's being done, for the approval prompt e.g. "deploy"
+	Action string
+}
+
+// guardEnv makes the checks of the selected environment (if any) before it is changed.
+func guardEnv(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, guards envGuards) error {
+	env, ok := pcfg.Env()
+	if !ok {
+		return nil
+	}
+
+	if guards.CheckDirty && env.Protected {
+		info, err := appGitInfo(ctx, cfg, commonFlags)
+		if err != nil {
+			return errutil.Wrap(err, "Environment [%s] is protected, and the git state of the app could not be checked", pcfg.EnvName)
+		}
+		if info.Dirty {
+			return fmt.Errorf("Environment [%s] is protected and the working tree has uncommitted changes. Commit or stash them first.", pcfg.EnvName)
+		}
+	}
+
+	if guards.Approval && env.RequireApproval {
+		if commonFlags.Approve {
+			log.Info(ctx, "Approved with --approve", "env", pcfg.EnvName, "action", guards.Action)
+			return nil
+		}
+		prompt := fmt.Sprintf("Environment [%s] requires approval to %s. Type the environment name to approve: ", pcfg.EnvName, guards.Action)
+		err := confirmByTyping(prompt, pcfg.EnvName, "--approve")
+		if err != nil {
+			return errutil.Wrap(err, "Approving changes to environment [%s]", pcfg.EnvName)
+		}
+	}
+
+	return nil
+}
+
+// loadEnvFile loads the env file (relative to the app root) into the environment. Variables that are already set are
+// not overridden.
+func loadEnvFile(ctx context.Context, cfg ogconfig.Config, path string) error {
+	if path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.AppRootPath.Full, path)
+	}
+	_, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("Env file [%s] does not exist", path)
+		}
+		return errutil.Wrap(err, "Checking env file [%s]", path)
+	}
+	return envutil.LoadEnvFilesV2(ctx, []string{path})
+}
+
+// confirmByTyping asks the user to type the expected value to confirm. When not running interactively, the user is
+// pointed to the flag that confirms instead.
+func confirmByTyping(prompt string, expected string, flag string) error {
+	if !isTerminal(os.Stdin) {
+		return ogerr.New(ogerr.CategoryUsage, "Refusing to continue without confirmation. Use %s to confirm when not running interactively.", flag)
+	}
+
+	fmt.Fprintf(os.Stderr, "\n%s", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return errutil.Wrap(err, "Reading confirmation")
+	}
+	if strings.TrimSpace(answer) != expected {
+		return ogerr.New(ogerr.CategoryCancelled, "Confirmation [%s] does not match [%s]. Nothing was changed.", strings.TrimSpace(answer), expected)
+	}
+	return nil
+}
+
+// ApproveEnvChange asks for approval, if the selected environment requires it, before a subcommand outside of deploy
+// changes it e.g. `og secrets set`.
+func ApproveEnvChange(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, action string) error {
+	return guardEnv(ctx, cfg, pcfg, commonFlags, envGuards{Approval: true, Action: action})
+}
@@ -0,0 +1,368 @@
+package deploy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+)
+
+const _defaultHistoryLimit = 10
+
+// _historyTimeout bounds the writes to the history that are made even after og is interrupted
+const _historyTimeout = 30 * time.Second
+
+type ReleaseStatus string
+
+const (
+	// ReleasePending is a release that was applied and whose workloads are not ready yet
+	ReleasePending  ReleaseStatus = "pending"
+	ReleaseDeployed ReleaseStatus = "deployed"
+	ReleaseFailed   ReleaseStatus = "failed"
+	// ReleaseUnknown is a release that was applied without waiting for its workloads, or whose wait was interrupted
+	ReleaseUnknown ReleaseStatus = "unknown"
+)
+
+// Release is one apply of the manifests to the cluster.
+type Release struct {
+	Revision         int           `json:"revision"`
+	DeployIdentifier string        `json:"deploy_identifier"`
+	Status           ReleaseStatus `json:"status"`
+	// Namespace is the default namespace of the release: the objects without a namespace were applied in it
+	Namespace string `json:"namespace,omitempty"`
+	// Description says why the release was made e.g. "Rollback to revision 3"
+	Description string `json:"description,omitempty"`
+	// Images are the images of the workloads in the release
+	Images       []string  `json:"images"`
+	ManifestHash string    `json:"manifest_hash"`
+	GitCommit    string    `json:"git_commit,omitempty"`
+	User         string    `json:"user,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Resources are the resources the release applied (or pruned), and what was done to them. They are only known to
+	// the run that applied the release, and are not kept in the history.
+	Resources []kube.Result `json:"resources,omitempty"`
+}
+
+// Identifiers returns the images of the release.
+func (r Release) Identifiers() []string {
+	return r.Images
+}
+
+// ReleaseHistory stores the releases of a deployment in a ConfigMap in the cluster, so that everyone deploying the app
+// sees the same history. Along with each release, the applied objects are stored (gzipped) so that it can be rolled back to.
+type ReleaseHistory struct {
+	kc         *kube.Client
+	identifier string
+	namespace  string
+	limit      int
+}
+
+func NewReleaseHistory(kc *kube.Client, identifier string, limit int) *ReleaseHistory {
+	if limit <= 0 {
+		limit = _defaultHistoryLimit
+	}
+	return &ReleaseHistory{
+		kc:         kc,
+		identifier: identifier,
+		namespace:  kc.Namespace,
+		limit:      limit,
+	}
+}
+
+func (h *ReleaseHistory) configMapName() string {
+	return "og-history-" + strings.ToLower(h.identifier)
+}
+
+func releaseKey(rev int) string {
+	return fmt.Sprintf("release.%06d.json", rev)
+}
+
+func manifestKey(rev int) string {
+	return fmt.Sprintf("manifest.%06d.yaml.gz", rev)
+}
+
+// getConfigMap returns the history ConfigMap, or nil if there is no history yet.
+func (h *ReleaseHistory) getConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+	cm, err := h.kc.Clientset.CoreV1().ConfigMaps(h.namespace).Get(ctx, h.configMapName(), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errutil.Wrap(err, "Getting release history [%s/%s]", h.namespace, h.configMapName())
+	}
+	return cm, nil
+}
+
+// List returns the releases, oldest first.
+func (h *ReleaseHistory) List(ctx context.Context) ([]Release, error) {
+	cm, err := h.getConfigMap(ctx)
+	if err != nil || cm == nil {
+		return nil, err
+	}
+	return releasesOf(cm)
+}
+
+func releasesOf(cm *corev1.ConfigMap) ([]Release, error) {
+	var releases []Release
+	for k, v := range cm.Data {
+		if !strings.HasPrefix(k, "release.") {
+			continue
+		}
+		var rel Release
+		err := json.Unmarshal([]byte(v), &rel)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Unmarshalling release [%s]", k)
+		}
+		releases = append(releases, rel)
+	}
+	slices.SortFunc(releases, func(a, b Release) int { return a.Revision - b.Revision })
+	return releases, nil
+}
+
+// Get returns the release and the objects that were applied in it.
+func (h *ReleaseHistory) Get(ctx context.Context, rev int) (Release, []*unstructured.Unstructured, error) {
+	cm, err := h.getConfigMap(ctx)
+	if err != nil {
+		return Release{}, nil, err
+	}
+	if cm == nil || cm.Data[releaseKey(rev)] == "" {
+		return Release{}, nil, fmt.Errorf("Revision [%d] not found in the release history", rev)
+	}
+
+	var rel Release
+	err = json.Unmarshal([]byte(cm.Data[releaseKey(rev)]), &rel)
+	if err != nil {
+		return rel, nil, errutil.Wrap(err, "Unmarshalling release [%d]", rev)
+	}
+	data, ok := cm.BinaryData[manifestKey(rev)]
+	if !ok {
+		return rel, nil, fmt.Errorf("Manifest of revision [%d] not found in the release history", rev)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return rel, nil, errutil.Wrap(err, "Decompressing manifest of revision [%d]", rev)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return rel, nil, errutil.Wrap(err, "Decompressing manifest of revision [%d]", rev)
+	}
+	objs, err := kube.DecodeManifest(b)
+	if err != nil {
+		return rel, nil, errutil.Wrap(err, "Decoding manifest of revision [%d]", rev)
+	}
+	return rel, objs, nil
+}
+
+// LastDeployed returns the latest release that was deployed successfully, other than the excluded revision.
+func (h *ReleaseHistory) LastDeployed(ctx context.Context, exclude int) (Release, bool, error) {
+	releases, err := h.List(ctx)
+	if err != nil {
+		return Release{}, false, err
+	}
+	for i := len(releases) - 1; i >= 0; i-- {
+		if releases[i].Revision != exclude && releases[i].Status == ReleaseDeployed {
+			return releases[i], true, nil
+		}
+	}
+	return Release{}, false, nil
+}
+
+// Record adds a release for the objects to the history, and returns it with its revision set. The oldest releases are
+// removed when there are more than the history limit.
+func (h *ReleaseHistory) Record(ctx context.Context, rel Release, objs []*unstructured.Unstructured) (Release, error) {
+	manifest, err := encodeManifest(objs)
+	if err != nil {
+		return rel, errutil.Wrap(err, "Encoding manifest")
+	}
+	sum := sha256.Sum256(manifest)
+	rel.ManifestHash = "sha256:" + hex.EncodeToString(sum[:])
+	rel.DeployIdentifier = h.identifier
+	if rel.Namespace == "" {
+		rel.Namespace = h.namespace
+	}
+	rel.Images = workloadImages(objs)
+	if rel.CreatedAt.IsZero() {
+		rel.CreatedAt = time.Now().UTC()
+	}
+
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	_, err = zw.Write(manifest)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return rel, errutil.Wrap(err, "Compressing manifest")
+	}
+
+	cm, err := h.getConfigMap(ctx)
+	if err != nil {
+		return rel, err
+	}
+	create := cm == nil
+	if create {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      h.configMapName(),
+				Namespace: h.namespace,
+			},
+		}
+	}
+	// The history is not in the manifests, and must not be pruned (histories recorded before the role label get it too)
+	if cm.Labels == nil {
+		cm.Labels = map[string]string{}
+	}
+	for k, v := range kube.BookkeepingLabels(h.identifier, kube.RoleHistory) {
+		cm.Labels[k] = v
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if cm.BinaryData == nil {
+		cm.BinaryData = map[string][]byte{}
+	}
+
+	releases, err := releasesOf(cm)
+	if err != nil {
+		return rel, err
+	}
+	rel.Revision = 1
+	if len(releases) > 0 {
+		rel.Revision = releases[len(releases)-1].Revision + 1
+	}
+
+	b, err := json.Marshal(rel)
+	if err != nil {
+		return rel, errutil.Wrap(err, "Marshalling release")
+	}
+	cm.Data[releaseKey(rel.Revision)] = string(b)
+	cm.BinaryData[manifestKey(rel.Revision)] = zbuf.Bytes()
+
+	// Trim the history
+	for i := 0; i < len(releases)+1-h.limit; i++ {
+		delete(cm.Data, releaseKey(releases[i].Revision))
+		delete(cm.BinaryData, manifestKey(releases[i].Revision))
+	}
+
+	err = h.save(ctx, cm, create)
+	if err != nil {
+		return rel, err
+	}
+	log.Debug(ctx, "Recorded release", "revision", rel.Revision, "status", rel.Status)
+	return rel, nil
+}
+
+// SetStatus updates the status of a recorded release.
+func (h *ReleaseHistory) SetStatus(ctx context.Context, rev int, status ReleaseStatus) error {
+	cm, err := h.getConfigMap(ctx)
+	if err != nil {
+		return err
+	}
+	if cm == nil || cm.Data[releaseKey(rev)] == "" {
+		return fmt.Errorf("Revision [%d] not found in the release history", rev)
+	}
+	var rel Release
+	err = json.Unmarshal([]byte(cm.Data[releaseKey(rev)]), &rel)
+	if err != nil {
+		return errutil.Wrap(err, "Unmarshalling release [%d]", rev)
+	}
+	rel.Status = status
+	b, err := json.Marshal(rel)
+	if err != nil {
+		return errutil.Wrap(err, "Marshalling release")
+	}
+	cm.Data[releaseKey(rev)] = string(b)
+	return h.save(ctx, cm, false)
+}
+
+func (h *ReleaseHistory) save(ctx context.Context, cm *corev1.ConfigMap, create bool) error {
+	var err error
+	if create {
+		_, err = h.kc.Clientset.CoreV1().ConfigMaps(h.namespace).Create(ctx, cm, metav1.CreateOptions{FieldManager: kube.FieldManager})
+	} else {
+		_, err = h.kc.Clientset.CoreV1().ConfigMaps(h.namespace).Update(ctx, cm, metav1.UpdateOptions{FieldManager: kube.FieldManager})
+	}
+	if err != nil {
+		if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+			return errutil.Wrap(err, "Saving release history (was another deploy running at the same time?)")
+		}
+		return errutil.Wrap(err, "Saving release history")
+	}
+	return nil
+}
+
+func encodeManifest(objs []*unstructured.Unstructured) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		b, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Marshalling [%s]", kube.RefOf(obj))
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// workloadImages returns the (unique) container images used by the objects.
+func workloadImages(objs []*unstructured.Unstructured) []string {
+	var images []string
+	for _, obj := range objs {
+		for _, spec := range podSpecsOf(obj) {
+			for _, c := range containersOf(spec) {
+				img, _ := c["image"].(string)
+				if img != "" && !slices.Contains(images, img) {
+					images = append(images, img)
+				}
+			}
+		}
+	}
+	return images
+}
+
+// lockHolder identifies who holds a lock e.g. alice@laptop.
+func lockHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return currentUser()
+	}
+	return currentUser() + "@" + host
+}
+
+func currentUser() string {
+	u, err := user.Current()
+	if err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Delete removes the release history from the cluster.
+func (h *ReleaseHistory) Delete(ctx context.Context) error {
+	err := h.kc.Clientset.CoreV1().ConfigMaps(h.namespace).Delete(ctx, h.configMapName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errutil.Wrap(err, "Deleting release history [%s/%s]", h.namespace, h.configMapName())
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package deploy
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube/kubefake"
+)
+
+// configMapObject returns a ConfigMap of the manifests (without a namespace), labelled for the deployment.
+func configMapObject(name string, value string, identifier string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name},
+		"data":       map[string]interface{}{"key": value},
+	}}
+	kube.SetDeployLabels([]*unstructured.Unstructured{obj}, identifier)
+	return obj
+}
+
+func TestPruneSkipsReleaseHistory(t *testing.T) {
+	ctx := context.Background()
+	kc := kubefake.NewClient("apps")
+	hist := NewReleaseHistory(kc, "myapp", 0)
+
+	objs := []*unstructured.Unstructured{configMapObject("config", "v1", "myapp"), configMapObject("removed", "v1", "myapp")}
+	_, err := applyRelease(ctx, kc, hist, objs, releaseOptions{})
+	if err != nil {
+		t.Fatalf("applyRelease() error = %v", err)
+	}
+
+	prunable, err := kc.FindPrunable(ctx, objs[:1], "myapp")
+	if err != nil {
+		t.Fatalf("FindPrunable() error = %v", err)
+	}
+	var names []string
+	for _, obj := range prunable {
+		names = append(names, obj.GetName())
+	}
+	if !slices.Equal(names, []string{"removed"}) {
+		t.Fatalf("FindPrunable() = %v, want [removed] (and not the history %s)", names, hist.configMapName())
+	}
+}
+
+func TestRollbackToRecordedNamespace(t *testing.T) {
+	ctx := context.Background()
+	kc := kubefake.NewClient("apps", &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "other"},
+	}})
+	hist := NewReleaseHistory(kc, "myapp", 0)
+
+	// Revision 1 is applied with another default namespace than the history's, revision 2 with the history's
+	other := *kc
+	other.Namespace = "other"
+	first, err := applyRelease(ctx, &other, hist, []*unstructured.Unstructured{configMapObject("config", "v1", "myapp")}, releaseOptions{})
+	if err != nil {
+		t.Fatalf("applyRelease() error = %v", err)
+	}
+	if first.Namespace != "other" {
+		t.Errorf("Release namespace = %q, want other", first.Namespace)
+	}
+	_, err = applyRelease(ctx, kc, hist, []*unstructured.Unstructured{configMapObject("config", "v2", "myapp")}, releaseOptions{})
+	if err != nil {
+		t.Fatalf("applyRelease() error = %v", err)
+	}
+
+	rel, err := rollbackTo(ctx, kc, hist, 1, secretObject(t, "myapp-env", "myapp"), false, 0)
+	if err != nil {
+		t.Fatalf("rollbackTo() error = %v", err)
+	}
+	if rel.Revision != 3 || rel.Namespace != "other" {
+		t.Errorf("rollbackTo() = revision %d in %q, want revision 3 in other", rel.Revision, rel.Namespace)
+	}
+	var applied []string
+	for _, res := range rel.Resources {
+		applied = append(applied, res.ObjectRef.String())
+	}
+	wantApplied := []string{
+		kube.ObjectRef{Kind: "Secret", Namespace: "other", Name: "myapp-env"}.String(),
+		kube.ObjectRef{Kind: "ConfigMap", Namespace: "other", Name: "config"}.String(),
+	}
+	if !slices.Equal(applied, wantApplied) {
+		t.Errorf("rollbackTo() applied %v, want %v", applied, wantApplied)
+	}
+
+	for ns, want := range map[string]string{"apps": "v2", "other": "v1"} {
+		cm, err := kc.Clientset.CoreV1().ConfigMaps(ns).Get(ctx, "config", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Getting ConfigMap [%s/config] error = %v", ns, err)
+		}
+		if cm.Data["key"] != want {
+			t.Errorf("ConfigMap [%s/config] = %q, want %q", ns, cm.Data["key"], want)
+		}
+	}
+}
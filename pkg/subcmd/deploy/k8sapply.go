@@ -0,0 +1,187 @@
+package deploy
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+var (
+	_defaultK8sManifestPath = filepath.Join("infra", ".goku", "generated", "k3s", "app.yaml")
+	_defaultWaitTimeout     = 5 * time.Minute
+)
+
+// KubeSettings are the resolved settings (flags > project config > defaults) for talking to the cluster.
+type KubeSettings struct {
+	// Namespace is where everything of the deployment is: the objects of the manifests, the release history and the
+	// secrets. If empty, it's the namespace of the kube context.
+	Namespace  string
+	Context    string
+	Kubeconfig string
+	// Manifests are the full paths of the k8s manifest files
+	Manifests    []string
+	WaitTimeout  time.Duration
+	HistoryLimit int
+}
+
+// GetKubeSettings resolves the cluster settings from the flags and the project config.
+func GetKubeSettings(cfg ogconfig.Config, pcfg projectconfig.Config, flags KubeFlags, manifests []string, waitTimeout time.Duration) (KubeSettings, error) {
+	kcfg := pcfg.Deploy.Kubernetes
+
+	s := KubeSettings{
+		Namespace:    firstNonEmpty(flags.Namespace, kcfg.Namespace),
+		Context:      firstNonEmpty(flags.KubeContext, kcfg.Context),
+		Kubeconfig:   firstNonEmpty(flags.Kubeconfig, kcfg.Kubeconfig),
+		WaitTimeout:  waitTimeout,
+		HistoryLimit: kcfg.HistoryLimit,
+	}
+
+	if len(manifests) == 0 {
+		manifests = kcfg.Manifests
+	}
+	if len(manifests) == 0 {
+		manifests = []string{_defaultK8sManifestPath}
+	}
+	for _, m := range manifests {
+		if !filepath.IsAbs(m) {
+			m = filepath.Join(cfg.AppRootPath.Full, m)
+		}
+		s.Manifests = append(s.Manifests, m)
+	}
+	if s.Namespace == "" {
+		s.Namespace = manifestsNamespace(s.Manifests)
+	}
+
+	if s.WaitTimeout == 0 && kcfg.WaitTimeout != "" {
+		d, err := time.ParseDuration(kcfg.WaitTimeout)
+		if err != nil {
+			return s, errutil.Wrap(err, "Parsing deploy.kubernetes.wait_timeout [%s] in %s", kcfg.WaitTimeout, projectconfig.FileName)
+		}
+		s.WaitTimeout = d
+	}
+	if s.WaitTimeout == 0 {
+		s.WaitTimeout = _defaultWaitTimeout
+	}
+
+	return s, nil
+}
+
+// manifestsNamespace returns the namespace of the objects in the manifests, if they all have the same. Manifests that
+// can't be read are left to the commands that need them to report.
+func manifestsNamespace(manifests []string) string {
+	objs, err := kube.ReadManifests(manifests)
+	if err != nil {
+		return ""
+	}
+	var namespace string
+	for _, obj := range objs {
+		ns := obj.GetNamespace()
+		if ns == "" || ns == namespace {
+			continue
+		}
+		if namespace != "" {
+			return ""
+		}
+		namespace = ns
+	}
+	return namespace
+}
+
+// checkNamespaces returns an error if an object is not in the namespace of the deployment.
+func checkNamespaces(objs []*unstructured.Unstructured, namespace string) error {
+	for _, obj := range objs {
+		if obj.GetNamespace() == "" || obj.GetNamespace() == namespace {
+			continue
+		}
+		if namespace == "" {
+			return ogerr.New(ogerr.CategoryUsage, "The objects of the k8s manifests are in more than one namespace e.g. [%s] is in [%s]. og keeps the release history and secrets of the deployment in one namespace: set it with --namespace or deploy.kubernetes.namespace, and remove the other namespaces from the manifests", kube.RefOf(obj), obj.GetNamespace())
+		}
+		return ogerr.New(ogerr.CategoryUsage, "[%s] is not in the namespace of the deployment [%s]. og keeps the release history and secrets of the deployment in one namespace: remove the namespace from the manifests, or deploy to [%s]", kube.RefOf(obj), namespace, obj.GetNamespace())
+	}
+	return nil
+}
+
+// NewClient creates a kubernetes client for the cluster selected by the settings.
+func (s KubeSettings) NewClient(ctx context.Context) (*kube.Client, error) {
+	return kube.NewClient(ctx, kube.Options{
+		Kubeconfig: s.Kubeconfig,
+		Context:    s.Context,
+		Namespace:  s.Namespace,
+	})
+}
+
+// RunApply applies the app to the deploy target of the environment.
+func RunApply(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *ApplyArgs, commonFlags CommonFlags) error {
+	_, err := migrateBeforeDeploy(ctx, cfg, pcfg, args.ApplyFlags, commonFlags)
+	if err != nil {
+		return err
+	}
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, args.TargetSettings())
+	if err != nil {
+		return err
+	}
+	rel, err := t.Apply(ctx, args.ApplyOptions())
+	if err != nil {
+		return ogerr.Mark(err, ogerr.CategoryDeploy)
+	}
+	log.Info(ctx, "Deployed", "target", t.Name(), "revision", rel.Revision, "status", rel.Status)
+	return output.Print(os.Stdout, rel, func(w io.Writer) { printRelease(w, rel) })
+}
+
+// TargetSettings returns the settings of the deploy target from the flags.
+func (f ApplyFlags) TargetSettings() TargetSettings {
+	return TargetSettings{
+		UseTags:     f.UseTags,
+		RenderFlags: f.RenderFlags,
+		KubeFlags:   f.KubeFlags,
+		Manifests:   f.Manifests,
+		WaitTimeout: f.WaitTimeout,
+	}
+}
+
+func (f ApplyFlags) ApplyOptions() ApplyOptions {
+	return ApplyOptions{
+		Wait:              !f.NoWait,
+		Prune:             f.Prune,
+		RollbackOnFailure: f.RollbackOnFailure,
+		SkipSecretsCheck:  f.SkipSecretsCheck,
+	}
+}
+
+// migrateBeforeDeploy applies the database migrations of the release about to be applied, if the project config asks
+// for it. It returns what it did, for the release summary.
+func migrateBeforeDeploy(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, flags ApplyFlags, commonFlags CommonFlags) (string, error) {
+	if !pcfg.Database.Migrate.BeforeDeploy {
+		return "skipped (database.migrate.before_deploy is not set)", nil
+	}
+	if flags.SkipMigrations {
+		log.Warn(ctx, "Skipping the database migrations of the release (--skip-migrations)")
+		return "skipped (--skip-migrations)", nil
+	}
+	err := RunMigrations(ctx, cfg, pcfg, commonFlags, MigrateOptions{Action: MigrateUp, Release: true, Settings: flags.TargetSettings()})
+	if err != nil {
+		return "", errutil.Wrap(err, "Migrating the database before deploying")
+	}
+	return "migrations applied", nil
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+func TestGetKubeSettingsNamespace(t *testing.T) {
+	const oneNamespace = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: apps\n"
+	const twoNamespaces = oneNamespace + "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n  namespace: other\n"
+	const noNamespace = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"
+
+	tests := []struct {
+		name     string
+		flag     string
+		config   string
+		manifest string
+		want     string
+	}{
+		{name: "flag", flag: "flag", config: "config", manifest: oneNamespace, want: "flag"},
+		{name: "config", config: "config", manifest: oneNamespace, want: "config"},
+		{name: "manifests", manifest: oneNamespace, want: "apps"},
+		{name: "manifests without namespace", manifest: noNamespace, want: ""},
+		{name: "manifests with several namespaces", manifest: twoNamespaces, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.yaml")
+			err := os.WriteFile(path, []byte(tt.manifest), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pcfg projectconfig.Config
+			pcfg.Deploy.Kubernetes.Namespace = tt.config
+			ks, err := GetKubeSettings(ogconfig.Config{}, pcfg, KubeFlags{Namespace: tt.flag}, []string{path}, 0)
+			if err != nil {
+				t.Fatalf("GetKubeSettings() error = %v", err)
+			}
+			if ks.Namespace != tt.want {
+				t.Errorf("GetKubeSettings() namespace = %q, want %q", ks.Namespace, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDeployObjectsNamespace(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: apps\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: default\n"
+
+	tests := []struct {
+		name      string
+		namespace string
+		wantErr   string
+	}{
+		{name: "same namespace", namespace: "apps"},
+		{name: "other namespace", namespace: "other", wantErr: "is not in the namespace of the deployment [other]"},
+		{name: "several namespaces", namespace: "", wantErr: "more than one namespace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			path := filepath.Join(root, "app.yaml")
+			err := os.WriteFile(path, []byte(manifest), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cfg ogconfig.Config
+			cfg.AppRootPath.Full = root
+			ks := KubeSettings{Namespace: tt.namespace, Manifests: []string{path}}
+			_, err = readDeployObjects(context.Background(), cfg, projectconfig.Config{}, ks, false, RenderFlags{}, CommonFlags{DeployIdentifier: "myapp"})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("readDeployObjects() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("readDeployObjects() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
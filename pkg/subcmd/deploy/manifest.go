@@ -0,0 +1,150 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/gitinfo"
+)
+
+// DefaultBuildManifestPath is where the build manifest is written by docker-image, and read by apply (relative to the app
+// root). Its directory is og's own, and is ignored by git (see ignoreDir).
+var DefaultBuildManifestPath = filepath.Join("infra", ".goku", "deploy", "build-manifest.json")
+
+// BuildManifest records what was built by docker-image, so that it can be deployed by digest. It's also the result of
+// docker-image.
+type BuildManifest struct {
+	DeployIdentifier string        `json:"deploy_identifier"`
+	Images           []BuiltImage  `json:"images"`
+	Git              *gitinfo.Info `json:"git,omitempty"`
+	CLIVersion       string        `json:"cli_version"`
+	EngineVersion    string        `json:"engine_version,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+}
+
+type BuiltImage struct {
+	// Name of the image in the project config e.g. app
+	Name string   `json:"name"`
+	Repo string   `json:"repo"`
+	Tags []string `json:"tags"`
+	// Digest of the pushed image (or manifest list). Empty if it was not pushed.
+	Digest    string   `json:"digest,omitempty"`
+	Platforms []string `json:"platforms"`
+	Pushed    bool     `json:"pushed"`
+	// Ref is the reference that deploys the image: by digest if it was pushed, else by tag
+	Ref string `json:"ref"`
+}
+
+// DigestRef returns the immutable reference to the image e.g. myrepo/app@sha256:...
+func (i BuiltImage) DigestRef() string {
+	if i.Digest == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s@%s", i.Repo, i.Digest)
+}
+
+// TagRefs returns the mutable references to the image e.g. myrepo/app:git-1a2b3c4d5e6f
+func (i BuiltImage) TagRefs() []string {
+	var ret []string
+	for _, t := range i.Tags {
+		ret = append(ret, fmt.Sprintf("%s:%s", i.Repo, t))
+	}
+	return ret
+}
+
+// Identifiers returns the references that deploy the built images.
+func (m BuildManifest) Identifiers() []string {
+	var ret []string
+	for _, img := range m.Images {
+		ret = append(ret, img.Ref)
+	}
+	return ret
+}
+
+func (m BuildManifest) GetImage(name string) (BuiltImage, bool) {
+	for _, img := range m.Images {
+		if img.Name == name {
+			return img, true
+		}
+	}
+	return BuiltImage{}, false
+}
+
+func SaveBuildManifest(ctx context.Context, path string, m BuildManifest) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errutil.Wrap(err, "Creating directory for the build manifest")
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errutil.Wrap(err, "Marshalling build manifest to json")
+	}
+	err = os.WriteFile(path, b, 0644)
+	if err != nil {
+		return errutil.Wrap(err, "Writing build manifest to file")
+	}
+	return nil
+}
+
+// LoadBuildManifest reads the build manifest at path. The returned error wraps os.ErrNotExist if there is no manifest.
+func LoadBuildManifest(ctx context.Context, path string) (BuildManifest, error) {
+	var ret BuildManifest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ret, errutil.Wrap(err, "Reading build manifest file")
+	}
+	err = json.Unmarshal(b, &ret)
+	if err != nil {
+		return ret, errutil.Wrap(err, "Unmarshalling build manifest")
+	}
+	return ret, nil
+}
+
+// ignoreDir makes git ignore everything in dir (which og owns), with a .gitignore that ignores itself too.
+func ignoreDir(dir string) error {
+	p := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errutil.Wrap(err, "Creating directory [%s]", dir)
+	}
+	err = os.WriteFile(p, []byte("# Written by og, which keeps the files of its own here\n*\n"), 0644)
+	if err != nil {
+		return errutil.Wrap(err, "Writing [%s]", p)
+	}
+	return nil
+}
+
+// appGitInfo returns the git state of the app. The build manifest is left out of the dirty state, since it's written by
+// docker-image: building would otherwise make the next build dirty.
+func appGitInfo(ctx context.Context, cfg ogconfig.Config, commonFlags CommonFlags) (gitinfo.Info, error) {
+	root := cfg.AppRootPath.Full
+	var exclude []string
+	rel, err := filepath.Rel(root, getBuildManifestPath(root, commonFlags))
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		exclude = append(exclude, rel)
+	}
+	return gitinfo.Get(ctx, root, exclude...)
+}
+
+// getBuildManifestPath returns the full path of the build manifest.
+func getBuildManifestPath(appRootPath string, flags CommonFlags) string {
+	p := flags.BuildManifestPath
+	if p == "" {
+		p = DefaultBuildManifestPath
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(appRootPath, p)
+}
@@ -0,0 +1,69 @@
+package deploy
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/teejays/gokutil/ogconfig"
+)
+
+// testGitApp returns the config of an app at the root of a git repository with one (empty) commit.
+func testGitApp(t *testing.T) ogconfig.Config {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "first"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	var cfg ogconfig.Config
+	cfg.AppRootPath.Full = root
+	return cfg
+}
+
+func TestBuildManifestDoesNotDirtyTree(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name        string
+		commonFlags CommonFlags
+		ignore      bool
+	}{
+		{name: "default path", ignore: true},
+		{name: "custom path in the app", commonFlags: CommonFlags{BuildManifestPath: filepath.Join("build", "manifest.json")}},
+		{name: "custom path outside the app", commonFlags: CommonFlags{BuildManifestPath: filepath.Join(t.TempDir(), "manifest.json")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testGitApp(t)
+			path := getBuildManifestPath(cfg.AppRootPath.Full, tt.commonFlags)
+			if tt.ignore {
+				err := ignoreDir(filepath.Dir(path))
+				if err != nil {
+					t.Fatalf("ignoreDir() error = %v", err)
+				}
+			}
+			err := SaveBuildManifest(ctx, path, BuildManifest{DeployIdentifier: "myapp"})
+			if err != nil {
+				t.Fatalf("SaveBuildManifest() error = %v", err)
+			}
+			info, err := appGitInfo(ctx, cfg, tt.commonFlags)
+			if err != nil {
+				t.Fatalf("appGitInfo() error = %v", err)
+			}
+			if info.Dirty {
+				t.Errorf("appGitInfo() = dirty after writing the build manifest to [%s]", path)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/teejays/gokutil/cmdutil"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// Migration actions
+const (
+	// MigrateUp applies the pending migrations
+	MigrateUp = "up"
+	// MigrateStatus lists the applied and pending migrations
+	MigrateStatus = "status"
+	// MigrateDown rolls back the last migration
+	MigrateDown = "down"
+)
+
+// MigrateLock is the lock of the deployment held while migrations are applied or rolled back.
+const MigrateLock = "db-migrate"
+
+const (
+	_defaultMigrateWorkload = "backend"
+	_defaultDatabaseTunnel  = "database"
+	_defaultDatabaseURLEnv  = "DATABASE_URL"
+)
+
+// MigrateOptions select how migrations are run.
+type MigrateOptions struct {
+	Action string
+	// Local runs the local commands on this machine, against a tunnel to the database
+	Local bool
+	// Release runs the migrations of the next release (with the built images), before it's applied
+	Release  bool
+	Settings TargetSettings
+	// Stdout receives the output of the migration command. Defaults to stderr, like the logs.
+	Stdout io.Writer
+}
+
+// RunMigrations runs the migration command of the action in a one-off container of the deployment. Applying and rolling
+// back take the migration lock of the deployment, so that two runs never migrate the database at the same time.
+func RunMigrations(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, opts MigrateOptions) error {
+	mcfg := pcfg.Database.Migrate
+
+	commands, configKey := map[string][]string{MigrateUp: mcfg.Up, MigrateStatus: mcfg.Status, MigrateDown: mcfg.Down}, "database.migrate."
+	if opts.Local {
+		commands, configKey = map[string][]string{MigrateUp: mcfg.Local.Up, MigrateStatus: mcfg.Local.Status, MigrateDown: mcfg.Local.Down}, "database.migrate.local."
+	}
+	command, ok := commands[opts.Action]
+	if !ok {
+		return fmt.Errorf("Unknown migration action [%s]", opts.Action)
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("No command to run the [%s] migrations. Set %s%s in %s", opts.Action, configKey, opts.Action, projectconfig.FileName)
+	}
+
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, opts.Settings)
+	if err != nil {
+		return err
+	}
+
+	if opts.Action != MigrateStatus {
+		lockCtx, release, err := t.Lock(ctx, MigrateLock)
+		var held LockHeldError
+		if errors.As(err, &held) {
+			return errutil.Wrap(err, "Another migration is running. If it is not (e.g. it crashed), release the lock with `og db unlock`")
+		}
+		if err != nil {
+			return errutil.Wrap(err, "Taking the migration lock")
+		}
+		defer func() {
+			// Released even if the context is cancelled, so that the next run is not blocked
+			err := release(context.Background())
+			if err != nil {
+				log.Warn(ctx, "Could not release the migration lock. Release it with `og db unlock`.", "error", err)
+			}
+		}()
+		// The migrations stop if the lock is lost, since another run could start them too
+		ctx = lockCtx
+	}
+
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stderr
+	}
+	if opts.Local {
+		err = runLocalMigration(ctx, cfg, pcfg, t, command, opts.Stdout)
+	} else {
+		workload := firstNonEmpty(mcfg.Workload, _defaultMigrateWorkload)
+		log.Info(ctx, "Running migrations", "action", opts.Action, "target", t.Name(), "workload", workload, "release", opts.Release)
+		err = t.Exec(ctx, ExecOptions{
+			Workload:  workload,
+			Container: mcfg.Container,
+			Command:   command,
+			OneOff:    true,
+			Release:   opts.Release,
+			Stdout:    opts.Stdout,
+			Stderr:    os.Stderr,
+		})
+	}
+	if err != nil && errors.Is(context.Cause(ctx), kube.ErrLockLost) {
+		// Not a cancelled error, since og was not asked to stop
+		return ogerr.New(ogerr.CategoryDeploy, "Stopped the [%s] migrations, since the migration lock was lost (%s). They may be partly applied: check with og db status before running them again: %s", opts.Action, context.Cause(ctx), err)
+	}
+	if err != nil && interrupt.Interrupted() {
+		return errutil.Wrap(err, "Interrupted while running the [%s] migrations. They may be partly applied: check with og db status before running them again", opts.Action)
+	}
+	return err
+}
+
+// runLocalMigration runs the command on this machine, with the connection string of a tunnel to the database in an env
+// variable.
+func runLocalMigration(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, t DeployTarget, command []string, stdout io.Writer) error {
+	lcfg := pcfg.Database.Migrate.Local
+	tc := TunnelComponent(pcfg, firstNonEmpty(lcfg.Tunnel, _defaultDatabaseTunnel))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ready := make(chan []Tunnel, 1)
+	tunnelErr := make(chan error, 1)
+	go func() {
+		tunnelErr <- t.Tunnel(ctx, TunnelOptions{
+			Services: []TunnelService{{Name: tc.Service, Ports: tc.Ports}},
+			Ready:    func(tunnels []Tunnel) { ready <- tunnels },
+		})
+	}()
+
+	var tunnels []Tunnel
+	select {
+	case tunnels = <-ready:
+	case err := <-tunnelErr:
+		if err != nil {
+			return errutil.Wrap(err, "Opening a tunnel to [%s]", tc.Component)
+		}
+		// Reached directly, the tunnels are ready already
+		tunnels = <-ready
+	}
+	if len(tunnels) == 0 {
+		return fmt.Errorf("No tunnel to [%s]", tc.Component)
+	}
+	url := ConnectionString(tc, tunnels[0])
+	if url == "" {
+		return fmt.Errorf("No connection string for [%s]. Set its connection under tunnels in %s", tc.Component, projectconfig.FileName)
+	}
+
+	urlEnv := firstNonEmpty(lcfg.URLEnv, _defaultDatabaseURLEnv)
+	log.Info(ctx, "Running migrations locally", "command", command, "tunnel", fmt.Sprintf("%s:%d", tunnels[0].Host, tunnels[0].LocalPort), "env", urlEnv)
+	cmd := interrupt.Command(ctx, command[0], command[1:]...)
+	err := cmdutil.ExecOSCmdWithOpts(ctx, cmd, cmdutil.ExecOptions{
+		Dir:       cfg.AppRootPath.Full,
+		ExtraEnvs: []string{urlEnv + "=" + url},
+		OutWriter: stdout,
+		ErrWriter: os.Stderr,
+	})
+	if err != nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+	return nil
+}
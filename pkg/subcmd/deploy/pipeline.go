@@ -0,0 +1,316 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// Steps of the `deploy all` pipeline, in order
+const (
+	// StepBuild builds the images and pushes them to the registry
+	StepBuild = "build"
+	// StepMigrate applies the database migrations of the release, if database.migrate.before_deploy is set
+	StepMigrate = "migrate"
+	// StepApply applies the manifests (with the built digests) and waits for the workloads to be ready
+	StepApply = "apply"
+	// StepVerify runs the smoke check against the health endpoint
+	StepVerify = "verify"
+)
+
+var _pipelineSteps = []string{StepBuild, StepMigrate, StepApply, StepVerify}
+
+const (
+	_defaultHealthTimeout = time.Minute
+	_healthAttemptTimeout = 10 * time.Second
+	_healthRetryInterval  = 2 * time.Second
+)
+
+type (
+	AllArgs struct {
+		FromStep string   `arg:"--from-step" help:"Resume the pipeline from this step. Steps: build, migrate, apply, verify."`
+		Only     []string `arg:"--only,separate" help:"Run only these step(s) of the pipeline. Steps: build, migrate, apply, verify."`
+
+		HealthURL     string        `arg:"--health-url,env:GOKU_DEPLOY_HEALTH_URL" help:"The health endpoint to smoke check after the release is ready. Overrides deploy.health_check.url in the project config."`
+		HealthTimeout time.Duration `arg:"--health-timeout" help:"How long to retry the health endpoint until it's healthy e.g. 1m. Defaults to 1m."`
+
+		DockerImageFlags
+		ApplyFlags
+
+		// Set by og, for the build manifest
+		GokuVersion string `arg:"-"`
+	}
+)
+
+// Statuses of a step of the pipeline
+const (
+	StepStatusDone        = "done"
+	StepStatusFailed      = "failed"
+	StepStatusInterrupted = "interrupted"
+	StepStatusNotRun      = "not_run"
+)
+
+// PipelineResult is the result of `deploy all`, the release summary. It's printed even if a step fails.
+type PipelineResult struct {
+	DeployIdentifier string `json:"deploy_identifier"`
+	// Images are the references of the deployed (or else built) images
+	Images []string `json:"images"`
+	// Release is the release made by the apply step, if it ran
+	Release *Release `json:"release,omitempty"`
+	// Steps are the selected steps, in order
+	Steps []StepResult `json:"steps"`
+}
+
+// StepResult is what a step of the pipeline did.
+type StepResult struct {
+	Step string `json:"step"`
+	// Status is done, failed, interrupted or not_run
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// DurationMS is how long the step took, in milliseconds
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// Identifiers returns the references of the images.
+func (r PipelineResult) Identifiers() []string {
+	return r.Images
+}
+
+// RunAll runs the deploy pipeline: build and push the images, apply the app to the deploy target pinned to the built
+// digests, wait for the workloads to be ready and smoke check the app. A release summary is printed at the end, even if
+// a step fails.
+func RunAll(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *AllArgs, commonFlags CommonFlags) error {
+
+	steps, err := selectSteps(args.FromStep, args.Only)
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Running deploy pipeline", "steps", strings.Join(steps, ", "))
+
+	var results []StepResult
+	var rel *Release
+	defer func() {
+		printErr := printReleaseSummary(ctx, cfg, commonFlags, steps, results, rel)
+		if printErr != nil {
+			log.Warn(ctx, "Could not print the release summary", "error", printErr)
+		}
+	}()
+
+	results, err = runSteps(ctx, steps, func(ctx context.Context, step string) (string, error) {
+		switch step {
+		case StepBuild:
+			_, err := buildImages(ctx, cfg, pcfg, &DockerImageArgs{DockerImageFlags: args.DockerImageFlags, GokuVersion: args.GokuVersion}, commonFlags)
+			if args.NoPush {
+				return "images built", err
+			}
+			return "images built and pushed", err
+
+		case StepMigrate:
+			return migrateBeforeDeploy(ctx, cfg, pcfg, args.ApplyFlags, commonFlags)
+
+		case StepApply:
+			t, err := GetTarget(ctx, cfg, pcfg, commonFlags, args.ApplyFlags.TargetSettings())
+			if err != nil {
+				return "", err
+			}
+			r, err := t.Apply(ctx, args.ApplyFlags.ApplyOptions())
+			if r.Status != "" {
+				rel = &r
+			}
+			return fmt.Sprintf("%s: revision %d %s", t.Name(), r.Revision, r.Status), err
+
+		case StepVerify:
+			return verifyHealth(ctx, pcfg, args)
+		}
+		return "", fmt.Errorf("Unknown pipeline step [%s]", step)
+	})
+	return err
+}
+
+// runSteps runs the steps in order with run, which returns the detail of the step, until one fails. The results are
+// those of the steps that ran, including the one that failed, whose detail is the error.
+func runSteps(ctx context.Context, steps []string, run func(ctx context.Context, step string) (string, error)) ([]StepResult, error) {
+	var results []StepResult
+	for _, step := range steps {
+		start := time.Now()
+		log.Info(ctx, "Running pipeline step", "step", step)
+
+		detail, err := run(ctx, step)
+		res := StepResult{Step: step, Status: StepStatusDone, Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil && interrupt.Interrupted() {
+			res.Status, res.Detail = StepStatusInterrupted, "interrupted"
+			results = append(results, res)
+			return results, errutil.Wrap(err, "Interrupted during pipeline step [%s]. Resume with --from-step %s", step, step)
+		}
+		if err != nil {
+			res.Status, res.Detail = StepStatusFailed, "failed: "+err.Error()
+			results = append(results, res)
+			return results, ogerr.Mark(errutil.Wrap(err, "Running pipeline step [%s]. Resume with --from-step %s once fixed", step, step), ogerr.CategoryDeploy)
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// selectSteps returns the steps of the pipeline to run.
+func selectSteps(fromStep string, only []string) ([]string, error) {
+	if fromStep != "" && len(only) > 0 {
+		return nil, ogerr.New(ogerr.CategoryUsage, "Only one of --from-step and --only can be used")
+	}
+
+	if len(only) > 0 {
+		only = splitCommaValues(only)
+		for _, s := range only {
+			if !slices.Contains(_pipelineSteps, s) {
+				return nil, ogerr.New(ogerr.CategoryUsage, "Unknown step [%s] in --only. Steps: %s", s, strings.Join(_pipelineSteps, ", "))
+			}
+		}
+		// Keep the pipeline order, whatever the order of the flags
+		var steps []string
+		for _, s := range _pipelineSteps {
+			if slices.Contains(only, s) {
+				steps = append(steps, s)
+			}
+		}
+		return steps, nil
+	}
+
+	if fromStep != "" {
+		i := slices.Index(_pipelineSteps, fromStep)
+		if i < 0 {
+			return nil, ogerr.New(ogerr.CategoryUsage, "Unknown step [%s] in --from-step. Steps: %s", fromStep, strings.Join(_pipelineSteps, ", "))
+		}
+		return _pipelineSteps[i:], nil
+	}
+
+	return _pipelineSteps, nil
+}
+
+// verifyHealth smoke checks the health endpoint of the app, retrying until it's healthy or the timeout passes.
+func verifyHealth(ctx context.Context, pcfg projectconfig.Config, args *AllArgs) (string, error) {
+	hcfg := pcfg.Deploy.HealthCheck
+
+	url := firstNonEmpty(args.HealthURL, hcfg.URL)
+	if url == "" {
+		log.Warn(ctx, "No health endpoint configured, skipping the smoke check. Set deploy.health_check.url in the project config or use --health-url.", "configFile", projectconfig.FileName)
+		return "skipped (no health endpoint)", nil
+	}
+
+	timeout := args.HealthTimeout
+	if timeout == 0 && hcfg.Timeout != "" {
+		d, err := time.ParseDuration(hcfg.Timeout)
+		if err != nil {
+			return "", errutil.Wrap(err, "Parsing deploy.health_check.timeout [%s] in %s", hcfg.Timeout, projectconfig.FileName)
+		}
+		timeout = d
+	}
+	if timeout == 0 {
+		timeout = _defaultHealthTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := &http.Client{Timeout: _healthAttemptTimeout}
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		status, err := checkHealth(ctx, client, url)
+		switch {
+		case err != nil:
+			lastErr = err
+		case hcfg.ExpectedStatus != 0 && status != hcfg.ExpectedStatus:
+			lastErr = fmt.Errorf("Got status [%d], expected [%d]", status, hcfg.ExpectedStatus)
+		case hcfg.ExpectedStatus == 0 && (status < 200 || status > 299):
+			lastErr = fmt.Errorf("Got status [%d], expected a 2xx status", status)
+		default:
+			log.Info(ctx, "Health check passed", "url", url, "status", status, "attempts", attempt)
+			return fmt.Sprintf("%s returned %d", url, status), nil
+		}
+		log.Debug(ctx, "Health check failed, retrying", "url", url, "attempt", attempt, "error", lastErr)
+
+		select {
+		case <-ctx.Done():
+			return "", errutil.Wrap(lastErr, "Health check of [%s] did not pass within %s", url, timeout)
+		case <-time.After(_healthRetryInterval):
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, client *http.Client, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, errutil.Wrap(err, "Creating health check request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// printReleaseSummary prints the result of the pipeline: the steps that ran, and what was deployed.
+func printReleaseSummary(ctx context.Context, cfg ogconfig.Config, commonFlags CommonFlags, steps []string, results []StepResult, rel *Release) error {
+	summary := PipelineResult{DeployIdentifier: commonFlags.DeployIdentifier, Release: rel, Images: []string{}}
+	if rel != nil {
+		summary.Images = output.NonNil(rel.Images)
+	} else if bm, err := LoadBuildManifest(ctx, getBuildManifestPath(cfg.AppRootPath.Full, commonFlags)); err == nil {
+		for _, img := range bm.Images {
+			summary.Images = append(summary.Images, builtImageRef(img, false))
+		}
+	}
+	for _, step := range steps {
+		i := slices.IndexFunc(results, func(r StepResult) bool { return r.Step == step })
+		if i < 0 {
+			summary.Steps = append(summary.Steps, StepResult{Step: step, Status: StepStatusNotRun})
+			continue
+		}
+		summary.Steps = append(summary.Steps, results[i])
+	}
+
+	return output.Print(os.Stdout, summary, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+
+		fmt.Fprintln(tw, "\nRelease summary")
+		fmt.Fprintf(tw, "  Deploy identifier:\t%s\n", summary.DeployIdentifier)
+		if rel != nil {
+			if rel.Revision > 0 {
+				fmt.Fprintf(tw, "  Revision:\t%d (%s)\n", rel.Revision, rel.Status)
+			} else {
+				// Targets without a release history
+				fmt.Fprintf(tw, "  Status:\t%s\n", rel.Status)
+			}
+		}
+		if rel != nil || len(summary.Images) > 0 {
+			fmt.Fprintf(tw, "  Images:\t%s\n", strings.Join(summary.Images, ", "))
+		}
+		if rel != nil && rel.GitCommit != "" {
+			fmt.Fprintf(tw, "  Git commit:\t%s\n", rel.GitCommit)
+		}
+
+		for _, res := range summary.Steps {
+			if res.Status == StepStatusNotRun {
+				fmt.Fprintf(tw, "  Step %s:\tnot run\n", res.Step)
+				continue
+			}
+			fmt.Fprintf(tw, "  Step %s:\t%s (%s)\n", res.Step, res.Detail, time.Duration(res.DurationMS)*time.Millisecond)
+		}
+	})
+}
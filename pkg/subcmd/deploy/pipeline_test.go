@@ -0,0 +1,74 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSelectSteps(t *testing.T) {
+	tests := []struct {
+		name     string
+		fromStep string
+		only     []string
+		want     []string
+		wantErr  string
+	}{
+		{name: "all", want: []string{StepBuild, StepMigrate, StepApply, StepVerify}},
+		{name: "from step", fromStep: StepApply, want: []string{StepApply, StepVerify}},
+		{name: "from the first step", fromStep: StepBuild, want: []string{StepBuild, StepMigrate, StepApply, StepVerify}},
+		{name: "only one", only: []string{StepMigrate}, want: []string{StepMigrate}},
+		{name: "only keeps the pipeline order", only: []string{StepVerify, StepBuild}, want: []string{StepBuild, StepVerify}},
+		{name: "only with commas", only: []string{"verify, apply", "build"}, want: []string{StepBuild, StepApply, StepVerify}},
+		{name: "from step and only", fromStep: StepApply, only: []string{StepVerify}, wantErr: "Only one of --from-step and --only"},
+		{name: "unknown from step", fromStep: "deploy", wantErr: "Unknown step [deploy] in --from-step"},
+		{name: "unknown only step", only: []string{"build,push"}, wantErr: "Unknown step [push] in --only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectSteps(tt.fromStep, tt.only)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("selectSteps() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectSteps() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectSteps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	var ran []string
+	results, err := runSteps(context.Background(), []string{StepBuild, StepApply, StepVerify}, func(ctx context.Context, step string) (string, error) {
+		ran = append(ran, step)
+		if step == StepApply {
+			return "kubernetes: revision 0 ", fmt.Errorf("Deployment [backend] is not ready")
+		}
+		return step + " done", nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "Resume with --from-step apply") {
+		t.Fatalf("runSteps() error = %v, want the apply step to fail", err)
+	}
+	if !slices.Equal(ran, []string{StepBuild, StepApply}) {
+		t.Errorf("runSteps() ran %v, want the steps up to the failing one", ran)
+	}
+
+	want := []StepResult{
+		{Step: StepBuild, Status: StepStatusDone, Detail: "build done"},
+		{Step: StepApply, Status: StepStatusFailed, Detail: "failed: Deployment [backend] is not ready"},
+	}
+	for i := range results {
+		results[i].DurationMS = 0
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("runSteps() = %+v, want %+v", results, want)
+	}
+}
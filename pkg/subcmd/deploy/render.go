@@ -0,0 +1,478 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+type (
+	RenderArgs struct {
+		UseTags bool `arg:"--use-tags" help:"Render the images referenced by their tags, instead of the digests recorded in the build manifest"`
+
+		RenderFlags
+		KubeFlags
+		Manifests  []string `arg:"--manifest,separate" help:"Path(s) to the k8s manifest file(s) to render, relative to the app root. Defaults to infra/.goku/generated/k3s/app.yaml."`
+		OutputFile string   `arg:"--output-file" help:"Write the rendered manifest to this file, instead of stdout"`
+	}
+
+	// RenderFlags change the k8s objects before they are applied. They are added to the overrides in the project config.
+	RenderFlags struct {
+		SetImages []string `arg:"--set-image,separate" help:"Replace an image in the manifests, in the form NAME=REF e.g. myrepo/app=myrepo/app:v2. NAME is the image without its tag, or the name of a built image."`
+		Replicas  []string `arg:"--replicas,separate" help:"Set the replicas of a workload, in the form NAME=COUNT e.g. backend=3"`
+	}
+)
+
+// renderOptions are everything that changes the k8s objects read from the manifests.
+type renderOptions struct {
+	DeployIdentifier string
+	// BuildManifest has the images built by docker-image. It's nil if nothing was built.
+	BuildManifest *BuildManifest
+	// UseTags references the built images by tag instead of digest
+	UseTags   bool
+	Images    []projectconfig.ImageOverride
+	Overrides []projectconfig.WorkloadOverride
+	// Patches are partial objects merged into the objects with the same kind and name
+	Patches []*unstructured.Unstructured
+	// EnvSecretName is the secret with the app's env variables, added to the env of all the containers. Empty to not
+	// inject it.
+	EnvSecretName string
+}
+
+// RunRender prints the k8s objects as they would be applied, after the images, labels and overrides are set.
+func RunRender(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *RenderArgs, commonFlags CommonFlags) error {
+	ks, err := GetKubeSettings(cfg, pcfg, args.KubeFlags, args.Manifests, 0)
+	if err != nil {
+		return errutil.Wrap(err, "Resolving kubernetes settings")
+	}
+
+	objs, err := readDeployObjects(ctx, cfg, pcfg, ks, args.UseTags, args.RenderFlags, commonFlags)
+	if err != nil {
+		return err
+	}
+	kube.SortForApply(objs)
+	if IsSealedSecrets(pcfg) {
+		log.Info(ctx, "The Secret with the sealed secrets is not rendered. og deploy apply applies it.", "secret", SecretName(pcfg, commonFlags))
+	}
+
+	b, err := encodeManifest(objs)
+	if err != nil {
+		return errutil.Wrap(err, "Encoding rendered manifest")
+	}
+
+	if args.OutputFile != "" {
+		err = os.WriteFile(args.OutputFile, b, 0644)
+		if err != nil {
+			return errutil.Wrap(err, "Writing rendered manifest to [%s]", args.OutputFile)
+		}
+		log.Info(ctx, "Rendered manifest written", "path", args.OutputFile, "objects", len(objs))
+		return nil
+	}
+
+	switch output.GetFormat() {
+	case output.FormatJSON:
+		// As a k8s List, like `kubectl get -o json`
+		list := map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": output.NonNil(objs)}
+		return output.Print(os.Stdout, list, nil)
+	case output.FormatQuiet:
+		for _, obj := range objs {
+			fmt.Fprintf(os.Stdout, "%s/%s\n", obj.GetKind(), obj.GetName())
+		}
+		return nil
+	default:
+		// The manifest is YAML already
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+}
+
+// readDeployObjects reads the k8s objects to deploy from the manifests and renders them: the built images (by digest,
+// unless useTags) and image overrides are set, the deploy labels are added and the workload overrides are applied. The
+// manifest files are not modified. The Secret with the sealed secrets is not part of them (see sealedSecretObject), so
+// that its values are never printed or recorded in the release history.
+func readDeployObjects(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, ks KubeSettings, useTags bool, flags RenderFlags, commonFlags CommonFlags) ([]*unstructured.Unstructured, error) {
+	objs, err := kube.ReadManifests(ks.Manifests)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Reading k8s manifests")
+	}
+
+	opts, err := getRenderOptions(ctx, cfg, pcfg, useTags, flags, commonFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = renderObjects(ctx, objs, opts)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Rendering k8s objects")
+	}
+	err = checkNamespaces(objs, ks.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// getRenderOptions collects the changes to make to the deployed objects, from the project config, the build manifest
+// and the flags.
+func getRenderOptions(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, useTags bool, flags RenderFlags, commonFlags CommonFlags) (renderOptions, error) {
+	var err error
+	opts := renderOptions{
+		DeployIdentifier: commonFlags.DeployIdentifier,
+		UseTags:          useTags,
+		Images:           slices.Clone(pcfg.Deploy.Kubernetes.Images),
+		Overrides:        slices.Clone(pcfg.Deploy.Kubernetes.Overrides),
+	}
+
+	var patchPaths []string
+	for _, p := range pcfg.Deploy.Kubernetes.Patches {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(cfg.AppRootPath.Full, p)
+		}
+		patchPaths = append(patchPaths, p)
+	}
+	opts.Patches, err = kube.ReadManifests(patchPaths)
+	if err != nil {
+		return opts, errutil.Wrap(err, "Reading k8s patches")
+	}
+
+	// The images built by docker-image (if any)
+	buildManifestPath := getBuildManifestPath(cfg.AppRootPath.Full, commonFlags)
+	bm, err := LoadBuildManifest(ctx, buildManifestPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return opts, errutil.Wrap(err, "Loading build manifest")
+		}
+		log.Warn(ctx, "No build manifest found. Images will be deployed as they are referenced in the manifest.", "path", buildManifestPath)
+	} else {
+		if bm.DeployIdentifier != commonFlags.DeployIdentifier {
+			log.Warn(ctx, "Build manifest was created for a different deploy identifier", "manifest", bm.DeployIdentifier, "current", commonFlags.DeployIdentifier)
+		}
+		opts.BuildManifest = &bm
+	}
+
+	// Flags are applied after (so they win over) the project config
+	for _, v := range flags.SetImages {
+		name, ref, ok := strings.Cut(v, "=")
+		if !ok || name == "" || ref == "" {
+			return opts, ogerr.New(ogerr.CategoryUsage, "Invalid --set-image [%s]. Expected NAME=REF", v)
+		}
+		repo, tag, digest := registry.SplitImageRef(ref)
+		opts.Images = append(opts.Images, projectconfig.ImageOverride{Name: name, NewName: repo, NewTag: tag, Digest: digest})
+	}
+	for _, v := range flags.Replicas {
+		name, count, ok := strings.Cut(v, "=")
+		n, err := strconv.Atoi(count)
+		if !ok || name == "" || err != nil || n < 0 {
+			return opts, ogerr.New(ogerr.CategoryUsage, "Invalid --replicas [%s]. Expected NAME=COUNT", v)
+		}
+		opts.Overrides = append(opts.Overrides, projectconfig.WorkloadOverride{Name: name, Replicas: &n})
+	}
+
+	if !pcfg.Secrets.NoInject {
+		opts.EnvSecretName = SecretName(pcfg, commonFlags)
+	}
+
+	return opts, nil
+}
+
+// renderObjects changes the objects in place.
+func renderObjects(ctx context.Context, objs []*unstructured.Unstructured, opts renderOptions) error {
+	for _, patch := range opts.Patches {
+		matched := false
+		for _, obj := range objs {
+			if obj.GetKind() != patch.GetKind() || obj.GetName() != patch.GetName() {
+				continue
+			}
+			if patch.GetNamespace() != "" && obj.GetNamespace() != patch.GetNamespace() {
+				continue
+			}
+			matched = true
+			log.Info(ctx, "Patching", "resource", kube.RefOf(obj).String())
+			mergeObject(obj.Object, patch.Object)
+		}
+		if !matched {
+			return fmt.Errorf("Patch for [%s] does not match any object in the manifests", kube.RefOf(patch))
+		}
+	}
+
+	for _, obj := range objs {
+		for _, spec := range podSpecsOf(obj) {
+			for _, c := range containersOf(spec) {
+				img, _ := c["image"].(string)
+				newImg, err := resolveImage(img, opts)
+				if err != nil {
+					return errutil.Wrap(err, "Resolving image of container [%s] in [%s]", c["name"], kube.RefOf(obj))
+				}
+				if newImg != img {
+					log.Info(ctx, "Setting image", "resource", kube.RefOf(obj).String(), "container", c["name"], "from", img, "to", newImg)
+					c["image"] = newImg
+				}
+			}
+		}
+	}
+
+	if opts.EnvSecretName != "" {
+		injectEnvFrom(objs, opts.EnvSecretName)
+	}
+
+	if opts.DeployIdentifier != "" {
+		kube.SetDeployLabels(objs, opts.DeployIdentifier)
+	}
+
+	for _, ov := range opts.Overrides {
+		matched := false
+		for _, obj := range objs {
+			if obj.GetName() != ov.Name || (ov.Kind != "" && obj.GetKind() != ov.Kind) || len(podSpecsOf(obj)) == 0 {
+				continue
+			}
+			matched = true
+			err := applyWorkloadOverride(ctx, obj, ov)
+			if err != nil {
+				return errutil.Wrap(err, "Overriding [%s]", kube.RefOf(obj))
+			}
+		}
+		if !matched {
+			return fmt.Errorf("Override for workload [%s] does not match any workload in the manifests", ov.Name)
+		}
+	}
+
+	return nil
+}
+
+// resolveImage returns the image reference that should replace img: from the image overrides if one matches, else the
+// built image with the same repo (or name) if any, else img as is.
+func resolveImage(img string, opts renderOptions) (string, error) {
+	repo, tag, digest := registry.SplitImageRef(img)
+
+	// The last matching override wins, so that flags win over the config
+	for i := len(opts.Images) - 1; i >= 0; i-- {
+		ov := opts.Images[i]
+		if ov.Name != repo {
+			continue
+		}
+		if ov.Image != "" {
+			bi, ok := findBuiltImage(opts.BuildManifest, ov.Image)
+			if !ok {
+				return "", fmt.Errorf("Image override for [%s] refers to image [%s], which is not in the build manifest", ov.Name, ov.Image)
+			}
+			return builtImageRef(bi, opts.UseTags), nil
+		}
+		if ov.NewName != "" {
+			repo = ov.NewName
+		}
+		if ov.NewTag != "" {
+			tag, digest = ov.NewTag, ""
+		}
+		if ov.Digest != "" {
+			digest = ov.Digest
+		}
+		return joinImageRef(repo, tag, digest), nil
+	}
+
+	if bi, ok := findBuiltImage(opts.BuildManifest, repo); ok {
+		return builtImageRef(bi, opts.UseTags), nil
+	}
+	return img, nil
+}
+
+// findBuiltImage finds the built image by its repo or its name.
+func findBuiltImage(bm *BuildManifest, repoOrName string) (BuiltImage, bool) {
+	if bm == nil {
+		return BuiltImage{}, false
+	}
+	for _, bi := range bm.Images {
+		if bi.Repo == repoOrName || bi.Name == repoOrName {
+			return bi, true
+		}
+	}
+	return BuiltImage{}, false
+}
+
+// builtImageRef is the reference that deploys the built image: its digest if it was pushed, else its tag.
+func builtImageRef(bi BuiltImage, useTags bool) string {
+	if !useTags && bi.Pushed && bi.Digest != "" {
+		return bi.DigestRef()
+	}
+	if refs := bi.TagRefs(); len(refs) > 0 {
+		return refs[0]
+	}
+	return bi.Repo
+}
+
+func joinImageRef(repo, tag, digest string) string {
+	ref := repo
+	if tag != "" {
+		ref += ":" + tag
+	}
+	if digest != "" {
+		ref += "@" + digest
+	}
+	return ref
+}
+
+var _replicatedKinds = []string{"Deployment", "StatefulSet", "ReplicaSet"}
+
+func applyWorkloadOverride(ctx context.Context, obj *unstructured.Unstructured, ov projectconfig.WorkloadOverride) error {
+	if ov.Replicas != nil {
+		if !slices.Contains(_replicatedKinds, obj.GetKind()) {
+			return fmt.Errorf("Replicas cannot be set on kind [%s]", obj.GetKind())
+		}
+		log.Info(ctx, "Setting replicas", "resource", kube.RefOf(obj).String(), "replicas", *ov.Replicas)
+		err := unstructured.SetNestedField(obj.Object, int64(*ov.Replicas), "spec", "replicas")
+		if err != nil {
+			return err
+		}
+	}
+
+	if ov.Resources == nil {
+		return nil
+	}
+	found := false
+	for _, spec := range podSpecsOf(obj) {
+		for _, c := range containersOf(spec) {
+			if ov.Container != "" && c["name"] != ov.Container {
+				continue
+			}
+			found = true
+			resources, _ := c["resources"].(map[string]interface{})
+			if resources == nil {
+				resources = map[string]interface{}{}
+			}
+			setResourceList(resources, "requests", ov.Resources.Requests)
+			setResourceList(resources, "limits", ov.Resources.Limits)
+			c["resources"] = resources
+		}
+	}
+	if !found {
+		return fmt.Errorf("Container [%s] not found", ov.Container)
+	}
+	return nil
+}
+
+func setResourceList(resources map[string]interface{}, key string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	list, _ := resources[key].(map[string]interface{})
+	if list == nil {
+		list = map[string]interface{}{}
+	}
+	for k, v := range values {
+		list[k] = v
+	}
+	resources[key] = list
+}
+
+// mergeObject merges the patch into dst, like a JSON merge patch (a null value removes the field), except that lists
+// of named items (e.g. containers, env, ports) are merged item by item by name, like a strategic merge patch.
+func mergeObject(dst, patch map[string]interface{}) {
+	for k, pv := range patch {
+		if pv == nil {
+			delete(dst, k)
+			continue
+		}
+		switch pv := pv.(type) {
+		case map[string]interface{}:
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeObject(dv, pv)
+				continue
+			}
+		case []interface{}:
+			if dv, ok := dst[k].([]interface{}); ok && isNamedList(dv) && isNamedList(pv) {
+				dst[k] = mergeNamedLists(dv, pv)
+				continue
+			}
+		}
+		dst[k] = pv
+	}
+}
+
+func isNamedList(list []interface{}) bool {
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := m["name"].(string); !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+func mergeNamedLists(dst, patch []interface{}) []interface{} {
+	for _, pItem := range patch {
+		pm := pItem.(map[string]interface{})
+		i := slices.IndexFunc(dst, func(dItem interface{}) bool {
+			return dItem.(map[string]interface{})["name"] == pm["name"]
+		})
+		if i < 0 {
+			dst = append(dst, pm)
+			continue
+		}
+		mergeObject(dst[i].(map[string]interface{}), pm)
+	}
+	return dst
+}
+
+// _podSpecPaths are where the pod spec is in the kinds that run containers.
+var _podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// podSpecsOf returns the pod spec of the object (as a mutable map), if it runs containers.
+func podSpecsOf(obj *unstructured.Unstructured) []map[string]interface{} {
+	path, ok := _podSpecPaths[obj.GetKind()]
+	if !ok {
+		return nil
+	}
+	var cur interface{} = obj.Object
+	for _, p := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[p]
+	}
+	spec, ok := cur.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return []map[string]interface{}{spec}
+}
+
+// containersOf returns the init containers and containers of the pod spec (as mutable maps).
+func containersOf(spec map[string]interface{}) []map[string]interface{} {
+	var ret []map[string]interface{}
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _ := spec[field].([]interface{})
+		for _, c := range list {
+			if m, ok := c.(map[string]interface{}); ok {
+				ret = append(ret, m)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,307 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/teejays/gokutil/ogconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+const _testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestResolveImage(t *testing.T) {
+	pushed := BuiltImage{Name: "app", Repo: "myrepo/app", Tags: []string{"git-1a2b3c4d5e6f"}, Digest: _testDigest, Pushed: true}
+	notPushed := BuiltImage{Name: "worker", Repo: "myrepo/worker", Tags: []string{"git-1a2b3c4d5e6f"}}
+	bm := &BuildManifest{Images: []BuiltImage{pushed, notPushed}}
+
+	tests := []struct {
+		name    string
+		img     string
+		opts    renderOptions
+		want    string
+		wantErr string
+	}{
+		{
+			name: "pushed image is pinned by digest",
+			img:  "myrepo/app:latest",
+			opts: renderOptions{BuildManifest: bm},
+			want: "myrepo/app@" + _testDigest,
+		},
+		{
+			name: "pushed image by tag with --use-tags",
+			img:  "myrepo/app:latest",
+			opts: renderOptions{BuildManifest: bm, UseTags: true},
+			want: "myrepo/app:git-1a2b3c4d5e6f",
+		},
+		{
+			name: "image built with --no-push falls back to its tag",
+			img:  "myrepo/worker",
+			opts: renderOptions{BuildManifest: bm},
+			want: "myrepo/worker:git-1a2b3c4d5e6f",
+		},
+		{
+			name: "built image found by name",
+			img:  "app",
+			opts: renderOptions{BuildManifest: bm},
+			want: "myrepo/app@" + _testDigest,
+		},
+		{
+			name: "image that was not built is left alone",
+			img:  "postgres:16",
+			opts: renderOptions{BuildManifest: bm},
+			want: "postgres:16",
+		},
+		{
+			name: "no build manifest",
+			img:  "myrepo/app:latest",
+			opts: renderOptions{},
+			want: "myrepo/app:latest",
+		},
+		{
+			name: "override with a new tag drops the digest",
+			img:  "postgres:16@" + _testDigest,
+			opts: renderOptions{Images: []projectconfig.ImageOverride{{Name: "postgres", NewTag: "17"}}},
+			want: "postgres:17",
+		},
+		{
+			name: "override with a new name and digest",
+			img:  "postgres:16",
+			opts: renderOptions{Images: []projectconfig.ImageOverride{{Name: "postgres", NewName: "mirror/postgres", Digest: _testDigest}}},
+			want: "mirror/postgres:16@" + _testDigest,
+		},
+		{
+			name: "override wins over the built image",
+			img:  "myrepo/app:latest",
+			opts: renderOptions{BuildManifest: bm, Images: []projectconfig.ImageOverride{{Name: "myrepo/app", NewTag: "v2"}}},
+			want: "myrepo/app:v2",
+		},
+		{
+			name: "override with a built image",
+			img:  "placeholder",
+			opts: renderOptions{BuildManifest: bm, Images: []projectconfig.ImageOverride{{Name: "placeholder", Image: "worker"}}},
+			want: "myrepo/worker:git-1a2b3c4d5e6f",
+		},
+		{
+			name: "the last override wins",
+			img:  "postgres:16",
+			opts: renderOptions{Images: []projectconfig.ImageOverride{{Name: "postgres", NewTag: "17"}, {Name: "postgres", NewTag: "18"}}},
+			want: "postgres:18",
+		},
+		{
+			name:    "override with an image that was not built",
+			img:     "placeholder",
+			opts:    renderOptions{BuildManifest: bm, Images: []projectconfig.ImageOverride{{Name: "placeholder", Image: "missing"}}},
+			wantErr: "not in the build manifest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveImage(tt.img, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveImage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveImage() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveImage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func decodeYAML(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	err := yaml.Unmarshal([]byte(s), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMergeObject(t *testing.T) {
+	tests := []struct {
+		name  string
+		dst   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "maps are merged",
+			dst:   "metadata: {name: app, labels: {tier: web}}",
+			patch: "metadata: {labels: {team: core}}",
+			want:  "metadata: {name: app, labels: {tier: web, team: core}}",
+		},
+		{
+			name:  "null removes a field",
+			dst:   "metadata: {labels: {tier: web, team: core}}",
+			patch: "metadata: {labels: {team: null}}",
+			want:  "metadata: {labels: {tier: web}}",
+		},
+		{
+			name:  "named lists are merged by name",
+			dst:   "containers: [{name: app, image: a, env: [{name: A, value: '1'}]}, {name: sidecar, image: s}]",
+			patch: "containers: [{name: app, env: [{name: A, value: '2'}, {name: B, value: '3'}]}, {name: extra, image: e}]",
+			want:  "containers: [{name: app, image: a, env: [{name: A, value: '2'}, {name: B, value: '3'}]}, {name: sidecar, image: s}, {name: extra, image: e}]",
+		},
+		{
+			name:  "other lists are replaced",
+			dst:   "args: [a, b]",
+			patch: "args: [c]",
+			want:  "args: [c]",
+		},
+		{
+			name:  "values are replaced",
+			dst:   "spec: {replicas: 1}",
+			patch: "spec: {replicas: 3}",
+			want:  "spec: {replicas: 3}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := decodeYAML(t, tt.dst)
+			mergeObject(dst, decodeYAML(t, tt.patch))
+			if want := decodeYAML(t, tt.want); !reflect.DeepEqual(dst, want) {
+				t.Errorf("mergeObject() = %v, want %v", dst, want)
+			}
+		})
+	}
+}
+
+const _testRenderManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: backend
+  labels:
+    tier: web
+    app.kubernetes.io/managed-by: kubectl
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        image: myrepo/app:latest
+`
+
+func TestRenderObjects(t *testing.T) {
+	replicas := func(n int) *int { return &n }
+	bm := &BuildManifest{Images: []BuiltImage{{Name: "app", Repo: "myrepo/app", Tags: []string{"v1"}, Digest: _testDigest, Pushed: true}}}
+
+	tests := []struct {
+		name         string
+		opts         renderOptions
+		wantImage    string
+		wantReplicas int64
+		wantErr      string
+	}{
+		{
+			name:         "built image and labels",
+			opts:         renderOptions{DeployIdentifier: "myapp", BuildManifest: bm},
+			wantImage:    "myrepo/app@" + _testDigest,
+			wantReplicas: 1,
+		},
+		{
+			name: "the last override of a workload wins",
+			opts: renderOptions{DeployIdentifier: "myapp", BuildManifest: bm, Overrides: []projectconfig.WorkloadOverride{
+				{Name: "backend", Replicas: replicas(2)},
+				{Name: "backend", Kind: "Deployment", Replicas: replicas(3)},
+			}},
+			wantImage:    "myrepo/app@" + _testDigest,
+			wantReplicas: 3,
+		},
+		{
+			name: "overrides are applied after the patches",
+			opts: renderOptions{
+				DeployIdentifier: "myapp",
+				Patches:          []*unstructured.Unstructured{{Object: decodeYAML(t, "{apiVersion: apps/v1, kind: Deployment, metadata: {name: backend}, spec: {replicas: 5, template: {spec: {containers: [{name: app, image: myrepo/app:patched}]}}}}")}},
+				Overrides:        []projectconfig.WorkloadOverride{{Name: "backend", Replicas: replicas(4)}},
+				Images:           []projectconfig.ImageOverride{{Name: "myrepo/app", NewTag: "v2"}},
+			},
+			wantImage:    "myrepo/app:v2",
+			wantReplicas: 4,
+		},
+		{
+			name:    "override of a missing workload",
+			opts:    renderOptions{Overrides: []projectconfig.WorkloadOverride{{Name: "frontend", Replicas: replicas(2)}}},
+			wantErr: "does not match any workload",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := kube.DecodeManifest([]byte(_testRenderManifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = renderObjects(context.Background(), objs, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("renderObjects() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderObjects() error = %v", err)
+			}
+			obj := objs[0]
+
+			image := containersOf(podSpecsOf(obj)[0])[0]["image"]
+			if image != tt.wantImage {
+				t.Errorf("renderObjects() image = %v, want %s", image, tt.wantImage)
+			}
+			// The decoded manifest has float64 numbers, the overrides int64
+			n, _, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "replicas")
+			if fmt.Sprint(n) != fmt.Sprint(tt.wantReplicas) {
+				t.Errorf("renderObjects() replicas = %v, want %d", n, tt.wantReplicas)
+			}
+			// The labels of the manifest are kept, and og's win
+			wantLabels := map[string]string{
+				"tier":                     "web",
+				kube.LabelManagedBy:        kube.FieldManager,
+				kube.LabelDeployIdentifier: "myapp",
+			}
+			if !reflect.DeepEqual(obj.GetLabels(), wantLabels) {
+				t.Errorf("renderObjects() labels = %v, want %v", obj.GetLabels(), wantLabels)
+			}
+		})
+	}
+}
+
+func TestGetRenderOptionsFlagsWin(t *testing.T) {
+	var cfg ogconfig.Config
+	cfg.AppRootPath.Full = t.TempDir()
+	var pcfg projectconfig.Config
+	two := 2
+	pcfg.Deploy.Kubernetes.Images = []projectconfig.ImageOverride{{Name: "postgres", NewTag: "16"}}
+	pcfg.Deploy.Kubernetes.Overrides = []projectconfig.WorkloadOverride{{Name: "backend", Replicas: &two}}
+
+	opts, err := getRenderOptions(context.Background(), cfg, pcfg, false, RenderFlags{
+		SetImages: []string{"postgres=postgres:17"},
+		Replicas:  []string{"backend=3"},
+	}, CommonFlags{DeployIdentifier: "myapp"})
+	if err != nil {
+		t.Fatalf("getRenderOptions() error = %v", err)
+	}
+	img, err := resolveImage("postgres:15", opts)
+	if err != nil || img != "postgres:17" {
+		t.Errorf("resolveImage() = %q, %v, want the --set-image postgres:17", img, err)
+	}
+	if last := opts.Overrides[len(opts.Overrides)-1]; last.Replicas == nil || *last.Replicas != 3 {
+		t.Errorf("getRenderOptions() last override = %+v, want the --replicas 3", last)
+	}
+	if opts.EnvSecretName != "myapp-env" {
+		t.Errorf("getRenderOptions() env secret = %q, want myapp-env", opts.EnvSecretName)
+	}
+}
@@ -0,0 +1,311 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+type (
+	HistoryArgs struct {
+		KubeFlags
+	}
+
+	RollbackArgs struct {
+		To int `arg:"--to" help:"The revision to roll back to (see deploy history). Defaults to the last successfully deployed revision before the current one."`
+
+		KubeFlags
+		WaitTimeout time.Duration `arg:"--wait-timeout,env:GOKU_DEPLOY_WAIT_TIMEOUT" help:"How long to wait for the workloads to be ready e.g. 5m. Defaults to 5m."`
+		NoWait      bool          `arg:"--no-wait" help:"Do not wait for the workloads to be ready"`
+	}
+)
+
+type releaseOptions struct {
+	Description string
+	GitCommit   string
+	Wait        bool
+	WaitTimeout time.Duration
+}
+
+// applyRelease applies the objects in the default namespace of the client, records the release in the history and waits for its workloads to be ready. The
+// returned release has the final status, even if an error is returned.
+func applyRelease(ctx context.Context, kc *kube.Client, hist *ReleaseHistory, objs []*unstructured.Unstructured, opts releaseOptions) (Release, error) {
+	rel := Release{
+		Namespace:   kc.Namespace,
+		Description: opts.Description,
+		GitCommit:   opts.GitCommit,
+		User:        currentUser(),
+		Status:      ReleasePending,
+	}
+	if !opts.Wait {
+		rel.Status = ReleaseUnknown
+	}
+
+	results, applyErr := kc.Apply(ctx, objs, kube.ApplyOptions{})
+	for _, res := range results {
+		log.Info(ctx, "Applied resource", "resource", res.ObjectRef.String(), "result", res.Action)
+	}
+	if applyErr != nil {
+		rel.Status = ReleaseFailed
+	}
+
+	// The history is kept even if og is interrupted, since some of the objects may be applied already
+	histCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), _historyTimeout)
+	defer cancel()
+	rel, err := hist.Record(histCtx, rel, objs)
+	if err != nil {
+		// The objects are applied, so the missing history should not fail the deploy
+		log.Warn(ctx, "Could not record the release in the history", "error", err)
+		rel.Revision = 0
+	} else {
+		log.Info(ctx, "Recorded release", "revision", rel.Revision)
+	}
+	rel.Resources = results
+
+	if applyErr != nil && interrupt.Interrupted() {
+		return rel, errutil.Wrap(applyErr, "Interrupted while applying the k8s manifests, some may not be applied. Run the deploy again")
+	}
+	if applyErr != nil {
+		return rel, errutil.Wrap(applyErr, "Applying k8s manifests")
+	}
+	if !opts.Wait {
+		return rel, nil
+	}
+
+	// Wait for the workloads that were applied to be ready
+	var refs []kube.ObjectRef
+	for _, res := range results {
+		refs = append(refs, res.ObjectRef)
+	}
+	statuses, waitErr := kc.WaitReady(ctx, refs, opts.WaitTimeout)
+	for _, st := range statuses {
+		log.Info(ctx, "Workload status", "workload", st.ObjectRef.String(), "ready", st.Ready, "status", st.Message)
+	}
+
+	rel.Status = ReleaseDeployed
+	if waitErr != nil {
+		rel.Status = ReleaseFailed
+	}
+	if waitErr != nil && interrupt.Interrupted() {
+		// The workloads may still become ready
+		rel.Status = ReleaseUnknown
+	}
+	if rel.Revision > 0 {
+		err = hist.SetStatus(histCtx, rel.Revision, rel.Status)
+		if err != nil {
+			log.Warn(ctx, "Could not update the release status in the history", "revision", rel.Revision, "error", err)
+		}
+	}
+
+	if waitErr != nil && interrupt.Interrupted() {
+		return rel, errutil.Wrap(waitErr, "Interrupted while waiting for the workloads to be ready. The release is applied: check it with og deploy status, or roll it back with og deploy rollback")
+	}
+	if waitErr != nil {
+		return rel, errutil.Wrap(waitErr, "Waiting for workloads to be ready")
+	}
+	return rel, nil
+}
+
+// rollbackTo re-applies the objects of a previous release, as a new release, in the namespace the release was applied
+// in. The sealed secret (if any) is applied first: it's not kept in the history, so its current values are used.
+func rollbackTo(ctx context.Context, kc *kube.Client, hist *ReleaseHistory, rev int, secret *unstructured.Unstructured, wait bool, waitTimeout time.Duration) (Release, error) {
+	prev, objs, err := hist.Get(ctx, rev)
+	if err != nil {
+		return Release{}, err
+	}
+	log.Info(ctx, "Rolling back", "revision", prev.Revision, "namespace", prev.Namespace, "images", strings.Join(prev.Images, ", "))
+
+	// Releases recorded before the namespace was kept were applied in the namespace of the history
+	if prev.Namespace != "" && prev.Namespace != kc.Namespace {
+		nkc := *kc
+		nkc.Namespace = prev.Namespace
+		kc = &nkc
+	}
+
+	var secretResults []kube.Result
+	if secret != nil {
+		secret = secret.DeepCopy()
+		secret.SetNamespace(kc.Namespace)
+		secretResults, err = kc.Apply(ctx, []*unstructured.Unstructured{secret}, kube.ApplyOptions{})
+		if err != nil {
+			return Release{}, errutil.Wrap(err, "Applying sealed secrets")
+		}
+	}
+
+	rel, err := applyRelease(ctx, kc, hist, objs, releaseOptions{
+		Description: fmt.Sprintf("Rollback to revision %d", prev.Revision),
+		GitCommit:   prev.GitCommit,
+		Wait:        wait,
+		WaitTimeout: waitTimeout,
+	})
+	rel.Resources = append(secretResults, rel.Resources...)
+	return rel, err
+}
+
+func RunHistory(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *HistoryArgs, commonFlags CommonFlags) error {
+	ks, err := GetKubeSettings(cfg, pcfg, args.KubeFlags, nil, 0)
+	if err != nil {
+		return errutil.Wrap(err, "Resolving kubernetes settings")
+	}
+	kc, err := ks.NewClient(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Creating kubernetes client")
+	}
+
+	releases, err := NewReleaseHistory(kc, commonFlags.DeployIdentifier, ks.HistoryLimit).List(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Listing releases")
+	}
+	if len(releases) == 0 {
+		log.Info(ctx, "No releases found", "deployIdentifier", commonFlags.DeployIdentifier, "namespace", kc.Namespace)
+	}
+
+	list := ReleaseList{DeployIdentifier: commonFlags.DeployIdentifier, Releases: output.NonNil(releases)}
+	return output.Print(os.Stdout, list, func(w io.Writer) { printReleases(w, list) })
+}
+
+// ReleaseList is the result of history: the releases of the deployment, oldest first.
+type ReleaseList struct {
+	DeployIdentifier string    `json:"deploy_identifier"`
+	Releases         []Release `json:"releases"`
+}
+
+// Identifiers returns the revisions of the releases.
+func (l ReleaseList) Identifiers() []string {
+	var ret []string
+	for _, rel := range l.Releases {
+		ret = append(ret, strconv.Itoa(rel.Revision))
+	}
+	return ret
+}
+
+func printReleases(w io.Writer, list ReleaseList) {
+	if len(list.Releases) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "REVISION\tSTATUS\tCREATED\tUSER\tGIT COMMIT\tMANIFEST\tIMAGES\tDESCRIPTION")
+	for _, rel := range list.Releases {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			rel.Revision,
+			rel.Status,
+			rel.CreatedAt.Local().Format(time.DateTime),
+			rel.User,
+			shortCommit(rel.GitCommit),
+			shorten(strings.TrimPrefix(rel.ManifestHash, "sha256:"), 12),
+			strings.Join(rel.Images, ","),
+			rel.Description,
+		)
+	}
+}
+
+func RunRollback(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *RollbackArgs, commonFlags CommonFlags) error {
+	ks, err := GetKubeSettings(cfg, pcfg, args.KubeFlags, nil, args.WaitTimeout)
+	if err != nil {
+		return errutil.Wrap(err, "Resolving kubernetes settings")
+	}
+	kc, err := ks.NewClient(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Creating kubernetes client")
+	}
+	hist := NewReleaseHistory(kc, commonFlags.DeployIdentifier, ks.HistoryLimit)
+
+	rev := args.To
+	if rev == 0 {
+		releases, err := hist.List(ctx)
+		if err != nil {
+			return errutil.Wrap(err, "Listing releases")
+		}
+		if len(releases) == 0 {
+			return fmt.Errorf("No releases found for deploy identifier [%s] in namespace [%s]", commonFlags.DeployIdentifier, kc.Namespace)
+		}
+		prev, ok, err := hist.LastDeployed(ctx, releases[len(releases)-1].Revision)
+		if err != nil {
+			return errutil.Wrap(err, "Finding previous release")
+		}
+		if !ok {
+			return fmt.Errorf("There is no successfully deployed release to roll back to. Use --to to pick a revision.")
+		}
+		rev = prev.Revision
+	}
+
+	var secret *unstructured.Unstructured
+	if IsSealedSecrets(pcfg) {
+		secret, err = sealedSecretObject(ctx, cfg, pcfg, commonFlags, ks.Namespace)
+		if err != nil {
+			return errutil.Wrap(err, "Rendering sealed secrets")
+		}
+	}
+
+	rel, err := rollbackTo(ctx, kc, hist, rev, secret, !args.NoWait, ks.WaitTimeout)
+	if err != nil {
+		return ogerr.Mark(errutil.Wrap(err, "Rolling back to revision [%d]", rev), ogerr.CategoryDeploy)
+	}
+	log.Info(ctx, "Rolled back", "to", rev, "revision", rel.Revision, "status", rel.Status)
+
+	return output.Print(os.Stdout, rel, func(w io.Writer) { printRelease(w, rel) })
+}
+
+// printRelease prints the release made by apply or rollback, and what it did to each resource.
+func printRelease(w io.Writer, rel Release) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+
+	if rel.Revision > 0 {
+		fmt.Fprintf(tw, "Release %d of %s: %s\n", rel.Revision, rel.DeployIdentifier, rel.Status)
+	} else {
+		// Targets without a release history
+		fmt.Fprintf(tw, "Release of %s: %s\n", rel.DeployIdentifier, rel.Status)
+	}
+	if len(rel.Resources) == 0 {
+		return
+	}
+	fmt.Fprintln(tw, "\nRESOURCE\tNAMESPACE\tACTION")
+	for _, res := range rel.Resources {
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\n", res.Kind, res.Name, firstNonEmpty(res.Namespace, "-"), res.Action)
+	}
+}
+
+// gitCommit returns the current commit of the app, if it's in a git repository.
+func gitCommit(ctx context.Context, cfg ogconfig.Config, commonFlags CommonFlags) string {
+	info, err := appGitInfo(ctx, cfg, commonFlags)
+	if err != nil {
+		log.Debug(ctx, "Could not get git info", "error", err)
+		return ""
+	}
+	if info.Dirty {
+		return info.Commit + "-dirty"
+	}
+	return info.Commit
+}
+
+func shortCommit(commit string) string {
+	if c, ok := strings.CutSuffix(commit, "-dirty"); ok {
+		return shorten(c, 12) + "-dirty"
+	}
+	return shorten(commit, 12)
+}
+
+func shorten(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/ogconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/secrets"
+)
+
+const _defaultExampleEnvFile = ".env.example"
+
+// SecretName is the name of the Kubernetes Secret with the app's env variables.
+func SecretName(pcfg projectconfig.Config, commonFlags CommonFlags) string {
+	return firstNonEmpty(pcfg.Secrets.Name, commonFlags.DeployIdentifier+"-env")
+}
+
+// SealedSecretsPath is the full path of the sealed secrets file of the environment.
+func SealedSecretsPath(cfg ogconfig.Config, pcfg projectconfig.Config) string {
+	p := pcfg.Secrets.SealedFile
+	if p == "" {
+		p = filepath.Join("infra", "secrets", firstNonEmpty(pcfg.EnvName, "default")+".sealed.yaml")
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(cfg.AppRootPath.Full, p)
+}
+
+// ExampleEnvFilePath is the full path of the example env file, that lists the variables the app needs.
+func ExampleEnvFilePath(cfg ogconfig.Config, pcfg projectconfig.Config) string {
+	p := firstNonEmpty(pcfg.Secrets.ExampleFile, _defaultExampleEnvFile)
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(cfg.AppRootPath.Full, p)
+}
+
+// IsSealedSecrets returns true if the secrets are kept sealed in the repo (rather than only in the cluster).
+func IsSealedSecrets(pcfg projectconfig.Config) bool {
+	return pcfg.Secrets.Store == secrets.StoreSealed
+}
+
+// GetSecretsStore returns the store of the app's secrets. The client is only needed for the cluster store.
+func GetSecretsStore(cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, kc *kube.Client) (secrets.Store, error) {
+	switch pcfg.Secrets.Store {
+	case secrets.StoreSealed:
+		return secrets.SealedStore{
+			Path:       SealedSecretsPath(cfg, pcfg),
+			Recipients: pcfg.Secrets.Recipients,
+		}, nil
+	case "", secrets.StoreCluster:
+		if kc == nil {
+			return nil, errutil.New("A kubernetes client is needed for the cluster secrets store")
+		}
+		return secrets.ClusterStore{
+			Client:     kc,
+			Namespace:  kc.Namespace,
+			SecretName: SecretName(pcfg, commonFlags),
+			Labels:     kube.BookkeepingLabels(commonFlags.DeployIdentifier, kube.RoleEnv),
+		}, nil
+	default:
+		return nil, errutil.New("Unknown secrets store [%s] in %s. Options: %s, %s", pcfg.Secrets.Store, projectconfig.FileName, secrets.StoreCluster, secrets.StoreSealed)
+	}
+}
+
+// sealedSecretObject returns the Kubernetes Secret with the sealed secrets (decrypted), labelled for the deployment. It's
+// applied apart from the rendered objects, so that its values are not recorded in the release history. It returns nil
+// if there are no sealed secrets.
+func sealedSecretObject(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, namespace string) (*unstructured.Unstructured, error) {
+	store, err := GetSecretsStore(cfg, pcfg, commonFlags, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := store.Load(ctx)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Loading secrets from [%s]", store.Name())
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	secret := secrets.NewSecret(SecretName(pcfg, commonFlags), "", nil, values)
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Converting secret")
+	}
+	obj := &unstructured.Unstructured{Object: u}
+	// Empty fields that the converter adds, and that would be applied as if they were set
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	obj.SetNamespace(namespace)
+	kube.SetDeployLabels([]*unstructured.Unstructured{obj}, commonFlags.DeployIdentifier)
+	return obj, nil
+}
+
+// injectEnvFrom adds the secret to the env variables of all the containers of the workloads (if not already there). The
+// secret is optional, so that the pods start even if it does not exist.
+func injectEnvFrom(objs []*unstructured.Unstructured, secretName string) {
+	for _, obj := range objs {
+		for _, spec := range podSpecsOf(obj) {
+			for _, c := range containersOf(spec) {
+				envFrom, _ := c["envFrom"].([]interface{})
+				exists := slices.ContainsFunc(envFrom, func(e interface{}) bool {
+					m, _ := e.(map[string]interface{})
+					ref, _ := m["secretRef"].(map[string]interface{})
+					return ref != nil && ref["name"] == secretName
+				})
+				if exists {
+					continue
+				}
+				c["envFrom"] = append(envFrom, map[string]interface{}{
+					"secretRef": map[string]interface{}{"name": secretName, "optional": true},
+				})
+			}
+		}
+	}
+}
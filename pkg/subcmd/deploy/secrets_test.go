@@ -0,0 +1,67 @@
+package deploy
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/teejays/gokutil/ogconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube/kubefake"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/secrets"
+)
+
+// secretObject returns a Secret of the manifests, labelled for the deployment.
+func secretObject(t *testing.T, name string, identifier string) *unstructured.Unstructured {
+	t.Helper()
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secrets.NewSecret(name, "", nil, map[string]string{"key": "value"}))
+	if err != nil {
+		t.Fatalf("Converting secret: %v", err)
+	}
+	obj := &unstructured.Unstructured{Object: u}
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	kube.SetDeployLabels([]*unstructured.Unstructured{obj}, identifier)
+	return obj
+}
+
+func TestPruneSkipsClusterSecretsStore(t *testing.T) {
+	ctx := context.Background()
+	kc := kubefake.NewClient("apps")
+	commonFlags := CommonFlags{DeployIdentifier: "myapp"}
+
+	store, err := GetSecretsStore(ogconfig.Config{}, projectconfig.Config{}, commonFlags, kc)
+	if err != nil {
+		t.Fatalf("GetSecretsStore() error = %v", err)
+	}
+	err = store.Save(ctx, map[string]string{"DATABASE_URL": "postgres://"})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	deployed := []*unstructured.Unstructured{secretObject(t, "tls", "myapp"), secretObject(t, "removed", "myapp")}
+	_, err = kc.Apply(ctx, deployed, kube.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	prunable, err := kc.FindPrunable(ctx, deployed[:1], "myapp")
+	if err != nil {
+		t.Fatalf("FindPrunable() error = %v", err)
+	}
+	var names []string
+	for _, obj := range prunable {
+		names = append(names, obj.GetName())
+	}
+	if !slices.Equal(names, []string{"removed"}) {
+		t.Fatalf("FindPrunable() = %v, want [removed] (and not the %s of the secrets store)", names, SecretName(projectconfig.Config{}, commonFlags))
+	}
+
+	values, err := store.Load(ctx)
+	if err != nil || values["DATABASE_URL"] != "postgres://" {
+		t.Fatalf("Load() = %v, %v, want the saved values", values, err)
+	}
+}
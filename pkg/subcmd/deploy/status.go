@@ -0,0 +1,165 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/registry"
+)
+
+// States of a running image, compared with the build manifest
+const (
+	ImageStateCurrent  = "current"
+	ImageStateOutdated = "outdated"
+	ImageStateUnknown  = "unknown"
+)
+
+type (
+	StatusArgs struct {
+		KubeFlags
+		Manifests []string `arg:"--manifest,separate" help:"Path(s) to the k8s manifest file(s) of the deployment, relative to the app root. Only used to find its namespaces. Defaults to infra/.goku/generated/k3s/app.yaml."`
+	}
+)
+
+// RunStatus prints the live state of the deployment: its workloads, the images they run compared with the last build,
+// recent warnings and the endpoints to reach it.
+func RunStatus(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *StatusArgs, commonFlags CommonFlags) error {
+	t, err := GetTarget(ctx, cfg, pcfg, commonFlags, TargetSettings{KubeFlags: args.KubeFlags, Manifests: args.Manifests})
+	if err != nil {
+		return err
+	}
+	report, err := t.Status(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Getting status from the %s target", t.Name())
+	}
+
+	// Compare the running images with the last build
+	bm, err := LoadBuildManifest(ctx, getBuildManifestPath(cfg.AppRootPath.Full, commonFlags))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errutil.Wrap(err, "Loading build manifest")
+	}
+	if err == nil {
+		compareImages(&report, &bm)
+	}
+
+	if len(report.Workloads) == 0 {
+		log.Warn(ctx, "No workloads found for the deployment. Has it been deployed?", "deployIdentifier", report.DeployIdentifier)
+	}
+	report.Workloads = output.NonNil(report.Workloads)
+	report.Endpoints = output.NonNil(report.Endpoints)
+	return output.Print(os.Stdout, report, func(w io.Writer) { printStatus(w, report) })
+}
+
+// compareImages sets the built digest and the image state of the containers whose image was built by docker-image.
+func compareImages(report *StatusReport, bm *BuildManifest) {
+	for i := range report.Workloads {
+		for j := range report.Workloads[i].Containers {
+			c := &report.Workloads[i].Containers[j]
+			repo, _, _ := registry.SplitImageRef(c.Image)
+			bi, ok := findBuiltImage(bm, repo)
+			if !ok {
+				continue
+			}
+			c.BuiltDigest = bi.Digest
+			switch {
+			case bi.Digest == "" || len(c.RunningDigests) == 0:
+				c.ImageState = ImageStateUnknown
+			case len(c.RunningDigests) == 1 && c.RunningDigests[0] == bi.Digest:
+				c.ImageState = ImageStateCurrent
+			default:
+				c.ImageState = ImageStateOutdated
+			}
+		}
+	}
+}
+
+func printStatus(w io.Writer, report StatusReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+
+	header := fmt.Sprintf("Deployment %s (target: %s", report.DeployIdentifier, report.Target)
+	if report.Env != "" {
+		header += ", env: " + report.Env
+	}
+	fmt.Fprintln(tw, header+")")
+	if rel := report.LastRelease; rel != nil {
+		fmt.Fprintf(tw, "Last release: revision %d, %s, %s ago by %s", rel.Revision, rel.Status, time.Since(rel.CreatedAt).Round(time.Second), firstNonEmpty(rel.User, "unknown"))
+		if rel.GitCommit != "" {
+			fmt.Fprintf(tw, ", commit %s", shortCommit(rel.GitCommit))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintln(tw, "\nWORKLOAD\tREADY\tRESTARTS\tSTATUS\tMESSAGE")
+	for _, ws := range report.Workloads {
+		status := "progressing"
+		switch {
+		case ws.Failed:
+			status = "failed"
+		case ws.Ready:
+			status = "ready"
+		}
+		fmt.Fprintf(tw, "%s/%s\t%d/%d\t%d\t%s\t%s\n", ws.Kind, ws.Name, ws.ReadyReplicas, ws.Desired, ws.Restarts, status, ws.Message)
+	}
+
+	fmt.Fprintln(tw, "\nWORKLOAD\tCONTAINER\tIMAGE\tRUNNING\tBUILT\tSTATE")
+	for _, ws := range report.Workloads {
+		for _, c := range ws.Containers {
+			var running []string
+			for _, d := range c.RunningDigests {
+				running = append(running, shortDigest(d))
+			}
+			fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\t%s\t%s\n", ws.Kind, ws.Name, c.Name, shortImage(c.Image), strings.Join(running, ","), shortDigest(c.BuiltDigest), firstNonEmpty(c.ImageState, "-"))
+		}
+	}
+
+	if len(report.Endpoints) > 0 {
+		fmt.Fprintln(tw, "\nENDPOINT\tTYPE\tADDRESSES\tPORTS")
+		for _, ep := range report.Endpoints {
+			fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\n", ep.Kind, ep.Name, ep.Type, strings.Join(ep.Addresses, ","), strings.Join(ep.Ports, ","))
+		}
+	}
+
+	if slices.ContainsFunc(report.Workloads, func(ws WorkloadStatus) bool { return len(ws.Warnings) > 0 }) {
+		fmt.Fprintln(tw, "\nRecent warnings:")
+		for _, ws := range report.Workloads {
+			for _, warning := range ws.Warnings {
+				fmt.Fprintf(tw, "  %s/%s: %s\n", ws.Kind, ws.Name, warning)
+			}
+		}
+	}
+}
+
+// shortDigest returns the first 12 characters of the digest's hash, like docker does.
+func shortDigest(digest string) string {
+	if digest == "" {
+		return "-"
+	}
+	_, hash, ok := strings.Cut(digest, ":")
+	if !ok {
+		hash = digest
+	}
+	return shorten(hash, 12)
+}
+
+// shortImage removes the digest from the image reference, since it's shown in its own column.
+func shortImage(ref string) string {
+	repo, tag, _ := registry.SplitImageRef(ref)
+	if tag == "" {
+		return repo
+	}
+	return repo + ":" + tag
+}
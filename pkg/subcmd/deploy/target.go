@@ -0,0 +1,282 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// DeployTarget is where the app is deployed e.g. a Kubernetes cluster, or a single host running Docker Compose.
+// Implementations are selected with deploy.target in the project config (which can be set per environment).
+type DeployTarget interface {
+	// Name is the value used to select the target in the project config
+	Name() string
+	// Plan returns the changes that Apply would make, without making them
+	Plan(ctx context.Context, opts PlanOptions) ([]PlannedChange, error)
+	// Apply deploys the app as a new release, and returns the release
+	Apply(ctx context.Context, opts ApplyOptions) (Release, error)
+	// Status returns the live state of the deployment: its workloads and how to reach them
+	Status(ctx context.Context) (StatusReport, error)
+	// Destroy removes the deployment
+	Destroy(ctx context.Context, opts DestroyOptions) error
+	// Logs passes the lines of the logs of the workloads of the deployment to opts.Handle
+	Logs(ctx context.Context, opts LogsOptions) error
+	// Tunnel makes services of the deployment reachable from the local machine until the context is done, and calls
+	// opts.Ready once they are. If they can all be reached directly (without forwarding), it returns after opts.Ready.
+	Tunnel(ctx context.Context, opts TunnelOptions) error
+	// Exec runs a command in a running container of a workload, or in a new one-off container like it. If the command
+	// exits with a non-zero code, it returns ogerr.ExitCodeError.
+	Exec(ctx context.Context, opts ExecOptions) error
+	// Lock takes the named lock of the deployment (e.g. so that migrations do not run concurrently), and returns the
+	// function that releases it. The work done under the lock uses lockCtx, which is ctx cancelled if the lock is lost
+	// (see kube.ErrLockLost). It fails with a LockHeldError if someone else holds the lock.
+	Lock(ctx context.Context, name string) (lockCtx context.Context, release func(context.Context) error, err error)
+	// Unlock releases the named lock, whoever holds it (e.g. if its holder crashed)
+	Unlock(ctx context.Context, name string) error
+}
+
+// Names of the supported deploy targets
+const (
+	TargetKubernetes = "kubernetes"
+	TargetCompose    = "compose"
+)
+
+// TargetSettings are the flags that select and shape what's deployed. Each target uses the ones that apply to it.
+type TargetSettings struct {
+	UseTags bool
+	RenderFlags
+	KubeFlags
+	Manifests   []string
+	WaitTimeout time.Duration
+}
+
+// newTargetFunc creates a target from the settings.
+type newTargetFunc func(cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, s TargetSettings) (DeployTarget, error)
+
+// _targets are the supported targets. New targets (e.g. a cloud's app platform) only need to be added here.
+var _targets = []struct {
+	name string
+	new  newTargetFunc
+}{
+	{TargetKubernetes, newKubernetesTarget},
+	{TargetCompose, newComposeTarget},
+}
+
+// TargetNames returns the names of the supported deploy targets.
+func TargetNames() []string {
+	var names []string
+	for _, t := range _targets {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// TargetName returns the name of the target selected in the project config (kubernetes by default).
+func TargetName(pcfg projectconfig.Config) string {
+	return firstNonEmpty(pcfg.Deploy.Target, TargetKubernetes)
+}
+
+// GetTarget returns the deploy target selected in the project config.
+func GetTarget(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, s TargetSettings) (DeployTarget, error) {
+	name := TargetName(pcfg)
+	for _, t := range _targets {
+		if t.name != name {
+			continue
+		}
+		log.Debug(ctx, "Using deploy target", "target", name, "env", pcfg.EnvName)
+		return t.new(cfg, pcfg, commonFlags, s)
+	}
+	return nil, fmt.Errorf("Unknown deploy target [%s] in %s. Options: %s", name, projectconfig.FileName, strings.Join(TargetNames(), ", "))
+}
+
+// requireTarget returns an error if the selected target is not the given one, for the subcommands that only make sense
+// for one target.
+func requireTarget(pcfg projectconfig.Config, name string, subcmd string) error {
+	if TargetName(pcfg) != name {
+		return ogerr.New(ogerr.CategoryUsage, "Subcommand [%s] is only supported by the %s target, and the deploy target is [%s]", subcmd, name, TargetName(pcfg))
+	}
+	return nil
+}
+
+// PlannedChange is a change that applying the deployment would make to one resource.
+type PlannedChange struct {
+	// Resource identifies the resource e.g. Deployment/backend (namespace: prod)
+	Resource string `json:"resource"`
+	// Action is created, configured, unchanged or deleted
+	Action kube.Action `json:"action"`
+	// Diff is the unified diff of the resource (empty if unchanged)
+	Diff string `json:"diff,omitempty"`
+}
+
+// StatusReport is the live state of a deployment. It's the result of status.
+type StatusReport struct {
+	Target           string `json:"target"`
+	DeployIdentifier string `json:"deploy_identifier"`
+	Env              string `json:"env,omitempty"`
+	// LastRelease is the latest release, for the targets that keep a release history
+	LastRelease *Release         `json:"last_release,omitempty"`
+	Workloads   []WorkloadStatus `json:"workloads"`
+	Endpoints   []Endpoint       `json:"endpoints"`
+}
+
+// Identifiers returns the workloads of the deployment e.g. Deployment/backend.
+func (r StatusReport) Identifiers() []string {
+	var ret []string
+	for _, ws := range r.Workloads {
+		ret = append(ret, ws.Kind+"/"+ws.Name)
+	}
+	return ret
+}
+
+// WorkloadStatus is the status of one workload (a k8s Deployment, a compose service, etc.) of the deployment.
+type WorkloadStatus struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Ready     bool   `json:"ready"`
+	Failed    bool   `json:"failed"`
+	Message   string `json:"message"`
+	// Desired and ReadyReplicas are the number of replicas (or containers) wanted and ready
+	Desired       int `json:"desired"`
+	ReadyReplicas int `json:"ready_replicas"`
+	// Restarts is the sum of the restarts of the containers, if known
+	Restarts   int               `json:"restarts"`
+	Containers []ContainerStatus `json:"containers"`
+	// Warnings are the recent warnings about the workload (and its pods)
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ContainerStatus is the image a container of a workload is running.
+type ContainerStatus struct {
+	Name string `json:"name"`
+	// Image is the image the container is meant to run
+	Image string `json:"image"`
+	// RunningDigests are the digests of the images the container is running (more than one during a rollout)
+	RunningDigests []string `json:"running_digests"`
+	// BuiltDigest is the digest of the image in the build manifest, if it was built by docker-image
+	BuiltDigest string `json:"built_digest,omitempty"`
+	// ImageState compares the running and the built image: current, outdated or unknown. Empty if the image was not built.
+	ImageState string `json:"image_state,omitempty"`
+}
+
+// Endpoint is how a service of the deployment can be reached.
+type Endpoint struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Type is e.g. ClusterIP, LoadBalancer, Ingress or Published (a port published by a compose service)
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses"`
+	Ports     []string `json:"ports"`
+}
+
+// PlanOptions change what changes are planned.
+type PlanOptions struct {
+	// Prune includes the removal of the resources that Apply with Prune would remove
+	Prune bool
+}
+
+// ApplyOptions change how a release is applied.
+type ApplyOptions struct {
+	// Wait for the workloads to be ready
+	Wait bool
+	// Prune removes the resources of the deployment that are no longer part of it
+	Prune bool
+	// RollbackOnFailure goes back to the previous release if the new one does not become ready (if supported)
+	RollbackOnFailure bool
+	// SkipSecretsCheck does not compare the secrets with the example env file before applying
+	SkipSecretsCheck bool
+}
+
+// DestroyOptions change how a deployment is destroyed.
+type DestroyOptions struct {
+	// DryRun lists what would be removed, without removing anything
+	DryRun bool
+	// KeepData keeps the volumes (and secrets, and the namespaces that hold them) of the deployment
+	KeepData bool
+	// Wait for the resources to be removed
+	Wait bool
+	// Confirm is called with the resources that will be removed, before they are removed (also on dry runs). Returning
+	// an error stops the destroy.
+	Confirm func(resources []string) error
+}
+
+// LogsOptions select the logs of the deployment.
+type LogsOptions struct {
+	// Workloads limits the logs to these workloads (by name). Defaults to all.
+	Workloads []string
+	Follow    bool
+	// Since only returns the logs newer than this duration
+	Since time.Duration
+	// Tail is the number of lines from the end of the logs of each container
+	Tail int64
+	// Handle is called with each line, one at a time
+	Handle func(LogLine)
+}
+
+// LogLine is a line of the logs of a workload.
+type LogLine struct {
+	// Time is when the line was written, if known
+	Time     time.Time `json:"time"`
+	Workload string    `json:"workload"`
+	// Instance is the pod (or the compose container) that wrote the line
+	Instance  string `json:"instance"`
+	Container string `json:"container,omitempty"`
+	Message   string `json:"message"`
+}
+
+// TunnelOptions select the services to reach from the local machine.
+type TunnelOptions struct {
+	Services []TunnelService
+	// Ready is called with the tunnels once they can be used
+	Ready func([]Tunnel)
+}
+
+// TunnelService is a service to reach, and its ports (all of them if empty).
+type TunnelService struct {
+	Name  string
+	Ports []int
+}
+
+// Tunnel is a local address that reaches a port of a service.
+type Tunnel struct {
+	Service string
+	// Host and LocalPort are the address to connect to
+	Host      string
+	LocalPort int
+	// RemotePort is the port of the service
+	RemotePort int
+	// Direct is true if the address reaches the service directly, without a forward that must be kept open
+	Direct bool
+}
+
+// ExecOptions are a command to run in a workload of the deployment, and its streams.
+type ExecOptions struct {
+	Workload string
+	// Container of the workload. Defaults to its first container.
+	Container string
+	Command   []string
+	// OneOff runs the command in a new container (e.g. a k8s Job) using the same image, instead of a serving one
+	OneOff bool
+	// Release creates the one-off container from the workload as the next release would deploy it (with the built
+	// images), instead of from the deployed workload. Used to migrate the database before a release is applied.
+	Release bool
+	// Stdin is nil if the command does not read stdin
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+	// TerminalSize returns the size of the local terminal, when TTY is set
+	TerminalSize func() (width uint16, height uint16, ok bool)
+}
+
+// LockHeldError is returned by Lock when the lock is held by someone else.
+type LockHeldError = kube.LockHeldError
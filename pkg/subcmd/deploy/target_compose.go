@@ -0,0 +1,804 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/teejays/gokutil/cmdutil"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+	"gopkg.in/yaml.v3"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/secrets"
+)
+
+const _defaultComposeFile = "infra/.goku/generated/compose/docker-compose.yaml"
+
+// composeTarget deploys the app to a single host with Docker Compose. The compose project is named after the deploy
+// identifier, so that deployments on the same host do not step on each other.
+type composeTarget struct {
+	cfg         ogconfig.Config
+	pcfg        projectconfig.Config
+	commonFlags CommonFlags
+	settings    TargetSettings
+
+	// files are the full paths of the compose files
+	files       []string
+	project     string
+	host        string
+	waitTimeout time.Duration
+}
+
+func newComposeTarget(cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, s TargetSettings) (DeployTarget, error) {
+	ccfg := pcfg.Deploy.Compose
+
+	t := &composeTarget{
+		cfg:         cfg,
+		pcfg:        pcfg,
+		commonFlags: commonFlags,
+		settings:    s,
+		project:     firstNonEmpty(ccfg.ProjectName, commonFlags.DeployIdentifier),
+		host:        ccfg.Host,
+		waitTimeout: s.WaitTimeout,
+	}
+
+	files := ccfg.Files
+	if len(files) == 0 {
+		files = []string{_defaultComposeFile}
+	}
+	for _, f := range files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(cfg.AppRootPath.Full, f)
+		}
+		t.files = append(t.files, f)
+	}
+
+	if t.waitTimeout == 0 && ccfg.WaitTimeout != "" {
+		d, err := time.ParseDuration(ccfg.WaitTimeout)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Parsing deploy.compose.wait_timeout [%s] in %s", ccfg.WaitTimeout, projectconfig.FileName)
+		}
+		t.waitTimeout = d
+	}
+	if t.waitTimeout == 0 {
+		t.waitTimeout = _defaultWaitTimeout
+	}
+
+	return t, nil
+}
+
+func (t *composeTarget) Name() string {
+	return TargetCompose
+}
+
+// command returns a `docker compose` command for the project, with the given compose files.
+func (t *composeTarget) command(ctx context.Context, files []string, args ...string) *exec.Cmd {
+	cmdArgs := []string{"compose", "--project-name", t.project, "--project-directory", t.cfg.AppRootPath.Full}
+	for _, f := range files {
+		cmdArgs = append(cmdArgs, "--file", f)
+	}
+	cmd := interrupt.Command(ctx, "docker", append(cmdArgs, args...)...)
+	cmd.Dir = t.cfg.AppRootPath.Full
+	if t.host != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_HOST="+t.host)
+	}
+	return cmd
+}
+
+// output runs the command and returns its stdout.
+func (t *composeTarget) output(ctx context.Context, files []string, args ...string) ([]byte, error) {
+	cmd := t.command(ctx, files, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errutil.Wrap(err, "Running command [%s]: %s", cmd, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// run runs the command, with its output shown to the user.
+func (t *composeTarget) run(ctx context.Context, files []string, args ...string) error {
+	cmd := t.command(ctx, files, args...)
+	err := cmdutil.ExecOSCmdWithOpts(ctx, cmd, cmdutil.ExecOptions{IsLoudCommand: true})
+	if err != nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+	return nil
+}
+
+type composeProject struct {
+	Services map[string]composeService `json:"services"`
+}
+
+type composeService struct {
+	Image string `json:"image"`
+}
+
+// services returns the services of the project, as compose resolves them from the files.
+func (t *composeTarget) services(ctx context.Context, files []string) (map[string]composeService, error) {
+	out, err := t.output(ctx, files, "config", "--format", "json")
+	if err != nil {
+		return nil, errutil.Wrap(err, "Reading compose files")
+	}
+	var p composeProject
+	err = json.Unmarshal(out, &p)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Decoding compose config")
+	}
+	return p.Services, nil
+}
+
+// composeContainer is a container of the project, as listed by `docker compose ps --format json`.
+type composeContainer struct {
+	Name     string `json:"Name"`
+	Service  string `json:"Service"`
+	Image    string `json:"Image"`
+	State    string `json:"State"`
+	Health   string `json:"Health"`
+	Status   string `json:"Status"`
+	ExitCode int    `json:"ExitCode"`
+	// Publishers are the published ports
+	Publishers []struct {
+		URL           string `json:"URL"`
+		TargetPort    int    `json:"TargetPort"`
+		PublishedPort int    `json:"PublishedPort"`
+		Protocol      string `json:"Protocol"`
+	} `json:"Publishers"`
+}
+
+// containers returns the containers of the project, including the stopped ones.
+func (t *composeTarget) containers(ctx context.Context) ([]composeContainer, error) {
+	out, err := t.output(ctx, t.files, "ps", "--all", "--format", "json")
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing containers")
+	}
+	out = bytes.TrimSpace(out)
+
+	// Older versions of compose print an array, newer ones a JSON object per line
+	var containers []composeContainer
+	if bytes.HasPrefix(out, []byte("[")) {
+		err = json.Unmarshal(out, &containers)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Decoding containers")
+		}
+		return containers, nil
+	}
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var c composeContainer
+		err = json.Unmarshal(line, &c)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Decoding container")
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// render writes an override file with the built images, the replicas and the sealed secrets set, and returns the
+// compose files to use (the project's files and the override). cleanup removes the override.
+func (t *composeTarget) render(ctx context.Context) (files []string, images map[string]string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	opts, err := getRenderOptions(ctx, t.cfg, t.pcfg, t.settings.UseTags, t.settings.RenderFlags, t.commonFlags)
+	if err != nil {
+		return nil, nil, cleanup, err
+	}
+	services, err := t.services(ctx, t.files)
+	if err != nil {
+		return nil, nil, cleanup, err
+	}
+
+	dir, err := os.MkdirTemp("", "og-compose-")
+	if err != nil {
+		return nil, nil, cleanup, errutil.Wrap(err, "Creating temp dir")
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	// Sealed secrets are given to the services as an env file (readable only by the user)
+	var envFile string
+	if IsSealedSecrets(t.pcfg) && !t.pcfg.Secrets.NoInject {
+		store, err := GetSecretsStore(t.cfg, t.pcfg, t.commonFlags, nil)
+		if err != nil {
+			return nil, nil, cleanup, err
+		}
+		values, err := store.Load(ctx)
+		if err != nil {
+			return nil, nil, cleanup, errutil.Wrap(err, "Loading secrets from [%s]", store.Name())
+		}
+		if len(values) > 0 {
+			content, err := godotenv.Marshal(values)
+			if err != nil {
+				return nil, nil, cleanup, errutil.Wrap(err, "Encoding secrets env file")
+			}
+			envFile = filepath.Join(dir, "secrets.env")
+			err = os.WriteFile(envFile, []byte(content+"\n"), 0600)
+			if err != nil {
+				return nil, nil, cleanup, errutil.Wrap(err, "Writing secrets env file")
+			}
+		}
+	}
+
+	images = map[string]string{}
+	override := map[string]map[string]interface{}{}
+	for name, svc := range services {
+		o := map[string]interface{}{}
+		img, err := resolveImage(svc.Image, opts)
+		if err != nil {
+			return nil, nil, cleanup, errutil.Wrap(err, "Resolving image of service [%s]", name)
+		}
+		images[name] = img
+		if img != svc.Image {
+			log.Info(ctx, "Setting image", "service", name, "from", svc.Image, "to", img)
+			o["image"] = img
+		}
+		if envFile != "" {
+			o["env_file"] = []string{envFile}
+		}
+		override[name] = o
+	}
+	for _, ov := range opts.Overrides {
+		o, ok := override[ov.Name]
+		if !ok {
+			return nil, nil, cleanup, fmt.Errorf("Override for workload [%s] does not match any service in the compose files", ov.Name)
+		}
+		if ov.Replicas != nil {
+			o["deploy"] = map[string]interface{}{"replicas": *ov.Replicas}
+		}
+		if ov.Resources != nil {
+			log.Warn(ctx, "Resource overrides are not supported by the compose target, ignoring them", "service", ov.Name)
+		}
+	}
+
+	b, err := yaml.Marshal(map[string]interface{}{"services": override})
+	if err != nil {
+		return nil, nil, cleanup, errutil.Wrap(err, "Encoding compose override")
+	}
+	overridePath := filepath.Join(dir, "og.override.yaml")
+	err = os.WriteFile(overridePath, b, 0600)
+	if err != nil {
+		return nil, nil, cleanup, errutil.Wrap(err, "Writing compose override")
+	}
+
+	return append(slices.Clone(t.files), overridePath), images, cleanup, nil
+}
+
+func (t *composeTarget) Plan(ctx context.Context, opts PlanOptions) ([]PlannedChange, error) {
+	_, images, cleanup, err := t.render(ctx)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := t.containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []PlannedChange
+	for _, name := range sortedKeys(images) {
+		ch := PlannedChange{Resource: "Service/" + name, Action: kube.ActionCreated}
+		i := slices.IndexFunc(containers, func(c composeContainer) bool { return c.Service == name })
+		switch {
+		case i < 0:
+			ch.Diff = kube.UnifiedDiff("live", "desired", "", "image: "+images[name]+"\n")
+		case containers[i].Image != images[name]:
+			ch.Action = kube.ActionConfigured
+			ch.Diff = kube.UnifiedDiff("live", "desired", "image: "+containers[i].Image+"\n", "image: "+images[name]+"\n")
+		default:
+			ch.Action = kube.ActionUnchanged
+		}
+		changes = append(changes, ch)
+	}
+	// The orphans are only removed by apply --prune
+	for _, c := range containers {
+		if _, ok := images[c.Service]; ok || !opts.Prune {
+			continue
+		}
+		changes = append(changes, PlannedChange{
+			Resource: "Service/" + c.Service,
+			Action:   kube.ActionDeleted,
+			Diff:     kube.UnifiedDiff("live", "desired", "image: "+c.Image+"\n", ""),
+		})
+	}
+	return changes, nil
+}
+
+func (t *composeTarget) Apply(ctx context.Context, opts ApplyOptions) (Release, error) {
+	rel := Release{
+		DeployIdentifier: t.commonFlags.DeployIdentifier,
+		Status:           ReleasePending,
+		GitCommit:        gitCommit(ctx, t.cfg, t.commonFlags),
+		User:             currentUser(),
+		CreatedAt:        time.Now().UTC(),
+	}
+
+	// Only sealed secrets can be checked, since there is no cluster to keep them in
+	if !opts.SkipSecretsCheck && IsSealedSecrets(t.pcfg) {
+		store, err := GetSecretsStore(t.cfg, t.pcfg, t.commonFlags, nil)
+		if err != nil {
+			return rel, err
+		}
+		err = secrets.CheckExample(ctx, store, ExampleEnvFilePath(t.cfg, t.pcfg))
+		if err != nil {
+			return rel, errutil.Wrap(err, "Checking secrets against [%s]. Use --skip-secrets-check to apply anyway", ExampleEnvFilePath(t.cfg, t.pcfg))
+		}
+	}
+	if opts.RollbackOnFailure || t.pcfg.Deploy.RollbackOnFailure {
+		log.Warn(ctx, "Rollback on failure is not supported by the compose target")
+	}
+
+	files, images, cleanup, err := t.render(ctx)
+	defer cleanup()
+	if err != nil {
+		return rel, err
+	}
+	for _, name := range sortedKeys(images) {
+		rel.Images = append(rel.Images, images[name])
+	}
+
+	args := []string{"up", "--detach"}
+	if opts.Prune {
+		args = append(args, "--remove-orphans")
+	}
+	if opts.Wait {
+		args = append(args, "--wait", "--wait-timeout", fmt.Sprint(int(t.waitTimeout.Seconds())))
+	}
+	log.Info(ctx, "Starting services", "project", t.project, "host", firstNonEmpty(t.host, os.Getenv("DOCKER_HOST"), "local"))
+	err = t.run(ctx, files, args...)
+	if err != nil {
+		rel.Status = ReleaseFailed
+		return rel, errutil.Wrap(err, "Starting services")
+	}
+	rel.Status = ReleaseDeployed
+	if !opts.Wait {
+		rel.Status = ReleaseUnknown
+	}
+	return rel, nil
+}
+
+// Status reports each service of the project as a workload, with its containers as the replicas.
+func (t *composeTarget) Status(ctx context.Context) (StatusReport, error) {
+	report := StatusReport{Target: TargetCompose, DeployIdentifier: t.commonFlags.DeployIdentifier, Env: t.pcfg.EnvName}
+
+	containers, err := t.containers(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	byService := map[string][]composeContainer{}
+	for _, c := range containers {
+		byService[c.Service] = append(byService[c.Service], c)
+	}
+	for _, name := range sortedKeys(byService) {
+		ws := WorkloadStatus{Kind: "Service", Name: name, Desired: len(byService[name])}
+		cs := ContainerStatus{Name: name}
+		var messages []string
+		for _, c := range byService[name] {
+			if c.State == "running" && (c.Health == "" || c.Health == "healthy") {
+				ws.ReadyReplicas++
+			}
+			if c.State == "dead" || (c.State == "exited" && c.ExitCode != 0) {
+				ws.Failed = true
+			}
+			messages = append(messages, fmt.Sprintf("%s: %s", c.Name, c.Status))
+			cs.Image = c.Image
+			if _, digest, ok := strings.Cut(c.Image, "@"); ok && !slices.Contains(cs.RunningDigests, digest) {
+				cs.RunningDigests = append(cs.RunningDigests, digest)
+			}
+			for _, p := range c.Publishers {
+				if p.PublishedPort == 0 {
+					continue
+				}
+				report.Endpoints = append(report.Endpoints, Endpoint{
+					Kind:      "Service",
+					Name:      name,
+					Type:      "Published",
+					Addresses: []string{firstNonEmpty(p.URL, "0.0.0.0")},
+					Ports:     []string{fmt.Sprintf("%d:%d/%s", p.PublishedPort, p.TargetPort, p.Protocol)},
+				})
+			}
+		}
+		ws.Ready = ws.ReadyReplicas == ws.Desired
+		ws.Message = strings.Join(messages, ", ")
+		ws.Containers = []ContainerStatus{cs}
+		report.Workloads = append(report.Workloads, ws)
+	}
+	return report, nil
+}
+
+func (t *composeTarget) Destroy(ctx context.Context, opts DestroyOptions) error {
+	containers, err := t.containers(ctx)
+	if err != nil {
+		return err
+	}
+	var resources []string
+	for _, c := range containers {
+		resources = append(resources, fmt.Sprintf("Container/%s (service: %s)", c.Name, c.Service))
+	}
+	if !opts.KeepData {
+		volumes, err := t.volumes(ctx)
+		if err != nil {
+			return err
+		}
+		for _, v := range volumes {
+			resources = append(resources, "Volume/"+v)
+		}
+	}
+	if len(resources) == 0 {
+		log.Info(ctx, "Nothing to destroy", "project", t.project)
+		return nil
+	}
+
+	err = opts.Confirm(resources)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	args := []string{"down", "--remove-orphans"}
+	if !opts.KeepData {
+		args = append(args, "--volumes")
+	}
+	err = t.run(ctx, t.files, args...)
+	if err != nil {
+		return errutil.Wrap(err, "Removing services")
+	}
+	return nil
+}
+
+// docker returns a docker (not compose) command for the docker host of the target.
+func (t *composeTarget) docker(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := interrupt.Command(ctx, "docker", args...)
+	if t.host != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_HOST="+t.host)
+	}
+	return cmd
+}
+
+// volumes returns the volumes of the project.
+func (t *composeTarget) volumes(ctx context.Context) ([]string, error) {
+	cmd := t.docker(ctx, "volume", "ls", "--quiet", "--filter", "label=com.docker.compose.project="+t.project)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errutil.Wrap(err, "Listing volumes of compose project [%s]", t.project)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+func (t *composeTarget) Logs(ctx context.Context, opts LogsOptions) error {
+	args := []string{"logs", "--no-color", "--timestamps"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Since > 0 {
+		args = append(args, "--since", fmt.Sprintf("%ds", int(opts.Since.Seconds())))
+	}
+	if opts.Tail > 0 {
+		args = append(args, "--tail", fmt.Sprint(opts.Tail))
+	}
+	args = append(args, opts.Workloads...)
+
+	cmd := t.command(ctx, t.files, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errutil.Wrap(err, "Getting the output of command [%s]", cmd)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+
+	// Compose multiplexes the logs itself (and follows restarted containers), as lines like
+	// "backend-1  | 2006-01-02T15:04:05.999999999Z message"
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		opts.Handle(parseComposeLogLine(scanner.Text()))
+	}
+
+	err = cmd.Wait()
+	if err != nil && ctx.Err() == nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+	return nil
+}
+
+// parseComposeLogLine parses a line of the output of docker compose logs --timestamps.
+func parseComposeLogLine(line string) LogLine {
+	prefix, msg, ok := strings.Cut(line, " | ")
+	if !ok {
+		return LogLine{Message: line}
+	}
+	l := LogLine{Instance: strings.TrimSpace(prefix), Message: msg}
+	// Containers are named <service>-<index>
+	l.Workload = l.Instance
+	if i := strings.LastIndex(l.Instance, "-"); i > 0 {
+		l.Workload = l.Instance[:i]
+	}
+	ts, rest, _ := strings.Cut(msg, " ")
+	if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		l.Time, l.Message = t, rest
+	}
+	return l
+}
+
+// _sshForwardTimeout is how long to wait for the ssh port forwards to a remote docker host to listen.
+const _sshForwardTimeout = 15 * time.Second
+
+// Tunnel reaches the published ports of the services. On the local docker host (or a tcp:// one) they are reached
+// directly. On an ssh:// host, they are forwarded to local ports over ssh.
+func (t *composeTarget) Tunnel(ctx context.Context, opts TunnelOptions) error {
+	containers, err := t.containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var hostURL *url.URL
+	if host := firstNonEmpty(t.host, os.Getenv("DOCKER_HOST")); host != "" {
+		hostURL, err = url.Parse(host)
+		if err != nil {
+			return errutil.Wrap(err, "Parsing docker host [%s]", host)
+		}
+	} else {
+		hostURL = &url.URL{Scheme: "unix"}
+	}
+
+	var tunnels []Tunnel
+	var sshArgs []string
+	for _, ts := range opts.Services {
+		// Published ports by the port of the container
+		published := map[int]int{}
+		found := false
+		for _, c := range containers {
+			if c.Service != ts.Name || c.State != "running" {
+				continue
+			}
+			found = true
+			for _, p := range c.Publishers {
+				if p.PublishedPort != 0 {
+					published[p.TargetPort] = p.PublishedPort
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("Service [%s] has no running containers in compose project [%s]", ts.Name, t.project)
+		}
+
+		ports := ts.Ports
+		if len(ports) == 0 {
+			ports = sortedKeys(published)
+		}
+		if len(ports) == 0 {
+			return fmt.Errorf("Service [%s] has no published ports, so it can't be reached from outside the docker host", ts.Name)
+		}
+		for _, port := range ports {
+			pub, ok := published[port]
+			if !ok {
+				return fmt.Errorf("Port %d of service [%s] is not published, so it can't be reached from outside the docker host", port, ts.Name)
+			}
+			tun := Tunnel{Service: ts.Name, Host: "127.0.0.1", LocalPort: pub, RemotePort: port, Direct: true}
+			switch hostURL.Scheme {
+			case "unix", "npipe":
+			case "tcp":
+				tun.Host = hostURL.Hostname()
+			case "ssh":
+				local, err := freePort()
+				if err != nil {
+					return err
+				}
+				sshArgs = append(sshArgs, "-L", fmt.Sprintf("127.0.0.1:%d:127.0.0.1:%d", local, pub))
+				tun.LocalPort, tun.Direct = local, false
+			default:
+				return fmt.Errorf("Tunnels to docker host [%s] are not supported", hostURL)
+			}
+			tunnels = append(tunnels, tun)
+		}
+	}
+
+	if len(sshArgs) == 0 {
+		opts.Ready(tunnels)
+		return nil
+	}
+
+	dest := hostURL.Hostname()
+	if hostURL.User != nil {
+		dest = hostURL.User.Username() + "@" + dest
+	}
+	if hostURL.Port() != "" {
+		sshArgs = append(sshArgs, "-p", hostURL.Port())
+	}
+	sshArgs = append([]string{"-N", "-o", "ExitOnForwardFailure=yes"}, append(sshArgs, dest)...)
+	cmd := interrupt.Command(ctx, "ssh", sshArgs...)
+	cmd.Stderr = os.Stderr
+	log.Debug(ctx, "Forwarding ports over ssh", "command", cmd.String())
+	err = cmd.Start()
+	if err != nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	// Wait for the forwards to listen
+	deadline := time.Now().Add(_sshForwardTimeout)
+	for _, tun := range tunnels {
+		for {
+			conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", tun.LocalPort), time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			select {
+			case err := <-exited:
+				return errutil.Wrap(err, "Running command [%s]", cmd)
+			case <-ctx.Done():
+				return nil
+			case <-time.After(200 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("Timed out after %s waiting for the ssh port forwards to %s", _sshForwardTimeout, dest)
+			}
+		}
+	}
+	opts.Ready(tunnels)
+
+	err = <-exited
+	if err != nil && ctx.Err() == nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+	return nil
+}
+
+// Exec runs the command with `docker compose exec` in a running container of the service, or with opts.OneOff with
+// `docker compose run` in a new container. One-off containers are created from the compose files as a deploy would
+// resolve them (with the built images and the sealed secrets).
+func (t *composeTarget) Exec(ctx context.Context, opts ExecOptions) error {
+	files := t.files
+	args := []string{"exec"}
+	if opts.OneOff || opts.Release {
+		rendered, _, cleanup, err := t.render(ctx)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+		files = rendered
+		args = []string{"run", "--rm", "--no-deps"}
+	}
+	if !opts.TTY {
+		args = append(args, "-T")
+	}
+	if opts.Stdin == nil {
+		args = append(args, "--interactive=false")
+	}
+	if opts.Container != "" && opts.Container != opts.Workload {
+		log.Warn(ctx, "Compose services have a single container, ignoring the container", "container", opts.Container)
+	}
+	args = append(args, opts.Workload)
+	args = append(args, opts.Command...)
+
+	cmd := t.command(ctx, files, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = opts.Stdin, opts.Stdout, opts.Stderr
+	log.Debug(ctx, "Running command in compose service", "command", cmd.String())
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ogerr.ExitCodeError{Code: exitErr.ExitCode()}
+	}
+	if err != nil {
+		return errutil.Wrap(err, "Running command [%s]", cmd)
+	}
+	return nil
+}
+
+// Labels of the containers that hold the locks of the compose target
+const (
+	_labelLockHolder = "ongoku.build/lock-holder"
+	_labelLockSince  = "ongoku.build/lock-since"
+)
+
+// Lock takes the named lock by creating a container (that is never started) with a name unique to the lock, since
+// docker refuses to create two containers with the same name. The container uses an image of the project, so that
+// nothing needs to be pulled.
+func (t *composeTarget) Lock(ctx context.Context, name string) (context.Context, func(context.Context) error, error) {
+	services, err := t.services(ctx, t.files)
+	if err != nil {
+		return nil, nil, err
+	}
+	var image string
+	for _, svcName := range sortedKeys(services) {
+		if services[svcName].Image != "" {
+			image = services[svcName].Image
+			break
+		}
+	}
+	if image == "" {
+		return nil, nil, fmt.Errorf("No service with an image in compose project [%s] to create the lock container with", t.project)
+	}
+
+	container := t.lockName(name)
+	cmd := t.docker(ctx, "container", "create", "--name", container,
+		"--label", _labelLockHolder+"="+lockHolder(),
+		"--label", _labelLockSince+"="+time.Now().UTC().Format(time.RFC3339),
+		image, "true")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		if !strings.Contains(stderr.String(), "is already in use") {
+			return nil, nil, errutil.Wrap(err, "Running command [%s]: %s", cmd, strings.TrimSpace(stderr.String()))
+		}
+		held := LockHeldError{Name: container}
+		out, err := t.docker(ctx, "container", "inspect", "--format", "{{json .Config.Labels}}", container).Output()
+		if err == nil {
+			var labels map[string]string
+			if json.Unmarshal(out, &labels) == nil {
+				held.Holder = labels[_labelLockHolder]
+				held.Since, _ = time.Parse(time.RFC3339, labels[_labelLockSince])
+			}
+		}
+		return nil, nil, held
+	}
+
+	// The container is only removed by its holder or og db unlock, so the lock cannot be lost
+	return ctx, func(ctx context.Context) error {
+		return t.Unlock(ctx, name)
+	}, nil
+}
+
+func (t *composeTarget) Unlock(ctx context.Context, name string) error {
+	cmd := t.docker(ctx, "container", "rm", "--force", t.lockName(name))
+	out, err := cmd.CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "No such container") {
+		return errutil.Wrap(err, "Running command [%s]: %s", cmd, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (t *composeTarget) lockName(name string) string {
+	return t.project + "-og-lock-" + name
+}
+
+// freePort returns a local port that is free to listen on.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, errutil.Wrap(err, "Finding a free local port")
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
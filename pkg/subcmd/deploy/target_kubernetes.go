@@ -0,0 +1,721 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/secrets"
+)
+
+const (
+	// _statusEventsSince is how far back warning events are reported by status
+	_statusEventsSince = time.Hour
+	// _statusMaxWarnings is the maximum number of warnings reported per workload
+	_statusMaxWarnings = 5
+)
+
+// kubernetesTarget deploys the app by applying the k8s manifests to a cluster, and keeps a release history there.
+type kubernetesTarget struct {
+	cfg         ogconfig.Config
+	pcfg        projectconfig.Config
+	commonFlags CommonFlags
+	settings    TargetSettings
+	ks          KubeSettings
+
+	// kc is created on first use, so that nothing talks to the cluster until it's needed
+	kc *kube.Client
+}
+
+func newKubernetesTarget(cfg ogconfig.Config, pcfg projectconfig.Config, commonFlags CommonFlags, s TargetSettings) (DeployTarget, error) {
+	ks, err := GetKubeSettings(cfg, pcfg, s.KubeFlags, s.Manifests, s.WaitTimeout)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Resolving kubernetes settings")
+	}
+	return &kubernetesTarget{cfg: cfg, pcfg: pcfg, commonFlags: commonFlags, settings: s, ks: ks}, nil
+}
+
+func (t *kubernetesTarget) Name() string {
+	return TargetKubernetes
+}
+
+func (t *kubernetesTarget) client(ctx context.Context) (*kube.Client, error) {
+	if t.kc != nil {
+		return t.kc, nil
+	}
+	kc, err := t.ks.NewClient(ctx)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Creating kubernetes client")
+	}
+	t.kc = kc
+	return kc, nil
+}
+
+// objects returns the rendered k8s objects of the deployment.
+func (t *kubernetesTarget) objects(ctx context.Context) ([]*unstructured.Unstructured, error) {
+	return readDeployObjects(ctx, t.cfg, t.pcfg, t.ks, t.settings.UseTags, t.settings.RenderFlags, t.commonFlags)
+}
+
+// sealedSecret returns the Secret with the sealed secrets, or nil if the secrets are not sealed.
+func (t *kubernetesTarget) sealedSecret(ctx context.Context) (*unstructured.Unstructured, error) {
+	if !IsSealedSecrets(t.pcfg) {
+		return nil, nil
+	}
+	secret, err := sealedSecretObject(ctx, t.cfg, t.pcfg, t.commonFlags, t.ks.Namespace)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Rendering sealed secrets")
+	}
+	return secret, nil
+}
+
+func (t *kubernetesTarget) Plan(ctx context.Context, opts PlanOptions) ([]PlannedChange, error) {
+	objs, err := t.objects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kc, err := t.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The diff of secrets is redacted
+	secret, err := t.sealedSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		objs = append(objs, secret)
+	}
+	results, err := kc.Diff(ctx, objs, t.commonFlags.DeployIdentifier, kube.DiffOptions{Prune: opts.Prune})
+	if err != nil {
+		return nil, errutil.Wrap(err, "Comparing k8s manifests with the cluster")
+	}
+	var changes []PlannedChange
+	for _, res := range results {
+		changes = append(changes, PlannedChange{Resource: res.ObjectRef.String(), Action: res.Action, Diff: res.Diff})
+	}
+	return changes, nil
+}
+
+func (t *kubernetesTarget) Apply(ctx context.Context, opts ApplyOptions) (Release, error) {
+	var rel Release
+	log.Debug(ctx, "Kubernetes settings", "settings", t.ks)
+
+	objs, err := t.objects(ctx)
+	if err != nil {
+		return rel, err
+	}
+	kc, err := t.client(ctx)
+	if err != nil {
+		return rel, err
+	}
+	hist := NewReleaseHistory(kc, t.commonFlags.DeployIdentifier, t.ks.HistoryLimit)
+
+	// Catch the variables the app needs but were never set, before anything is applied
+	if !opts.SkipSecretsCheck {
+		store, err := GetSecretsStore(t.cfg, t.pcfg, t.commonFlags, kc)
+		if err != nil {
+			return rel, err
+		}
+		err = secrets.CheckExample(ctx, store, ExampleEnvFilePath(t.cfg, t.pcfg))
+		if err != nil {
+			return rel, errutil.Wrap(err, "Checking secrets against [%s]. Use --skip-secrets-check to apply anyway", ExampleEnvFilePath(t.cfg, t.pcfg))
+		}
+	}
+
+	// The sealed secrets are applied before the workloads that use them, and are left out of the release so that they
+	// are not recorded in the history
+	secret, err := t.sealedSecret(ctx)
+	if err != nil {
+		return rel, err
+	}
+	var secretResults []kube.Result
+	if secret != nil {
+		secretResults, err = kc.Apply(ctx, []*unstructured.Unstructured{secret}, kube.ApplyOptions{})
+		if err != nil {
+			return rel, errutil.Wrap(err, "Applying sealed secrets")
+		}
+		log.Info(ctx, "Applied sealed secrets", "resource", kube.RefOf(secret).String(), "result", secretResults[0].Action)
+	}
+
+	// Server-side apply, so that the result of each object is known
+	rel, err = applyRelease(ctx, kc, hist, objs, releaseOptions{
+		GitCommit:   gitCommit(ctx, t.cfg, t.commonFlags),
+		Wait:        opts.Wait,
+		WaitTimeout: t.ks.WaitTimeout,
+	})
+	rel.Resources = append(secretResults, rel.Resources...)
+	if err != nil {
+		if !opts.RollbackOnFailure && !t.pcfg.Deploy.RollbackOnFailure {
+			return rel, err
+		}
+		if interrupt.Interrupted() {
+			log.Warn(ctx, "Not rolling back, since og was interrupted. Roll back with og deploy rollback if needed.", "revision", rel.Revision)
+			return rel, err
+		}
+		log.Error(ctx, "Release failed. Rolling back to the previous release.", "revision", rel.Revision, "error", err)
+		prev, ok, rbErr := hist.LastDeployed(ctx, rel.Revision)
+		if rbErr != nil {
+			return rel, errutil.Wrap(err, "Release failed, and the previous release could not be found for rollback: %s", rbErr)
+		}
+		if !ok {
+			return rel, errutil.Wrap(err, "Release failed, and there is no previous successful release to roll back to")
+		}
+		_, rbErr = rollbackTo(ctx, kc, hist, prev.Revision, secret, opts.Wait, t.ks.WaitTimeout)
+		if rbErr != nil {
+			return rel, errutil.Wrap(err, "Release failed, and the rollback to revision [%d] failed too: %s", prev.Revision, rbErr)
+		}
+		return rel, errutil.Wrap(err, "Release failed, and was rolled back to revision [%d]", prev.Revision)
+	}
+
+	// Remove what's no longer in the manifests
+	if opts.Prune {
+		if secret != nil {
+			objs = append(objs, secret)
+		}
+		prunable, err := kc.FindPrunable(ctx, objs, t.commonFlags.DeployIdentifier)
+		if err != nil {
+			return rel, errutil.Wrap(err, "Finding resources removed from the manifests")
+		}
+		pruned, err := kc.Delete(ctx, prunable, false)
+		for _, res := range pruned {
+			log.Info(ctx, "Pruned resource", "resource", res.ObjectRef.String(), "result", res.Action)
+		}
+		rel.Resources = append(rel.Resources, pruned...)
+		if err != nil {
+			return rel, errutil.Wrap(err, "Pruning k8s resources")
+		}
+	}
+
+	return rel, nil
+}
+
+// liveWorkloads returns the workloads labelled with the deploy identifier, in the namespaces of the manifests.
+func (t *kubernetesTarget) liveWorkloads(ctx context.Context) ([]kube.ObjectRef, error) {
+	kc, err := t.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := t.namespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var refs []kube.ObjectRef
+	for _, ns := range namespaces {
+		nsRefs, err := kc.ListWorkloads(ctx, ns, kube.DeploySelector(t.commonFlags.DeployIdentifier))
+		if err != nil {
+			return nil, errutil.Wrap(err, "Listing workloads in namespace [%s]", ns)
+		}
+		refs = append(refs, nsRefs...)
+	}
+	return refs, nil
+}
+
+// Status looks up the workloads and endpoints labelled with the deploy identifier, in the namespaces of the manifests.
+func (t *kubernetesTarget) Status(ctx context.Context) (StatusReport, error) {
+	report := StatusReport{Target: TargetKubernetes, DeployIdentifier: t.commonFlags.DeployIdentifier, Env: t.pcfg.EnvName}
+
+	kc, err := t.client(ctx)
+	if err != nil {
+		return report, err
+	}
+	namespaces, err := t.namespaces(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	releases, err := NewReleaseHistory(kc, t.commonFlags.DeployIdentifier, t.ks.HistoryLimit).List(ctx)
+	if err != nil {
+		log.Warn(ctx, "Could not read the release history", "error", err)
+	} else if len(releases) > 0 {
+		report.LastRelease = &releases[len(releases)-1]
+	}
+
+	selector := kube.DeploySelector(t.commonFlags.DeployIdentifier)
+	for _, ns := range namespaces {
+		refs, err := kc.ListWorkloads(ctx, ns, selector)
+		if err != nil {
+			return report, errutil.Wrap(err, "Listing workloads in namespace [%s]", ns)
+		}
+		events, err := kc.WarningEvents(ctx, ns, _statusEventsSince)
+		if err != nil {
+			log.Warn(ctx, "Could not list events", "namespace", ns, "error", err)
+		}
+
+		for _, ref := range refs {
+			d, err := kc.DescribeWorkload(ctx, ref)
+			if err != nil {
+				return report, errutil.Wrap(err, "Getting status of [%s]", ref)
+			}
+			ws := WorkloadStatus{
+				Kind:          ref.Kind,
+				Name:          ref.Name,
+				Namespace:     ref.Namespace,
+				Ready:         d.Ready,
+				Failed:        d.Failed,
+				Message:       d.Message,
+				Desired:       int(d.Desired),
+				ReadyReplicas: int(d.ReadyReplicas),
+				Restarts:      int(d.Restarts),
+			}
+			for _, c := range d.Containers {
+				cs := ContainerStatus{Name: c.Name, Image: c.Image}
+				for _, id := range c.ImageIDs {
+					cs.RunningDigests = append(cs.RunningDigests, digestOf(id))
+				}
+				ws.Containers = append(ws.Containers, cs)
+			}
+			// Events of the workload, and of the replica sets and pods it owns
+			for _, e := range events {
+				if !slices.Contains(d.UIDs, e.UID) {
+					continue
+				}
+				if len(ws.Warnings) == _statusMaxWarnings {
+					break
+				}
+				ws.Warnings = append(ws.Warnings, fmt.Sprintf("%s %s: %s (x%d, %s ago)", e.Object, e.Reason, e.Message, max(e.Count, 1), time.Since(e.LastSeen).Round(time.Second)))
+			}
+			report.Workloads = append(report.Workloads, ws)
+		}
+
+		endpoints, err := kc.ListEndpoints(ctx, ns, selector)
+		if err != nil {
+			return report, errutil.Wrap(err, "Listing endpoints in namespace [%s]", ns)
+		}
+		for _, ep := range endpoints {
+			report.Endpoints = append(report.Endpoints, Endpoint{Kind: ep.Kind, Name: ep.Name, Type: ep.Type, Addresses: ep.Addresses, Ports: ep.Ports})
+		}
+	}
+
+	return report, nil
+}
+
+// namespaces returns the namespaces of the rendered objects, or the default namespace if they can't be rendered.
+func (t *kubernetesTarget) namespaces(ctx context.Context) ([]string, error) {
+	kc, err := t.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := t.objects(ctx)
+	if err != nil {
+		log.Warn(ctx, "Could not render the k8s manifests, looking for the deployment in the default namespace only", "namespace", kc.Namespace, "error", err)
+		return []string{kc.Namespace}, nil
+	}
+	var namespaces []string
+	for _, obj := range objs {
+		ns := firstNonEmpty(obj.GetNamespace(), kc.Namespace)
+		if obj.GetKind() == "Namespace" || slices.Contains(namespaces, ns) {
+			continue
+		}
+		namespaces = append(namespaces, ns)
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{kc.Namespace}
+	}
+	return namespaces, nil
+}
+
+// digestOf returns the digest in an image ID e.g. docker-pullable://myrepo/app@sha256:... returns sha256:...
+func digestOf(imageID string) string {
+	if _, digest, ok := strings.Cut(imageID, "@"); ok {
+		return digest
+	}
+	return imageID
+}
+
+// Destroy deletes the resources of the deployment from the cluster. Only the resources labelled with the deploy
+// identifier are deleted, so that resources with the same names that were not created by og are left alone.
+func (t *kubernetesTarget) Destroy(ctx context.Context, opts DestroyOptions) error {
+	objs, err := t.objects(ctx)
+	if err != nil {
+		return err
+	}
+	kc, err := t.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	deployed, skipped, err := kc.FindDeployed(ctx, objs, t.commonFlags.DeployIdentifier)
+	if err != nil {
+		return errutil.Wrap(err, "Finding the resources of the deployment")
+	}
+	for _, ref := range skipped {
+		log.Warn(ctx, "Skipping resource that is not labelled for this deployment", "resource", ref.String(), "deployIdentifier", t.commonFlags.DeployIdentifier)
+	}
+
+	var toDelete []*unstructured.Unstructured
+	var resources []string
+	for _, obj := range deployed {
+		if opts.KeepData && slices.Contains(_dataKinds, obj.GetKind()) {
+			log.Info(ctx, "Keeping resource", "resource", kube.RefOf(obj).String())
+			continue
+		}
+		toDelete = append(toDelete, obj)
+		resources = append(resources, kube.RefOf(obj).String())
+	}
+	if len(toDelete) == 0 {
+		log.Info(ctx, "Nothing to destroy", "deployIdentifier", t.commonFlags.DeployIdentifier)
+		return nil
+	}
+
+	err = opts.Confirm(resources)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		results, err := kc.Delete(ctx, toDelete, true)
+		for _, res := range results {
+			log.Info(ctx, "Would delete resource", "resource", res.ObjectRef.String(), "result", res.Action)
+		}
+		if err != nil {
+			return errutil.Wrap(err, "Dry running delete of k8s resources")
+		}
+		return nil
+	}
+
+	results, err := kc.Delete(ctx, toDelete, false)
+	for _, res := range results {
+		log.Info(ctx, "Deleted resource", "resource", res.ObjectRef.String(), "result", res.Action)
+	}
+	if err != nil {
+		return errutil.Wrap(err, "Deleting k8s resources")
+	}
+
+	// The release history is meaningless without the deployment
+	err = NewReleaseHistory(kc, t.commonFlags.DeployIdentifier, t.ks.HistoryLimit).Delete(ctx)
+	if err != nil {
+		log.Warn(ctx, "Could not delete the release history", "error", err)
+	}
+
+	if !opts.Wait {
+		return nil
+	}
+	log.Info(ctx, "Waiting for the resources to be deleted", "timeout", t.ks.WaitTimeout)
+	err = kc.WaitDeleted(ctx, toDelete, t.ks.WaitTimeout)
+	if err != nil {
+		return errutil.Wrap(err, "Waiting for k8s resources to be deleted")
+	}
+	return nil
+}
+
+func (t *kubernetesTarget) Logs(ctx context.Context, opts LogsOptions) error {
+	refs, err := t.liveWorkloads(ctx)
+	if err != nil {
+		return err
+	}
+	if len(opts.Workloads) > 0 {
+		var names []string
+		for _, ref := range refs {
+			names = append(names, ref.Name)
+		}
+		for _, w := range opts.Workloads {
+			if !slices.Contains(names, w) {
+				return fmt.Errorf("No workload named [%s] in the deployment. Options: %s", w, strings.Join(names, ", "))
+			}
+		}
+		refs = slices.DeleteFunc(refs, func(ref kube.ObjectRef) bool { return !slices.Contains(opts.Workloads, ref.Name) })
+	}
+	if len(refs) == 0 {
+		log.Warn(ctx, "No workloads found for the deployment. Has it been deployed?", "deployIdentifier", t.commonFlags.DeployIdentifier)
+		return nil
+	}
+
+	handle := func(l kube.LogLine) {
+		opts.Handle(LogLine{Time: l.Time, Workload: l.Workload, Instance: l.Pod, Container: l.Container, Message: l.Message})
+	}
+	return t.kc.StreamLogs(ctx, refs, kube.LogOptions{Follow: opts.Follow, Since: opts.Since, Tail: opts.Tail}, handle)
+}
+
+// Tunnel forwards local ports to a ready pod behind each of the k8s Services.
+func (t *kubernetesTarget) Tunnel(ctx context.Context, opts TunnelOptions) error {
+	kc, err := t.client(ctx)
+	if err != nil {
+		return err
+	}
+	namespaces, err := t.namespaces(ctx)
+	if err != nil {
+		return err
+	}
+	var services []kube.Endpoint
+	for _, ns := range namespaces {
+		endpoints, err := kc.ListEndpoints(ctx, ns, kube.DeploySelector(t.commonFlags.DeployIdentifier))
+		if err != nil {
+			return errutil.Wrap(err, "Listing services in namespace [%s]", ns)
+		}
+		for _, ep := range endpoints {
+			if ep.Kind == "Service" {
+				services = append(services, ep)
+			}
+		}
+	}
+
+	// servicePort is a port of a service, and the port of the pod it targets
+	type servicePort struct {
+		port   int
+		target uint16
+	}
+	type forward struct {
+		service string
+		pod     corev1.Pod
+		// ports are forwarded once per pod port, which several ports of the service may target
+		ports        []kube.ForwardedPort
+		servicePorts []servicePort
+	}
+	var forwards []forward
+	for _, ts := range opts.Services {
+		i := slices.IndexFunc(services, func(ep kube.Endpoint) bool { return ep.Name == ts.Name })
+		if i < 0 {
+			var names []string
+			for _, ep := range services {
+				names = append(names, ep.Name)
+			}
+			return fmt.Errorf("No service named [%s] in the deployment. Options: %s", ts.Name, strings.Join(names, ", "))
+		}
+		pod, portMap, err := kc.ServiceBackend(ctx, services[i].Namespace, ts.Name)
+		if err != nil {
+			return err
+		}
+
+		ports := ts.Ports
+		if len(ports) == 0 {
+			for p := range portMap {
+				ports = append(ports, int(p))
+			}
+			slices.Sort(ports)
+		}
+		f := forward{service: ts.Name, pod: pod}
+		for _, p := range ports {
+			target, ok := portMap[int32(p)]
+			if !ok {
+				return fmt.Errorf("Service [%s] has no port %d", ts.Name, p)
+			}
+			if slices.ContainsFunc(f.servicePorts, func(sp servicePort) bool { return sp.port == p }) {
+				continue
+			}
+			f.servicePorts = append(f.servicePorts, servicePort{port: p, target: uint16(target)})
+			if !slices.ContainsFunc(f.ports, func(fp kube.ForwardedPort) bool { return fp.Remote == uint16(target) }) {
+				f.ports = append(f.ports, kube.ForwardedPort{Remote: uint16(target)})
+			}
+		}
+		forwards = append(forwards, f)
+	}
+
+	if len(forwards) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var tunnels []Tunnel
+	pending := len(forwards)
+	errCh := make(chan error, len(forwards))
+	for _, f := range forwards {
+		go func() {
+			log.Debug(ctx, "Forwarding ports", "service", f.service, "pod", f.pod.Name)
+			errCh <- kc.ForwardPorts(ctx, f.pod, f.ports, func(got []kube.ForwardedPort) {
+				mu.Lock()
+				defer mu.Unlock()
+				locals := map[uint16]uint16{}
+				for _, p := range got {
+					locals[p.Remote] = p.Local
+				}
+				for _, sp := range f.servicePorts {
+					tunnels = append(tunnels, Tunnel{Service: f.service, Host: "127.0.0.1", LocalPort: int(locals[sp.target]), RemotePort: sp.port})
+				}
+				pending--
+				if pending == 0 {
+					opts.Ready(tunnels)
+				}
+			})
+		}()
+	}
+
+	// The first forward to end (with an error, or because the context is done) closes the others
+	err = <-errCh
+	cancel()
+	for range len(forwards) - 1 {
+		<-errCh
+	}
+	return err
+}
+
+// _oneOffStartTimeout is how long to wait for the pod of a one-off job to start (its image may need to be pulled).
+const _oneOffStartTimeout = 5 * time.Minute
+
+// Exec runs the command in a ready pod of the workload, or with opts.OneOff in the pod of a new Job created from the
+// pod template of the workload.
+func (t *kubernetesTarget) Exec(ctx context.Context, opts ExecOptions) error {
+	kc, err := t.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	var ref kube.ObjectRef
+	var tmpl corev1.PodTemplateSpec
+	if opts.Release {
+		ref, tmpl, err = t.releaseWorkload(ctx, opts.Workload)
+		if err != nil {
+			return err
+		}
+	} else {
+		refs, err := t.liveWorkloads(ctx)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(refs, func(ref kube.ObjectRef) bool { return ref.Name == opts.Workload })
+		if i < 0 {
+			var names []string
+			for _, ref := range refs {
+				names = append(names, ref.Name)
+			}
+			return fmt.Errorf("No workload named [%s] in the deployment. Options: %s", opts.Workload, strings.Join(names, ", "))
+		}
+		ref = refs[i]
+		tmpl, err = kc.PodTemplateOf(ctx, ref)
+		if err != nil {
+			return errutil.Wrap(err, "Getting pod template of [%s]", ref)
+		}
+	}
+
+	if len(tmpl.Spec.Containers) == 0 {
+		return fmt.Errorf("Workload [%s] has no containers", ref)
+	}
+	container := opts.Container
+	if container == "" {
+		container = tmpl.Spec.Containers[0].Name
+	}
+	execOpts := kube.ExecOptions{Container: container, Stdin: opts.Stdin, Stdout: opts.Stdout, Stderr: opts.Stderr, TTY: opts.TTY, TerminalSize: opts.TerminalSize}
+
+	if !opts.OneOff {
+		pods, err := kc.PodsOf(ctx, ref)
+		if err != nil {
+			return errutil.Wrap(err, "Finding pods of [%s]", ref)
+		}
+		i := slices.IndexFunc(pods, kube.PodReady)
+		if i < 0 {
+			return fmt.Errorf("Workload [%s] has no ready pods", ref)
+		}
+		log.Debug(ctx, "Running command in pod", "pod", pods[i].Name, "container", container, "command", opts.Command)
+		return kc.Exec(ctx, pods[i], opts.Command, execOpts)
+	}
+
+	interactive := opts.Stdin != nil
+	job, err := kc.CreateOneOffJob(ctx, ref, tmpl, container, opts.Command, interactive)
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Running command in a one-off job", "job", job.Name, "namespace", job.Namespace)
+	defer func() {
+		// The job is done (or given up on) even if the context is cancelled
+		err := kc.DeleteJob(context.Background(), job)
+		if err != nil {
+			log.Warn(ctx, "Could not delete the one-off job. It's deleted by the cluster an hour after it's done.", "job", job.Name, "error", err)
+		}
+	}()
+
+	pod, err := kc.WaitForJobPod(ctx, job, _oneOffStartTimeout)
+	if err != nil {
+		return err
+	}
+	if interactive && pod.Status.Phase == corev1.PodRunning {
+		err = kc.Attach(ctx, pod, execOpts)
+		if err != nil {
+			log.Debug(ctx, "Could not attach to the one-off job, showing its logs instead", "error", err)
+			err = kc.PodLogs(ctx, pod, container, true, opts.Stdout)
+		}
+	} else {
+		err = kc.PodLogs(ctx, pod, container, true, opts.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	code, err := kc.WaitForContainerExit(ctx, pod, container)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return ogerr.ExitCodeError{Code: code}
+	}
+	return nil
+}
+
+// releaseWorkload returns the workload with its pod template, as in the rendered manifests of the next release.
+func (t *kubernetesTarget) releaseWorkload(ctx context.Context, name string) (kube.ObjectRef, corev1.PodTemplateSpec, error) {
+	var tmpl corev1.PodTemplateSpec
+	objs, err := t.objects(ctx)
+	if err != nil {
+		return kube.ObjectRef{}, tmpl, err
+	}
+	kc, err := t.client(ctx)
+	if err != nil {
+		return kube.ObjectRef{}, tmpl, err
+	}
+	var names []string
+	for _, obj := range objs {
+		if !kube.IsWorkload(obj.GetKind()) {
+			continue
+		}
+		if obj.GetName() != name {
+			names = append(names, obj.GetName())
+			continue
+		}
+		ref := kube.RefOf(obj)
+		ref.Namespace = firstNonEmpty(ref.Namespace, kc.Namespace)
+		m, found, err := unstructured.NestedMap(obj.Object, "spec", "template")
+		if err != nil || !found {
+			return ref, tmpl, fmt.Errorf("Workload [%s] has no pod template in the manifests", ref)
+		}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(m, &tmpl)
+		if err != nil {
+			return ref, tmpl, errutil.Wrap(err, "Decoding pod template of [%s]", ref)
+		}
+		return ref, tmpl, nil
+	}
+	return kube.ObjectRef{}, tmpl, fmt.Errorf("No workload named [%s] in the manifests. Options: %s", name, strings.Join(names, ", "))
+}
+
+// _lockDuration is how long a lock is held without being renewed, before someone else can take it over.
+const _lockDuration = time.Minute
+
+// Lock takes the named lock with a k8s Lease in the default namespace.
+func (t *kubernetesTarget) Lock(ctx context.Context, name string) (context.Context, func(context.Context) error, error) {
+	kc, err := t.client(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels := kube.BookkeepingLabels(t.commonFlags.DeployIdentifier, kube.RoleLock)
+	lease, err := kc.AcquireLease(ctx, kc.Namespace, t.lockName(name), lockHolder(), labels, _lockDuration)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lease.Context(), lease.Release, nil
+}
+
+func (t *kubernetesTarget) Unlock(ctx context.Context, name string) error {
+	kc, err := t.client(ctx)
+	if err != nil {
+		return err
+	}
+	return kc.DeleteLease(ctx, kc.Namespace, t.lockName(name))
+}
+
+func (t *kubernetesTarget) lockName(name string) string {
+	return "og-lock-" + strings.ToLower(t.commonFlags.DeployIdentifier) + "-" + name
+}
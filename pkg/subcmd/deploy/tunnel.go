@@ -0,0 +1,43 @@
+package deploy
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// _defaultConnections are the connection strings of the well known ports, for the components without one in the config.
+var _defaultConnections = map[int]string{
+	80:    "http://{host}:{port}",
+	443:   "https://{host}:{port}",
+	3000:  "http://{host}:{port}",
+	3306:  "mysql://{host}:{port}",
+	5432:  "postgres://{host}:{port}",
+	6379:  "redis://{host}:{port}",
+	8080:  "http://{host}:{port}",
+	27017: "mongodb://{host}:{port}",
+}
+
+// TunnelComponent returns the tunnel settings of the component from the project config, or the defaults if it has none.
+func TunnelComponent(pcfg projectconfig.Config, name string) projectconfig.TunnelConfig {
+	if i := slices.IndexFunc(pcfg.Tunnels, func(tc projectconfig.TunnelConfig) bool { return tc.Component == name }); i >= 0 {
+		tc := pcfg.Tunnels[i]
+		tc.Service = firstNonEmpty(tc.Service, name)
+		return tc
+	}
+	return projectconfig.TunnelConfig{Component: name, Service: name}
+}
+
+// ConnectionString returns the connection string of the tunnel to the component, or an empty string if it's not known.
+// The configured connection string is for the first port of the component, and the others get the default one of
+// their port.
+func ConnectionString(tc projectconfig.TunnelConfig, tun Tunnel) string {
+	conn := tc.Connection
+	if conn == "" || (len(tc.Ports) > 0 && tc.Ports[0] != tun.RemotePort) {
+		conn = _defaultConnections[tun.RemotePort]
+	}
+	return os.ExpandEnv(strings.NewReplacer("{host}", tun.Host, "{port}", strconv.Itoa(tun.LocalPort)).Replace(conn))
+}
@@ -0,0 +1,207 @@
+package dev
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+// _defaultAppComponents are the components of an app that does not list its components in ongoku.yaml (same as
+// `og create`).
+var _defaultAppComponents = []string{"backend", "database", "frontend"}
+
+// component is a component of the app, as it runs locally.
+type component struct {
+	Name string
+	projectconfig.DevComponentConfig
+}
+
+func (c component) isContainer() bool {
+	return c.Image != ""
+}
+
+func (c component) kind() string {
+	if c.isContainer() {
+		return "container"
+	}
+	return "process"
+}
+
+// defaultComponents returns how the components of a generated app run locally: the database in a postgres container,
+// the backend with `go run` (restarted when a .go file changes) and the frontend with its dev server.
+func defaultComponents(cfg ogconfig.Config) map[string]projectconfig.DevComponentConfig {
+	db := cfg.AppName.ToSnake()
+	if db == "" {
+		db = "app"
+	}
+	return map[string]projectconfig.DevComponentConfig{
+		"database": {
+			Image:   "postgres:16-alpine",
+			Env:     map[string]string{"POSTGRES_USER": db, "POSTGRES_PASSWORD": db, "POSTGRES_DB": db},
+			Ports:   []int{5432},
+			Volumes: []string{"pgdata:/var/lib/postgresql/data"},
+			HealthCheck: projectconfig.DevHealthCheckConfig{
+				Command: []string{"pg_isready", "-h", "localhost", "-U", db, "-d", db},
+			},
+		},
+		"backend": {
+			Command: []string{"go", "run", "."},
+			Dir:     "backend",
+			Env: map[string]string{
+				"DATABASE_URL": fmt.Sprintf("postgres://%s:%s@localhost:5432/%s?sslmode=disable", db, db, db),
+			},
+			Ports:     []int{8080},
+			DependsOn: []string{"database"},
+			Watch:     projectconfig.DevWatchConfig{Paths: []string{"."}, Extensions: []string{".go"}},
+		},
+		"frontend": {
+			Command:   []string{"yarn", "dev"},
+			Dir:       "apps",
+			Ports:     []int{3000},
+			DependsOn: []string{"backend"},
+		},
+	}
+}
+
+// resolveComponents returns the components to run, in the order they are started: the components enabled in
+// ongoku.yaml (with their defaults) and the ones of the project config, narrowed down to the selected ones and their
+// dependencies.
+func resolveComponents(cfg ogconfig.Config, pcfg projectconfig.Config, selected []string) ([]component, error) {
+	all := map[string]projectconfig.DevComponentConfig{}
+
+	enabled := cfg.Components
+	if len(enabled) == 0 {
+		enabled = _defaultAppComponents
+	}
+	defaults := defaultComponents(cfg)
+	for _, name := range enabled {
+		if c, ok := defaults[name]; ok {
+			all[name] = c
+		}
+	}
+	for name, c := range pcfg.Dev.Components {
+		all[name] = c
+	}
+	for name, c := range all {
+		if c.Disabled {
+			delete(all, name)
+			continue
+		}
+		if c.Image == "" && len(c.Command) == 0 {
+			return nil, fmt.Errorf("Component [%s] has neither an image nor a command. Set one under dev.components.%s in %s", name, name, projectconfig.FileName)
+		}
+		// Defaults may depend on components that the app does not have e.g. the backend of an app without a database
+		if _, configured := pcfg.Dev.Components[name]; !configured {
+			continue
+		}
+		for _, dep := range c.DependsOn {
+			if _, ok := all[dep]; !ok && !isDisabled(pcfg, dep) {
+				return nil, fmt.Errorf("Component [%s] depends on [%s], which is not a component. Components: %s", name, dep, strings.Join(sortedNames(all), ", "))
+			}
+		}
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("No components to run. Define them under dev.components in %s", projectconfig.FileName)
+	}
+
+	// The selected components and their dependencies
+	want := map[string]bool{}
+	var add func(name string) error
+	add = func(name string) error {
+		if want[name] {
+			return nil
+		}
+		c, ok := all[name]
+		if !ok {
+			// Dependencies on disabled (or missing) components are dropped e.g. when the database runs elsewhere
+			return nil
+		}
+		want[name] = true
+		for _, dep := range c.DependsOn {
+			err := add(dep)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(selected) == 0 {
+		selected = sortedNames(all)
+	}
+	for _, name := range selected {
+		if _, ok := all[name]; !ok {
+			return nil, ogerr.New(ogerr.CategoryUsage, "Unknown component [%s]. Components: %s", name, strings.Join(sortedNames(all), ", "))
+		}
+		err := add(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orderComponents(all, want)
+}
+
+// orderComponents sorts the wanted components so that each one comes after its dependencies. Components that do not
+// depend on each other are sorted by name.
+func orderComponents(all map[string]projectconfig.DevComponentConfig, want map[string]bool) ([]component, error) {
+	var ordered []component
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if done[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("Components depend on each other in a cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		visiting[name] = true
+		deps := slices.Clone(all[name].DependsOn)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if !want[dep] {
+				continue
+			}
+			err := visit(dep, append(path, name))
+			if err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		done[name] = true
+		ordered = append(ordered, component{Name: name, DevComponentConfig: all[name]})
+		return nil
+	}
+
+	for _, name := range sortedNames(all) {
+		if !want[name] {
+			continue
+		}
+		err := visit(name, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+func isDisabled(pcfg projectconfig.Config, name string) bool {
+	c, ok := pcfg.Dev.Components[name]
+	return ok && c.Disabled
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
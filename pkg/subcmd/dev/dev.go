@@ -0,0 +1,321 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	goutput "github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+const _downTimeout = 30 * time.Second
+
+type Args struct {
+	Up     *UpArgs   `arg:"subcommand:up" help:"Run the components of the app locally (e.g. database, backend, frontend), until Ctrl+C"`
+	Down   *DownArgs `arg:"subcommand:down" help:"Stop the components run by og dev up, and remove their containers"`
+	Status *struct{} `arg:"subcommand:status" help:"Show the components run by og dev up, and whether they are healthy"`
+}
+
+type UpArgs struct {
+	Components    []string      `arg:"positional" help:"The components to run, with their dependencies. Defaults to all the components of the app."`
+	HealthTimeout time.Duration `arg:"--health-timeout" help:"How long to wait for a component to be healthy e.g. 2m. Overrides dev.health_timeout in the project config. Defaults to 1m."`
+	NoColor       bool          `arg:"--no-color" help:"Do not colour the prefixes. Colours are only used when the output is a terminal and NO_COLOR is not set."`
+}
+
+type DownArgs struct {
+	Volumes bool `arg:"-v,--volumes" help:"Also remove the volumes of the containers e.g. the data of the database"`
+}
+
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	if args.Up == nil && args.Down == nil && args.Status == nil {
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
+	}
+
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+
+	switch {
+	case args.Up != nil:
+		err = up(ctx, cfg, pcfg, args.Up)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [up]")
+		}
+	case args.Down != nil:
+		err = down(ctx, cfg, args.Down)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [down]")
+		}
+	case args.Status != nil:
+		err = status(ctx, cfg, pcfg)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [status]")
+		}
+	}
+
+	return nil
+}
+
+func up(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, args *UpArgs) error {
+	components, err := resolveComponents(cfg, pcfg, args.Components)
+	if err != nil {
+		return err
+	}
+
+	healthTimeout := args.HealthTimeout
+	if healthTimeout == 0 && pcfg.Dev.HealthTimeout != "" {
+		healthTimeout, err = time.ParseDuration(pcfg.Dev.HealthTimeout)
+		if err != nil {
+			return errutil.Wrap(err, "Parsing dev.health_timeout [%s] in %s", pcfg.Dev.HealthTimeout, projectconfig.FileName)
+		}
+	}
+	if healthTimeout == 0 {
+		healthTimeout = _defaultHealthTimeout
+	}
+
+	prev, err := loadState(cfg.AppRootPath.Full)
+	if err != nil {
+		return err
+	}
+	if prev != nil && processAlive(prev.PID) {
+		return fmt.Errorf("og dev up is already running (pid %d). Stop it with og dev down", prev.PID)
+	}
+
+	var names []string
+	for _, c := range components {
+		names = append(names, c.Name)
+		if c.isContainer() {
+			_, err := exec.LookPath("docker")
+			if err != nil {
+				return fmt.Errorf("Component [%s] runs in a container, and docker is not installed", c.Name)
+			}
+		}
+	}
+	// What a crashed run left behind would hold the ports
+	if prev != nil {
+		cleanup(ctx, prev)
+	}
+	err = checkPorts(components)
+	if err != nil {
+		return err
+	}
+
+	s := &supervisor{
+		app:           appName(cfg),
+		appRootPath:   cfg.AppRootPath.Full,
+		healthTimeout: healthTimeout,
+		out:           newOutput(deploy.ColorEnabled(args.NoColor), components),
+		state:         newState(cfg.AppRootPath.Full),
+		failed:        make(chan error, len(components)),
+	}
+	err = s.state.save()
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Starting the app locally", "components", strings.Join(names, ", "))
+	return s.run(ctx, components)
+}
+
+// down stops a running `og dev up` and removes whatever it left behind: processes, containers and, if asked,
+// volumes.
+func down(ctx context.Context, cfg ogconfig.Config, args *DownArgs) error {
+	st, err := loadState(cfg.AppRootPath.Full)
+	if err != nil {
+		return err
+	}
+
+	if st != nil && processAlive(st.PID) {
+		log.Info(ctx, "Stopping og dev up", "pid", st.PID)
+		err = syscall.Kill(st.PID, syscall.SIGTERM)
+		if err != nil {
+			return errutil.Wrap(err, "Stopping og dev up (pid %d)", st.PID)
+		}
+		deadline := time.Now().Add(_downTimeout)
+		for processAlive(st.PID) && time.Now().Before(deadline) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if processAlive(st.PID) {
+			log.Warn(ctx, "og dev up did not stop in time, cleaning up after it", "pid", st.PID, "timeout", _downTimeout)
+		}
+	}
+	if st != nil {
+		cleanup(ctx, st)
+	}
+
+	_, err = exec.LookPath("docker")
+	if err != nil {
+		log.Debug(ctx, "Docker is not installed, no containers to remove")
+		return nil
+	}
+	app := appName(cfg)
+	err = removeByLabel(ctx, "container", app)
+	if err != nil {
+		return err
+	}
+	if args.Volumes {
+		err = removeByLabel(ctx, "volume", app)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info(ctx, "The app is stopped", "volumesRemoved", args.Volumes)
+	return nil
+}
+
+// cleanup stops the processes and removes the containers of the state, and the state file.
+func cleanup(ctx context.Context, st *state) {
+	for _, name := range sortedNames(st.Components) {
+		c := st.Components[name]
+		if c.PID > 0 && processAlive(c.PID) {
+			log.Info(ctx, "Stopping leftover process", "component", name, "pid", c.PID)
+			_ = syscall.Kill(-c.PID, syscall.SIGKILL)
+		}
+		if c.Container != "" {
+			_ = docker(ctx, "container", "rm", "--force", c.Container).Run()
+		}
+	}
+	err := st.remove()
+	if err != nil {
+		log.Warn(ctx, "Could not remove the dev state file", "error", err)
+	}
+}
+
+// removeByLabel removes the containers or volumes (kind) of og dev of the app.
+func removeByLabel(ctx context.Context, kind string, app string) error {
+	args := []string{kind, "ls", "--quiet", "--filter", "label=" + _labelDev + "=" + app}
+	if kind == "container" {
+		// Stopped ones too
+		args = append(args, "--all")
+	}
+	out, err := docker(ctx, args...).Output()
+	if err != nil {
+		return errutil.Wrap(err, "Listing the %ss of og dev", kind)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil
+	}
+	log.Info(ctx, "Removing "+kind+"s", "count", len(ids))
+	out, err = docker(ctx, append([]string{kind, "rm", "--force"}, ids...)...).CombinedOutput()
+	if err != nil {
+		return errutil.Wrap(err, "Removing the %ss of og dev: %s", kind, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Status is the result of og dev status.
+type Status struct {
+	// Running tells whether og dev up is running. The other fields are only set when it is.
+	Running bool `json:"running"`
+	// PID is the process of og dev up
+	PID        int               `json:"pid,omitempty"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	Components []ComponentStatus `json:"components"`
+}
+
+type ComponentStatus struct {
+	Name string `json:"name"`
+	// Kind is process or container
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	// Health is healthy, unhealthy, or empty when it's not checked (e.g. the component exited)
+	Health    string    `json:"health,omitempty"`
+	Ports     []int     `json:"ports"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (s Status) Identifiers() []string {
+	var names []string
+	for _, c := range s.Components {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// status prints the components of the running `og dev up`, with their live state and health.
+func status(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config) error {
+	st, err := loadState(cfg.AppRootPath.Full)
+	if err != nil {
+		return err
+	}
+	if st == nil || !processAlive(st.PID) {
+		result := Status{Components: []ComponentStatus{}}
+		return goutput.Print(os.Stdout, result, func(w io.Writer) { printStatus(w, result) })
+	}
+
+	// The components as configured, for their health checks
+	configured := map[string]component{}
+	components, err := resolveComponents(cfg, pcfg, nil)
+	if err != nil {
+		log.Warn(ctx, "Could not resolve the components, not checking their health", "error", err)
+	}
+	for _, c := range components {
+		configured[c.Name] = c
+	}
+
+	result := Status{Running: true, PID: st.PID, StartedAt: &st.StartedAt, Components: []ComponentStatus{}}
+	for _, name := range sortedNames(st.Components) {
+		c := st.Components[name]
+		cs := ComponentStatus{Name: name, Kind: c.Kind, Status: c.Status, Ports: goutput.NonNil(c.Ports), StartedAt: c.StartedAt}
+		if cc, ok := configured[name]; ok && c.Status != statusExited {
+			inst := &instance{component: cc, dir: filepath.Join(cfg.AppRootPath.Full, cc.Dir), container: c.Container, env: os.Environ()}
+			cs.Health = "healthy"
+			if err := checkHealth(ctx, inst); err != nil {
+				cs.Health = "unhealthy"
+			}
+		}
+		result.Components = append(result.Components, cs)
+	}
+	return goutput.Print(os.Stdout, result, func(w io.Writer) { printStatus(w, result) })
+}
+
+func printStatus(w io.Writer, s Status) {
+	if !s.Running {
+		fmt.Fprintln(w, "The app is not running. Start it with og dev up")
+		return
+	}
+	fmt.Fprintf(w, "og dev up is running (pid %d) since %s\n\n", s.PID, s.StartedAt.Format(time.DateTime))
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "COMPONENT\tKIND\tSTATUS\tHEALTH\tPORTS\tUPTIME")
+	for _, c := range s.Components {
+		health := c.Health
+		if health == "" {
+			health = "-"
+		}
+		var ports []string
+		for _, p := range c.Ports {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		uptime := "-"
+		if c.Status != statusExited && !c.StartedAt.IsZero() {
+			uptime = time.Since(c.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.Kind, c.Status, health, strings.Join(ports, ","), uptime)
+	}
+}
+
+// appName is the name the containers and volumes of the app are named after.
+func appName(cfg ogconfig.Config) string {
+	if name := cfg.AppName.ToKebab(); name != "" {
+		return name
+	}
+	return "app"
+}
@@ -0,0 +1,96 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+)
+
+const (
+	_defaultHealthTimeout = time.Minute
+	_healthAttemptTimeout = 5 * time.Second
+	_healthRetryInterval  = time.Second
+)
+
+// checkHealth checks once whether the component is ready. Components without a health check and without ports are
+// ready as soon as they run.
+func checkHealth(ctx context.Context, c *instance) error {
+	ctx, cancel := context.WithTimeout(ctx, _healthAttemptTimeout)
+	defer cancel()
+
+	hc := c.HealthCheck
+	switch {
+	case len(hc.Command) > 0:
+		var cmd *exec.Cmd
+		if c.isContainer() {
+			cmd = docker(ctx, append([]string{"exec", c.container}, hc.Command...)...)
+		} else {
+			cmd = interrupt.Command(ctx, hc.Command[0], hc.Command[1:]...)
+			cmd.Dir, cmd.Env = c.dir, c.env
+		}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return errutil.Wrap(err, "Running health check command [%s]: %s", cmd, out)
+		}
+		return nil
+
+	case hc.URL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.URL, nil)
+		if err != nil {
+			return errutil.Wrap(err, "Creating health check request")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("Got status [%d] from [%s]", resp.StatusCode, hc.URL)
+		}
+		return nil
+	}
+
+	port := hc.Port
+	if port == 0 && len(c.Ports) > 0 {
+		port = c.Ports[0]
+	}
+	if port == 0 {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// checkPorts returns an error if a port of the components is used twice, or is already in use on this machine.
+func checkPorts(components []component) error {
+	owners := map[int]string{}
+	for _, c := range components {
+		for _, p := range c.Ports {
+			if other, ok := owners[p]; ok {
+				return fmt.Errorf("Port %d is used by both [%s] and [%s]. Change the ports under dev.components in %s", p, other, c.Name, projectconfig.FileName)
+			}
+			owners[p] = c.Name
+
+			l, err := net.Listen("tcp", ":"+strconv.Itoa(p))
+			if err != nil {
+				return fmt.Errorf("Port %d of [%s] is already in use. Stop what is using it, or change dev.components.%s.ports in %s", p, c.Name, c.Name, projectconfig.FileName)
+			}
+			l.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goutput "github.com/build-ongoku/ongoku-cli/pkg/output"
+)
+
+// _prefixColors are the colours of the prefixes, picked by component so that each one keeps its colour.
+var _prefixColors = []string{"\033[36m", "\033[32m", "\033[33m", "\033[35m", "\033[34m", "\033[91m", "\033[96m", "\033[92m"}
+
+const _colorReset = "\033[0m"
+
+// output multiplexes the output of the components, prefixing each line with the name of its component (like docker
+// compose). In json and yaml, each line is a Line.
+type output struct {
+	color bool
+	// width the names are padded to, so that the lines line up
+	width int
+	mu    sync.Mutex
+}
+
+func newOutput(color bool, components []component) *output {
+	o := &output{color: color}
+	for _, c := range components {
+		o.width = max(o.width, len(c.Name))
+	}
+	return o
+}
+
+// writer returns the writer of the component's output. Lines are written whole, so that the output of the components
+// does not interleave mid-line.
+func (o *output) writer(name string) *lineWriter {
+	prefix := name + strings.Repeat(" ", o.width-len(name)) + " | "
+	if o.color {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		prefix = _prefixColors[h.Sum32()%uint32(len(_prefixColors))] + prefix + _colorReset
+	}
+	return &lineWriter{o: o, name: name, prefix: prefix}
+}
+
+// Line is a line of the output of a component.
+type Line struct {
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Message   string    `json:"message"`
+}
+
+type lineWriter struct {
+	o      *output
+	name   string
+	prefix string
+	buf    []byte
+	mu     sync.Mutex
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.print(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line, if it did not end with a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.print(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) print(line []byte) {
+	w.o.mu.Lock()
+	defer w.o.mu.Unlock()
+	line = bytes.TrimRight(line, "\r")
+	_ = goutput.PrintItem(os.Stdout, Line{Time: time.Now().UTC(), Component: w.name, Message: string(line)}, func(out io.Writer) {
+		fmt.Fprintf(out, "%s%s\n", w.prefix, line)
+	})
+}
@@ -0,0 +1,123 @@
+package dev
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+)
+
+// _stateFile is where `og dev up` records what it runs, relative to the app root, so that `og dev status` and
+// `og dev down` can find it.
+var _stateFile = filepath.Join(".goku", "dev", "state.json")
+
+// Statuses of a component
+const (
+	statusStarting   = "starting"
+	statusHealthy    = "healthy"
+	statusRestarting = "restarting"
+	statusExited     = "exited"
+)
+
+type state struct {
+	// PID of `og dev up`
+	PID        int                        `json:"pid"`
+	StartedAt  time.Time                  `json:"started_at"`
+	Components map[string]*componentState `json:"components"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type componentState struct {
+	Kind string `json:"kind"`
+	// Container of a container component
+	Container string `json:"container,omitempty"`
+	// PID of the process (group) of a process component
+	PID       int       `json:"pid,omitempty"`
+	Ports     []int     `json:"ports,omitempty"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func statePath(appRootPath string) string {
+	return filepath.Join(appRootPath, _stateFile)
+}
+
+// loadState reads the state of the last `og dev up`, and nil if there is none.
+func loadState(appRootPath string) (*state, error) {
+	path := statePath(appRootPath)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errutil.Wrap(err, "Reading dev state file [%s]", path)
+	}
+	s := state{path: path}
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, errutil.Wrap(err, "Parsing dev state file [%s]", path)
+	}
+	return &s, nil
+}
+
+func newState(appRootPath string) *state {
+	return &state{
+		PID:        os.Getpid(),
+		StartedAt:  time.Now(),
+		Components: map[string]*componentState{},
+		path:       statePath(appRootPath),
+	}
+}
+
+// update changes the state of the component and saves the state.
+func (s *state) update(name string, f func(c *componentState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.Components[name]
+	if !ok {
+		c = &componentState{}
+		s.Components[name] = c
+	}
+	f(c)
+	return s.save()
+}
+
+func (s *state) save() error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return errutil.Wrap(err, "Creating directory of dev state file [%s]", s.path)
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errutil.Wrap(err, "Encoding dev state")
+	}
+	err = os.WriteFile(s.path, b, 0o644)
+	if err != nil {
+		return errutil.Wrap(err, "Writing dev state file [%s]", s.path)
+	}
+	return nil
+}
+
+func (s *state) remove() error {
+	err := os.Remove(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errutil.Wrap(err, "Removing dev state file [%s]", s.path)
+	}
+	return nil
+}
+
+// processAlive tells whether the process exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
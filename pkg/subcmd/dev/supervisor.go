@@ -0,0 +1,426 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/interrupt"
+)
+
+// Labels of the containers and volumes of `og dev`, so that `og dev down` finds them even if `og dev up` crashed
+const (
+	_labelDev          = "ongoku.build/dev"
+	_labelDevComponent = "ongoku.build/dev-component"
+)
+
+const (
+	_stopTimeout   = 10 * time.Second
+	_watchInterval = time.Second
+)
+
+// instance is a component being run.
+type instance struct {
+	component
+	// dir of the process
+	dir string
+	// vars are the KEY=VALUE env variables of the component (env file and env), and env the full env of its process
+	vars []string
+	env  []string
+	// container of a container component
+	container string
+	out       *lineWriter
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+	// exited is closed when the process (or container) exits, with the error in exitErr
+	exited  chan struct{}
+	exitErr error
+}
+
+// supervisor starts the components in order, keeps them running, and stops them in reverse order.
+type supervisor struct {
+	// app is the name of the app, used to name the containers and volumes
+	app           string
+	appRootPath   string
+	healthTimeout time.Duration
+	out           *output
+	state         *state
+	// failed receives the error of a component that exited, and is not restarted on changes
+	failed chan error
+}
+
+func (s *supervisor) newInstance(c component) (*instance, error) {
+	inst := &instance{
+		component: c,
+		dir:       filepath.Join(s.appRootPath, c.Dir),
+		container: s.app + "-dev-" + c.Name,
+		out:       s.out.writer(c.Name),
+		exited:    make(chan struct{}),
+	}
+	close(inst.exited)
+
+	if c.EnvFile != "" {
+		path := c.EnvFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.appRootPath, path)
+		}
+		values, err := godotenv.Read(path)
+		if err != nil {
+			return nil, errutil.Wrap(err, "Reading env file [%s] of component [%s]", path, c.Name)
+		}
+		for _, k := range sortedNames(values) {
+			inst.vars = append(inst.vars, k+"="+values[k])
+		}
+	}
+	for _, k := range sortedNames(c.Env) {
+		inst.vars = append(inst.vars, k+"="+os.ExpandEnv(c.Env[k]))
+	}
+	inst.env = append(os.Environ(), inst.vars...)
+
+	return inst, nil
+}
+
+// run starts the components, each one once its dependencies are healthy, and keeps them running until the context is
+// done or one of them exits. The components are stopped before it returns.
+func (s *supervisor) run(ctx context.Context, components []component) error {
+	var started []*instance
+	var wg sync.WaitGroup
+	superviseCtx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		wg.Wait()
+		s.shutdown(started)
+	}()
+
+	for _, c := range components {
+		inst, err := s.newInstance(c)
+		if err != nil {
+			return err
+		}
+		log.Info(ctx, "Starting component", "component", c.Name, "kind", c.kind())
+		err = s.start(ctx, inst)
+		if err != nil {
+			return err
+		}
+		started = append(started, inst)
+
+		err = s.waitHealthy(ctx, inst)
+		if err != nil {
+			return errutil.Wrap(err, "Waiting for component [%s] to be healthy", c.Name)
+		}
+		s.setStatus(ctx, inst, statusHealthy)
+		log.Info(ctx, "Component is ready", "component", c.Name, "ports", c.Ports)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.supervise(superviseCtx, inst)
+		}()
+	}
+
+	log.Info(ctx, "All components are up. Press Ctrl+C to stop them.")
+	select {
+	case <-ctx.Done():
+		log.Info(ctx, "Stopping the components")
+		return nil
+	case err := <-s.failed:
+		return err
+	}
+}
+
+func (s *supervisor) start(ctx context.Context, inst *instance) error {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	s.setStatus(ctx, inst, statusStarting)
+	if inst.isContainer() {
+		return s.startContainer(ctx, inst)
+	}
+	return s.startProcess(ctx, inst)
+}
+
+func (s *supervisor) startProcess(ctx context.Context, inst *instance) error {
+	cmd := exec.Command(inst.Command[0], inst.Command[1:]...)
+	cmd.Dir, cmd.Env = inst.dir, inst.env
+	cmd.Stdout, cmd.Stderr = inst.out, inst.out
+	// Its own process group, so that the children (e.g. the binary built by `go run`) are stopped with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	log.Debug(ctx, "Starting process", "component", inst.Name, "command", cmd.String(), "dir", cmd.Dir)
+	err := cmd.Start()
+	if err != nil {
+		return errutil.Wrap(err, "Starting component [%s] with command [%s]", inst.Name, cmd)
+	}
+
+	exited := make(chan struct{})
+	inst.cmd, inst.exited = cmd, exited
+	go func() {
+		err := cmd.Wait()
+		inst.out.Flush()
+		inst.exitErr = err
+		close(exited)
+	}()
+
+	return s.state.update(inst.Name, func(c *componentState) {
+		c.Kind, c.PID, c.Ports, c.StartedAt = inst.kind(), cmd.Process.Pid, inst.Ports, time.Now()
+	})
+}
+
+func (s *supervisor) startContainer(ctx context.Context, inst *instance) error {
+	// A container left behind by a crashed run
+	_ = docker(ctx, "container", "rm", "--force", inst.container).Run()
+
+	args := []string{"run", "--detach", "--name", inst.container,
+		"--label", _labelDev + "=" + s.app, "--label", _labelDevComponent + "=" + inst.Name}
+	for _, p := range inst.Ports {
+		args = append(args, "--publish", fmt.Sprintf("%d:%d", p, p))
+	}
+	for _, v := range inst.Volumes {
+		v, err := s.volume(ctx, v)
+		if err != nil {
+			return errutil.Wrap(err, "Preparing the volumes of component [%s]", inst.Name)
+		}
+		args = append(args, "--volume", v)
+	}
+	for _, v := range inst.vars {
+		args = append(args, "--env", v)
+	}
+	args = append(args, inst.Image)
+	args = append(args, inst.Command...)
+
+	cmd := docker(ctx, args...)
+	log.Debug(ctx, "Starting container", "component", inst.Name, "command", cmd.String())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errutil.Wrap(err, "Starting component [%s] with command [%s]: %s", inst.Name, cmd, strings.TrimSpace(string(out)))
+	}
+
+	// The logs end when the container is removed
+	logs := docker(context.Background(), "container", "logs", "--follow", inst.container)
+	logs.Stdout, logs.Stderr = inst.out, inst.out
+	err = logs.Start()
+	if err != nil {
+		return errutil.Wrap(err, "Streaming the logs of component [%s]", inst.Name)
+	}
+
+	exited := make(chan struct{})
+	inst.cmd, inst.exited = logs, exited
+	go func() {
+		out, err := docker(context.Background(), "container", "wait", inst.container).Output()
+		code := strings.TrimSpace(string(out))
+		if err == nil {
+			err = fmt.Errorf("Container exited with code %s", code)
+		}
+		logs.Wait()
+		inst.out.Flush()
+		inst.exitErr = err
+		close(exited)
+	}()
+
+	return s.state.update(inst.Name, func(c *componentState) {
+		c.Kind, c.Container, c.Ports, c.StartedAt = inst.kind(), inst.container, inst.Ports, time.Now()
+	})
+}
+
+// volume returns the --volume of the container. Named volumes are prefixed with the app name (and created with the
+// label of og dev), and relative paths are relative to the app root.
+func (s *supervisor) volume(ctx context.Context, v string) (string, error) {
+	src, rest, ok := strings.Cut(v, ":")
+	if !ok {
+		return v, nil
+	}
+	if strings.HasPrefix(src, ".") || strings.HasPrefix(src, "/") || strings.HasPrefix(src, "~") {
+		if !filepath.IsAbs(src) && !strings.HasPrefix(src, "~") {
+			src = filepath.Join(s.appRootPath, src)
+		}
+		return src + ":" + rest, nil
+	}
+
+	name := s.app + "-dev-" + src
+	out, err := docker(ctx, "volume", "create", "--label", _labelDev+"="+s.app, name).CombinedOutput()
+	if err != nil {
+		return "", errutil.Wrap(err, "Creating volume [%s]: %s", name, strings.TrimSpace(string(out)))
+	}
+	return name + ":" + rest, nil
+}
+
+// stop stops the component, if it's running.
+func (s *supervisor) stop(ctx context.Context, inst *instance) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	select {
+	case <-inst.exited:
+		if inst.isContainer() {
+			_ = docker(ctx, "container", "rm", "--force", inst.container).Run()
+		}
+		return
+	default:
+	}
+
+	if inst.isContainer() {
+		out, err := docker(ctx, "container", "stop", "--time", strconv.Itoa(int(_stopTimeout.Seconds())), inst.container).CombinedOutput()
+		if err != nil {
+			log.Warn(ctx, "Could not stop container", "component", inst.Name, "container", inst.container, "error", err, "output", strings.TrimSpace(string(out)))
+		}
+		_ = docker(ctx, "container", "rm", "--force", inst.container).Run()
+		<-inst.exited
+		return
+	}
+
+	pid := inst.cmd.Process.Pid
+	_ = syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case <-inst.exited:
+	case <-time.After(_stopTimeout):
+		log.Warn(ctx, "Component did not stop in time, killing it", "component", inst.Name, "pid", pid)
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+		<-inst.exited
+	}
+}
+
+// shutdown stops the components in the reverse order they were started in.
+func (s *supervisor) shutdown(started []*instance) {
+	ctx := context.Background()
+	for _, inst := range slices.Backward(started) {
+		log.Info(ctx, "Stopping component", "component", inst.Name)
+		s.stop(ctx, inst)
+	}
+	err := s.state.remove()
+	if err != nil {
+		log.Warn(ctx, "Could not remove the dev state file", "error", err)
+	}
+}
+
+// waitHealthy waits for the health check of the component to pass.
+func (s *supervisor) waitHealthy(ctx context.Context, inst *instance) error {
+	deadline := time.After(s.healthTimeout)
+	exited := inst.exited
+	for {
+		err := checkHealth(ctx, inst)
+		if err == nil {
+			return nil
+		}
+		log.Debug(ctx, "Component is not healthy yet", "component", inst.Name, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-exited:
+			return errutil.Wrap(inst.exitErr, "Component exited before being healthy")
+		case <-deadline:
+			return errutil.Wrap(err, "Component is not healthy after %s", s.healthTimeout)
+		case <-time.After(_healthRetryInterval):
+		}
+	}
+}
+
+// supervise watches the running component. Components with watched files are restarted when the files change, and
+// wait for a change if they exit. Other components fail the run when they exit.
+func (s *supervisor) supervise(ctx context.Context, inst *instance) {
+	if len(inst.Watch.Paths) == 0 {
+		select {
+		case <-ctx.Done():
+		case <-inst.exited:
+			s.setStatus(ctx, inst, statusExited)
+			s.failed <- fmt.Errorf("Component [%s] exited: %v", inst.Name, inst.exitErr)
+		}
+		return
+	}
+
+	last := s.fingerprint(inst)
+	exited := inst.exited
+	ticker := time.NewTicker(_watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-exited:
+			exited = nil
+			s.setStatus(ctx, inst, statusExited)
+			log.Warn(ctx, "Component exited. It will be restarted when its files change.", "component", inst.Name, "error", inst.exitErr)
+		case <-ticker.C:
+			fp := s.fingerprint(inst)
+			if fp == last {
+				continue
+			}
+			last = fp
+			log.Info(ctx, "Files changed, restarting component", "component", inst.Name)
+			s.setStatus(ctx, inst, statusRestarting)
+			s.stop(ctx, inst)
+			err := s.start(ctx, inst)
+			if err != nil {
+				s.setStatus(ctx, inst, statusExited)
+				log.Warn(ctx, "Could not restart component. It will be restarted when its files change.", "component", inst.Name, "error", err)
+				continue
+			}
+			exited = inst.exited
+			go func() {
+				err := s.waitHealthy(ctx, inst)
+				if err != nil {
+					log.Warn(ctx, "Restarted component is not healthy", "component", inst.Name, "error", err)
+					return
+				}
+				s.setStatus(ctx, inst, statusHealthy)
+				log.Info(ctx, "Component is ready", "component", inst.Name)
+			}()
+		}
+	}
+}
+
+// fingerprint summarises the watched files of the component (their number, sizes and modification times), so that a
+// change to any of them changes it.
+func (s *supervisor) fingerprint(inst *instance) string {
+	var files, sum int64
+	for _, p := range inst.Watch.Paths {
+		root := filepath.Join(inst.dir, p)
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != root && (name == "node_modules" || strings.HasPrefix(name, ".") || slices.Contains(inst.Watch.Ignore, name)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if len(inst.Watch.Extensions) > 0 && !slices.Contains(inst.Watch.Extensions, filepath.Ext(path)) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files++
+			sum += info.ModTime().UnixNano() + info.Size()
+			return nil
+		})
+	}
+	return fmt.Sprintf("%d-%d", files, sum)
+}
+
+func (s *supervisor) setStatus(ctx context.Context, inst *instance, status string) {
+	err := s.state.update(inst.Name, func(c *componentState) { c.Status = status })
+	if err != nil {
+		log.Warn(ctx, "Could not save the dev state", "error", err)
+	}
+}
+
+func docker(ctx context.Context, args ...string) *exec.Cmd {
+	return interrupt.Command(ctx, "docker", args...)
+}
@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+	"golang.org/x/term"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+// _defaultShell starts bash if the image has it, and sh otherwise.
+var _defaultShell = []string{"/bin/sh", "-c", "command -v bash >/dev/null 2>&1 && exec bash || exec sh"}
+
+type (
+	Args struct {
+		Component string   `arg:"positional,required" help:"The workload to run the command in e.g. backend"`
+		Command   []string `arg:"positional,required" help:"The command to run, after -- e.g. og exec backend -- ./migrate up"`
+		Stdin     bool     `arg:"-i,--stdin" help:"Pass stdin to the command"`
+		TTY       bool     `arg:"-t,--tty" help:"Run the command in a terminal. Implies --stdin."`
+		CommonFlags
+	}
+	ShellArgs struct {
+		Component string `arg:"positional,required" help:"The workload to open a shell in e.g. backend"`
+		Shell     string `arg:"--shell" help:"The shell to run e.g. /bin/bash. Defaults to bash if the image has it, and sh otherwise."`
+		CommonFlags
+	}
+	CommonFlags struct {
+		Container string `arg:"-c,--container" help:"The container of the workload. Defaults to its first container."`
+		OneOff    bool   `arg:"--one-off" help:"Run in a new one-off container (a k8s Job, or docker compose run) using the same image, instead of a serving one"`
+
+		Env              string `arg:"-e,--env,env:GOKU_DEPLOY_ENV" help:"The environment of the deployment, as defined under environments in ongoku.cli.yaml e.g. staging, prod"`
+		DeployIdentifier string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The deploy identifier of the deployment"`
+		Approve          bool   `arg:"--approve,env:GOKU_DEPLOY_APPROVE" help:"Approve running commands in environments that require approval, without being asked"`
+		deploy.KubeFlags
+	}
+)
+
+// Run runs a command in a workload of the deployment. If the command exits with a non-zero code, a
+// ogerr.ExitCodeError is returned.
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	return run(ctx, cfg, args.CommonFlags, args.Component, args.Command, args.Stdin || args.TTY, args.TTY)
+}
+
+// RunShell opens an interactive shell in a workload of the deployment.
+func RunShell(ctx context.Context, cfg ogconfig.Config, args *ShellArgs) error {
+	command := _defaultShell
+	if args.Shell != "" {
+		command = []string{args.Shell}
+	}
+	return run(ctx, cfg, args.CommonFlags, args.Component, command, true, term.IsTerminal(int(os.Stdin.Fd())))
+}
+
+func run(ctx context.Context, cfg ogconfig.Config, flags CommonFlags, component string, command []string, stdin bool, tty bool) error {
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+	commonFlags := deploy.CommonFlags{DeployIdentifier: flags.DeployIdentifier, Env: flags.Env, Approve: flags.Approve}
+	pcfg, err = deploy.ResolveEnv(ctx, cfg, pcfg, &commonFlags)
+	if err != nil {
+		return err
+	}
+	err = deploy.ApproveEnvChange(ctx, cfg, pcfg, commonFlags, fmt.Sprintf("run a command in [%s]", component))
+	if err != nil {
+		return err
+	}
+
+	t, err := deploy.GetTarget(ctx, cfg, pcfg, commonFlags, deploy.TargetSettings{KubeFlags: flags.KubeFlags})
+	if err != nil {
+		return err
+	}
+
+	opts := deploy.ExecOptions{
+		Workload:  component,
+		Container: flags.Container,
+		Command:   command,
+		OneOff:    flags.OneOff,
+		Stdout:    os.Stdout,
+		Stderr:    os.Stderr,
+		TTY:       tty,
+	}
+	if stdin {
+		opts.Stdin = os.Stdin
+	}
+	if tty {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return fmt.Errorf("Cannot run the command in a terminal, stdin is not a terminal. Remove --tty.")
+		}
+		opts.TerminalSize = func() (uint16, uint16, bool) {
+			w, h, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil {
+				return 0, 0, false
+			}
+			return uint16(w), uint16(h), true
+		}
+		// The remote terminal handles the keys (e.g. Ctrl-C goes to the command)
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return errutil.Wrap(err, "Setting the terminal to raw mode")
+		}
+		defer term.Restore(fd, state)
+	}
+
+	log.Debug(ctx, "Running command", "target", t.Name(), "component", component, "command", command, "tty", tty)
+	err = t.Exec(ctx, opts)
+	if err != nil {
+		var exitErr ogerr.ExitCodeError
+		if errors.As(err, &exitErr) {
+			return err
+		}
+		return errutil.Wrap(err, "Running command with the %s target", t.Name())
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+type Args struct {
+	Components []string `arg:"positional" help:"The workloads (e.g. backend) to show the logs of. Defaults to all the workloads of the deployment."`
+
+	// Flags
+	Env              string        `arg:"-e,--env,env:GOKU_DEPLOY_ENV" help:"The environment of the deployment, as defined under environments in ongoku.cli.yaml e.g. staging, prod"`
+	DeployIdentifier string        `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The deploy identifier of the deployment"`
+	Follow           bool          `arg:"-f,--follow" help:"Keep streaming new lines. New pods and restarted containers are picked up along the way."`
+	Since            time.Duration `arg:"--since" help:"Only show the lines newer than this duration e.g. 10m, 1h"`
+	Tail             int64         `arg:"--tail" help:"Number of lines to show from the end of the logs of each container. Defaults to all."`
+	Grep             string        `arg:"--grep" help:"Only show the lines that match this regular expression"`
+	JSON             bool          `arg:"--json" help:"Print each line as a JSON object (time, workload, instance, container, message), for piping. Same as --output json."`
+	NoColor          bool          `arg:"--no-color" help:"Do not colour the prefixes. Colours are only used when the output is a terminal and NO_COLOR is not set."`
+	deploy.KubeFlags
+}
+
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	var grep *regexp.Regexp
+	if args.Grep != "" {
+		var err error
+		grep, err = regexp.Compile(args.Grep)
+		if err != nil {
+			return errutil.Wrap(err, "Parsing --grep")
+		}
+	}
+
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+	commonFlags := deploy.CommonFlags{DeployIdentifier: args.DeployIdentifier, Env: args.Env}
+	pcfg, err = deploy.ResolveEnv(ctx, cfg, pcfg, &commonFlags)
+	if err != nil {
+		return err
+	}
+
+	t, err := deploy.GetTarget(ctx, cfg, pcfg, commonFlags, deploy.TargetSettings{KubeFlags: args.KubeFlags})
+	if err != nil {
+		return err
+	}
+	log.Debug(ctx, "Streaming logs", "target", t.Name(), "components", args.Components, "follow", args.Follow)
+
+	if args.JSON {
+		output.SetFormat(output.FormatJSON)
+	}
+	p := printer{color: !output.IsStructured() && deploy.ColorEnabled(args.NoColor)}
+	err = t.Logs(ctx, deploy.LogsOptions{
+		Workloads: args.Components,
+		Follow:    args.Follow,
+		Since:     args.Since,
+		Tail:      args.Tail,
+		Handle: func(l deploy.LogLine) {
+			if grep != nil && !grep.MatchString(l.Message) {
+				return
+			}
+			p.print(l)
+		},
+	})
+	if err != nil {
+		return errutil.Wrap(err, "Streaming logs from the %s target", t.Name())
+	}
+	return nil
+}
+
+// _prefixColors are the colours of the prefixes, picked by pod and container so that each stream keeps its colour.
+var _prefixColors = []string{"\033[36m", "\033[32m", "\033[33m", "\033[35m", "\033[34m", "\033[91m", "\033[96m", "\033[92m"}
+
+const _colorReset = "\033[0m"
+
+type printer struct {
+	color bool
+}
+
+// print writes the line as it comes, on its own in json and yaml.
+func (p printer) print(l deploy.LogLine) {
+	_ = output.PrintItem(os.Stdout, l, func(w io.Writer) {
+		prefix := l.Instance
+		if l.Container != "" {
+			prefix += "/" + l.Container
+		}
+		if prefix == "" {
+			fmt.Fprintln(w, l.Message)
+			return
+		}
+		if p.color {
+			h := fnv.New32a()
+			h.Write([]byte(prefix))
+			prefix = _prefixColors[h.Sum32()%uint32(len(_prefixColors))] + prefix + _colorReset
+		}
+		fmt.Fprintf(w, "[%s] %s\n", prefix, l.Message)
+	})
+}
@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/client/beta/appclient"
+	"github.com/build-ongoku/ongoku-cli/pkg/local"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/plugin"
+)
+
+type Args struct {
+	List    *struct{}    `arg:"subcommand:list" help:"List the plugins, which run as og <name>: og-<name> executables in ~/.ongoku/plugins or on the PATH"`
+	Install *InstallArgs `arg:"subcommand:install" help:"Install a plugin into ~/.ongoku/plugins, from a file or an https URL"`
+	Remove  *RemoveArgs  `arg:"subcommand:remove" help:"Remove a plugin installed with og plugin install"`
+
+	// Set by og
+	GokuVersion string   `arg:"-"`
+	Reserved    []string `arg:"-"`
+}
+
+type (
+	InstallArgs struct {
+		Source         string `arg:"positional,required" help:"The path or https URL of the plugin executable, named og-<name>. Its manifest, og-<name>.yaml next to it, is installed too if there is one (for URLs, only with --manifest-sha256)."`
+		Name           string `arg:"--name" help:"The name of the plugin, which runs as og <name>. Defaults to the name of the executable without og-"`
+		Force          bool   `arg:"--force" help:"Install the plugin even if its manifest says it does not work with this version of og"`
+		SHA256         string `arg:"--sha256" help:"The SHA-256 checksum (hex) of the plugin executable, which is checked before it's installed. Required for URLs"`
+		ManifestSHA256 string `arg:"--manifest-sha256" help:"The SHA-256 checksum (hex) of the plugin manifest, which is checked before it's installed. The manifest of a URL is only installed with it"`
+	}
+	RemoveArgs struct {
+		Name string `arg:"positional,required" help:"The name of the plugin"`
+	}
+)
+
+func Run(ctx context.Context, args *Args) error {
+	if args.List == nil && args.Install == nil && args.Remove == nil {
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
+	}
+
+	switch {
+	case args.List != nil:
+		err := list(ctx, args)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [list]")
+		}
+	case args.Install != nil:
+		p, err := plugin.Install(ctx, args.Install.Source, plugin.InstallOptions{
+			Name:           args.Install.Name,
+			OgVersion:      args.GokuVersion,
+			Force:          args.Install.Force,
+			SHA256:         args.Install.SHA256,
+			ManifestSHA256: args.Install.ManifestSHA256,
+		})
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [install]")
+		}
+		var version string
+		if p.Manifest != nil {
+			version = p.Manifest.Version
+		}
+		log.Info(ctx, "Installed plugin. Run it with og "+p.Name, "plugin", p.Name, "version", version, "path", p.Path)
+	case args.Remove != nil:
+		p, err := plugin.Remove(ctx, args.Remove.Name)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [remove]")
+		}
+		log.Info(ctx, "Removed plugin", "plugin", p.Name, "path", p.Path)
+	}
+
+	return nil
+}
+
+// PluginList is the result of plugin list.
+type PluginList struct {
+	Plugins []ListedPlugin `json:"plugins"`
+}
+
+type ListedPlugin struct {
+	plugin.Plugin
+	// Compatible tells whether the plugin works with this version of og, as per its manifest
+	Compatible bool `json:"compatible"`
+}
+
+// Identifiers returns the names of the plugins.
+func (l PluginList) Identifiers() []string {
+	var names []string
+	for _, p := range l.Plugins {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func list(ctx context.Context, args *Args) error {
+	plugins, err := plugin.List(ctx, args.Reserved)
+	if err != nil {
+		return err
+	}
+	result := PluginList{Plugins: []ListedPlugin{}}
+	for _, p := range plugins {
+		result.Plugins = append(result.Plugins, ListedPlugin{Plugin: p, Compatible: p.CheckOgVersion(args.GokuVersion) == nil})
+	}
+	return output.Print(os.Stdout, result, func(w io.Writer) { printPlugins(w, result) })
+}
+
+func printPlugins(w io.Writer, l PluginList) {
+	if len(l.Plugins) == 0 {
+		fmt.Fprintln(w, "No plugins found. Install one with og plugin install, or put an og-<name> executable on the PATH")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "NAME\tVERSION\tOG VERSION\tPATH\tDESCRIPTION")
+	for _, p := range l.Plugins {
+		version, ogVersion, description := "-", "-", ""
+		if m := p.Manifest; m != nil {
+			version, ogVersion, description = valueOr(m.Version, "-"), valueOr(m.OgVersion, "-"), m.Description
+		}
+		if !p.Compatible {
+			ogVersion += " (not this one)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Name, version, ogVersion, p.Path, description)
+	}
+}
+
+func valueOr(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// RunOptions are the settings of og that a plugin is run with.
+type RunOptions struct {
+	GokuVersion            string
+	AppRootFromCurrDirPath string
+	LogLevel               string
+	Output                 string
+}
+
+// RunPlugin runs the plugin with the name (og <name> args...), with the context og resolved in env variables. If the
+// plugin fails with an exit code, ogerr.ExitCodeError is returned.
+func RunPlugin(ctx context.Context, name string, args []string, opts RunOptions) error {
+	p, ok, err := plugin.Find(ctx, name)
+	if err != nil {
+		return errutil.Wrap(err, "Finding plugin [%s]", name)
+	}
+	if !ok {
+		return ogerr.New(ogerr.CategoryUsage, "Unknown command [%s]. See og --help for the commands, and og plugin list for the plugins", name)
+	}
+	err = p.CheckOgVersion(opts.GokuVersion)
+	if err != nil {
+		return err
+	}
+
+	env := plugin.Env{
+		OgVersion: opts.GokuVersion,
+		LogLevel:  opts.LogLevel,
+		Output:    opts.Output,
+		ServerURL: appclient.BaseURL(),
+	}
+	env.OgBin, err = os.Executable()
+	if err != nil {
+		log.Debug(ctx, "Could not get the path of og, not passing it to the plugin", "error", err)
+	}
+	// Plugins can run outside of an app too
+	err = ogconfig.InitializeConfig(opts.GokuVersion, &ogconfig.CLIConfig{AppRootFromCurrDirPath: opts.AppRootFromCurrDirPath})
+	if err != nil {
+		log.Debug(ctx, "Not in an app, not passing it to the plugin", "error", err)
+	} else {
+		cfg := ogconfig.GetConfig()
+		env.AppRoot, env.AppName = cfg.AppRootPath.Full, cfg.AppName.ToKebab()
+	}
+	env.Profile, err = local.GetDefaultConfigFilePath(ctx)
+	if err == nil {
+		profile, err := local.LoadConfig(ctx, env.Profile)
+		if err != nil {
+			log.Debug(ctx, "Could not load the profile config, not passing the token to the plugin", "error", err)
+		}
+		env.Token = profile.Temporary.Token
+	}
+
+	log.Debug(ctx, "Running plugin", "plugin", p.Path, "args", args)
+	err = p.Run(ctx, args, env)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return ogerr.ExitCodeError{Code: exitErr.ExitCode()}
+	}
+	if err != nil {
+		return errutil.Wrap(err, "Running plugin [%s]", p.Path)
+	}
+	return nil
+}
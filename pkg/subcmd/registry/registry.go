@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	reg "github.com/build-ongoku/ongoku-cli/pkg/registry"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+type Args struct {
+	Login *struct{} `arg:"subcommand:login" help:"Log the image builder into the configured registry, using the configured credentials source."`
+	Check *struct{} `arg:"subcommand:check" help:"Verify that the configured credentials can push to the image repo(s)."`
+
+	// Flags
+	ImageRepo        string `arg:"--image-repo,env:GOKU_DEPLOY_IMAGE_REPO" help:"The image repo to use. If not provided, the repo from the registry config is used."`
+	DeployIdentifier string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The deploy identifier, used for the {identifier} placeholder in the repository naming scheme."`
+	Builder          string `arg:"--builder,env:GOKU_DEPLOY_BUILDER" help:"The image builder to log in (for login). Options: auto, docker, podman, buildah, kaniko. Defaults to auto-detecting what's available."`
+}
+
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	if args.Login == nil && args.Check == nil {
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
+	}
+
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+	regCfg, err := pcfg.GetRegistryConfig(ctx)
+	if err != nil {
+		return errutil.Wrap(err, "Getting registry config")
+	}
+
+	if args.DeployIdentifier == "" {
+		args.DeployIdentifier = cfg.AppName.ToCompact()
+	}
+	repo := args.ImageRepo
+	if repo == "" {
+		repo = regCfg.ImageRepo(reg.RepoVars{
+			App:        cfg.AppName.ToKebab(),
+			Identifier: args.DeployIdentifier,
+		})
+	}
+	if repo == "" {
+		return fmt.Errorf("No image repository is configured. Set registry.repository in %s (or in your profile config), or pass --image-repo.", projectconfig.FileName)
+	}
+
+	// Permissions are granted per repo, so with a repo per image we check each of them
+	repos := []string{repo}
+	if reg.HasImagePlaceholder(repo) {
+		images := []string{deploy.DefaultImageName}
+		if len(pcfg.Deploy.Images) > 0 {
+			images = nil
+			for _, img := range pcfg.Deploy.Images {
+				images = append(images, img.Name)
+			}
+		}
+		repos = nil
+		for _, img := range images {
+			repos = append(repos, reg.ExpandImage(repo, img))
+		}
+	}
+
+	// Login is done by the builder that will push the images
+	var builder deploy.Builder
+	if args.Login != nil {
+		builderName := args.Builder
+		if builderName == "" {
+			builderName = pcfg.Deploy.Builder
+		}
+		builder, err = deploy.GetBuilder(ctx, builderName)
+		if err != nil {
+			return errutil.Wrap(err, "Getting image builder")
+		}
+	}
+
+	result := Repos{Repos: []Repo{}}
+	for _, r := range repos {
+		res, err := runForRepo(ctx, builder, regCfg, r, args)
+		if err != nil {
+			return err
+		}
+		result.Repos = append(result.Repos, res)
+	}
+
+	return output.Print(os.Stdout, result, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "REPO\tHOST\tUSERNAME\tLOGGED IN\tPUSH VERIFIED")
+		for _, r := range result.Repos {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%t\n", r.Repo, r.Host, r.Username, r.LoggedIn, r.PushVerified)
+		}
+	})
+}
+
+// Repos is the result of login and check.
+type Repos struct {
+	Repos []Repo `json:"repos"`
+}
+
+// Repo is an image repo, and what was done with it.
+type Repo struct {
+	Repo     string `json:"repo"`
+	Host     string `json:"host"`
+	Username string `json:"username,omitempty"`
+	// LoggedIn is true if the builder was logged into the host (login)
+	LoggedIn bool `json:"logged_in"`
+	// PushVerified is true if the credentials can push to the repo (check)
+	PushVerified bool `json:"push_verified"`
+}
+
+// Identifiers returns the repos.
+func (r Repos) Identifiers() []string {
+	var ret []string
+	for _, repo := range r.Repos {
+		ret = append(ret, repo.Repo)
+	}
+	return ret
+}
+
+func runForRepo(ctx context.Context, builder deploy.Builder, regCfg reg.Config, repo string, args *Args) (Repo, error) {
+	host, _ := reg.ParseRepo(repo)
+	res := Repo{Repo: repo, Host: host}
+	creds, err := regCfg.GetCredentials(ctx, host)
+	if err != nil {
+		return res, errutil.Wrap(err, "Getting credentials for registry [%s]", host)
+	}
+	res.Username = creds.Username
+
+	// Login
+	if args.Login != nil {
+		log.Info(ctx, "Running subcommand [login]", "host", host, "username", creds.Username, "builder", builder.Name())
+		err = builder.Login(ctx, host, creds)
+		if err != nil {
+			return res, errutil.Wrap(err, "Running subcommand [login]")
+		}
+		res.LoggedIn = true
+		// Docker may have new credentials now
+		if regCfg.Credentials.Source == "" || regCfg.Credentials.Source == reg.CredentialSourceDocker {
+			creds, err = regCfg.GetCredentials(ctx, host)
+			if err != nil {
+				return res, errutil.Wrap(err, "Getting credentials for registry [%s]", host)
+			}
+			res.Username = creds.Username
+		}
+	}
+
+	// Check
+	if args.Check != nil {
+		log.Info(ctx, "Running subcommand [check]", "repo", repo, "username", creds.Username)
+		err = reg.CheckPush(ctx, repo, creds)
+		if err != nil {
+			return res, errutil.Wrap(err, "Running subcommand [check]")
+		}
+		log.Info(ctx, "Push permissions verified", "repo", repo)
+		res.PushVerified = true
+	}
+
+	return res, nil
+}
@@ -0,0 +1,397 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/kube"
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/secrets"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+type Args struct {
+	Set    *SetArgs    `arg:"subcommand:set" help:"Set one or more secrets, in the form KEY=VALUE"`
+	Get    *GetArgs    `arg:"subcommand:get" help:"Print the value of a secret"`
+	List   *ListArgs   `arg:"subcommand:list" help:"List the keys of the secrets (not their values)"`
+	Unset  *UnsetArgs  `arg:"subcommand:unset" help:"Remove one or more secrets"`
+	Import *ImportArgs `arg:"subcommand:import" help:"Set the secrets from a .env file"`
+	Check  *struct{}   `arg:"subcommand:check" help:"Compare the secrets with the example env file (.env.example), and fail if any variable is missing"`
+	Sync   *struct{}   `arg:"subcommand:sync" help:"Apply the sealed secrets of the repo to the cluster, without a deploy"`
+	Keygen *struct{}   `arg:"subcommand:keygen" help:"Create an age key to encrypt and decrypt sealed secrets with, and print its public key"`
+
+	// Flags
+	Env              string `arg:"-e,--env,env:GOKU_DEPLOY_ENV" help:"The environment of the secrets, as defined under environments in ongoku.cli.yaml e.g. staging, prod"`
+	DeployIdentifier string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The deploy identifier of the deployment the secrets belong to"`
+	Approve          bool   `arg:"--approve,env:GOKU_DEPLOY_APPROVE" help:"Approve changes to environments that require approval, without being asked"`
+	deploy.KubeFlags
+}
+
+type (
+	SetArgs struct {
+		Values []string `arg:"positional,required" help:"The secrets to set, in the form KEY=VALUE. Use KEY=- to read the value from stdin."`
+	}
+	GetArgs struct {
+		Key string `arg:"positional,required" help:"The key of the secret"`
+	}
+	ListArgs struct {
+		ShowValues bool `arg:"--show-values" help:"Print the values too"`
+	}
+	UnsetArgs struct {
+		Keys []string `arg:"positional,required" help:"The keys of the secrets to remove"`
+	}
+	ImportArgs struct {
+		File    string `arg:"positional,required" help:"The .env file to import"`
+		Replace bool   `arg:"--replace" help:"Remove the secrets that are not in the file, instead of keeping them"`
+	}
+)
+
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	if args.Set == nil && args.Get == nil && args.List == nil && args.Unset == nil && args.Import == nil && args.Check == nil && args.Sync == nil && args.Keygen == nil {
+		return ogerr.New(ogerr.CategoryUsage, "Please provide a subcommand.")
+	}
+
+	// Keygen does not need the project config
+	if args.Keygen != nil {
+		recipient, path, err := secrets.GenerateKey(ctx)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [keygen]")
+		}
+		log.Info(ctx, "Created age key. Keep it safe, it decrypts the sealed secrets.", "path", path)
+		fmt.Fprintf(os.Stderr, "Add the public key to secrets.recipients in %s:\n", projectconfig.FileName)
+		key := Key{PublicKey: recipient, Path: path}
+		return output.Print(os.Stdout, key, func(w io.Writer) { fmt.Fprintln(w, key.PublicKey) })
+	}
+
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+	commonFlags := deploy.CommonFlags{DeployIdentifier: args.DeployIdentifier, Env: args.Env, Approve: args.Approve}
+	pcfg, err = deploy.ResolveEnv(ctx, cfg, pcfg, &commonFlags)
+	if err != nil {
+		return err
+	}
+
+	// The cluster is only needed when the secrets live there (or are synced there)
+	var kc *kube.Client
+	if !deploy.IsSealedSecrets(pcfg) || args.Sync != nil {
+		ks, err := deploy.GetKubeSettings(cfg, pcfg, args.KubeFlags, nil, 0)
+		if err != nil {
+			return errutil.Wrap(err, "Resolving kubernetes settings")
+		}
+		kc, err = ks.NewClient(ctx)
+		if err != nil {
+			return errutil.Wrap(err, "Creating kubernetes client")
+		}
+	}
+	store, err := deploy.GetSecretsStore(cfg, pcfg, commonFlags, kc)
+	if err != nil {
+		return err
+	}
+	log.Debug(ctx, "Using secrets store", "store", store.Name())
+
+	if args.Set != nil || args.Unset != nil || args.Import != nil || args.Sync != nil {
+		err = deploy.ApproveEnvChange(ctx, cfg, pcfg, commonFlags, "change secrets")
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case args.Set != nil:
+		err = runSet(ctx, store, args.Set)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [set]")
+		}
+	case args.Get != nil:
+		err = runGet(ctx, store, args.Get)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [get]")
+		}
+	case args.List != nil:
+		err = runList(ctx, cfg, pcfg, store, args.List)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [list]")
+		}
+	case args.Unset != nil:
+		err = runUnset(ctx, store, args.Unset)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [unset]")
+		}
+	case args.Import != nil:
+		err = runImport(ctx, store, args.Import)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [import]")
+		}
+	case args.Check != nil:
+		err = runCheck(ctx, cfg, pcfg, store)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [check]")
+		}
+	case args.Sync != nil:
+		err = runSync(ctx, pcfg, commonFlags, store, kc)
+		if err != nil {
+			return errutil.Wrap(err, "Running subcommand [sync]")
+		}
+	}
+
+	return nil
+}
+
+func runSet(ctx context.Context, store secrets.Store, args *SetArgs) error {
+	values, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	for _, kv := range args.Values {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return ogerr.New(ogerr.CategoryUsage, "Invalid secret [%s]. Expected KEY=VALUE", kv)
+		}
+		err = secrets.ValidateKey(k)
+		if err != nil {
+			return err
+		}
+		if v == "-" {
+			v, err = readStdin()
+			if err != nil {
+				return errutil.Wrap(err, "Reading value of [%s] from stdin", k)
+			}
+		}
+		values[k] = v
+	}
+	err = store.Save(ctx, values)
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Secrets set", "count", len(args.Values), "store", store.Name())
+	return nil
+}
+
+func runGet(ctx context.Context, store secrets.Store, args *GetArgs) error {
+	values, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	v, ok := values[args.Key]
+	if !ok {
+		return fmt.Errorf("Secret [%s] is not set", args.Key)
+	}
+	secret := Secret{Key: args.Key, Value: v}
+	return output.Print(os.Stdout, secret, func(w io.Writer) { fmt.Fprintln(w, secret.Value) })
+}
+
+func runList(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, store secrets.Store, args *ListArgs) error {
+	values, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	list := SecretList{Store: store.Name(), Secrets: []Secret{}, Missing: []string{}}
+	for _, k := range secrets.SortedKeys(values) {
+		secret := Secret{Key: k}
+		if args.ShowValues {
+			secret.Value = values[k]
+		}
+		list.Secrets = append(list.Secrets, secret)
+	}
+
+	// Point out what's missing, without failing
+	example, err := secrets.ReadExampleKeys(deploy.ExampleEnvFilePath(cfg, pcfg))
+	if err == nil {
+		list.Missing, _ = secrets.CompareKeys(example, values)
+		list.Missing = output.NonNil(list.Missing)
+		if len(list.Missing) > 0 {
+			log.Warn(ctx, "Some variables of the example env file are not set", "missing", strings.Join(list.Missing, ", "))
+		}
+	}
+
+	return output.Print(os.Stdout, list, func(w io.Writer) {
+		for _, secret := range list.Secrets {
+			if args.ShowValues {
+				fmt.Fprintf(w, "%s=%s\n", secret.Key, secret.Value)
+				continue
+			}
+			fmt.Fprintln(w, secret.Key)
+		}
+	})
+}
+
+func runUnset(ctx context.Context, store secrets.Store, args *UnsetArgs) error {
+	values, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range args.Keys {
+		if _, ok := values[k]; !ok {
+			log.Warn(ctx, "Secret is not set, nothing to remove", "key", k)
+			continue
+		}
+		delete(values, k)
+	}
+	err = store.Save(ctx, values)
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Secrets removed", "keys", strings.Join(args.Keys, ", "), "store", store.Name())
+	return nil
+}
+
+func runImport(ctx context.Context, store secrets.Store, args *ImportArgs) error {
+	imported, err := secrets.ReadEnvFile(args.File)
+	if err != nil {
+		return errutil.Wrap(err, "Reading env file [%s]", args.File)
+	}
+	for k := range imported {
+		err = secrets.ValidateKey(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	values := map[string]string{}
+	if !args.Replace {
+		values, err = store.Load(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range imported {
+		values[k] = v
+	}
+	err = store.Save(ctx, values)
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Secrets imported", "file", args.File, "count", len(imported), "store", store.Name())
+	return nil
+}
+
+func runCheck(ctx context.Context, cfg ogconfig.Config, pcfg projectconfig.Config, store secrets.Store) error {
+	examplePath := deploy.ExampleEnvFilePath(cfg, pcfg)
+	example, err := secrets.ReadExampleKeys(examplePath)
+	if err != nil {
+		return errutil.Wrap(err, "Reading example env file [%s]", examplePath)
+	}
+	values, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	missing, extra := secrets.CompareKeys(example, values)
+	check := Check{Example: examplePath, Missing: output.NonNil(missing), Extra: output.NonNil(extra)}
+	err = output.Print(os.Stdout, check, func(w io.Writer) {
+		for _, k := range check.Missing {
+			fmt.Fprintf(w, "- %s (missing)\n", k)
+		}
+		for _, k := range check.Extra {
+			fmt.Fprintf(w, "+ %s (not in %s)\n", k, filepath.Base(examplePath))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return secrets.MissingKeysError{Keys: missing}
+	}
+	log.Info(ctx, "All the variables of the example env file are set", "example", examplePath, "store", store.Name())
+	return nil
+}
+
+// runSync writes the sealed secrets to the Secret in the cluster, the same way apply would.
+func runSync(ctx context.Context, pcfg projectconfig.Config, commonFlags deploy.CommonFlags, store secrets.Store, kc *kube.Client) error {
+	if !deploy.IsSealedSecrets(pcfg) {
+		return fmt.Errorf("Secrets are not sealed (secrets.store is not [%s]), they are already in the cluster", secrets.StoreSealed)
+	}
+	values, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	clusterPcfg := pcfg
+	clusterPcfg.Secrets.Store = secrets.StoreCluster
+	cluster, err := deploy.GetSecretsStore(ogconfig.Config{}, clusterPcfg, commonFlags, kc)
+	if err != nil {
+		return err
+	}
+	err = cluster.Save(ctx, values)
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, "Secrets synced", "count", len(values), "to", cluster.Name())
+	return nil
+}
+
+// Key is the result of keygen.
+type Key struct {
+	// PublicKey is the age recipient to add to secrets.recipients
+	PublicKey string `json:"public_key"`
+	// Path of the private key
+	Path string `json:"path"`
+}
+
+// Identifiers returns the public key.
+func (k Key) Identifiers() []string {
+	return []string{k.PublicKey}
+}
+
+// Secret is the result of get, and an item of the result of list.
+type Secret struct {
+	Key string `json:"key"`
+	// Value is only listed with --show-values
+	Value string `json:"value,omitempty"`
+}
+
+// Identifiers returns the value of the secret.
+func (s Secret) Identifiers() []string {
+	return []string{s.Value}
+}
+
+// SecretList is the result of list.
+type SecretList struct {
+	Store   string   `json:"store"`
+	Secrets []Secret `json:"secrets"`
+	// Missing are the variables of the example env file that are not set
+	Missing []string `json:"missing"`
+}
+
+// Identifiers returns the keys of the secrets.
+func (l SecretList) Identifiers() []string {
+	var ret []string
+	for _, secret := range l.Secrets {
+		ret = append(ret, secret.Key)
+	}
+	return ret
+}
+
+// Check is the result of check. Check fails if any variable is missing, after printing it.
+type Check struct {
+	// Example is the path of the example env file
+	Example string `json:"example"`
+	// Missing are the variables of the example env file that are not set
+	Missing []string `json:"missing"`
+	// Extra are the secrets that are not in the example env file
+	Extra []string `json:"extra"`
+}
+
+// Identifiers returns the missing variables.
+func (c Check) Identifiers() []string {
+	return c.Missing
+}
+
+func readStdin() (string, error) {
+	b, err := os.ReadFile("/dev/stdin")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
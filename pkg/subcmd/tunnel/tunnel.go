@@ -0,0 +1,145 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/teejays/gokutil/errutil"
+	"github.com/teejays/gokutil/log"
+	"github.com/teejays/gokutil/ogconfig"
+
+	"github.com/build-ongoku/ongoku-cli/pkg/ogerr"
+	"github.com/build-ongoku/ongoku-cli/pkg/output"
+	"github.com/build-ongoku/ongoku-cli/pkg/projectconfig"
+	"github.com/build-ongoku/ongoku-cli/pkg/subcmd/deploy"
+)
+
+type Args struct {
+	Components []string `arg:"positional,required" help:"The components to reach, as named under tunnels in ongoku.cli.yaml or by their service name. Add :PORT to only forward one port e.g. database:5432."`
+
+	// Flags
+	Env              string `arg:"-e,--env,env:GOKU_DEPLOY_ENV" help:"The environment of the deployment, as defined under environments in ongoku.cli.yaml e.g. staging, prod"`
+	DeployIdentifier string `arg:"--deploy-identifier,env:GOKU_DEPLOY_IDENTIFIER" help:"The deploy identifier of the deployment"`
+	deploy.KubeFlags
+}
+
+func Run(ctx context.Context, cfg ogconfig.Config, args *Args) error {
+	pcfg, err := projectconfig.Load(cfg.AppRootPath.Full)
+	if err != nil {
+		return errutil.Wrap(err, "Loading project config")
+	}
+	commonFlags := deploy.CommonFlags{DeployIdentifier: args.DeployIdentifier, Env: args.Env}
+	pcfg, err = deploy.ResolveEnv(ctx, cfg, pcfg, &commonFlags)
+	if err != nil {
+		return err
+	}
+
+	// Components by the service they reach, to find their connection strings
+	components := map[string]projectconfig.TunnelConfig{}
+	var services []deploy.TunnelService
+	for _, c := range args.Components {
+		name, portStr, hasPort := strings.Cut(c, ":")
+		tc := deploy.TunnelComponent(pcfg, name)
+		ts := deploy.TunnelService{Name: tc.Service, Ports: tc.Ports}
+		if hasPort {
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				return ogerr.New(ogerr.CategoryUsage, "Invalid port in [%s]", c)
+			}
+			ts.Ports = []int{port}
+		}
+		components[ts.Name] = tc
+		services = append(services, ts)
+	}
+
+	t, err := deploy.GetTarget(ctx, cfg, pcfg, commonFlags, deploy.TargetSettings{KubeFlags: args.KubeFlags})
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C (which cancels ctx) closes the tunnels
+	err = t.Tunnel(ctx, deploy.TunnelOptions{
+		Services: services,
+		Ready: func(tunnels []deploy.Tunnel) {
+			err := printTunnels(tunnels, components)
+			if err != nil {
+				log.Warn(ctx, "Could not print the tunnels", "error", err)
+			}
+			if slices.ContainsFunc(tunnels, func(tun deploy.Tunnel) bool { return !tun.Direct }) {
+				log.Info(ctx, "Tunnels are open. Press Ctrl-C to close them.")
+			}
+		},
+	})
+	if err != nil {
+		return errutil.Wrap(err, "Opening tunnels with the %s target", t.Name())
+	}
+	return nil
+}
+
+// Tunnels is the result of tunnel, printed once the tunnels are open.
+type Tunnels struct {
+	Tunnels []Tunnel `json:"tunnels"`
+}
+
+// Tunnel is a local address that reaches a port of a component.
+type Tunnel struct {
+	Component string `json:"component"`
+	Service   string `json:"service"`
+	// RemotePort is the port of the service
+	RemotePort int `json:"remote_port"`
+	// Address is the local address to connect to e.g. localhost:5432
+	Address string `json:"address"`
+	// Connection is the connection string of the component (e.g. a database URL), if it's configured
+	Connection string `json:"connection,omitempty"`
+	// Direct is true if the address reaches the service directly, and stays reachable after og exits
+	Direct bool `json:"direct"`
+}
+
+// Identifiers returns the connection strings, or the addresses of the tunnels without one.
+func (t Tunnels) Identifiers() []string {
+	var ret []string
+	for _, tun := range t.Tunnels {
+		ret = append(ret, firstNonEmpty(tun.Connection, tun.Address))
+	}
+	return ret
+}
+
+func printTunnels(tunnels []deploy.Tunnel, components map[string]projectconfig.TunnelConfig) error {
+	result := Tunnels{Tunnels: []Tunnel{}}
+	for _, tun := range tunnels {
+		tc := components[tun.Service]
+		result.Tunnels = append(result.Tunnels, Tunnel{
+			Component:  tc.Component,
+			Service:    tun.Service,
+			RemotePort: tun.RemotePort,
+			Address:    fmt.Sprintf("%s:%d", tun.Host, tun.LocalPort),
+			Connection: deploy.ConnectionString(tc, tun),
+			Direct:     tun.Direct,
+		})
+	}
+
+	return output.Print(os.Stdout, result, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		defer tw.Flush()
+
+		fmt.Fprintln(tw, "COMPONENT\tSERVICE PORT\tLOCAL ADDRESS\tCONNECTION")
+		for _, tun := range result.Tunnels {
+			fmt.Fprintf(tw, "%s\t%s:%d\t%s\t%s\n", tun.Component, tun.Service, tun.RemotePort, tun.Address, firstNonEmpty(tun.Connection, "-"))
+		}
+	})
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,14 @@
+module github.com/teejays/gokutil/log
+
+go 1.23.4
+
+require (
+	github.com/teejays/gokutil/env v0.0.0-20250110184101-7bed71063e1b
+	github.com/teejays/gokutil/panics v0.0.0-20250110184101-7bed71063e1b
+	github.com/teejays/gokutil/sclog v0.0.0-20250110184101-7bed71063e1b
+)
+
+require (
+	github.com/teejays/gokutil/clog v0.0.0-20250110184101-7bed71063e1b // indirect
+	github.com/teejays/gokutil/ctxutil v0.0.0-20250110184101-7bed71063e1b // indirect
+)